@@ -1,13 +1,16 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"llm-gateway/core"
-	"llm-gateway/core/security"
 	"llm-gateway/models"
 	"os"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -46,35 +49,72 @@ func withTransaction(db *gorm.DB, fn func(*gorm.DB) error) error {
 	return tx.Commit().Error
 }
 
-// safeMaskKey 安全地脱敏密钥，避免切片越界
-func safeMaskKey(key string) string {
-	if key == "" {
-		return "***"
+// validateStrategy 校验策略名称是否已在 LoadBalancer 中注册
+func validateStrategy(lb *core.LoadBalancer, strategy string) error {
+	if !lb.HasStrategy(strategy) {
+		return fmt.Errorf("unknown strategy %q, valid strategies: %s", strategy, strings.Join(lb.RegisteredStrategies(), ", "))
 	}
+	return nil
+}
 
-	if len(key) <= 8 {
-		// 对于短密钥，只显示前2位
-		if len(key) <= 4 {
-			return key[:1] + "***"
-		}
-		return key[:2] + "***" + key[len(key)-2:]
+// validateOnExhausted 校验 on_exhausted 策略，fallback 组不能是组自身
+func validateOnExhausted(policy, groupID string) error {
+	switch {
+	case policy == "", policy == core.ExhaustedError, policy == core.ExhaustedStaticMessage:
+		return nil
+	case strings.HasPrefix(policy, core.ExhaustedFallbackPrefix):
+		target := strings.TrimPrefix(policy, core.ExhaustedFallbackPrefix)
+		if target == "" || target == groupID {
+			return errors.New("on_exhausted fallback group must be another group")
+		}
+		return nil
+	}
+	return errors.New("on_exhausted must be error, static_message or fallback_group:<group_id>")
+}
+
+// normalizeTags 去除空白、空项与重复项，保持原有顺序
+func normalizeTags(tags []string) []string {
+	var result []string
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
 	}
+	return result
+}
 
-	// 显示前8位和后4位
-	return key[:8] + "..." + key[len(key)-4:]
+// hasAllTags 判断 tags 是否包含 wanted 中的所有标签
+func hasAllTags(tags, wanted []string) bool {
+	for _, w := range wanted {
+		found := false
+		for _, tag := range tags {
+			if tag == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
 }
 
 // handleRoot 处理根路径请求
-func handleRoot(lb *core.LoadBalancer) gin.HandlerFunc {
+func handleRoot(lb *core.LoadBalancer, basePath string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"name":    "LLM API Aggregation Gateway",
 			"version": "2.0.0",
 			"endpoints": gin.H{
-				"chat":        "/v1/chat/completions",
-				"health":      "/health",
-				"dashboard":   "/dashboard",
-				"admin_stats": "/admin/stats",
+				"chat":        basePath + "/v1/chat/completions",
+				"health":      basePath + "/health",
+				"dashboard":   basePath + "/dashboard",
+				"admin_stats": basePath + "/admin/stats",
 			},
 			"model_groups": getGroupIDs(lb),
 			"timestamp":    time.Now().Unix(),
@@ -83,45 +123,121 @@ func handleRoot(lb *core.LoadBalancer) gin.HandlerFunc {
 }
 
 // handleHealth 处理健康检查
-func handleHealth(lb *core.LoadBalancer) gin.HandlerFunc {
+// 默认为轻量的存活检查；?deep=true 时检查数据库连接与 Key 池状态，
+// 无法提供任何服务 (数据库不可用或没有可用 Key) 时返回 503，可作为就绪探针
+func handleHealth(lb *core.LoadBalancer, proxyHandler *core.ProxyHandler) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.JSON(200, models.HealthResponse{
+		resp := models.HealthResponse{
 			Status:      "healthy",
 			Gateway:     "LLM API Aggregation Gateway",
 			ModelGroups: getGroupIDs(lb),
 			Timestamp:   time.Now().Unix(),
-		})
+		}
+
+		if c.Query("deep") != "true" {
+			c.JSON(200, resp)
+			return
+		}
+
+		checks := &models.HealthChecks{
+			Database:       "ok",
+			TotalGroups:    len(resp.ModelGroups),
+			ServableGroups: lb.ServableGroups(),
+		}
+		resp.Checks = checks
+		if proxyHandler != nil {
+			checks.InFlight = proxyHandler.InFlight()
+			checks.MaxInFlight = proxyHandler.MaxInFlight()
+		}
+
+		if sqlDB, err := lb.GetDB().DB(); err != nil {
+			checks.Database = err.Error()
+		} else if err := sqlDB.PingContext(c.Request.Context()); err != nil {
+			checks.Database = err.Error()
+		}
+
+		switch {
+		case checks.Database != "ok" || checks.ServableGroups == 0:
+			resp.Status = "unhealthy"
+			c.JSON(503, resp)
+			return
+		case checks.ServableGroups < checks.TotalGroups:
+			resp.Status = "degraded"
+		}
+		c.JSON(200, resp)
 	}
 }
 
 // handleDashboard 处理管理员仪表板（完整版）
-func handleDashboard() gin.HandlerFunc {
+// offline 为 true 时脚本改为加载内嵌资源，适用于无法访问外网的部署
+func handleDashboard(basePath string, offline bool) gin.HandlerFunc {
+	// 注入路径前缀，页面内的 API 请求与接入地址都基于它拼接
+	html := strings.Replace(DashboardHTML, "{{BASE_PATH}}", basePath, 1)
+	if offline {
+		html = offlineDashboardHTML(html, basePath)
+	}
+	page := []byte(html)
 	return func(c *gin.Context) {
-		c.Data(200, "text/html; charset=utf-8", []byte(DashboardHTML))
+		c.Data(200, "text/html; charset=utf-8", page)
 	}
 }
 
 // handleListModelGroups 处理获取模型组列表
+// 传入 limit/offset 时分页，返回 {total, limit, offset, groups}；否则返回完整数组 (兼容旧客户端)
+// summary=true 时只返回组信息与模型数量，不加载模型详情
 func handleListModelGroups(lb *core.LoadBalancer) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 从数据库直接查询，避免缓存问题
 		var dbGroups []models.ModelGroup
-		if err := lb.GetDB().Find(&dbGroups).Error; err != nil {
+		if err := lb.GetDB().Order("id").Find(&dbGroups).Error; err != nil {
 			c.JSON(500, models.NewErrorResponse("Failed to query model groups: "+err.Error()))
 			return
 		}
 
+		// ?tag= 可重复，需同时包含所有标签
+		wantedTags := normalizeTags(c.QueryArray("tag"))
+		filtered := make([]models.ModelGroup, 0, len(dbGroups))
+		for _, group := range dbGroups {
+			if hasAllTags(group.Tags, wantedTags) {
+				filtered = append(filtered, group)
+			}
+		}
+
+		_, hasLimit := c.GetQuery("limit")
+		_, hasOffset := c.GetQuery("offset")
+		paginated := hasLimit || hasOffset
+		total := len(filtered)
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if limit > 100 {
+			limit = 100
+		}
+		if limit < 1 {
+			limit = 50
+		}
+		if offset < 0 {
+			offset = 0
+		}
+		if paginated {
+			if offset > total {
+				offset = total
+			}
+			filtered = filtered[offset:min(offset+limit, total)]
+		}
+		summary := c.Query("summary") == "true"
+
 		// 查询每个组的模型数量
 		type ModelGroupInfo struct {
 			ID          uint                   `json:"id"`
 			GroupID     string                 `json:"group_id"`
 			Strategy    string                 `json:"strategy"`
+			Tags        []string               `json:"tags,omitempty"`
 			ModelCount  int                    `json:"model_count"`
 			Models      []models.ModelConfig   `json:"models,omitempty"`
 		}
 
-		var groupsInfo []ModelGroupInfo
-		for _, group := range dbGroups {
+		groupsInfo := make([]ModelGroupInfo, 0, len(filtered))
+		for _, group := range filtered {
 			var modelCount int64
 			if err := lb.GetDB().Model(&models.ModelConfig{}).Where("model_group_id = ?", group.ID).Count(&modelCount).Error; err != nil {
 				lb.GetLogger().Errorf("Failed to count models for group %d: %v", group.ID, err)
@@ -129,20 +245,32 @@ func handleListModelGroups(lb *core.LoadBalancer) gin.HandlerFunc {
 			}
 
 			var modelConfigs []models.ModelConfig
-			if err := lb.GetDB().Preload("Stats").Where("model_group_id = ?", group.ID).Find(&modelConfigs).Error; err != nil {
-				lb.GetLogger().Errorf("Failed to load models for group %d: %v", group.ID, err)
-				modelConfigs = []models.ModelConfig{}
+			if !summary {
+				if err := lb.GetDB().Preload("Stats").Where("model_group_id = ?", group.ID).Order("priority ASC, id ASC").Find(&modelConfigs).Error; err != nil {
+					lb.GetLogger().Errorf("Failed to load models for group %d: %v", group.ID, err)
+					modelConfigs = []models.ModelConfig{}
+				}
 			}
 
 			groupsInfo = append(groupsInfo, ModelGroupInfo{
 				ID:         group.ID,
 				GroupID:    group.GroupID,
 				Strategy:   group.Strategy,
+				Tags:       group.Tags,
 				ModelCount: int(modelCount),
 				Models:     modelConfigs,
 			})
 		}
 
+		if paginated {
+			c.JSON(200, models.NewSuccessResponse("Model groups retrieved successfully", gin.H{
+				"total":  total,
+				"limit":  limit,
+				"offset": offset,
+				"groups": groupsInfo,
+			}))
+			return
+		}
 		c.JSON(200, models.NewSuccessResponse("Model groups retrieved successfully", groupsInfo))
 	}
 }
@@ -160,6 +288,40 @@ func handleCreateModelGroup(lb *core.LoadBalancer) gin.HandlerFunc {
 		if group.Strategy == "" {
 			group.Strategy = "fallback"
 		}
+		group.Tags = normalizeTags(group.Tags)
+		if err := validateStrategy(lb, group.Strategy); err != nil {
+			c.JSON(400, models.NewErrorResponse(err.Error()))
+			return
+		}
+		if group.ErrorRateThreshold < 0 || group.ErrorRateThreshold > 1 {
+			c.JSON(400, models.NewErrorResponse("error_rate_threshold must be between 0 and 1"))
+			return
+		}
+		if group.MaxMessages < 0 || group.MaxContextChars < 0 {
+			c.JSON(400, models.NewErrorResponse("max_messages and max_context_chars must not be negative"))
+			return
+		}
+		if group.RequestsPerMinute < 0 || group.TokensPerMinute < 0 {
+			c.JSON(400, models.NewErrorResponse("requests_per_minute and tokens_per_minute must not be negative"))
+			return
+		}
+		if a := group.UnhealthyAction; a != "" && a != "deprioritize" && a != "skip" {
+			c.JSON(400, models.NewErrorResponse("unhealthy_action must be deprioritize or skip"))
+			return
+		}
+		// race_count 为 0 表示未设置，使用默认值
+		if group.RaceCount < 0 || group.RaceCount > 10 {
+			c.JSON(400, models.NewErrorResponse("race_count must be between 1 and 10"))
+			return
+		}
+		if group.MaxRetries < 0 || group.MaxRetries > 50 {
+			c.JSON(400, models.NewErrorResponse("max_retries must be between 0 and 50"))
+			return
+		}
+		if err := validateOnExhausted(group.OnExhausted, group.GroupID); err != nil {
+			c.JSON(400, models.NewErrorResponse(err.Error()))
+			return
+		}
 
 		// 使用 Unscoped() 检查是否存在（包括软删除的记录）
 		var existingGroup models.ModelGroup
@@ -170,6 +332,21 @@ func handleCreateModelGroup(lb *core.LoadBalancer) gin.HandlerFunc {
 			if existingGroup.DeletedAt.Valid {
 				// 记录已被软删除，执行正确的"复活"操作
 				existingGroup.Strategy = group.Strategy
+				existingGroup.Tags = group.Tags
+				existingGroup.ErrorRateThreshold = group.ErrorRateThreshold
+				existingGroup.UnhealthyAction = group.UnhealthyAction
+				existingGroup.OnExhausted = group.OnExhausted
+				existingGroup.ExhaustedMessage = group.ExhaustedMessage
+				existingGroup.LeastLoadedKeys = group.LeastLoadedKeys
+				existingGroup.MaxMessages = group.MaxMessages
+				existingGroup.MaxContextChars = group.MaxContextChars
+				existingGroup.RequestsPerMinute = group.RequestsPerMinute
+				existingGroup.TokensPerMinute = group.TokensPerMinute
+				existingGroup.RaceCount = group.RaceCount
+				if existingGroup.RaceCount == 0 {
+					existingGroup.RaceCount = 2 // 与新建时的默认值一致
+				}
+				existingGroup.MaxRetries = group.MaxRetries
 				existingGroup.DeletedAt = gorm.DeletedAt{} // 正确重置软删除
 
 				if err := lb.GetDB().Unscoped().Save(&existingGroup).Error; err != nil {
@@ -182,11 +359,14 @@ func handleCreateModelGroup(lb *core.LoadBalancer) gin.HandlerFunc {
 					lb.GetLogger().Warnf("Failed to refresh cache after restoring model group: %v", err)
 				}
 
+				recordAudit(c, lb, "create_group", "group:"+group.GroupID, "restored")
+
 				// 返回恢复后的数据
 				c.JSON(200, models.NewSuccessResponse("Model group restored successfully", gin.H{
 					"id":       existingGroup.ID,
 					"group_id": group.GroupID,
 					"strategy": group.Strategy,
+					"tags":     group.Tags,
 				}))
 			} else {
 				// 记录存在且未被删除
@@ -207,6 +387,7 @@ func handleCreateModelGroup(lb *core.LoadBalancer) gin.HandlerFunc {
 			}
 
 			lb.GetLogger().Infof("[INFO] CreateGroup | ID: %s | Strategy: %s | Success", group.GroupID, group.Strategy)
+			recordAudit(c, lb, "create_group", "group:"+group.GroupID, "strategy="+group.Strategy)
 			c.JSON(200, models.NewSuccessResponse("Model group created successfully", group))
 		} else {
 			// 数据库查询错误
@@ -243,7 +424,7 @@ func handleGetModelGroup(lb *core.LoadBalancer) gin.HandlerFunc {
 
 		// 查询模型配置
 		var modelConfigs []models.ModelConfig
-		if err := lb.GetDB().Where("model_group_id = ?", group.ID).Find(&modelConfigs).Error; err != nil {
+		if err := lb.GetDB().Where("model_group_id = ?", group.ID).Order("priority ASC, id ASC").Find(&modelConfigs).Error; err != nil {
 			modelConfigs = []models.ModelConfig{}
 		}
 
@@ -281,13 +462,36 @@ func handleUpdateModelGroup(lb *core.LoadBalancer) gin.HandlerFunc {
 		groupIDStr := c.Param("group_id")
 
 		var updateData struct {
-			Strategy string `json:"strategy"`
+			Strategy   *string `json:"strategy"` // 所有字段均为可选，只更新传入的字段
+			RaceCount  *int   `json:"race_count" binding:"omitempty,min=1,max=10"`
+			MaxRetries *int   `json:"max_retries" binding:"omitempty,min=0,max=50"` // 0 表示使用全局重试策略
+			Tags       *[]string `json:"tags"`                                       // 传入时整体替换，[] 清空
+
+			ErrorRateThreshold *float64 `json:"error_rate_threshold" binding:"omitempty,min=0,max=1"` // 0 表示关闭健康度降级
+			UnhealthyAction    *string  `json:"unhealthy_action" binding:"omitempty,oneof=deprioritize skip"`
+
+			OnExhausted      *string `json:"on_exhausted"`
+			ExhaustedMessage *string `json:"exhausted_message"`
+
+			LeastLoadedKeys *bool `json:"least_loaded_keys"`
+
+			MaxMessages     *int `json:"max_messages" binding:"omitempty,min=0"`      // 0 表示使用全局设置
+			MaxContextChars *int `json:"max_context_chars" binding:"omitempty,min=0"` // 0 表示使用全局设置
+
+			RequestsPerMinute *int `json:"requests_per_minute" binding:"omitempty,min=0"` // 0 表示不限制
+			TokensPerMinute   *int `json:"tokens_per_minute" binding:"omitempty,min=0"`   // 0 表示不限制
 		}
 
 		if err := c.ShouldBindJSON(&updateData); err != nil {
 			c.JSON(400, models.NewErrorResponse("Invalid request format: "+err.Error()))
 			return
 		}
+		if updateData.Strategy != nil {
+			if err := validateStrategy(lb, *updateData.Strategy); err != nil {
+				c.JSON(400, models.NewErrorResponse(err.Error()))
+				return
+			}
+		}
 
 		var group models.ModelGroup
 		var err error
@@ -305,20 +509,69 @@ func handleUpdateModelGroup(lb *core.LoadBalancer) gin.HandlerFunc {
 			return
 		}
 
-		// 更新策略
-		if err := lb.GetDB().Model(&group).Update("strategy", updateData.Strategy).Error; err != nil {
-			c.JSON(500, models.NewErrorResponse("Failed to update model group: "+err.Error()))
-			return
+		updates := map[string]interface{}{}
+		if updateData.Strategy != nil {
+			updates["strategy"] = *updateData.Strategy
+		}
+		if updateData.RaceCount != nil {
+			updates["race_count"] = *updateData.RaceCount
+		}
+		if updateData.MaxRetries != nil {
+			updates["max_retries"] = *updateData.MaxRetries
+		}
+		if updateData.Tags != nil {
+			// map 更新不经过 serializer，需要手动编码
+			tags, _ := json.Marshal(normalizeTags(*updateData.Tags))
+			updates["tags"] = string(tags)
+		}
+		if updateData.ErrorRateThreshold != nil {
+			updates["error_rate_threshold"] = *updateData.ErrorRateThreshold
+		}
+		if updateData.UnhealthyAction != nil {
+			updates["unhealthy_action"] = *updateData.UnhealthyAction
+		}
+		if updateData.OnExhausted != nil {
+			if err := validateOnExhausted(*updateData.OnExhausted, group.GroupID); err != nil {
+				c.JSON(400, models.NewErrorResponse(err.Error()))
+				return
+			}
+			updates["on_exhausted"] = *updateData.OnExhausted
+		}
+		if updateData.ExhaustedMessage != nil {
+			updates["exhausted_message"] = *updateData.ExhaustedMessage
+		}
+		if updateData.LeastLoadedKeys != nil {
+			updates["least_loaded_keys"] = *updateData.LeastLoadedKeys
+		}
+		if updateData.MaxMessages != nil {
+			updates["max_messages"] = *updateData.MaxMessages
+		}
+		if updateData.MaxContextChars != nil {
+			updates["max_context_chars"] = *updateData.MaxContextChars
+		}
+		if updateData.RequestsPerMinute != nil {
+			updates["requests_per_minute"] = *updateData.RequestsPerMinute
+		}
+		if updateData.TokensPerMinute != nil {
+			updates["tokens_per_minute"] = *updateData.TokensPerMinute
+		}
+		if len(updates) > 0 {
+			if err := lb.GetDB().Model(&group).Updates(updates).Error; err != nil {
+				c.JSON(500, models.NewErrorResponse("Failed to update model group: "+err.Error()))
+				return
+			}
+			lb.GetDB().First(&group, group.ID)
 		}
 
 		// 刷新缓存
 		if err := lb.RefreshData(); err != nil {
 			lb.GetLogger().Warnf("Failed to refresh cache after updating model group: %v", err)
 		}
+		recordAudit(c, lb, "update_group", "group:"+group.GroupID, "")
 
 		c.JSON(200, models.NewSuccessResponse("Model group updated successfully", gin.H{
 			"group_id": group.GroupID, // 返回实际的GroupID
-			"strategy": updateData.Strategy,
+			"strategy": group.Strategy,
 		}))
 	}
 }
@@ -391,12 +644,240 @@ func handleDeleteModelGroup(lb *core.LoadBalancer) gin.HandlerFunc {
 		}
 
 		lb.GetLogger().Infof("[INFO] DeleteGroup | ID: %s | Success", group.GroupID)
+		recordAudit(c, lb, "delete_group", "group:"+group.GroupID, "")
 		c.JSON(200, models.NewSuccessResponse("Model group deleted successfully", gin.H{
 			"group_id": group.GroupID, // 返回实际的GroupID
 		}))
 	}
 }
 
+// handleCloneModelGroup 复制模型组 (含模型与 Key，Key 重新加密) 到新的 group_id，不复制统计数据
+func handleCloneModelGroup(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			GroupID string `json:"group_id" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, models.NewErrorResponse("Invalid request format: "+err.Error()))
+			return
+		}
+		req.GroupID = strings.TrimSpace(req.GroupID)
+
+		groupIDStr := c.Param("group_id")
+		var source models.ModelGroup
+		var err error
+		if id, parseErr := parseAndValidateID(groupIDStr, "group_id"); parseErr == nil {
+			err = lb.GetDB().First(&source, id).Error
+		} else {
+			err = lb.GetDB().Where("group_id = ?", groupIDStr).First(&source).Error
+		}
+		if err != nil {
+			c.JSON(404, models.NewErrorResponse("Model group not found"))
+			return
+		}
+
+		// 软删除的组仍占用 group_id (唯一索引)
+		var count int64
+		if err := lb.GetDB().Unscoped().Model(&models.ModelGroup{}).Where("group_id = ?", req.GroupID).Count(&count).Error; err != nil {
+			c.JSON(500, models.NewErrorResponse("Failed to check model group: "+err.Error()))
+			return
+		}
+		if req.GroupID == "" || count > 0 {
+			c.JSON(400, models.NewErrorResponse("Group ID already exists (including deleted groups in trash)"))
+			return
+		}
+
+		var clone models.ModelGroup
+		modelCount, keyCount := 0, 0
+		if err := withTransaction(lb.GetDB(), func(tx *gorm.DB) error {
+			clone = source
+			clone.Model = gorm.Model{}
+			clone.GroupID = req.GroupID
+			clone.Models, clone.Stats = nil, nil
+			if err := tx.Create(&clone).Error; err != nil {
+				return fmt.Errorf("failed to create model group: %w", err)
+			}
+
+			var configs []models.ModelConfig
+			if err := tx.Where("model_group_id = ?", source.ID).Order("priority ASC, id ASC").Preload("APIKeys").Find(&configs).Error; err != nil {
+				return fmt.Errorf("failed to query models: %w", err)
+			}
+			for _, cfg := range configs {
+				keys := cfg.APIKeys
+				cfg.Model = gorm.Model{}
+				cfg.ModelGroupID = clone.ID
+				cfg.ModelGroup, cfg.APIKeys, cfg.Stats = models.ModelGroup{}, nil, nil
+				if err := tx.Omit("ModelGroup").Create(&cfg).Error; err != nil {
+					return fmt.Errorf("failed to create model: %w", err)
+				}
+				modelCount++
+
+				for _, key := range keys {
+					plaintext, err := lb.Decrypt(key.KeyValue)
+					if err != nil {
+						return fmt.Errorf("failed to decrypt API key %d: %w", key.ID, err)
+					}
+					encrypted, err := lb.Encrypt(plaintext)
+					if err != nil {
+						return fmt.Errorf("failed to encrypt API key: %w", err)
+					}
+					newKey := models.APIKey{KeyValue: encrypted, ModelConfigID: cfg.ID, Weight: key.Weight}
+					if err := tx.Omit("ModelConfig").Create(&newKey).Error; err != nil {
+						return fmt.Errorf("failed to create API key: %w", err)
+					}
+					keyCount++
+				}
+			}
+			return nil
+		}); err != nil {
+			lb.GetLogger().Errorf("[ERROR] CloneGroup | Source: %s | Target: %s | Error: %v", source.GroupID, req.GroupID, err)
+			c.JSON(500, models.NewErrorResponse("Failed to clone model group: "+err.Error()))
+			return
+		}
+
+		// 刷新缓存
+		if err := lb.RefreshData(); err != nil {
+			lb.GetLogger().Warnf("Failed to refresh cache after cloning model group: %v", err)
+		}
+
+		lb.GetLogger().Infof("[INFO] CloneGroup | Source: %s | Target: %s | Models: %d | Keys: %d | Success", source.GroupID, clone.GroupID, modelCount, keyCount)
+		recordAudit(c, lb, "clone_group", "group:"+clone.GroupID, "source="+source.GroupID)
+		c.JSON(200, models.NewSuccessResponse("Model group cloned successfully", gin.H{
+			"id":           clone.ID,
+			"group_id":     clone.GroupID,
+			"source":       source.GroupID,
+			"models_count": modelCount,
+			"keys_count":   keyCount,
+		}))
+	}
+}
+
+// validateAliasTarget 校验别名目标 ("组ID" 或 "组ID$序号") 指向已存在的组
+func validateAliasTarget(lb *core.LoadBalancer, target string) error {
+	groupID, index, pinned := strings.Cut(target, "$")
+	if pinned {
+		if n, err := strconv.Atoi(index); err != nil || n < 1 {
+			return fmt.Errorf("invalid model index in target: %s", target)
+		}
+	}
+	var count int64
+	if err := lb.GetDB().Model(&models.ModelGroup{}).Where("group_id = ?", groupID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("target group not found: %s", groupID)
+	}
+	return nil
+}
+
+// handleListModelAliases 处理获取模型别名列表
+func handleListModelAliases(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var aliases []models.ModelAlias
+		if err := lb.GetDB().Order("alias ASC").Find(&aliases).Error; err != nil {
+			c.JSON(500, models.NewErrorResponse("Failed to query model aliases: "+err.Error()))
+			return
+		}
+		c.JSON(200, models.NewSuccessResponse("Model aliases retrieved successfully", aliases))
+	}
+}
+
+// handleCreateModelAlias 处理创建模型别名
+func handleCreateModelAlias(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.ModelAliasRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, models.NewErrorResponse("Invalid request format: "+err.Error()))
+			return
+		}
+		if req.Alias == "" {
+			c.JSON(400, models.NewErrorResponse("alias is required"))
+			return
+		}
+		if err := validateAliasTarget(lb, req.Target); err != nil {
+			c.JSON(400, models.NewErrorResponse(err.Error()))
+			return
+		}
+
+		var count int64
+		lb.GetDB().Model(&models.ModelAlias{}).Where("alias = ?", req.Alias).Count(&count)
+		if count > 0 {
+			c.JSON(400, models.NewErrorResponse("Alias already exists"))
+			return
+		}
+
+		alias := models.ModelAlias{Alias: req.Alias, Target: req.Target}
+		if err := lb.GetDB().Create(&alias).Error; err != nil {
+			c.JSON(500, models.NewErrorResponse("Failed to create model alias: "+err.Error()))
+			return
+		}
+
+		// 刷新缓存
+		if err := lb.RefreshData(); err != nil {
+			lb.GetLogger().Warnf("Failed to refresh cache after creating model alias: %v", err)
+		}
+
+		lb.GetLogger().Infof("[INFO] CreateAlias | %s -> %s | Success", alias.Alias, alias.Target)
+		c.JSON(200, models.NewSuccessResponse("Model alias created successfully", alias))
+	}
+}
+
+// handleUpdateModelAlias 处理修改模型别名的路由目标
+func handleUpdateModelAlias(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.ModelAliasRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, models.NewErrorResponse("Invalid request format: "+err.Error()))
+			return
+		}
+		if err := validateAliasTarget(lb, req.Target); err != nil {
+			c.JSON(400, models.NewErrorResponse(err.Error()))
+			return
+		}
+
+		var alias models.ModelAlias
+		if err := lb.GetDB().Where("alias = ?", c.Param("alias")).First(&alias).Error; err != nil {
+			c.JSON(404, models.NewErrorResponse("Model alias not found"))
+			return
+		}
+		if err := lb.GetDB().Model(&alias).Update("target", req.Target).Error; err != nil {
+			c.JSON(500, models.NewErrorResponse("Failed to update model alias: "+err.Error()))
+			return
+		}
+
+		// 刷新缓存
+		if err := lb.RefreshData(); err != nil {
+			lb.GetLogger().Warnf("Failed to refresh cache after updating model alias: %v", err)
+		}
+
+		c.JSON(200, models.NewSuccessResponse("Model alias updated successfully", alias))
+	}
+}
+
+// handleDeleteModelAlias 处理删除模型别名
+func handleDeleteModelAlias(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var alias models.ModelAlias
+		if err := lb.GetDB().Where("alias = ?", c.Param("alias")).First(&alias).Error; err != nil {
+			c.JSON(404, models.NewErrorResponse("Model alias not found"))
+			return
+		}
+		if err := lb.GetDB().Delete(&alias).Error; err != nil {
+			c.JSON(500, models.NewErrorResponse("Failed to delete model alias: "+err.Error()))
+			return
+		}
+
+		// 刷新缓存
+		if err := lb.RefreshData(); err != nil {
+			lb.GetLogger().Warnf("Failed to refresh cache after deleting model alias: %v", err)
+		}
+
+		c.JSON(200, models.NewSuccessResponse("Model alias deleted successfully", gin.H{
+			"alias": alias.Alias,
+		}))
+	}
+}
+
 // handleCreateModel 处理创建模型
 func handleCreateModel(lb *core.LoadBalancer) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -424,20 +905,49 @@ func handleCreateModel(lb *core.LoadBalancer) gin.HandlerFunc {
 			return
 		}
 
+		// 组内已存在相同的模型时不重复创建，避免打乱 fallback 顺序
+		var existing models.ModelConfig
+		if err := lb.GetDB().Where("model_group_id = ? AND provider_name = ? AND upstream_url = ? AND upstream_path = ? AND upstream_model = ?",
+			group.ID, req.ProviderName, req.UpstreamURL, req.UpstreamPath, req.UpstreamModel).First(&existing).Error; err == nil {
+			if req.OnDuplicate != "merge" {
+				c.JSON(409, models.NewErrorResponse(fmt.Sprintf(
+					"Model %s (%s) already exists in group %s as model %d; set on_duplicate to merge to add the keys to it",
+					req.UpstreamModel, req.UpstreamURL, group.GroupID, existing.ID)))
+				return
+			}
+			mergeModelKeys(c, lb, group, existing, req.Keys)
+			return
+		}
+
 		// 使用事务处理模型和API密钥的创建
+		var modelID uint
 		if err := withTransaction(lb.GetDB(), func(tx *gorm.DB) error {
 			// 创建模型配置
 			model := models.ModelConfig{
 				ProviderName:  req.ProviderName,
 				UpstreamURL:   req.UpstreamURL,
 				UpstreamModel: req.UpstreamModel,
+				UpstreamPath:  req.UpstreamPath,
 				Timeout:       req.Timeout,
 				ModelGroupID:  group.ID,
+
+				IdentityPatch:     req.IdentityPatch,
+				IdentityPatchText: req.IdentityPatchText,
+				RequestOverrides:  req.RequestOverrides.Encode(),
+
+				MaxOutputTokens:  req.MaxOutputTokens,
+				DefaultMaxTokens: req.DefaultMaxTokens,
+
+				HideThoughts: req.HideThoughts,
+				Priority:     req.Priority,
+				GoogleSearch: req.GoogleSearch.Encode(),
+				Capabilities: req.Capabilities.Encode(),
 			}
 
 			if err := tx.Create(&model).Error; err != nil {
 				return fmt.Errorf("failed to create model: %w", err)
 			}
+			modelID = model.ID
 
 			// 创建API密钥
 			for _, key := range req.Keys {
@@ -473,6 +983,7 @@ func handleCreateModel(lb *core.LoadBalancer) gin.HandlerFunc {
 		}
 
 		lb.GetLogger().Infof("[INFO] CreateModel | Group: %s | Model: %s | Keys: %d | Success", group.GroupID, req.UpstreamModel, len(req.Keys))
+		recordAudit(c, lb, "create_model", fmt.Sprintf("model:%d", modelID), fmt.Sprintf("group=%s upstream_model=%s", group.GroupID, req.UpstreamModel))
 		c.JSON(200, models.NewSuccessResponse("Model created successfully", gin.H{
 			"provider_name":  req.ProviderName,
 			"upstream_url":   req.UpstreamURL,
@@ -483,6 +994,61 @@ func handleCreateModel(lb *core.LoadBalancer) gin.HandlerFunc {
 	}
 }
 
+// mergeModelKeys 将 Key 合并到组内已存在的相同模型 (on_duplicate 为 merge)，已存在的 Key 跳过
+func mergeModelKeys(c *gin.Context, lb *core.LoadBalancer, group models.ModelGroup, model models.ModelConfig, keys []string) {
+	var existingKeys []models.APIKey
+	lb.GetDB().Where("model_config_id = ?", model.ID).Find(&existingKeys)
+	seen := make(map[string]bool, len(existingKeys))
+	for _, k := range existingKeys {
+		if plaintext, err := lb.Decrypt(k.KeyValue); err == nil {
+			seen[plaintext] = true
+		} else {
+			seen[k.KeyValue] = true // 兼容旧的明文数据
+		}
+	}
+
+	added, skipped := 0, 0
+	if err := withTransaction(lb.GetDB(), func(tx *gorm.DB) error {
+		for _, key := range keys {
+			if key == "" {
+				continue
+			}
+			if seen[key] {
+				skipped++
+				continue
+			}
+			seen[key] = true
+
+			encryptedKey, err := lb.Encrypt(key)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt API key: %w", err)
+			}
+			if err := tx.Create(&models.APIKey{KeyValue: encryptedKey, ModelConfigID: model.ID}).Error; err != nil {
+				return fmt.Errorf("failed to create API key: %w", err)
+			}
+			added++
+		}
+		return nil
+	}); err != nil {
+		lb.GetLogger().Errorf("[ERROR] CreateModel | Group: %s | Merge into model %d | Error: %v", group.GroupID, model.ID, err)
+		c.JSON(500, models.NewErrorResponse("Failed to merge keys: "+err.Error()))
+		return
+	}
+
+	if err := lb.RefreshData(); err != nil {
+		lb.GetLogger().Warnf("Failed to refresh cache after merging keys: %v", err)
+	}
+	lb.GetLogger().Infof("[INFO] CreateModel | Group: %s | Model: %s | Merged into model %d | Added: %d | Skipped: %d",
+		group.GroupID, model.UpstreamModel, model.ID, added, skipped)
+	recordAudit(c, lb, "merge_model_keys", fmt.Sprintf("model:%d", model.ID), fmt.Sprintf("group=%s added=%d skipped=%d", group.GroupID, added, skipped))
+	c.JSON(200, models.NewSuccessResponse("Model already exists, keys merged", gin.H{
+		"model_id":       model.ID,
+		"upstream_model": model.UpstreamModel,
+		"keys_added":     added,
+		"keys_skipped":   skipped,
+	}))
+}
+
 // handleUpdateModel 处理更新模型
 func handleUpdateModel(lb *core.LoadBalancer) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -493,6 +1059,29 @@ func handleUpdateModel(lb *core.LoadBalancer) gin.HandlerFunc {
 			UpstreamURL   string `json:"upstream_url"`
 			UpstreamModel string `json:"upstream_model"`
 			Timeout       int    `json:"timeout"`
+
+			// 可选字段：未传时保持原值
+			IdentityPatch     *bool   `json:"identity_patch"`
+			IdentityPatchText *string `json:"identity_patch_text"`
+
+			// 传入对象时整体替换，传入 {} 清除
+			RequestOverrides *models.RequestOverrides `json:"request_overrides"`
+
+			// 0 表示不限制 / 使用适配器默认值
+			MaxOutputTokens  *int `json:"max_output_tokens" binding:"omitempty,min=0"`
+			DefaultMaxTokens *int `json:"default_max_tokens" binding:"omitempty,min=0"`
+
+			HideThoughts *bool `json:"hide_thoughts"`
+			Priority     *int  `json:"priority"`
+
+			// 传入空字符串恢复适配器默认路径
+			UpstreamPath *string `json:"upstream_path"`
+
+			// 传入对象时整体替换，传入 {} 恢复默认
+			GoogleSearch *models.GoogleSearchConfig `json:"google_search"`
+
+			// 传入对象时整体替换，传入 {} 恢复提供商默认能力
+			Capabilities *models.CapabilityOverrides `json:"capabilities"`
 		}
 
 		if err := c.ShouldBindJSON(&updateData); err != nil {
@@ -520,6 +1109,40 @@ func handleUpdateModel(lb *core.LoadBalancer) gin.HandlerFunc {
 			"upstream_model": updateData.UpstreamModel,
 			"timeout":        updateData.Timeout,
 		}
+		if updateData.IdentityPatch != nil {
+			updates["identity_patch"] = *updateData.IdentityPatch
+		}
+		if updateData.IdentityPatchText != nil {
+			updates["identity_patch_text"] = *updateData.IdentityPatchText
+		}
+		if updateData.RequestOverrides != nil {
+			if *updateData.RequestOverrides == (models.RequestOverrides{}) {
+				updates["request_overrides"] = ""
+			} else {
+				updates["request_overrides"] = updateData.RequestOverrides.Encode()
+			}
+		}
+		if updateData.MaxOutputTokens != nil {
+			updates["max_output_tokens"] = *updateData.MaxOutputTokens
+		}
+		if updateData.DefaultMaxTokens != nil {
+			updates["default_max_tokens"] = *updateData.DefaultMaxTokens
+		}
+		if updateData.HideThoughts != nil {
+			updates["hide_thoughts"] = *updateData.HideThoughts
+		}
+		if updateData.Priority != nil {
+			updates["priority"] = *updateData.Priority
+		}
+		if updateData.GoogleSearch != nil {
+			updates["google_search"] = updateData.GoogleSearch.Encode()
+		}
+		if updateData.Capabilities != nil {
+			updates["capabilities"] = updateData.Capabilities.Encode()
+		}
+		if updateData.UpstreamPath != nil {
+			updates["upstream_path"] = *updateData.UpstreamPath
+		}
 
 		if err := lb.GetDB().Model(&model).Updates(updates).Error; err != nil {
 			c.JSON(500, models.NewErrorResponse("Failed to update model: "+err.Error()))
@@ -530,6 +1153,7 @@ func handleUpdateModel(lb *core.LoadBalancer) gin.HandlerFunc {
 		if err := lb.RefreshData(); err != nil {
 			lb.GetLogger().Warnf("Failed to refresh cache after updating model: %v", err)
 		}
+		recordAudit(c, lb, "update_model", fmt.Sprintf("model:%d", modelID), "")
 
 		c.JSON(200, models.NewSuccessResponse("Model updated successfully", gin.H{
 			"model_id": modelID,
@@ -582,6 +1206,7 @@ func handleDeleteModel(lb *core.LoadBalancer) gin.HandlerFunc {
 		if err := lb.RefreshData(); err != nil {
 			lb.GetLogger().Warnf("Failed to refresh cache after deleting model: %v", err)
 		}
+		recordAudit(c, lb, "delete_model", fmt.Sprintf("model:%d", modelID), "")
 
 		c.JSON(200, models.NewSuccessResponse("Model deleted successfully", gin.H{
 			"model_id": modelID,
@@ -602,7 +1227,8 @@ func handleCreateAPIKey(lb *core.LoadBalancer) gin.HandlerFunc {
 		}
 
 		var requestData struct {
-			Key string `json:"key" binding:"required"`
+			Key    string `json:"key" binding:"required"`
+			Weight int    `json:"weight" binding:"omitempty,min=1"` // 未传时为 1
 		}
 
 		if err := c.ShouldBindJSON(&requestData); err != nil {
@@ -634,16 +1260,19 @@ func handleCreateAPIKey(lb *core.LoadBalancer) gin.HandlerFunc {
 			if existingKey.DeletedAt.Valid {
 				// 记录已被软删除，执行恢复操作
 				existingKey.DeletedAt = gorm.DeletedAt{}
-				// 注意：如果原来是明文，这里恢复时顺便加密
-				if !security.IsBase64(existingKey.KeyValue) || len(existingKey.KeyValue) < 20 { // 粗略判断
-					enc, _ := lb.Encrypt(requestData.Key)
-					existingKey.KeyValue = enc
+				// 恢复时使用当前密钥重新加密 (兼容旧的明文数据)
+				enc, err := lb.Encrypt(requestData.Key)
+				if err != nil {
+					c.JSON(500, models.NewErrorResponse("Failed to encrypt API key"))
+					return
 				}
+				existingKey.KeyValue = enc
 
 				if err = lb.GetDB().Unscoped().Save(existingKey).Error; err != nil {
 					c.JSON(500, models.NewErrorResponse("Failed to restore API key: "+err.Error()))
 					return
 				}
+				recordAudit(c, lb, "create_key", fmt.Sprintf("key:%d", existingKey.ID), fmt.Sprintf("model=%d restored", model.ID))
 				c.JSON(200, models.NewSuccessResponse("API key restored successfully", existingKey))
 			} else {
 				// 记录存在且未被删除
@@ -663,6 +1292,7 @@ func handleCreateAPIKey(lb *core.LoadBalancer) gin.HandlerFunc {
 			apiKey := models.APIKey{
 				KeyValue:      encryptedKey,
 				ModelConfigID: model.ID,
+				Weight:        requestData.Weight,
 			}
 
 			if err := lb.GetDB().Create(&apiKey).Error; err != nil {
@@ -671,6 +1301,7 @@ func handleCreateAPIKey(lb *core.LoadBalancer) gin.HandlerFunc {
 				return
 			}
 			lb.GetLogger().Infof("[INFO] CreateAPIKey | Model: %d | Success", model.ID)
+			recordAudit(c, lb, "create_key", fmt.Sprintf("key:%d", apiKey.ID), fmt.Sprintf("model=%d", model.ID))
 			c.JSON(200, models.NewSuccessResponse("API key created successfully", apiKey))
 		}
 
@@ -681,8 +1312,49 @@ func handleCreateAPIKey(lb *core.LoadBalancer) gin.HandlerFunc {
 	}
 }
 
-// handleDeleteAPIKey 处理删除API密钥
-func handleDeleteAPIKey(lb *core.LoadBalancer) gin.HandlerFunc {
+// handleUpdateAPIKey 修改 API 密钥的选择权重
+func handleUpdateAPIKey(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keyID, err := parseAndValidateID(c.Param("key_id"), "key_id")
+		if err != nil {
+			c.JSON(400, models.NewErrorResponse(err.Error()))
+			return
+		}
+
+		var requestData struct {
+			Weight int `json:"weight" binding:"required,min=1"`
+		}
+		if err := c.ShouldBindJSON(&requestData); err != nil {
+			c.JSON(400, models.NewErrorResponse("Invalid request format: "+err.Error()))
+			return
+		}
+
+		var apiKey models.APIKey
+		if err := lb.GetDB().First(&apiKey, keyID).Error; err != nil {
+			c.JSON(404, models.NewErrorResponse("API key not found"))
+			return
+		}
+
+		if err := lb.GetDB().Model(&apiKey).Update("weight", requestData.Weight).Error; err != nil {
+			c.JSON(500, models.NewErrorResponse("Failed to update API key: "+err.Error()))
+			return
+		}
+
+		// 刷新缓存
+		if err := lb.RefreshData(); err != nil {
+			lb.GetLogger().Warnf("Failed to refresh cache after updating API key: %v", err)
+		}
+		recordAudit(c, lb, "update_key", fmt.Sprintf("key:%d", keyID), fmt.Sprintf("weight=%d", requestData.Weight))
+
+		c.JSON(200, models.NewSuccessResponse("API key updated successfully", gin.H{
+			"key_id": keyID,
+			"weight": requestData.Weight,
+		}))
+	}
+}
+
+// handleDeleteAPIKey 处理删除API密钥
+func handleDeleteAPIKey(lb *core.LoadBalancer) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		keyIDStr := c.Param("key_id")
 
@@ -709,6 +1381,7 @@ func handleDeleteAPIKey(lb *core.LoadBalancer) gin.HandlerFunc {
 		if err := lb.RefreshData(); err != nil {
 			lb.GetLogger().Warnf("Failed to refresh cache after deleting API key: %v", err)
 		}
+		recordAudit(c, lb, "delete_key", fmt.Sprintf("key:%d", keyID), fmt.Sprintf("model=%d", apiKey.ModelConfigID))
 
 		c.JSON(200, models.NewSuccessResponse("API key deleted successfully", gin.H{
 			"key_id": keyID,
@@ -716,14 +1389,564 @@ func handleDeleteAPIKey(lb *core.LoadBalancer) gin.HandlerFunc {
 	}
 }
 
+// handleReactivateAPIKey 手动恢复被标记为冷却或失效的 API Key
+func handleReactivateAPIKey(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keyID, err := parseAndValidateID(c.Param("key_id"), "key_id")
+		if err != nil {
+			c.JSON(400, models.NewErrorResponse(err.Error()))
+			return
+		}
+
+		var apiKey models.APIKey
+		if err := lb.GetDB().First(&apiKey, keyID).Error; err != nil {
+			c.JSON(404, models.NewErrorResponse("API key not found"))
+			return
+		}
+
+		var model models.ModelConfig
+		if err := lb.GetDB().First(&model, apiKey.ModelConfigID).Error; err != nil {
+			c.JSON(404, models.NewErrorResponse("API key does not belong to any model"))
+			return
+		}
+
+		plaintext, err := lb.Decrypt(apiKey.KeyValue)
+		if err != nil {
+			c.JSON(500, models.NewErrorResponse("Failed to decrypt API key: "+err.Error()))
+			return
+		}
+
+		previous := lb.GetKeyState(plaintext).Status.String()
+		lb.ReactivateKey(plaintext)
+
+		lb.GetLogger().Infof("[INFO] ReactivateAPIKey | Key: %d | Model: %s | Previous: %s", keyID, model.UpstreamModel, previous)
+		c.JSON(200, models.NewSuccessResponse("API key reactivated successfully", gin.H{
+			"key_id":          keyID,
+			"model_id":        model.ID,
+			"previous_status": previous,
+			"status":          lb.GetKeyState(plaintext).Status.String(),
+		}))
+	}
+}
+
+// trashCascadeWindow 删除组/模型时级联删除的子记录与父记录的删除时间差上限
+// 恢复父记录时只恢复在此窗口内删除的子记录，之前单独删除的保留在回收站
+const trashCascadeWindow = 5 * time.Second
+
+// handleListTrash 列出软删除的模型组、模型与 API Key (Key 脱敏)
+func handleListTrash(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := lb.GetDB().Unscoped().Session(&gorm.Session{})
+
+		var groups []models.ModelGroup
+		if err := db.Where("deleted_at IS NOT NULL").Order("deleted_at DESC").Find(&groups).Error; err != nil {
+			c.JSON(500, models.NewErrorResponse("Failed to query deleted groups: "+err.Error()))
+			return
+		}
+		var modelConfigs []models.ModelConfig
+		if err := db.Where("deleted_at IS NOT NULL").Order("deleted_at DESC").Find(&modelConfigs).Error; err != nil {
+			c.JSON(500, models.NewErrorResponse("Failed to query deleted models: "+err.Error()))
+			return
+		}
+		var keys []models.APIKey
+		if err := db.Where("deleted_at IS NOT NULL").Order("deleted_at DESC").Find(&keys).Error; err != nil {
+			c.JSON(500, models.NewErrorResponse("Failed to query deleted API keys: "+err.Error()))
+			return
+		}
+
+		groupItems := make([]gin.H, 0, len(groups))
+		for _, g := range groups {
+			groupItems = append(groupItems, gin.H{
+				"id":         g.ID,
+				"group_id":   g.GroupID,
+				"strategy":   g.Strategy,
+				"deleted_at": g.DeletedAt.Time,
+			})
+		}
+		modelItems := make([]gin.H, 0, len(modelConfigs))
+		for _, m := range modelConfigs {
+			modelItems = append(modelItems, gin.H{
+				"id":             m.ID,
+				"model_group_id": m.ModelGroupID,
+				"provider_name":  m.ProviderName,
+				"upstream_model": m.UpstreamModel,
+				"deleted_at":     m.DeletedAt.Time,
+			})
+		}
+		keyItems := make([]gin.H, 0, len(keys))
+		for _, k := range keys {
+			preview := "(undecryptable)"
+			if plaintext, err := lb.Decrypt(k.KeyValue); err == nil {
+				preview = models.MaskAPIKey(plaintext)
+			}
+			keyItems = append(keyItems, gin.H{
+				"id":              k.ID,
+				"model_config_id": k.ModelConfigID,
+				"key_preview":     preview,
+				"deleted_at":      k.DeletedAt.Time,
+			})
+		}
+
+		c.JSON(200, models.NewSuccessResponse("Trash retrieved successfully", gin.H{
+			"groups": groupItems,
+			"models": modelItems,
+			"keys":   keyItems,
+		}))
+	}
+}
+
+// handleRestoreTrash 恢复软删除的模型组、模型或 API Key
+// 恢复组/模型时一并恢复随其级联删除的模型、Key 与统计；父记录仍处于删除状态时返回 409
+func handleRestoreTrash(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.RestoreRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, models.NewErrorResponse("Invalid request format: "+err.Error()))
+			return
+		}
+
+		status := 200
+		err := withTransaction(lb.GetDB(), func(tx *gorm.DB) error {
+			switch req.Type {
+			case "group":
+				var group models.ModelGroup
+				if err := tx.Unscoped().Where("deleted_at IS NOT NULL").First(&group, req.ID).Error; err != nil {
+					status = 404
+					return errors.New("deleted model group not found")
+				}
+				from, to := group.DeletedAt.Time.Add(-trashCascadeWindow), group.DeletedAt.Time
+
+				var modelIDs []uint
+				if err := tx.Unscoped().Model(&models.ModelConfig{}).
+					Where("model_group_id = ? AND deleted_at BETWEEN ? AND ?", group.ID, from, to).
+					Pluck("id", &modelIDs).Error; err != nil {
+					return err
+				}
+				if err := restoreRows(tx, &models.ModelConfig{}, from, to, "id IN ?", modelIDs); err != nil {
+					return err
+				}
+				if err := restoreRows(tx, &models.APIKey{}, from, to, "model_config_id IN ?", modelIDs); err != nil {
+					return err
+				}
+				if err := restoreRows(tx, &models.ModelStats{}, from, to, "model_group_id = ? OR model_config_id IN ?", group.ID, modelIDs); err != nil {
+					return err
+				}
+				return tx.Unscoped().Model(&group).Update("deleted_at", nil).Error
+
+			case "model":
+				var model models.ModelConfig
+				if err := tx.Unscoped().Where("deleted_at IS NOT NULL").First(&model, req.ID).Error; err != nil {
+					status = 404
+					return errors.New("deleted model not found")
+				}
+				if err := tx.First(&models.ModelGroup{}, model.ModelGroupID).Error; err != nil {
+					status = 409
+					return errors.New("model group is deleted, restore the group first")
+				}
+				from, to := model.DeletedAt.Time.Add(-trashCascadeWindow), model.DeletedAt.Time
+				if err := restoreRows(tx, &models.APIKey{}, from, to, "model_config_id = ?", model.ID); err != nil {
+					return err
+				}
+				if err := restoreRows(tx, &models.ModelStats{}, from, to, "model_config_id = ?", model.ID); err != nil {
+					return err
+				}
+				return tx.Unscoped().Model(&model).Update("deleted_at", nil).Error
+
+			default: // key
+				var key models.APIKey
+				if err := tx.Unscoped().Where("deleted_at IS NOT NULL").First(&key, req.ID).Error; err != nil {
+					status = 404
+					return errors.New("deleted API key not found")
+				}
+				if err := tx.First(&models.ModelConfig{}, key.ModelConfigID).Error; err != nil {
+					status = 409
+					return errors.New("model is deleted, restore the model first")
+				}
+				// 同一模型下已重新添加了相同的 Key 时不恢复，避免重复
+				plaintext, err := lb.Decrypt(key.KeyValue)
+				if err != nil {
+					return fmt.Errorf("failed to decrypt API key: %w", err)
+				}
+				var active []models.APIKey
+				if err := tx.Where("model_config_id = ?", key.ModelConfigID).Find(&active).Error; err != nil {
+					return err
+				}
+				for _, k := range active {
+					if decrypted, err := lb.Decrypt(k.KeyValue); err == nil && decrypted == plaintext {
+						status = 409
+						return errors.New("API key already exists")
+					}
+				}
+				return tx.Unscoped().Model(&key).Update("deleted_at", nil).Error
+			}
+		})
+		if err != nil {
+			if status == 200 {
+				status = 500
+			}
+			c.JSON(status, models.NewErrorResponse("Failed to restore "+req.Type+": "+err.Error()))
+			return
+		}
+
+		// 刷新缓存
+		if err := lb.RefreshData(); err != nil {
+			lb.GetLogger().Warnf("Failed to refresh cache after restoring %s: %v", req.Type, err)
+		}
+
+		lb.GetLogger().Infof("[INFO] Restore | Type: %s | ID: %d | Success", req.Type, req.ID)
+		c.JSON(200, models.NewSuccessResponse("Restored successfully", gin.H{
+			"type": req.Type,
+			"id":   req.ID,
+		}))
+	}
+}
+
+// restoreRows 恢复在 [from, to] 内被软删除且满足条件的记录
+func restoreRows(tx *gorm.DB, model interface{}, from, to time.Time, query string, args ...interface{}) error {
+	return tx.Unscoped().Model(model).
+		Where("deleted_at BETWEEN ? AND ?", from, to).
+		Where(query, args...).
+		Update("deleted_at", nil).Error
+}
+
+// handleRotateEncryption 使用当前密钥版本重新加密所有 API Key
+// 需先以 "新密钥 + 旧密钥" 配置 SecretProvider，使旧密文仍可解密
+func handleRotateEncryption(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rotated int
+
+		if err := withTransaction(lb.GetDB(), func(tx *gorm.DB) error {
+			// 包括软删除的记录，保证恢复后依然可以解密
+			var keys []models.APIKey
+			if err := tx.Unscoped().Find(&keys).Error; err != nil {
+				return fmt.Errorf("failed to query API keys: %w", err)
+			}
+
+			for i := range keys {
+				plaintext, err := lb.Decrypt(keys[i].KeyValue)
+				if err != nil {
+					return fmt.Errorf("failed to decrypt API key %d: %w", keys[i].ID, err)
+				}
+				encrypted, err := lb.Encrypt(plaintext)
+				if err != nil {
+					return fmt.Errorf("failed to encrypt API key %d: %w", keys[i].ID, err)
+				}
+				if err := tx.Unscoped().Model(&keys[i]).Update("key_value", encrypted).Error; err != nil {
+					return fmt.Errorf("failed to update API key %d: %w", keys[i].ID, err)
+				}
+				rotated++
+			}
+			return nil
+		}); err != nil {
+			lb.GetLogger().Errorf("[ERROR] RotateEncryption | Error: %v", err)
+			c.JSON(500, models.NewErrorResponse(err.Error()))
+			return
+		}
+
+		// 刷新缓存
+		if err := lb.RefreshData(); err != nil {
+			lb.GetLogger().Warnf("Failed to refresh cache after rotating encryption: %v", err)
+		}
+
+		lb.GetLogger().Infof("[INFO] RotateEncryption | Keys: %d | Success", rotated)
+		c.JSON(200, models.NewSuccessResponse("API keys re-encrypted successfully", gin.H{
+			"rotated": rotated,
+		}))
+	}
+}
+
+// handleEncryptAllKeys 将数据库中残留的明文 API Key 加密
+func handleEncryptAllKeys(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		migrated, err := core.EncryptPlaintextKeys(lb.GetDB(), lb, lb.GetLogger())
+		if err != nil {
+			c.JSON(500, models.NewErrorResponse(err.Error()))
+			return
+		}
+
+		// 刷新缓存
+		if err := lb.RefreshData(); err != nil {
+			lb.GetLogger().Warnf("Failed to refresh cache after encrypting API keys: %v", err)
+		}
+
+		c.JSON(200, models.NewSuccessResponse("Plaintext API keys encrypted successfully", gin.H{
+			"encrypted": migrated,
+		}))
+	}
+}
+
+// handleGetKeyStatus 按模型列出每个 API Key 的健康状态 (可用/冷却/失效)
+func handleGetKeyStatus(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var groups []models.ModelGroup
+		if err := lb.GetDB().Preload("Models.APIKeys").Order("id").Find(&groups).Error; err != nil {
+			c.JSON(500, models.NewErrorResponse("Failed to query API keys: "+err.Error()))
+			return
+		}
+
+		result := make([]models.ModelKeyStatus, 0)
+		for _, group := range groups {
+			for _, model := range group.Models {
+				entry := models.ModelKeyStatus{
+					ModelID:       model.ID,
+					GroupID:       group.GroupID,
+					ProviderName:  model.ProviderName,
+					UpstreamModel: model.UpstreamModel,
+					Keys:          make([]models.KeyStatusInfo, 0, len(model.APIKeys)),
+				}
+
+				for _, key := range model.APIKeys {
+					plaintext, err := lb.Decrypt(key.KeyValue)
+					if err != nil {
+						lb.GetLogger().Warnf("Failed to decrypt API key %d: %v", key.ID, err)
+						continue
+					}
+
+					state := lb.GetKeyState(plaintext)
+					info := models.KeyStatusInfo{
+						KeyID:      key.ID,
+						KeyPreview: models.MaskAPIKey(plaintext),
+						Status:     state.Status.String(),
+						RateLimit:  lb.GetKeyRateLimit(plaintext),
+					}
+					if state.Status == core.KeyStatusCooldown {
+						unlock := state.UnlockTime
+						info.CooldownUntil = &unlock
+					}
+					entry.Keys = append(entry.Keys, info)
+				}
+				result = append(result, entry)
+			}
+		}
+
+		c.JSON(200, models.NewSuccessResponse("Key status retrieved successfully", result))
+	}
+}
+
+// handleRoutePreview 路由预览：返回给定模型名将命中的组、模型与 Key，不请求上游
+func handleRoutePreview(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Model string `json:"model" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, models.NewErrorResponse("Invalid request format: "+err.Error()))
+			return
+		}
+
+		routing, modelIndex, err := lb.PreviewRoute(req.Model)
+		if err != nil {
+			c.JSON(404, models.NewErrorResponse("No route for model: "+err.Error()))
+			return
+		}
+
+		c.JSON(200, models.NewSuccessResponse("Route preview generated successfully", gin.H{
+			"model":          req.Model,
+			"group_id":       routing.GroupID,
+			"pinned":         strings.Contains(req.Model, "$"),
+			"model_index":    modelIndex + 1, // 与 "group$index" 语法一致，从 1 开始
+			"model_id":       routing.ModelConfigID,
+			"provider":       routing.Provider,
+			"upstream_url":   routing.UpstreamURL,
+			"upstream_model": routing.UpstreamModel,
+			"key_preview":    models.MaskAPIKey(routing.APIKey),
+		}))
+	}
+}
+
+// handleDebugResolve 路由诊断：给定模型名，返回解析出的组、策略、组内模型及 Key 状态 (脱敏)
+func handleDebugResolve(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		model := c.Query("model")
+		if model == "" {
+			c.JSON(400, models.NewErrorResponse("Missing model parameter"))
+			return
+		}
+
+		resolution, err := lb.ResolveModel(model)
+		if err != nil {
+			c.JSON(404, models.NewErrorResponse("No route for model: "+err.Error()))
+			return
+		}
+		c.JSON(200, models.NewSuccessResponse("Model resolved successfully", resolution))
+	}
+}
+
 // handleStats 处理统计信息
-func handleStats(lb *core.LoadBalancer) gin.HandlerFunc {
+// stats_persistence 为 off 时附带内存中的模型统计 (数据库中没有这些统计)
+func handleStats(lb *core.LoadBalancer, proxyHandler *core.ProxyHandler) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		stats := lb.GetTotalStats()
+		if l := proxyHandler.RequestLogger(); l != nil && l.StatsInMemory() {
+			stats["model_stats"] = l.LiveStats()
+		}
+		stats["ttft"] = ttftStats(lb, proxyHandler.RequestLogger())
+		stats["embeddings"] = embeddingStats(lb, proxyHandler.RequestLogger())
+		stats["cache"] = cacheStats(lb, proxyHandler.RequestLogger())
 		c.JSON(200, models.NewSuccessResponse("Stats retrieved successfully", stats))
 	}
 }
 
+// ttftStats 按模型汇总流式请求的平均首字节耗时，统计只保存在内存中时读取内存统计
+func ttftStats(lb *core.LoadBalancer, l *core.AsyncRequestLogger) []models.TTFTStats {
+	result := []models.TTFTStats{}
+	add := func(configID, groupID uint, total float64, count int) {
+		if count > 0 {
+			result = append(result, models.TTFTStats{
+				ModelConfigID:  configID,
+				ModelGroupID:   groupID,
+				StreamRequests: count,
+				AvgTTFT:        total / float64(count),
+			})
+		}
+	}
+
+	if l != nil && l.StatsInMemory() {
+		for _, s := range l.LiveStats() {
+			add(s.ModelConfigID, s.ModelGroupID, s.TotalTTFT, s.TTFTCount)
+		}
+		return result
+	}
+
+	var rows []models.ModelStats
+	if err := lb.GetDB().Where("ttft_count > 0").Order("model_config_id").Find(&rows).Error; err != nil {
+		lb.GetLogger().Warnf("Failed to load TTFT stats: %v", err)
+	}
+	for _, s := range rows {
+		add(s.ModelConfigID, s.ModelGroupID, s.TotalTTFT, s.TTFTCount)
+	}
+	return result
+}
+
+// cacheStats 按模型汇总 Prompt 缓存读写的 Token 数，统计只保存在内存中时读取内存统计
+func cacheStats(lb *core.LoadBalancer, l *core.AsyncRequestLogger) []models.CacheStats {
+	result := []models.CacheStats{}
+	add := func(configID, groupID uint, read, write int64) {
+		if read > 0 || write > 0 {
+			result = append(result, models.CacheStats{
+				ModelConfigID:    configID,
+				ModelGroupID:     groupID,
+				CacheReadTokens:  read,
+				CacheWriteTokens: write,
+			})
+		}
+	}
+
+	if l != nil && l.StatsInMemory() {
+		for _, s := range l.LiveStats() {
+			add(s.ModelConfigID, s.ModelGroupID, s.CacheReadTokens, s.CacheWriteTokens)
+		}
+		return result
+	}
+
+	var rows []models.ModelStats
+	if err := lb.GetDB().Where("cache_read_tokens > 0 OR cache_write_tokens > 0").Order("model_config_id").Find(&rows).Error; err != nil {
+		lb.GetLogger().Warnf("Failed to load cache stats: %v", err)
+	}
+	for _, s := range rows {
+		add(s.ModelConfigID, s.ModelGroupID, s.CacheReadTokens, s.CacheWriteTokens)
+	}
+	return result
+}
+
+// embeddingStats 按模型汇总 Embedding 请求 (不计入 model_stats 中的聊天统计)，统计只保存在内存中时读取内存统计
+func embeddingStats(lb *core.LoadBalancer, l *core.AsyncRequestLogger) []models.EmbeddingStats {
+	result := []models.EmbeddingStats{}
+	add := func(configID, groupID uint, requests, errors int, latency float64) {
+		if requests > 0 {
+			result = append(result, models.EmbeddingStats{
+				ModelConfigID: configID,
+				ModelGroupID:  groupID,
+				Requests:      requests,
+				Errors:        errors,
+				AvgLatency:    latency / float64(requests),
+			})
+		}
+	}
+
+	if l != nil && l.StatsInMemory() {
+		for _, s := range l.LiveStats() {
+			add(s.ModelConfigID, s.ModelGroupID, s.EmbeddingRequests, s.EmbeddingErrors, s.EmbeddingLatency)
+		}
+		return result
+	}
+
+	var rows []models.ModelStats
+	if err := lb.GetDB().Where("embedding_requests > 0").Order("model_config_id").Find(&rows).Error; err != nil {
+		lb.GetLogger().Warnf("Failed to load embedding stats: %v", err)
+	}
+	for _, s := range rows {
+		add(s.ModelConfigID, s.ModelGroupID, s.EmbeddingRequests, s.EmbeddingErrors, s.EmbeddingLatency)
+	}
+	return result
+}
+
+// handleStatsTimeseries 按小时聚合 RequestLog 中的请求数与平均耗时 (在数据库中 GROUP BY，只读取各小时的汇总行)
+// 参数: hours (默认 24，最大 168)，group (可选，按模型组过滤)，type (可选，chat / embeddings)
+func handleStatsTimeseries(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		hours, _ := strconv.Atoi(c.DefaultQuery("hours", "24"))
+		if hours < 1 || hours > 168 {
+			hours = 24
+		}
+
+		end := time.Now().Truncate(time.Hour)
+		start := end.Add(-time.Duration(hours-1) * time.Hour)
+
+		// bucket 为自 Unix 纪元起的小时数，成功的判断与 RequestLog.IsSuccess 一致
+		query := lb.GetDB().Model(&models.RequestLog{}).
+			Select("CAST(strftime('%s', created_at) AS INTEGER) / 3600 AS bucket, " +
+				"COUNT(*) AS requests, " +
+				"SUM(CASE WHEN status_code >= 200 AND status_code < 500 AND status_code <> 429 THEN 1 ELSE 0 END) AS success, " +
+				"AVG(duration) AS avg_duration").
+			Where("created_at >= ?", start).
+			Group("bucket")
+		if group := c.Query("group"); group != "" {
+			query = query.Where("model_group = ?", group)
+		}
+		if requestType := c.Query("type"); requestType != "" {
+			query = query.Where("request_type = ?", requestType)
+		}
+
+		var rows []struct {
+			Bucket      int64
+			Requests    int
+			Success     int
+			AvgDuration float64
+		}
+		if err := query.Scan(&rows).Error; err != nil {
+			c.JSON(500, models.NewErrorResponse("Failed to query logs: "+err.Error()))
+			return
+		}
+
+		// 预先生成连续的时间桶，便于前端直接绘图
+		buckets := make([]models.StatsBucket, hours)
+		for i := range buckets {
+			buckets[i].Hour = start.Add(time.Duration(i) * time.Hour)
+		}
+		first := start.Unix() / 3600
+		for _, row := range rows {
+			idx := int(row.Bucket - first)
+			if idx < 0 || idx >= hours {
+				continue
+			}
+			buckets[idx].Requests = row.Requests
+			buckets[idx].Success = row.Success
+			buckets[idx].Error = row.Requests - row.Success
+			buckets[idx].AvgDuration = row.AvgDuration
+		}
+
+		c.JSON(200, models.NewSuccessResponse("Timeseries retrieved successfully", buckets))
+	}
+}
+
+// handleListStrategies 处理获取已注册的路由策略列表
+func handleListStrategies(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, models.NewSuccessResponse("Strategies retrieved successfully", lb.RegisteredStrategies()))
+	}
+}
+
 // handleReload 处理配置重载
 func handleReload(lb *core.LoadBalancer) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -760,8 +1983,12 @@ func handleGetRequestLogs(lb *core.LoadBalancer) gin.HandlerFunc {
 		var logs []models.RequestLog
 		var total int64
 
-		db := lb.GetDB()
-		if err := db.Model(&models.RequestLog{}).Count(&total).Error; err != nil {
+		db := lb.GetDB().Model(&models.RequestLog{})
+		// 按失败分类过滤 (network / timeout / auth / rate_limit / client / server)
+		if errorType := c.Query("error_type"); errorType != "" {
+			db = db.Where("error_type = ?", errorType)
+		}
+		if err := db.Count(&total).Error; err != nil {
 			c.JSON(500, models.NewErrorResponse("Failed to count logs: "+err.Error()))
 			return
 		}
@@ -780,10 +2007,75 @@ func handleGetRequestLogs(lb *core.LoadBalancer) gin.HandlerFunc {
 	}
 }
 
+// recordAudit 记录一条管理操作审计日志，操作者取自鉴权中间件；写入失败只记录警告，不影响操作结果
+func recordAudit(c *gin.Context, lb *core.LoadBalancer, action, target, detail string) {
+	entry := models.AuditLog{
+		AdminID:   c.GetUint("admin_id"),
+		AdminName: c.GetString("admin_name"),
+		AuthType:  c.GetString("admin_auth"),
+		Action:    action,
+		Target:    target,
+		Detail:    detail,
+		IP:        c.ClientIP(),
+	}
+	if err := lb.GetDB().Create(&entry).Error; err != nil {
+		lb.GetLogger().Warnf("Failed to write audit log | Action: %s | Target: %s | Error: %v", action, target, err)
+	}
+}
+
+// handleListAuditLogs 处理查询审计日志 (按时间倒序，支持 action、admin_name 过滤)
+func handleListAuditLogs(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if limit > 100 {
+			limit = 100
+		}
+		if limit < 1 {
+			limit = 50
+		}
+		if offset < 0 {
+			offset = 0
+		}
+
+		query := lb.GetDB().Model(&models.AuditLog{})
+		if action := c.Query("action"); action != "" {
+			query = query.Where("action = ?", action)
+		}
+		if name := c.Query("admin_name"); name != "" {
+			query = query.Where("admin_name = ?", name)
+		}
+
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
+			c.JSON(500, models.NewErrorResponse("Failed to count audit logs: "+err.Error()))
+			return
+		}
+
+		var logs []models.AuditLog
+		if err := query.Order("id desc").Limit(limit).Offset(offset).Find(&logs).Error; err != nil {
+			c.JSON(500, models.NewErrorResponse("Failed to query audit logs: "+err.Error()))
+			return
+		}
+
+		c.JSON(200, models.NewSuccessResponse("Audit logs retrieved successfully", gin.H{
+			"total":  total,
+			"limit":  limit,
+			"offset": offset,
+			"logs":   logs,
+		}))
+	}
+}
+
 // handleGetSystemLogs 处理获取系统文件日志
 func handleGetSystemLogs() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		content, err := os.ReadFile("gateway.log")
+		if logFilePath == "" {
+			c.JSON(200, models.NewSuccessResponse("File logging is disabled (LOG_FILE is empty)", gin.H{"logs": ""}))
+			return
+		}
+
+		content, err := os.ReadFile(logFilePath)
 		if err != nil {
 			// 如果文件不存在，返回空
 			c.JSON(200, models.NewSuccessResponse("System logs retrieved", gin.H{"logs": ""}))
@@ -803,7 +2095,7 @@ func handleGetSystemLogs() gin.HandlerFunc {
 }
 
 // handleListAdminKeys 处理列出所有管理员密钥
-func handleListAdminKeys() gin.HandlerFunc {
+func handleListAdminKeys(lb *core.LoadBalancer) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		db := c.MustGet("db").(*gorm.DB)
 
@@ -813,26 +2105,36 @@ func handleListAdminKeys() gin.HandlerFunc {
 			return
 		}
 
-		// 返回完整密钥信息（包含原始密钥和脱敏预览）
+		// 默认只返回脱敏预览，完整密钥仅在创建时返回；开启 reveal_admin_keys 时返回完整密钥
 		type AdminKeyResponse struct {
 			ID        uint   `json:"id"`
 			Name      string `json:"name"`
-			Key       string `json:"key"`          // 【修改】返回完整密钥
-			KeyPreview string `json:"key_preview"` // 保留脱敏预览用于显示
+			Key       string `json:"key,omitempty"`
+			KeyPreview string `json:"key_preview"`
+			AppName    string `json:"app_name,omitempty"`
+			AppReferer string `json:"app_referer,omitempty"`
+			IsAdmin    bool   `json:"is_admin"`
 			CreatedAt int64  `json:"created_at"`
 		}
 
+		reveal := false
+		if settings := lb.GetGatewaySettings(); settings != nil {
+			reveal = settings.RevealAdminKeys
+		}
 		response := make([]AdminKeyResponse, len(adminKeys))
 		for i, key := range adminKeys {
-			// 使用安全的密钥脱敏函数
-			keyPreview := safeMaskKey(key.Key)
 			response[i] = AdminKeyResponse{
 				ID:         key.ID,
 				Name:       key.Name,
-				Key:        key.Key,         // 【修改】返回完整密钥
-				KeyPreview: keyPreview,
+				KeyPreview: models.MaskAPIKey(key.Key),
+				AppName:    key.AppName,
+				AppReferer: key.AppReferer,
+				IsAdmin:    key.IsAdmin,
 				CreatedAt:  key.CreatedAt.Unix(),
 			}
+			if reveal {
+				response[i].Key = key.Key
+			}
 		}
 
 		c.JSON(200, models.NewSuccessResponse("Admin keys retrieved successfully", response))
@@ -840,18 +2142,29 @@ func handleListAdminKeys() gin.HandlerFunc {
 }
 
 // handleCreateAdminKey 处理创建新的管理员密钥
-func handleCreateAdminKey() gin.HandlerFunc {
+func handleCreateAdminKey(lb *core.LoadBalancer) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		db := c.MustGet("db").(*gorm.DB)
 
 		var request struct {
 			Name string `json:"name" binding:"required"`
+
+			// 可选的应用归属信息，转发给上游的 X-Title / HTTP-Referer 头
+			AppName    string `json:"app_name" binding:"max=200"`
+			AppReferer string `json:"app_referer" binding:"omitempty,url"`
+
+			// 管理员权限 (X-Upstream-Base 等运维请求头)，默认关闭
+			IsAdmin bool `json:"is_admin"`
 		}
 
 		if err := c.ShouldBindJSON(&request); err != nil {
 			c.JSON(400, models.NewErrorResponse("Invalid request format: "+err.Error()))
 			return
 		}
+		if request.IsAdmin && c.GetString("admin_scope") != core.ScopeAdmin {
+			c.JSON(403, models.NewErrorResponse("Only admin keys can grant is_admin"))
+			return
+		}
 
 		// 检查是否已有管理员密钥
 		var count int64
@@ -862,8 +2175,11 @@ func handleCreateAdminKey() gin.HandlerFunc {
 
 		// 创建新的管理员密钥
 		adminKey := models.AdminKey{
-			Name: request.Name,
-			Key:  models.GenerateAdminKey(),
+			Name:       request.Name,
+			Key:        models.GenerateAdminKey(),
+			AppName:    request.AppName,
+			AppReferer: request.AppReferer,
+			IsAdmin:    request.IsAdmin,
 		}
 
 		if err := db.Create(&adminKey).Error; err != nil {
@@ -876,16 +2192,175 @@ func handleCreateAdminKey() gin.HandlerFunc {
 			return
 		}
 
+		recordAudit(c, lb, "create_admin_key", fmt.Sprintf("admin_key:%d", adminKey.ID), fmt.Sprintf("name=%s is_admin=%t", adminKey.Name, adminKey.IsAdmin))
 		c.JSON(200, models.NewSuccessResponse("Admin key created successfully", gin.H{
-			"id":   adminKey.ID,
-			"name": adminKey.Name,
-			"key":  adminKey.Key, // 只在创建时返回完整的密钥
+			"id":       adminKey.ID,
+			"name":     adminKey.Name,
+			"key":      adminKey.Key, // 只在创建时返回完整的密钥
+			"is_admin": adminKey.IsAdmin,
+		}))
+	}
+}
+
+// handleUpdateAdminKey 处理更新管理员密钥的名称、应用归属信息 (传入空字符串清除) 与管理员权限
+func handleUpdateAdminKey(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := c.MustGet("db").(*gorm.DB)
+
+		id, err := parseAndValidateID(c.Param("id"), "admin key ID")
+		if err != nil {
+			c.JSON(400, models.NewErrorResponse(err.Error()))
+			return
+		}
+
+		var request struct {
+			Name       *string `json:"name" binding:"omitempty,min=1"`
+			AppName    *string `json:"app_name" binding:"omitempty,max=200"`
+			AppReferer *string `json:"app_referer" binding:"omitempty,url|len=0"`
+			IsAdmin    *bool   `json:"is_admin"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(400, models.NewErrorResponse("Invalid request format: "+err.Error()))
+			return
+		}
+		// 只有管理员密钥可以授予或撤销管理员权限 (防止普通密钥提升自身权限)
+		if request.IsAdmin != nil && c.GetString("admin_scope") != core.ScopeAdmin {
+			c.JSON(403, models.NewErrorResponse("Only admin keys can change is_admin"))
+			return
+		}
+
+		var adminKey models.AdminKey
+		if err := db.First(&adminKey, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(404, models.NewErrorResponse("Admin key not found"))
+				return
+			}
+			c.JSON(500, models.NewErrorResponse("Failed to query admin key: "+err.Error()))
+			return
+		}
+
+		updates := map[string]interface{}{}
+		if request.Name != nil {
+			updates["name"] = *request.Name
+		}
+		if request.AppName != nil {
+			updates["app_name"] = *request.AppName
+		}
+		if request.AppReferer != nil {
+			updates["app_referer"] = *request.AppReferer
+		}
+		if request.IsAdmin != nil {
+			updates["is_admin"] = *request.IsAdmin
+		}
+		if err := db.Model(&adminKey).Updates(updates).Error; err != nil {
+			c.JSON(500, models.NewErrorResponse("Failed to update admin key: "+err.Error()))
+			return
+		}
+		db.First(&adminKey, id)
+
+		recordAudit(c, lb, "update_admin_key", fmt.Sprintf("admin_key:%d", adminKey.ID), fmt.Sprintf("is_admin=%t", adminKey.IsAdmin))
+		c.JSON(200, models.NewSuccessResponse("Admin key updated successfully", gin.H{
+			"id":          adminKey.ID,
+			"name":        adminKey.Name,
+			"app_name":    adminKey.AppName,
+			"app_referer": adminKey.AppReferer,
+			"is_admin":    adminKey.IsAdmin,
+		}))
+	}
+}
+
+// handleGetAdminKeyGroups 处理查询管理员密钥可访问的组 (空列表表示不限制)
+func handleGetAdminKeyGroups(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := c.MustGet("db").(*gorm.DB)
+
+		id, err := parseAndValidateID(c.Param("id"), "admin key ID")
+		if err != nil {
+			c.JSON(400, models.NewErrorResponse(err.Error()))
+			return
+		}
+		if err := db.First(&models.AdminKey{}, id).Error; err != nil {
+			c.JSON(404, models.NewErrorResponse("Admin key not found"))
+			return
+		}
+
+		groups := []string{}
+		if err := db.Model(&models.AdminKeyGroup{}).Where("admin_key_id = ?", id).Order("group_id ASC").Pluck("group_id", &groups).Error; err != nil {
+			c.JSON(500, models.NewErrorResponse("Failed to query admin key groups: "+err.Error()))
+			return
+		}
+		c.JSON(200, models.NewSuccessResponse("Admin key groups retrieved successfully", gin.H{
+			"admin_key_id": id,
+			"group_ids":    groups,
+		}))
+	}
+}
+
+// handleSetAdminKeyGroups 处理设置管理员密钥可访问的组 (整体替换，传入空列表取消限制)
+func handleSetAdminKeyGroups(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := c.MustGet("db").(*gorm.DB)
+
+		id, err := parseAndValidateID(c.Param("id"), "admin key ID")
+		if err != nil {
+			c.JSON(400, models.NewErrorResponse(err.Error()))
+			return
+		}
+
+		var request struct {
+			GroupIDs []string `json:"group_ids" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(400, models.NewErrorResponse("Invalid request format: "+err.Error()))
+			return
+		}
+
+		var adminKey models.AdminKey
+		if err := db.First(&adminKey, id).Error; err != nil {
+			c.JSON(404, models.NewErrorResponse("Admin key not found"))
+			return
+		}
+
+		known := make(map[string]bool)
+		for _, g := range lb.GetAllModelGroups() {
+			known[g.GroupID] = true
+		}
+		groups := make([]string, 0, len(request.GroupIDs))
+		for _, groupID := range request.GroupIDs {
+			if !known[groupID] {
+				c.JSON(400, models.NewErrorResponse(fmt.Sprintf("Model group %q not found", groupID)))
+				return
+			}
+			if !slices.Contains(groups, groupID) {
+				groups = append(groups, groupID)
+			}
+		}
+
+		if err := withTransaction(db, func(tx *gorm.DB) error {
+			if err := tx.Where("admin_key_id = ?", adminKey.ID).Delete(&models.AdminKeyGroup{}).Error; err != nil {
+				return fmt.Errorf("failed to clear admin key groups: %w", err)
+			}
+			for _, groupID := range groups {
+				if err := tx.Create(&models.AdminKeyGroup{AdminKeyID: adminKey.ID, GroupID: groupID}).Error; err != nil {
+					return fmt.Errorf("failed to add group %s: %w", groupID, err)
+				}
+			}
+			return nil
+		}); err != nil {
+			c.JSON(500, models.NewErrorResponse(err.Error()))
+			return
+		}
+
+		recordAudit(c, lb, "set_admin_key_groups", fmt.Sprintf("admin_key:%d", adminKey.ID), "groups="+strings.Join(groups, ","))
+		c.JSON(200, models.NewSuccessResponse("Admin key groups updated successfully", gin.H{
+			"admin_key_id": adminKey.ID,
+			"group_ids":    groups,
 		}))
 	}
 }
 
 // handleDeleteAdminKey 处理删除管理员密钥
-func handleDeleteAdminKey() gin.HandlerFunc {
+func handleDeleteAdminKey(lb *core.LoadBalancer) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		db := c.MustGet("db").(*gorm.DB)
 
@@ -924,10 +2399,13 @@ func handleDeleteAdminKey() gin.HandlerFunc {
 				return fmt.Errorf("cannot delete the last admin key")
 			}
 
-			// 删除管理员密钥
+			// 删除管理员密钥及其组限制
 			if err := tx.Delete(&adminKey).Error; err != nil {
 				return fmt.Errorf("failed to delete admin key: %w", err)
 			}
+			if err := tx.Where("admin_key_id = ?", adminKey.ID).Delete(&models.AdminKeyGroup{}).Error; err != nil {
+				return fmt.Errorf("failed to delete admin key groups: %w", err)
+			}
 
 			return nil
 		}); err != nil {
@@ -938,6 +2416,7 @@ func handleDeleteAdminKey() gin.HandlerFunc {
 			c.JSON(status, models.NewErrorResponse(err.Error()))
 			return
 		}
+		recordAudit(c, lb, "delete_admin_key", fmt.Sprintf("admin_key:%d", adminKey.ID), "name="+adminKey.Name)
 		c.JSON(200, models.NewSuccessResponse("Admin key deleted successfully", gin.H{
 			"id":   adminKey.ID,
 			"name": adminKey.Name,
@@ -945,6 +2424,70 @@ func handleDeleteAdminKey() gin.HandlerFunc {
 	}
 }
 
+// handleGetModel 处理 OpenAI 兼容的模型详情查询 (GET /v1/models/*id)
+// id 可以是模型组 ID 或上游模型名；组的 owned_by 为其模型共同的提供商，混合提供商时为 "llm-gateway"
+// capabilities 为模型支持的请求特性，组为其模型能力的并集；别名按其路由到的组返回
+// 密钥限定了可访问的组时，其他组及其上游模型按不存在处理
+func handleGetModel(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := strings.TrimPrefix(c.Param("id"), "/")
+		groupID := id
+		if target, ok := lb.AliasGroup(id); ok {
+			groupID = target
+		}
+
+		allowed := c.GetStringSlice("admin_groups")
+		var groups []models.ModelGroup
+		for _, g := range lb.GetAllModelGroups() {
+			if len(allowed) == 0 || slices.Contains(allowed, g.GroupID) {
+				groups = append(groups, g)
+			}
+		}
+		sort.Slice(groups, func(i, j int) bool { return groups[i].GroupID < groups[j].GroupID })
+
+		for _, g := range groups {
+			if g.GroupID != groupID {
+				continue
+			}
+			ownedBy := ""
+			var caps models.Capabilities
+			for i := range g.Models {
+				caps = caps.Union(core.ModelCapabilities(&g.Models[i]))
+			}
+			for _, m := range g.Models {
+				if ownedBy == "" {
+					ownedBy = m.ProviderName
+				} else if ownedBy != m.ProviderName {
+					ownedBy = "llm-gateway"
+					break
+				}
+			}
+			if ownedBy == "" {
+				ownedBy = "llm-gateway"
+			}
+			c.JSON(200, models.OpenAIModel{ID: id, Object: "model", Created: g.CreatedAt.Unix(), OwnedBy: ownedBy, Capabilities: &caps})
+			return
+		}
+
+		for _, g := range groups {
+			for _, m := range g.Models {
+				if m.UpstreamModel == id {
+					caps := core.ModelCapabilities(&m)
+					c.JSON(200, models.OpenAIModel{ID: id, Object: "model", Created: m.CreatedAt.Unix(), OwnedBy: m.ProviderName, Capabilities: &caps})
+					return
+				}
+			}
+		}
+
+		c.JSON(404, models.ErrorResponse{Error: models.ErrorDetail{
+			Message: fmt.Sprintf("The model '%s' does not exist", id),
+			Type:    "invalid_request_error",
+			Param:   "model",
+			Code:    "model_not_found",
+		}})
+	}
+}
+
 // Helper functions
 
 func getGroupIDs(lb *core.LoadBalancer) []string {
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"llm-gateway/core"
+	"llm-gateway/models"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleValidateConfig 校验一份完整的配置 (组、模型、Key、别名)，不写入数据库
+// 配置本身有问题时仍返回 200，问题列表在 problems 中；请求体无法解析时返回 400
+func handleValidateConfig(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.ConfigValidateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, models.NewErrorResponse("Invalid request format: "+err.Error()))
+			return
+		}
+
+		problems := validateConfig(lb, req)
+		result := models.ConfigValidateResponse{Valid: true, Problems: problems}
+		for _, p := range problems {
+			if p.Severity == "error" {
+				result.Valid = false
+				break
+			}
+		}
+		c.JSON(200, models.NewSuccessResponse("Config validated", result))
+	}
+}
+
+// configProblems 收集校验问题
+type configProblems []models.ConfigProblem
+
+func (p *configProblems) errorf(path, format string, args ...interface{}) {
+	*p = append(*p, models.ConfigProblem{Path: path, Severity: "error", Message: fmt.Sprintf(format, args...)})
+}
+
+func (p *configProblems) warnf(path, format string, args ...interface{}) {
+	*p = append(*p, models.ConfigProblem{Path: path, Severity: "warning", Message: fmt.Sprintf(format, args...)})
+}
+
+// validateConfig 按创建/更新接口的规则校验配置，并检查组 ID 重复、空 Key 列表、引用不存在的组等跨对象问题
+func validateConfig(lb *core.LoadBalancer, cfg models.ConfigValidateRequest) []models.ConfigProblem {
+	problems := configProblems{}
+
+	groupModels := make(map[string]int, len(cfg.Groups)) // group_id -> 模型数量
+	for i, group := range cfg.Groups {
+		path := fmt.Sprintf("groups[%d]", i)
+		switch _, dup := groupModels[group.GroupID]; {
+		case group.GroupID == "":
+			problems.errorf(path+".group_id", "group_id is required")
+		case dup:
+			problems.errorf(path+".group_id", "duplicate group_id %q", group.GroupID)
+		default:
+			groupModels[group.GroupID] = len(group.Models)
+		}
+	}
+
+	for i, group := range cfg.Groups {
+		path := fmt.Sprintf("groups[%d]", i)
+		if group.Strategy != "" {
+			if err := validateStrategy(lb, group.Strategy); err != nil {
+				problems.errorf(path+".strategy", "%v", err)
+			}
+		}
+		if group.RaceCount < 0 || group.RaceCount > 10 {
+			problems.errorf(path+".race_count", "race_count must be between 1 and 10")
+		}
+		if group.MaxRetries < 0 || group.MaxRetries > 50 {
+			problems.errorf(path+".max_retries", "max_retries must be between 0 and 50")
+		}
+		if group.ErrorRateThreshold < 0 || group.ErrorRateThreshold > 1 {
+			problems.errorf(path+".error_rate_threshold", "error_rate_threshold must be between 0 and 1")
+		}
+		if a := group.UnhealthyAction; a != "" && a != "deprioritize" && a != "skip" {
+			problems.errorf(path+".unhealthy_action", "unhealthy_action must be deprioritize or skip")
+		}
+		if group.MaxMessages < 0 {
+			problems.errorf(path+".max_messages", "max_messages must not be negative")
+		}
+		if group.MaxContextChars < 0 {
+			problems.errorf(path+".max_context_chars", "max_context_chars must not be negative")
+		}
+		if group.RequestsPerMinute < 0 {
+			problems.errorf(path+".requests_per_minute", "requests_per_minute must not be negative")
+		}
+		if group.TokensPerMinute < 0 {
+			problems.errorf(path+".tokens_per_minute", "tokens_per_minute must not be negative")
+		}
+		if err := validateOnExhausted(group.OnExhausted, group.GroupID); err != nil {
+			problems.errorf(path+".on_exhausted", "%v", err)
+		} else if target, ok := strings.CutPrefix(group.OnExhausted, core.ExhaustedFallbackPrefix); ok {
+			if _, exists := groupModels[target]; !exists {
+				problems.errorf(path+".on_exhausted", "fallback group %q is not defined in this config", target)
+			}
+		}
+		if group.OnExhausted == core.ExhaustedStaticMessage && group.ExhaustedMessage == "" {
+			problems.warnf(path+".exhausted_message", "static_message policy with an empty exhausted_message")
+		}
+
+		if len(group.Models) == 0 {
+			problems.warnf(path+".models", "group has no models, requests to it will fail")
+		}
+		for j, model := range group.Models {
+			validateConfigModel(&problems, fmt.Sprintf("%s.models[%d]", path, j), model)
+		}
+	}
+
+	aliases := make(map[string]bool, len(cfg.Aliases))
+	for i, alias := range cfg.Aliases {
+		path := fmt.Sprintf("aliases[%d]", i)
+		switch {
+		case alias.Alias == "":
+			problems.errorf(path+".alias", "alias is required")
+		case aliases[alias.Alias]:
+			problems.errorf(path+".alias", "duplicate alias %q", alias.Alias)
+		}
+		aliases[alias.Alias] = true
+
+		groupID, index, pinned := strings.Cut(alias.Target, "$")
+		count, exists := groupModels[groupID]
+		if !exists {
+			problems.errorf(path+".target", "target group %q is not defined in this config", groupID)
+			continue
+		}
+		if pinned {
+			if n, err := strconv.Atoi(index); err != nil || n < 1 {
+				problems.errorf(path+".target", "invalid model index in target: %s", alias.Target)
+			} else if n > count {
+				problems.errorf(path+".target", "model index %d out of bounds for group %s", n, groupID)
+			}
+		}
+	}
+
+	return problems
+}
+
+// validateConfigModel 校验单个模型及其 Key
+func validateConfigModel(problems *configProblems, path string, model models.ModelConfig) {
+	if model.ProviderName == "" {
+		problems.errorf(path+".provider_name", "provider_name is required")
+	} else if !core.KnownProvider(model.ProviderName) {
+		problems.warnf(path+".provider_name", "unknown provider %q, it will be called as an OpenAI-compatible API", model.ProviderName)
+	}
+	if u, err := url.Parse(model.UpstreamURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems.errorf(path+".upstream_url", "invalid upstream_url %q, expected an absolute http(s) URL", model.UpstreamURL)
+	}
+	if model.UpstreamModel == "" {
+		problems.errorf(path+".upstream_model", "upstream_model is required")
+	}
+	if model.Timeout < 0 || model.Timeout > 300 {
+		problems.errorf(path+".timeout", "timeout must be between 1 and 300 seconds")
+	}
+	if _, err := models.ParseRequestOverrides(model.RequestOverrides); err != nil {
+		problems.errorf(path+".request_overrides", "invalid request_overrides: %v", err)
+	}
+	if _, err := models.ParseGoogleSearchConfig(model.GoogleSearch); err != nil {
+		problems.errorf(path+".google_search", "invalid google_search: %v", err)
+	}
+	if _, err := models.ParseCapabilityOverrides(model.Capabilities); err != nil {
+		problems.errorf(path+".capabilities", "invalid capabilities: %v", err)
+	}
+
+	if len(model.APIKeys) == 0 {
+		problems.errorf(path+".api_keys", "model has no API keys")
+	}
+	seen := make(map[string]bool, len(model.APIKeys))
+	for k, key := range model.APIKeys {
+		keyPath := fmt.Sprintf("%s.api_keys[%d]", path, k)
+		value := strings.TrimSpace(key.KeyValue)
+		switch {
+		case value == "":
+			problems.errorf(keyPath+".key_value", "API key is empty")
+		case seen[value]:
+			problems.warnf(keyPath+".key_value", "duplicate API key %s", models.MaskAPIKey(value))
+		}
+		seen[value] = true
+	}
+}
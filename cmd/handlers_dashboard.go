@@ -1,8 +1,67 @@
 package main
 
 import (
-	_ "embed"
+	"embed"
+	"mime"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
 )
 
 //go:embed templates/dashboard.html
 var DashboardHTML string
+
+// 离线资源: 构建前执行 go generate ./cmd 下载到 assets/，随二进制一起内嵌
+//go:generate curl -fsSL -o assets/tailwindcss.js https://cdn.tailwindcss.com
+//go:generate curl -fsSL -o assets/lucide.min.js https://unpkg.com/lucide@latest/dist/umd/lucide.min.js
+
+//go:embed assets
+var dashboardAssets embed.FS
+
+// dashboardCDNAssets 仪表板引用的 CDN 脚本 -> 内嵌资源文件名
+var dashboardCDNAssets = map[string]string{
+	"https://cdn.tailwindcss.com":     "tailwindcss.js",
+	"https://unpkg.com/lucide@latest": "lucide.min.js",
+}
+
+// googleFontsLink 匹配 Google Fonts 的 preconnect 与样式表标签
+var googleFontsLink = regexp.MustCompile(`\s*<link[^>]*fonts\.(googleapis|gstatic)\.com[^>]*>`)
+
+// offlineDashboardHTML 将 CDN 脚本地址改写为内嵌资源地址，并移除 Google Fonts (回退到系统字体)
+func offlineDashboardHTML(html, basePath string) string {
+	for url, name := range dashboardCDNAssets {
+		html = strings.ReplaceAll(html, `"`+url+`"`, `"`+basePath+"/dashboard/assets/"+name+`"`)
+	}
+	return googleFontsLink.ReplaceAllString(html, "")
+}
+
+// missingDashboardAssets 返回未内嵌的离线资源 (未执行 go generate 时)
+func missingDashboardAssets() []string {
+	var missing []string
+	for _, name := range dashboardCDNAssets {
+		if _, err := dashboardAssets.Open("assets/" + name); err != nil {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// handleDashboardAsset 提供内嵌的仪表板静态资源，只允许 dashboardCDNAssets 中的文件
+func handleDashboardAsset() gin.HandlerFunc {
+	allowed := make(map[string]bool, len(dashboardCDNAssets))
+	for _, name := range dashboardCDNAssets {
+		allowed[name] = true
+	}
+	return func(c *gin.Context) {
+		name := c.Param("name")
+		data, err := dashboardAssets.ReadFile("assets/" + name)
+		if !allowed[name] || err != nil {
+			c.Status(404)
+			return
+		}
+		c.Header("Cache-Control", "public, max-age=86400")
+		c.Data(200, mime.TypeByExtension(path.Ext(name)), data)
+	}
+}
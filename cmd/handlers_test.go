@@ -0,0 +1,1169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"llm-gateway/core"
+	"llm-gateway/models"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// newTestLoadBalancer 创建基于独立内存数据库的 LoadBalancer
+func newTestLoadBalancer(t *testing.T) *core.LoadBalancer {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	lb, err := core.NewLoadBalancer(db, logger, core.NewKeyStateManager(), core.NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	return lb
+}
+
+func doJSON(engine *gin.Engine, method, path string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+func TestCreateModelGroup_RejectsUnknownStrategy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lb := newTestLoadBalancer(t)
+
+	engine := gin.New()
+	engine.POST("/admin/model-groups", handleCreateModelGroup(lb))
+	engine.PUT("/admin/model-groups/:group_id", handleUpdateModelGroup(lb))
+
+	w := doJSON(engine, http.MethodPost, "/admin/model-groups", gin.H{"group_id": "g1", "strategy": "bogus"})
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "round_robin")
+
+	w = doJSON(engine, http.MethodPost, "/admin/model-groups", gin.H{"group_id": "g1", "strategy": "round_robin"})
+	assert.Equal(t, 200, w.Code)
+
+	w = doJSON(engine, http.MethodPut, "/admin/model-groups/g1", gin.H{"strategy": "bogus"})
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestCreateModelGroup_RestoreSoftDeleted(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lb := newTestLoadBalancer(t)
+	db := lb.GetDB()
+	group := models.ModelGroup{GroupID: "g1", Strategy: "race", RaceCount: 3, MaxRetries: 8}
+	db.Create(&group)
+	db.Delete(&group)
+
+	engine := gin.New()
+	engine.POST("/admin/model-groups", handleCreateModelGroup(lb))
+
+	w := doJSON(engine, http.MethodPost, "/admin/model-groups", gin.H{"group_id": "g2", "strategy": "race", "race_count": 11})
+	assert.Equal(t, 400, w.Code)
+	w = doJSON(engine, http.MethodPost, "/admin/model-groups", gin.H{"group_id": "g2", "max_retries": 51})
+	assert.Equal(t, 400, w.Code)
+
+	// 恢复时使用新请求的配置，而不是删除前的值
+	w = doJSON(engine, http.MethodPost, "/admin/model-groups", gin.H{"group_id": "g1", "strategy": "race", "race_count": 5, "max_retries": 2})
+	assert.Equal(t, 200, w.Code)
+	var restored models.ModelGroup
+	assert.NoError(t, db.Where("group_id = ?", "g1").First(&restored).Error)
+	assert.Equal(t, group.ID, restored.ID)
+	assert.Equal(t, 5, restored.RaceCount)
+	assert.Equal(t, 2, restored.MaxRetries)
+}
+
+func TestUpdateModelGroup_PartialUpdate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lb := newTestLoadBalancer(t)
+	lb.GetDB().Create(&models.ModelGroup{GroupID: "g1", Strategy: "round_robin", RaceCount: 3, MaxRetries: 2})
+
+	engine := gin.New()
+	engine.PUT("/admin/model-groups/:group_id", handleUpdateModelGroup(lb))
+	get := func() models.ModelGroup {
+		var group models.ModelGroup
+		assert.NoError(t, lb.GetDB().Where("group_id = ?", "g1").First(&group).Error)
+		return group
+	}
+
+	// 只传入部分字段时，未传入的字段 (包括 strategy) 保持不变
+	w := doJSON(engine, http.MethodPut, "/admin/model-groups/g1", gin.H{"tags": []string{"env:prod"}})
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"strategy":"round_robin"`)
+	group := get()
+	assert.Equal(t, "round_robin", group.Strategy)
+	assert.Equal(t, []string{"env:prod"}, group.Tags)
+	assert.Equal(t, 3, group.RaceCount)
+
+	assert.Equal(t, 200, doJSON(engine, http.MethodPut, "/admin/model-groups/g1", gin.H{"max_retries": 5}).Code)
+	group = get()
+	assert.Equal(t, 5, group.MaxRetries)
+	assert.Equal(t, "round_robin", group.Strategy)
+
+	assert.Equal(t, 200, doJSON(engine, http.MethodPut, "/admin/model-groups/g1", gin.H{}).Code)
+	assert.Equal(t, 400, doJSON(engine, http.MethodPut, "/admin/model-groups/g1", gin.H{"strategy": ""}).Code)
+
+	assert.Equal(t, 200, doJSON(engine, http.MethodPut, "/admin/model-groups/g1", gin.H{"strategy": "fallback"}).Code)
+	group = get()
+	assert.Equal(t, "fallback", group.Strategy)
+	assert.Equal(t, 5, group.MaxRetries)
+}
+
+func TestReactivateAPIKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	base := newTestLoadBalancer(t)
+	db := base.GetDB()
+
+	group := models.ModelGroup{GroupID: "g1", Strategy: "round_robin"}
+	db.Create(&group)
+	model := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: "https://api.openai.com", ModelGroupID: group.ID}
+	db.Create(&model)
+	dead := models.APIKey{KeyValue: "sk-dead", ModelConfigID: model.ID}
+	db.Create(&dead)
+	cooling := models.APIKey{KeyValue: "sk-cooling", ModelConfigID: model.ID}
+	db.Create(&cooling)
+
+	// 使用可直接标记 Key 状态的 KeyStateManager
+	km := core.NewKeyStateManager()
+	lb, err := core.NewLoadBalancer(db, base.GetLogger(), km, core.NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	km.MarkDead("sk-dead")
+	km.MarkCooldown("sk-cooling", time.Hour)
+
+	engine := gin.New()
+	engine.POST("/admin/keys/:key_id/reactivate", handleReactivateAPIKey(lb))
+
+	w := doJSON(engine, http.MethodPost, "/admin/keys/999/reactivate", nil)
+	assert.Equal(t, 404, w.Code)
+
+	// 两个 Key 都不可用时无法路由
+	_, err = lb.Route("g1")
+	assert.Error(t, err)
+
+	w = doJSON(engine, http.MethodPost, fmt.Sprintf("/admin/keys/%d/reactivate", dead.ID), nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"previous_status":"dead"`)
+	assert.Contains(t, w.Body.String(), `"status":"available"`)
+	assert.Equal(t, core.KeyStatusAvailable, lb.GetKeyState("sk-dead").Status)
+
+	w = doJSON(engine, http.MethodPost, fmt.Sprintf("/admin/keys/%d/reactivate", cooling.ID), nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"previous_status":"cooldown"`)
+	assert.Equal(t, core.KeyStatusAvailable, lb.GetKeyState("sk-cooling").Status)
+
+	// 恢复后重新参与路由
+	routing, err := lb.Route("g1")
+	assert.NoError(t, err)
+	assert.Contains(t, []string{"sk-dead", "sk-cooling"}, routing.APIKey)
+}
+
+func TestHealth_DeepCheck(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lb := newTestLoadBalancer(t)
+
+	engine := gin.New()
+	engine.GET("/health", handleHealth(lb, nil))
+
+	// 存活检查始终返回 200
+	w := doJSON(engine, http.MethodGet, "/health", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.NotContains(t, w.Body.String(), "checks")
+
+	// 没有任何可用 Key 时深度检查返回 503
+	w = doJSON(engine, http.MethodGet, "/health?deep=true", nil)
+	assert.Equal(t, 503, w.Code)
+	assert.Contains(t, w.Body.String(), `"servable_groups":0`)
+
+	group := models.ModelGroup{GroupID: "g1", Strategy: "round_robin"}
+	lb.GetDB().Create(&group)
+	model := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: "https://api.openai.com", ModelGroupID: group.ID}
+	lb.GetDB().Create(&model)
+	lb.GetDB().Create(&models.APIKey{KeyValue: "sk-ok", ModelConfigID: model.ID})
+	assert.NoError(t, lb.RefreshData())
+
+	w = doJSON(engine, http.MethodGet, "/health?deep=true", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"database":"ok"`)
+	assert.Contains(t, w.Body.String(), `"servable_groups":1`)
+}
+
+func TestStatsTimeseries(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lb := newTestLoadBalancer(t)
+
+	now := time.Now()
+	lb.GetDB().Create(&[]models.RequestLog{
+		{CreatedAt: now, StatusCode: 200, ModelGroup: "g1", Duration: 100},
+		{CreatedAt: now, StatusCode: 502, ModelGroup: "g1", Duration: 300},
+		{CreatedAt: now.Add(-2 * time.Hour), StatusCode: 200, ModelGroup: "g2"},
+		{CreatedAt: now.Add(-48 * time.Hour), StatusCode: 200, ModelGroup: "g1"},
+		{CreatedAt: now.Add(-2 * time.Hour), StatusCode: 200, ModelGroup: "g2", RequestType: models.RequestTypeEmbeddings},
+	})
+
+	engine := gin.New()
+	engine.GET("/admin/stats/timeseries", handleStatsTimeseries(lb))
+
+	var resp struct {
+		Data []models.StatsBucket `json:"data"`
+	}
+	w := doJSON(engine, http.MethodGet, "/admin/stats/timeseries?hours=3", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Data, 3)
+	assert.Equal(t, 2, resp.Data[0].Requests)
+	assert.Equal(t, 0, resp.Data[1].Requests)
+	assert.Equal(t, 2, resp.Data[2].Requests)
+	assert.Equal(t, 1, resp.Data[2].Success)
+	assert.Equal(t, 1, resp.Data[2].Error)
+	assert.Equal(t, 200.0, resp.Data[2].AvgDuration)
+
+	w = doJSON(engine, http.MethodGet, "/admin/stats/timeseries?hours=3&group=g1", nil)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 0, resp.Data[0].Requests)
+	assert.Equal(t, 2, resp.Data[2].Requests)
+
+	// 按请求类型过滤
+	w = doJSON(engine, http.MethodGet, "/admin/stats/timeseries?hours=3&type=embeddings", nil)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 1, resp.Data[0].Requests)
+	assert.Equal(t, 0, resp.Data[2].Requests)
+}
+
+func TestStats_CacheAndEmbeddings(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lb := newTestLoadBalancer(t)
+	lb.GetDB().Create(&[]models.ModelStats{
+		{ModelConfigID: 1, ModelGroupID: 1, RequestCount: 2, CacheReadTokens: 300, CacheWriteTokens: 50},
+		{ModelConfigID: 2, ModelGroupID: 1, EmbeddingRequests: 4, EmbeddingErrors: 1, EmbeddingLatency: 80},
+	})
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	engine := gin.New()
+	engine.GET("/admin/stats", handleStats(lb, core.NewProxyHandler(lb, &http.Client{}, logger, nil)))
+
+	var resp struct {
+		Data struct {
+			Cache      []models.CacheStats     `json:"cache"`
+			Embeddings []models.EmbeddingStats `json:"embeddings"`
+		} `json:"data"`
+	}
+	w := doJSON(engine, http.MethodGet, "/admin/stats", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, []models.CacheStats{{ModelConfigID: 1, ModelGroupID: 1, CacheReadTokens: 300, CacheWriteTokens: 50}}, resp.Data.Cache)
+	assert.Equal(t, []models.EmbeddingStats{{ModelConfigID: 2, ModelGroupID: 1, Requests: 4, Errors: 1, AvgLatency: 20}}, resp.Data.Embeddings)
+}
+
+func TestSetupRoutes_BasePath(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lb := newTestLoadBalancer(t)
+
+	basePath, err := normalizeBasePath(" llm/ ")
+	assert.NoError(t, err)
+	assert.Equal(t, "/llm", basePath)
+	_, err = normalizeBasePath("/llm?x=1")
+	assert.Error(t, err)
+
+	engine := gin.New()
+	setupRoutes(engine.Group(basePath), lb, nil, false)
+
+	assert.Equal(t, 200, doJSON(engine, http.MethodGet, "/llm/health", nil).Code)
+	assert.Equal(t, 404, doJSON(engine, http.MethodGet, "/health", nil).Code)
+	assert.Equal(t, 401, doJSON(engine, http.MethodGet, "/llm/admin/stats", nil).Code)
+
+	w := doJSON(engine, http.MethodGet, "/llm/", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"chat":"/llm/v1/chat/completions"`)
+
+	w = doJSON(engine, http.MethodGet, "/llm/dashboard", nil)
+	assert.Contains(t, w.Body.String(), `const BASE_PATH = '/llm';`)
+
+	// 未配置前缀时路由保持不变
+	plain := gin.New()
+	setupRoutes(plain.Group(""), lb, nil, false)
+	assert.Equal(t, 200, doJSON(plain, http.MethodGet, "/health", nil).Code)
+	assert.Contains(t, doJSON(plain, http.MethodGet, "/dashboard", nil).Body.String(), `const BASE_PATH = '';`)
+}
+
+func TestDashboardOfflineAssets(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lb := newTestLoadBalancer(t)
+
+	engine := gin.New()
+	setupRoutes(engine.Group("/llm"), lb, nil, true)
+
+	body := doJSON(engine, http.MethodGet, "/llm/dashboard", nil).Body.String()
+	assert.Contains(t, body, `src="/llm/dashboard/assets/tailwindcss.js"`)
+	assert.Contains(t, body, `src="/llm/dashboard/assets/lucide.min.js"`)
+	assert.NotContains(t, body, "cdn.tailwindcss.com")
+	assert.NotContains(t, body, "unpkg.com")
+	assert.NotContains(t, body, "fonts.googleapis.com")
+
+	// 只提供白名单内的资源
+	assert.Equal(t, 404, doJSON(engine, http.MethodGet, "/llm/dashboard/assets/README.md", nil).Code)
+	for _, name := range dashboardCDNAssets {
+		w := doJSON(engine, http.MethodGet, "/llm/dashboard/assets/"+name, nil)
+		if slices.Contains(missingDashboardAssets(), name) {
+			assert.Equal(t, 404, w.Code)
+		} else {
+			assert.Equal(t, 200, w.Code)
+			assert.Contains(t, w.Header().Get("Content-Type"), "javascript")
+		}
+	}
+}
+
+func TestGetModel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lb := newTestLoadBalancer(t)
+
+	group := models.ModelGroup{GroupID: "mixed", Strategy: "round_robin"}
+	lb.GetDB().Create(&group)
+	lb.GetDB().Create(&models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4o", UpstreamURL: "https://api.openai.com/v1", ModelGroupID: group.ID})
+	lb.GetDB().Create(&models.ModelConfig{ProviderName: "claude", UpstreamModel: "meta/claude-3", UpstreamURL: "https://api.anthropic.com/v1", ModelGroupID: group.ID})
+	single := models.ModelGroup{GroupID: "gpt", Strategy: "round_robin"}
+	lb.GetDB().Create(&single)
+	lb.GetDB().Create(&models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4o-mini", UpstreamURL: "https://api.openai.com/v1", ModelGroupID: single.ID, Capabilities: `{"vision":false}`})
+	assert.NoError(t, lb.RefreshData())
+
+	engine := gin.New()
+	engine.GET("/v1/models/*id", handleGetModel(lb))
+
+	get := func(id string) (int, models.OpenAIModel) {
+		w := doJSON(engine, http.MethodGet, "/v1/models/"+id, nil)
+		var m models.OpenAIModel
+		json.Unmarshal(w.Body.Bytes(), &m)
+		return w.Code, m
+	}
+
+	code, m := get("gpt")
+	assert.Equal(t, 200, code)
+	textOnly := models.Capabilities{Tools: true, JSONSchema: true, Streaming: true}
+	assert.Equal(t, models.OpenAIModel{ID: "gpt", Object: "model", Created: single.CreatedAt.Unix(), OwnedBy: "openai", Capabilities: &textOnly}, m)
+
+	// 组能力为其模型能力的并集
+	_, m = get("mixed")
+	assert.Equal(t, "llm-gateway", m.OwnedBy)
+	assert.True(t, m.Capabilities.Vision)
+
+	code, m = get("meta/claude-3")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "meta/claude-3", m.ID)
+	assert.Equal(t, "claude", m.OwnedBy)
+	assert.NotZero(t, m.Created)
+
+	w := doJSON(engine, http.MethodGet, "/v1/models/unknown", nil)
+	assert.Equal(t, 404, w.Code)
+	assert.Contains(t, w.Body.String(), "model_not_found")
+
+	// 别名按路由目标组返回
+	lb.GetDB().Create(&models.ModelAlias{Alias: "fast", Target: "gpt$1"})
+	assert.NoError(t, lb.RefreshData())
+	code, m = get("fast")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "fast", m.ID)
+	assert.Equal(t, "openai", m.OwnedBy)
+
+	// 限定了组的密钥看不到其他组及其上游模型
+	scoped := gin.New()
+	scoped.GET("/v1/models/*id", func(c *gin.Context) {
+		c.Set("admin_groups", []string{"gpt"})
+	}, handleGetModel(lb))
+	for id, want := range map[string]int{"gpt": 200, "fast": 200, "gpt-4o-mini": 200, "mixed": 404, "meta/claude-3": 404} {
+		w = doJSON(scoped, http.MethodGet, "/v1/models/"+id, nil)
+		assert.Equal(t, want, w.Code, id)
+	}
+}
+
+func TestModelAliases(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lb := newTestLoadBalancer(t)
+
+	group := models.ModelGroup{GroupID: "openai-pool", Strategy: "round_robin"}
+	lb.GetDB().Create(&group)
+	for _, name := range []string{"gpt-4o", "gpt-4"} {
+		m := models.ModelConfig{ProviderName: "openai", UpstreamModel: name, UpstreamURL: "https://api.openai.com", ModelGroupID: group.ID}
+		lb.GetDB().Create(&m)
+		lb.GetDB().Create(&models.APIKey{KeyValue: "sk-" + name, ModelConfigID: m.ID})
+	}
+	assert.NoError(t, lb.RefreshData())
+
+	engine := gin.New()
+	engine.GET("/admin/model-aliases", handleListModelAliases(lb))
+	engine.POST("/admin/model-aliases", handleCreateModelAlias(lb))
+	engine.PUT("/admin/model-aliases/:alias", handleUpdateModelAlias(lb))
+	engine.DELETE("/admin/model-aliases/:alias", handleDeleteModelAlias(lb))
+
+	// 目标组必须存在
+	w := doJSON(engine, http.MethodPost, "/admin/model-aliases", gin.H{"alias": "gpt4", "target": "missing$1"})
+	assert.Equal(t, 400, w.Code)
+
+	w = doJSON(engine, http.MethodPost, "/admin/model-aliases", gin.H{"alias": "gpt4", "target": "openai-pool$2"})
+	assert.Equal(t, 200, w.Code)
+	w = doJSON(engine, http.MethodPost, "/admin/model-aliases", gin.H{"alias": "gpt4", "target": "openai-pool"})
+	assert.Equal(t, 400, w.Code)
+
+	routing, _, err := lb.PreviewRoute("gpt4")
+	assert.NoError(t, err)
+	assert.Equal(t, "gpt-4", routing.UpstreamModel)
+
+	w = doJSON(engine, http.MethodPut, "/admin/model-aliases/gpt4", gin.H{"target": "openai-pool$1"})
+	assert.Equal(t, 200, w.Code)
+	routing, _, err = lb.PreviewRoute("gpt4")
+	assert.NoError(t, err)
+	assert.Equal(t, "gpt-4o", routing.UpstreamModel)
+
+	w = doJSON(engine, http.MethodGet, "/admin/model-aliases", nil)
+	assert.Contains(t, w.Body.String(), `"target":"openai-pool$1"`)
+
+	w = doJSON(engine, http.MethodDelete, "/admin/model-aliases/gpt4", nil)
+	assert.Equal(t, 200, w.Code)
+	_, _, err = lb.PreviewRoute("gpt4")
+	assert.ErrorIs(t, err, core.ErrGroupNotFound)
+	assert.Equal(t, 404, doJSON(engine, http.MethodDelete, "/admin/model-aliases/gpt4", nil).Code)
+}
+
+func TestDebugResolve(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	group := models.ModelGroup{GroupID: "debug-pool", Strategy: "fallback"}
+	db.Create(&group)
+	m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4o", UpstreamURL: "https://api.openai.com", ModelGroupID: group.ID}
+	db.Create(&m)
+	db.Create(&models.APIKey{KeyValue: "sk-debug-key-0001", ModelConfigID: m.ID})
+	db.Create(&models.APIKey{KeyValue: "sk-debug-key-0002", ModelConfigID: m.ID})
+
+	keyManager := core.NewKeyStateManager()
+	keyManager.MarkCooldown("sk-debug-key-0001", time.Minute)
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	lb, err := core.NewLoadBalancer(db, logger, keyManager, core.NewNoOpSecretProvider())
+	assert.NoError(t, err)
+
+	engine := gin.New()
+	engine.GET("/admin/debug/resolve", handleDebugResolve(lb))
+
+	assert.Equal(t, 400, doJSON(engine, http.MethodGet, "/admin/debug/resolve", nil).Code)
+	assert.Equal(t, 404, doJSON(engine, http.MethodGet, "/admin/debug/resolve?model=missing", nil).Code)
+
+	w := doJSON(engine, http.MethodGet, "/admin/debug/resolve?model=debug-pool", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.NotContains(t, w.Body.String(), "sk-debug-key-0002")
+
+	var resp struct {
+		Data models.ModelResolution `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	res := resp.Data
+	assert.Equal(t, "debug-pool", res.GroupID)
+	assert.Equal(t, "fallback", res.Strategy)
+	assert.Equal(t, 0, res.PinIndex)
+	assert.Len(t, res.Models, 1)
+	assert.Equal(t, 1, res.Models[0].AvailableKeys)
+	assert.Equal(t, []string{"cooldown", "available"}, []string{res.Models[0].Keys[0].Status, res.Models[0].Keys[1].Status})
+	assert.NotNil(t, res.Models[0].Keys[0].CooldownUntil)
+	assert.Equal(t, 1, res.NextModelIndex)
+	assert.Equal(t, models.MaskAPIKey("sk-debug-key-0002"), res.NextKeyPreview)
+
+	// 所有 Key 不可用时仍返回诊断信息，并给出路由错误
+	keyManager.MarkDead("sk-debug-key-0002")
+	w = doJSON(engine, http.MethodGet, "/admin/debug/resolve?model=debug-pool$1", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 1, resp.Data.PinIndex)
+	assert.Equal(t, 0, resp.Data.Models[0].AvailableKeys)
+	assert.Contains(t, resp.Data.RouteError, "cooldown or dead")
+}
+
+func TestModelGroupTags(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lb := newTestLoadBalancer(t)
+
+	engine := gin.New()
+	engine.GET("/admin/model-groups", handleListModelGroups(lb))
+	engine.POST("/admin/model-groups", handleCreateModelGroup(lb))
+	engine.PUT("/admin/model-groups/:group_id", handleUpdateModelGroup(lb))
+
+	assert.Equal(t, 200, doJSON(engine, http.MethodPost, "/admin/model-groups", gin.H{"group_id": "search-prod", "tags": []string{"env:prod", " team:search ", "env:prod"}}).Code)
+	assert.Equal(t, 200, doJSON(engine, http.MethodPost, "/admin/model-groups", gin.H{"group_id": "search-dev", "tags": []string{"env:dev", "team:search"}}).Code)
+	assert.Equal(t, 200, doJSON(engine, http.MethodPost, "/admin/model-groups", gin.H{"group_id": "untagged"}).Code)
+
+	listGroups := func(query string) []string {
+		w := doJSON(engine, http.MethodGet, "/admin/model-groups"+query, nil)
+		assert.Equal(t, 200, w.Code)
+		var resp struct {
+			Data []struct {
+				GroupID string   `json:"group_id"`
+				Tags    []string `json:"tags"`
+			} `json:"data"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		var ids []string
+		for _, g := range resp.Data {
+			ids = append(ids, g.GroupID)
+			if g.GroupID == "search-prod" {
+				assert.Equal(t, []string{"env:prod", "team:search"}, g.Tags)
+			}
+		}
+		return ids
+	}
+
+	assert.Equal(t, []string{"search-prod", "search-dev", "untagged"}, listGroups(""))
+	assert.Equal(t, []string{"search-prod", "search-dev"}, listGroups("?tag=team:search"))
+	assert.Equal(t, []string{"search-prod"}, listGroups("?tag=team:search&tag=env:prod"))
+	assert.Empty(t, listGroups("?tag=team:chat"))
+
+	// 整体替换标签
+	assert.Equal(t, 200, doJSON(engine, http.MethodPut, "/admin/model-groups/search-dev", gin.H{"tags": []string{"env:prod"}}).Code)
+	assert.Equal(t, []string{"search-prod", "search-dev"}, listGroups("?tag=env:prod"))
+	assert.Equal(t, []string{"search-prod"}, listGroups("?tag=team:search"))
+}
+
+func TestListModelGroups_Pagination(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lb := newTestLoadBalancer(t)
+	for i := 1; i <= 3; i++ {
+		group := models.ModelGroup{GroupID: fmt.Sprintf("group-%d", i), Strategy: "fallback"}
+		lb.GetDB().Create(&group)
+		lb.GetDB().Create(&models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4o", UpstreamURL: "https://api.openai.com", ModelGroupID: group.ID})
+	}
+
+	engine := gin.New()
+	engine.GET("/admin/model-groups", handleListModelGroups(lb))
+
+	type groupInfo struct {
+		GroupID    string               `json:"group_id"`
+		ModelCount int                  `json:"model_count"`
+		Models     []models.ModelConfig `json:"models"`
+	}
+
+	// 不带分页参数时保持数组格式
+	w := doJSON(engine, http.MethodGet, "/admin/model-groups", nil)
+	var all struct {
+		Data []groupInfo `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &all))
+	assert.Len(t, all.Data, 3)
+	assert.Len(t, all.Data[0].Models, 1)
+
+	w = doJSON(engine, http.MethodGet, "/admin/model-groups?limit=2&offset=1&summary=true", nil)
+	assert.Equal(t, 200, w.Code)
+	var page struct {
+		Data struct {
+			Total  int         `json:"total"`
+			Limit  int         `json:"limit"`
+			Offset int         `json:"offset"`
+			Groups []groupInfo `json:"groups"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, 3, page.Data.Total)
+	assert.Equal(t, 2, page.Data.Limit)
+	assert.Len(t, page.Data.Groups, 2)
+	assert.Equal(t, "group-2", page.Data.Groups[0].GroupID)
+	assert.Equal(t, 1, page.Data.Groups[0].ModelCount)
+	assert.Empty(t, page.Data.Groups[0].Models)
+
+	w = doJSON(engine, http.MethodGet, "/admin/model-groups?offset=10", nil)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, 3, page.Data.Total)
+	assert.Empty(t, page.Data.Groups)
+}
+
+func TestTrashRestore(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lb := newTestLoadBalancer(t)
+	db := lb.GetDB()
+
+	group := models.ModelGroup{GroupID: "trash-pool", Strategy: "fallback"}
+	db.Create(&group)
+	model := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4o", UpstreamURL: "https://api.openai.com", ModelGroupID: group.ID}
+	db.Create(&model)
+	key := models.APIKey{KeyValue: "sk-trash-key-0001", ModelConfigID: model.ID}
+	db.Create(&key)
+	// 一小时前单独删除的 Key，恢复组时不应被恢复
+	oldKey := models.APIKey{KeyValue: "sk-trash-key-0002", ModelConfigID: model.ID}
+	db.Create(&oldKey)
+	db.Unscoped().Model(&oldKey).Update("deleted_at", time.Now().Add(-time.Hour))
+	assert.NoError(t, lb.RefreshData())
+
+	engine := gin.New()
+	engine.DELETE("/admin/model-groups/:group_id", handleDeleteModelGroup(lb))
+	engine.DELETE("/admin/models/:model_id", handleDeleteModel(lb))
+	engine.GET("/admin/trash", handleListTrash(lb))
+	engine.POST("/admin/trash/restore", handleRestoreTrash(lb))
+
+	assert.Equal(t, 200, doJSON(engine, http.MethodDelete, "/admin/model-groups/trash-pool", nil).Code)
+	_, err := lb.Route("trash-pool")
+	assert.Error(t, err)
+
+	w := doJSON(engine, http.MethodGet, "/admin/trash", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.NotContains(t, w.Body.String(), "sk-trash-key-0001")
+	var trash struct {
+		Data struct {
+			Groups []gin.H `json:"groups"`
+			Models []gin.H `json:"models"`
+			Keys   []gin.H `json:"keys"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &trash))
+	assert.Len(t, trash.Data.Groups, 1)
+	assert.Len(t, trash.Data.Models, 1)
+	assert.Len(t, trash.Data.Keys, 2)
+
+	// 父记录仍被删除时不能单独恢复
+	assert.Equal(t, 409, doJSON(engine, http.MethodPost, "/admin/trash/restore", gin.H{"type": "key", "id": key.ID}).Code)
+	assert.Equal(t, 409, doJSON(engine, http.MethodPost, "/admin/trash/restore", gin.H{"type": "model", "id": model.ID}).Code)
+	assert.Equal(t, 400, doJSON(engine, http.MethodPost, "/admin/trash/restore", gin.H{"type": "bogus", "id": 1}).Code)
+
+	assert.Equal(t, 200, doJSON(engine, http.MethodPost, "/admin/trash/restore", gin.H{"type": "group", "id": group.ID}).Code)
+	routing, err := lb.Route("trash-pool")
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-trash-key-0001", routing.APIKey)
+	var remaining int64
+	db.Unscoped().Model(&models.APIKey{}).Where("deleted_at IS NOT NULL").Count(&remaining)
+	assert.Equal(t, int64(1), remaining)
+	assert.Equal(t, 404, doJSON(engine, http.MethodPost, "/admin/trash/restore", gin.H{"type": "group", "id": group.ID}).Code)
+
+	// 单独删除并恢复模型
+	assert.Equal(t, 200, doJSON(engine, http.MethodDelete, fmt.Sprintf("/admin/models/%d", model.ID), nil).Code)
+	assert.Equal(t, 200, doJSON(engine, http.MethodPost, "/admin/trash/restore", gin.H{"type": "model", "id": model.ID}).Code)
+	_, err = lb.Route("trash-pool")
+	assert.NoError(t, err)
+
+	assert.Equal(t, 200, doJSON(engine, http.MethodPost, "/admin/trash/restore", gin.H{"type": "key", "id": oldKey.ID}).Code)
+}
+
+func TestAuditLog(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lb := newTestLoadBalancer(t)
+
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Set("admin_id", uint(7))
+		c.Set("admin_name", "ops")
+		c.Set("admin_auth", "admin_key")
+	})
+	engine.POST("/admin/model-groups", handleCreateModelGroup(lb))
+	engine.DELETE("/admin/model-groups/:group_id", handleDeleteModelGroup(lb))
+	engine.GET("/admin/audit", handleListAuditLogs(lb))
+
+	assert.Equal(t, 200, doJSON(engine, http.MethodPost, "/admin/model-groups", gin.H{"group_id": "audited"}).Code)
+	assert.Equal(t, 200, doJSON(engine, http.MethodDelete, "/admin/model-groups/audited", nil).Code)
+	// 失败的操作不记录
+	assert.Equal(t, 404, doJSON(engine, http.MethodDelete, "/admin/model-groups/audited", nil).Code)
+
+	var resp struct {
+		Data struct {
+			Total int64             `json:"total"`
+			Logs  []models.AuditLog `json:"logs"`
+		} `json:"data"`
+	}
+	w := doJSON(engine, http.MethodGet, "/admin/audit", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, int64(2), resp.Data.Total)
+	assert.Equal(t, "delete_group", resp.Data.Logs[0].Action)
+	assert.Equal(t, "group:audited", resp.Data.Logs[0].Target)
+	assert.Equal(t, uint(7), resp.Data.Logs[0].AdminID)
+	assert.Equal(t, "ops", resp.Data.Logs[0].AdminName)
+
+	w = doJSON(engine, http.MethodGet, "/admin/audit?action=create_group", nil)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, int64(1), resp.Data.Total)
+	assert.Equal(t, "create_group", resp.Data.Logs[0].Action)
+}
+
+func TestListAdminKeys_Masked(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lb := newTestLoadBalancer(t)
+	lb.GetDB().Create(&models.AdminKey{Name: "ops", Key: "sk-admin-0123456789abcdef"})
+
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) { c.Set("db", lb.GetDB()) })
+	engine.GET("/admin/admin-keys", handleListAdminKeys(lb))
+
+	w := doJSON(engine, http.MethodGet, "/admin/admin-keys", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.NotContains(t, w.Body.String(), "sk-admin-0123456789abcdef")
+	assert.Contains(t, w.Body.String(), `"key_preview":"sk-***cdef"`)
+
+	// break-glass: 开启后列表返回完整密钥
+	lb.GetDB().Model(&models.GatewaySettings{}).Where("1 = 1").Update("reveal_admin_keys", true)
+	assert.NoError(t, lb.RefreshData())
+	w = doJSON(engine, http.MethodGet, "/admin/admin-keys", nil)
+	assert.Contains(t, w.Body.String(), `"key":"sk-admin-0123456789abcdef"`)
+}
+
+func TestAdminKeyAttribution(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var title, referer string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		title, referer = r.Header.Get("X-Title"), r.Header.Get("HTTP-Referer")
+		fmt.Fprint(w, `{"id":"1","object":"chat.completion","model":"gpt-4","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer upstream.Close()
+
+	lb := newTestLoadBalancer(t)
+	db := lb.GetDB()
+	group := models.ModelGroup{GroupID: "attributed", Strategy: "round_robin"}
+	db.Create(&group)
+	m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID}
+	db.Create(&m)
+	db.Create(&models.APIKey{KeyValue: "sk-upstream", ModelConfigID: m.ID})
+	db.Create(&models.AdminKey{Name: "root", Key: "sk-admin-root"})
+	assert.NoError(t, lb.RefreshData())
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) { c.Set("db", db) })
+	engine.POST("/admin/admin-keys", handleCreateAdminKey(lb))
+	engine.PUT("/admin/admin-keys/:id", handleUpdateAdminKey(lb))
+	engine.POST("/v1/chat/completions", verifyAdminToken(lb), core.NewProxyHandler(lb, &http.Client{}, logger, nil).HandleProxyRequest())
+
+	chat := func(token string) int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"attributed","messages":[{"role":"user","content":"hi"}]}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, 400, doJSON(engine, http.MethodPost, "/admin/admin-keys", gin.H{"name": "team-a", "app_referer": "not a url"}).Code)
+	w := doJSON(engine, http.MethodPost, "/admin/admin-keys", gin.H{"name": "team-a", "app_name": "Team A", "app_referer": "https://team-a.example.com"})
+	assert.Equal(t, 200, w.Code)
+	var created struct {
+		Data struct {
+			ID  uint   `json:"id"`
+			Key string `json:"key"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+
+	assert.Equal(t, 200, chat(created.Data.Key))
+	assert.Equal(t, "Team A", title)
+	assert.Equal(t, "https://team-a.example.com", referer)
+
+	// 未配置归属信息的密钥不发送这两个头
+	assert.Equal(t, 200, chat("sk-admin-root"))
+	assert.Empty(t, title)
+	assert.Empty(t, referer)
+
+	// 传入空字符串清除
+	w = doJSON(engine, http.MethodPut, fmt.Sprintf("/admin/admin-keys/%d", created.Data.ID), gin.H{"app_referer": ""})
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, 200, chat(created.Data.Key))
+	assert.Equal(t, "Team A", title)
+	assert.Empty(t, referer)
+}
+
+func TestAdminKeyGroupScoping(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id":"1","object":"chat.completion","model":"gpt-4","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer upstream.Close()
+
+	lb := newTestLoadBalancer(t)
+	db := lb.GetDB()
+	for _, groupID := range []string{"team-a", "team-b"} {
+		group := models.ModelGroup{GroupID: groupID, Strategy: "round_robin"}
+		db.Create(&group)
+		m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID}
+		db.Create(&m)
+		db.Create(&models.APIKey{KeyValue: "sk-" + groupID, ModelConfigID: m.ID})
+	}
+	db.Create(&models.ModelAlias{Alias: "b-alias", Target: "team-b"})
+	tenant := models.AdminKey{Name: "tenant-a", Key: "sk-admin-tenant-a"}
+	db.Create(&tenant)
+	assert.NoError(t, lb.RefreshData())
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) { c.Set("db", db) })
+	engine.GET("/admin/admin-keys/:id/groups", handleGetAdminKeyGroups(lb))
+	engine.PUT("/admin/admin-keys/:id/groups", handleSetAdminKeyGroups(lb))
+	engine.POST("/v1/chat/completions", verifyAdminToken(lb), core.NewProxyHandler(lb, &http.Client{}, logger, nil).HandleProxyRequest())
+
+	chat := func(model string) int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"`+model+`","messages":[{"role":"user","content":"hi"}]}`))
+		req.Header.Set("Authorization", "Bearer sk-admin-tenant-a")
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w.Code
+	}
+	path := fmt.Sprintf("/admin/admin-keys/%d/groups", tenant.ID)
+
+	// 未设置限制时可访问全部组
+	assert.Equal(t, 200, chat("team-b"))
+
+	assert.Equal(t, 400, doJSON(engine, http.MethodPut, path, gin.H{"group_ids": []string{"missing"}}).Code)
+	assert.Equal(t, 200, doJSON(engine, http.MethodPut, path, gin.H{"group_ids": []string{"team-a", "team-a"}}).Code)
+	w := doJSON(engine, http.MethodGet, path, nil)
+	assert.Contains(t, w.Body.String(), `"group_ids":["team-a"]`)
+
+	// 别名与固定序号按解析出的组检查
+	assert.Equal(t, 200, chat("team-a"))
+	assert.Equal(t, 403, chat("team-b"))
+	assert.Equal(t, 403, chat("team-b$1"))
+	assert.Equal(t, 403, chat("b-alias"))
+
+	// 传入空列表取消限制
+	assert.Equal(t, 200, doJSON(engine, http.MethodPut, path, gin.H{"group_ids": []string{}}).Code)
+	assert.Equal(t, 200, chat("team-b"))
+}
+
+func TestUpstreamBaseRequiresAdminScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var configuredHits, overrideHits int
+	configured := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		configuredHits++
+		fmt.Fprint(w, `{"id":"1","object":"chat.completion","model":"gpt-4","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer configured.Close()
+	override := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		overrideHits++
+		fmt.Fprint(w, `{"id":"1","object":"chat.completion","model":"gpt-4","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer override.Close()
+
+	lb := newTestLoadBalancer(t)
+	db := lb.GetDB()
+	group := models.ModelGroup{GroupID: "prod", Strategy: "round_robin"}
+	db.Create(&group)
+	m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: configured.URL + "/v1", ModelGroupID: group.ID}
+	db.Create(&m)
+	db.Create(&models.APIKey{KeyValue: "sk-prod", ModelConfigID: m.ID})
+	db.Create(&models.AdminKey{Name: "app", Key: "sk-admin-app"})
+	db.Create(&models.AdminKey{Name: "ops", Key: "sk-admin-ops", IsAdmin: true})
+	restricted := models.AdminKey{Name: "tenant", Key: "sk-admin-tenant", IsAdmin: true}
+	db.Create(&restricted)
+	db.Create(&models.AdminKeyGroup{AdminKeyID: restricted.ID, GroupID: "prod"})
+	assert.NoError(t, lb.RefreshData())
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	engine := gin.New()
+	engine.POST("/v1/chat/completions", verifyAdminToken(lb), core.NewProxyHandler(lb, &http.Client{}, logger, nil).HandleProxyRequest())
+
+	chat := func(token string) int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"prod","messages":[{"role":"user","content":"hi"}]}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Upstream-Base", override.URL+"/v1")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// 普通密钥与限定了组的密钥都不能改写上游地址
+	assert.Equal(t, 403, chat("sk-admin-app"))
+	assert.Equal(t, 403, chat("sk-admin-tenant"))
+	assert.Equal(t, 0, overrideHits+configuredHits)
+
+	assert.Equal(t, 200, chat("sk-admin-ops"))
+	assert.Equal(t, 1, overrideHits)
+	assert.Equal(t, 0, configuredHits)
+}
+
+func TestAdminKeyCannotSelfGrantAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lb := newTestLoadBalancer(t)
+	db := lb.GetDB()
+	app := models.AdminKey{Name: "app", Key: "sk-admin-app"}
+	db.Create(&app)
+	db.Create(&models.AdminKey{Name: "ops", Key: "sk-admin-ops", IsAdmin: true})
+
+	engine := gin.New()
+	setupRoutes(engine.Group(""), lb, core.NewProxyHandler(lb, &http.Client{}, lb.GetLogger(), nil), false)
+
+	send := func(method, path, token, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w.Code
+	}
+	appPath := fmt.Sprintf("/admin/admin-keys/%d", app.ID)
+
+	// 普通密钥不能提升自身权限，也不能创建管理员密钥
+	assert.Equal(t, 403, send(http.MethodPut, appPath, "sk-admin-app", `{"is_admin":true}`))
+	assert.Equal(t, 403, send(http.MethodPost, "/admin/admin-keys", "sk-admin-app", `{"name":"escalate","is_admin":true}`))
+	var adminCount int64
+	db.Model(&models.AdminKey{}).Where("is_admin = ?", true).Count(&adminCount)
+	assert.Equal(t, int64(1), adminCount)
+
+	// 不涉及 is_admin 的修改不受影响
+	assert.Equal(t, 200, send(http.MethodPut, appPath, "sk-admin-app", `{"name":"app-renamed"}`))
+	assert.Equal(t, 200, send(http.MethodPost, "/admin/admin-keys", "sk-admin-app", `{"name":"plain"}`))
+
+	// 管理员密钥可以授予权限
+	assert.Equal(t, 200, send(http.MethodPut, appPath, "sk-admin-ops", `{"is_admin":true}`))
+	db.First(&app, app.ID)
+	assert.True(t, app.IsAdmin)
+}
+
+func TestScopedKeyRejectedFromAdminAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id":"1","object":"chat.completion","model":"gpt-4","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer upstream.Close()
+
+	lb := newTestLoadBalancer(t)
+	db := lb.GetDB()
+	for _, groupID := range []string{"team-a", "team-b"} {
+		group := models.ModelGroup{GroupID: groupID, Strategy: "round_robin"}
+		db.Create(&group)
+		m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID}
+		db.Create(&m)
+		db.Create(&models.APIKey{KeyValue: "sk-" + groupID, ModelConfigID: m.ID})
+	}
+	db.Create(&models.AdminKey{Name: "ops", Key: "sk-admin-ops"})
+	tenant := models.AdminKey{Name: "tenant-a", Key: "sk-admin-tenant-a"}
+	db.Create(&tenant)
+	db.Create(&models.AdminKeyGroup{AdminKeyID: tenant.ID, GroupID: "team-a"})
+	assert.NoError(t, lb.RefreshData())
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	proxyHandler := core.NewProxyHandler(lb, &http.Client{}, logger, nil)
+	engine := gin.New()
+	setupRoutes(engine.Group(""), lb, proxyHandler, false)
+	engine.POST("/v1/chat/completions", verifyAdminToken(lb), proxyHandler.HandleProxyRequest())
+
+	send := func(method, path, token, body string, headers map[string]string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w.Code
+	}
+	groupsPath := fmt.Sprintf("/admin/admin-keys/%d/groups", tenant.ID)
+
+	// 限定了组的密钥不能访问管理接口，包括解除自身的限制
+	assert.Equal(t, 403, send(http.MethodPut, groupsPath, "sk-admin-tenant-a", `{"group_ids":[]}`, nil))
+	assert.Equal(t, 403, send(http.MethodPut, "/admin/model-groups/team-b", "sk-admin-tenant-a", `{"tags":["x"]}`, nil))
+	assert.Equal(t, 403, send(http.MethodGet, "/admin/stats", "sk-admin-tenant-a", "", nil))
+	var count int64
+	db.Model(&models.AdminKeyGroup{}).Where("admin_key_id = ?", tenant.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+	assert.Equal(t, 200, send(http.MethodGet, groupsPath, "sk-admin-ops", "", nil))
+
+	// 强制路由请求头同样拒绝，不带请求头时正常访问所属组
+	chat := `{"model":"team-a","messages":[{"role":"user","content":"hi"}]}`
+	assert.Equal(t, 403, send(http.MethodPost, "/v1/chat/completions", "sk-admin-tenant-a", chat, map[string]string{"X-Force-Model-Index": "1"}))
+	assert.Equal(t, 403, send(http.MethodPost, "/v1/chat/completions", "sk-admin-tenant-a", chat, map[string]string{"X-Force-Group": "team-b"}))
+	assert.Equal(t, 200, send(http.MethodPost, "/v1/chat/completions", "sk-admin-tenant-a", chat, nil))
+	assert.Equal(t, 200, send(http.MethodPost, "/v1/chat/completions", "sk-admin-ops", chat, map[string]string{"X-Force-Group": "team-b"}))
+}
+
+func TestCloneModelGroup(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lb := newTestLoadBalancer(t)
+	db := lb.GetDB()
+
+	group := models.ModelGroup{GroupID: "prod", Strategy: "round_robin", Tags: []string{"env:prod"}}
+	db.Create(&group)
+	for i, name := range []string{"gpt-4o", "gpt-4"} {
+		m := models.ModelConfig{ProviderName: "openai", UpstreamModel: name, UpstreamURL: "https://api.openai.com/v1", ModelGroupID: group.ID, Priority: i}
+		db.Create(&m)
+		db.Create(&models.APIKey{KeyValue: "sk-" + name, ModelConfigID: m.ID, Weight: 3})
+	}
+	assert.NoError(t, lb.RefreshData())
+
+	engine := gin.New()
+	engine.POST("/admin/model-groups/:group_id/clone", handleCloneModelGroup(lb))
+
+	w := doJSON(engine, http.MethodPost, "/admin/model-groups/prod/clone", gin.H{"group_id": "staging"})
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"keys_count":2`)
+
+	var clone models.ModelGroup
+	assert.NoError(t, db.Preload("Models.APIKeys").Where("group_id = ?", "staging").First(&clone).Error)
+	assert.Equal(t, "round_robin", clone.Strategy)
+	assert.Equal(t, []string{"env:prod"}, clone.Tags)
+	assert.Len(t, clone.Models, 2)
+	for _, m := range clone.Models {
+		assert.Len(t, m.APIKeys, 1)
+		assert.Equal(t, "sk-"+m.UpstreamModel, m.APIKeys[0].KeyValue)
+		assert.Equal(t, 3, m.APIKeys[0].Weight)
+	}
+
+	// 新组已加载到缓存，源组不受影响
+	routing, _, err := lb.PreviewRoute("staging$2")
+	assert.NoError(t, err)
+	assert.Equal(t, "gpt-4", routing.UpstreamModel)
+	var sourceModels int64
+	db.Model(&models.ModelConfig{}).Where("model_group_id = ?", group.ID).Count(&sourceModels)
+	assert.Equal(t, int64(2), sourceModels)
+
+	assert.Equal(t, 400, doJSON(engine, http.MethodPost, "/admin/model-groups/prod/clone", gin.H{"group_id": "staging"}).Code)
+	assert.Equal(t, 400, doJSON(engine, http.MethodPost, "/admin/model-groups/prod/clone", gin.H{}).Code)
+	assert.Equal(t, 404, doJSON(engine, http.MethodPost, "/admin/model-groups/missing/clone", gin.H{"group_id": "copy"}).Code)
+}
+
+func TestGetRequestLogs_ErrorTypeFilter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lb := newTestLoadBalancer(t)
+	for _, errType := range []string{"", core.ErrorTypeRateLimit, core.ErrorTypeRateLimit, core.ErrorTypeServer} {
+		lb.GetDB().Create(&models.RequestLog{Path: "/v1/chat/completions", ErrorType: errType})
+	}
+
+	engine := gin.New()
+	engine.GET("/admin/logs", handleGetRequestLogs(lb))
+
+	w := doJSON(engine, http.MethodGet, "/admin/logs?error_type=rate_limit", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"total":2`)
+	assert.NotContains(t, w.Body.String(), `"error_type":"server"`)
+
+	w = doJSON(engine, http.MethodGet, "/admin/logs", nil)
+	assert.Contains(t, w.Body.String(), `"total":4`)
+}
+
+func TestValidateConfig(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lb := newTestLoadBalancer(t)
+
+	engine := gin.New()
+	engine.POST("/admin/config/validate", handleValidateConfig(lb))
+
+	validate := func(cfg gin.H) models.ConfigValidateResponse {
+		w := doJSON(engine, http.MethodPost, "/admin/config/validate", cfg)
+		assert.Equal(t, 200, w.Code)
+		var resp struct {
+			Data models.ConfigValidateResponse `json:"data"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp.Data
+	}
+	model := func(provider, url string, keys ...string) gin.H {
+		apiKeys := make([]gin.H, 0, len(keys))
+		for _, k := range keys {
+			apiKeys = append(apiKeys, gin.H{"key_value": k})
+		}
+		return gin.H{"provider_name": provider, "upstream_url": url, "upstream_model": "gpt-4", "api_keys": apiKeys}
+	}
+
+	valid := validate(gin.H{
+		"groups": []gin.H{
+			{"group_id": "main", "strategy": "round_robin", "on_exhausted": "fallback_group:backup", "models": []gin.H{model("openai", "https://api.openai.com/v1", "sk-1")}},
+			{"group_id": "backup", "models": []gin.H{model("claude", "https://api.anthropic.com/v1", "sk-2")}},
+		},
+		"aliases": []gin.H{{"alias": "gpt", "target": "main$1"}},
+	})
+	assert.True(t, valid.Valid)
+	assert.Empty(t, valid.Problems)
+
+	invalid := validate(gin.H{
+		"groups": []gin.H{
+			{"group_id": "main", "strategy": "bogus", "on_exhausted": "fallback_group:missing", "models": []gin.H{
+				model("openai", "not-a-url"),
+				model("mystery", "https://example.com/v1", "sk-1", "sk-1"),
+			}},
+			{"group_id": "main"},
+		},
+		"aliases": []gin.H{{"alias": "gpt", "target": "main$3"}, {"alias": "x", "target": "nope"}},
+	})
+	assert.False(t, invalid.Valid)
+
+	got := make(map[string]string)
+	for _, p := range invalid.Problems {
+		got[p.Path] = p.Severity
+	}
+	assert.Equal(t, map[string]string{
+		"groups[0].strategy":                        "error",
+		"groups[0].on_exhausted":                    "error",
+		"groups[0].models[0].upstream_url":          "error",
+		"groups[0].models[0].api_keys":              "error",
+		"groups[0].models[1].provider_name":         "warning",
+		"groups[0].models[1].api_keys[1].key_value": "warning",
+		"groups[1].group_id":                        "error",
+		"groups[1].models":                          "warning",
+		"aliases[0].target":                         "error",
+		"aliases[1].target":                         "error",
+	}, got)
+
+	// 校验不会写入数据库
+	var count int64
+	lb.GetDB().Model(&models.ModelGroup{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestCreateModel_Duplicate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lb := newTestLoadBalancer(t)
+	lb.GetDB().Create(&models.ModelGroup{GroupID: "g1", Strategy: "fallback"})
+
+	engine := gin.New()
+	engine.POST("/admin/model-groups/:group_id/models", handleCreateModel(lb))
+
+	body := gin.H{
+		"provider_name":  "openai",
+		"upstream_url":   "https://api.openai.com/v1",
+		"upstream_model": "gpt-4",
+		"keys":           []string{"sk-a"},
+		"timeout":        30,
+	}
+	w := doJSON(engine, http.MethodPost, "/admin/model-groups/g1/models", body)
+	assert.Equal(t, 200, w.Code)
+
+	// 默认拒绝重复的模型
+	w = doJSON(engine, http.MethodPost, "/admin/model-groups/g1/models", body)
+	assert.Equal(t, 409, w.Code)
+	assert.Contains(t, w.Body.String(), "already exists")
+
+	// merge: 新 Key 合并到已有模型，已存在的 Key 跳过
+	body["keys"] = []string{"sk-a", "sk-b"}
+	body["on_duplicate"] = "merge"
+	w = doJSON(engine, http.MethodPost, "/admin/model-groups/g1/models", body)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"keys_added":1`)
+	assert.Contains(t, w.Body.String(), `"keys_skipped":1`)
+
+	var modelCount, keyCount int64
+	lb.GetDB().Model(&models.ModelConfig{}).Count(&modelCount)
+	lb.GetDB().Model(&models.APIKey{}).Count(&keyCount)
+	assert.Equal(t, int64(1), modelCount)
+	assert.Equal(t, int64(2), keyCount)
+
+	// 不同的上游模型正常创建
+	body["upstream_model"] = "gpt-4o"
+	delete(body, "on_duplicate")
+	w = doJSON(engine, http.MethodPost, "/admin/model-groups/g1/models", body)
+	assert.Equal(t, 200, w.Code)
+}
@@ -2,12 +2,18 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"llm-gateway/core"
+	"llm-gateway/core/security"
 	"llm-gateway/models"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -21,17 +27,9 @@ import (
 
 func main() {
 	// 创建日志器
-	log := logrus.New()
-	log.SetLevel(logrus.InfoLevel)
-	log.SetFormatter(&logrus.JSONFormatter{})
-
-	// 配置日志输出：同时输出到文件（供前端查看）和 Stdout（供 Docker 查看）
-	// 使用带轮转的文件写入器 (10MB 限制)，确保轻量化
-	rotator, err := core.NewLogRotator("gateway.log", 10)
-	if err == nil {
-		log.SetOutput(io.MultiWriter(os.Stdout, rotator))
-	} else {
-		log.Warn("Failed to init log rotator, using default stderr")
+	log, rotator := initLogger()
+	if rotator != nil {
+		defer rotator.Close() // 最后关闭，确保 asyncLogger 等退出时的日志也能写入文件
 	}
 
 	// 🔇 关闭 Gin Debug 模式输出
@@ -62,9 +60,31 @@ func main() {
 	defer asyncLogger.Close() // 确保程序退出时刷新剩余日志
 
 	// 初始化 SecretProvider
-	// ⚠️ 用户要求去除加密：使用明文存储 (NoOpSecretProvider)
-	sp := core.NewNoOpSecretProvider()
-	log.Info("🔓 Encryption DISABLED (Plain text mode requested)")
+	// 未配置密钥时保持明文存储 (NoOpSecretProvider)
+	sp, err := initSecretProvider(log)
+	if err != nil {
+		log.Fatal("Failed to initialize secret provider: ", err)
+	}
+
+	// 迁移旧版本遗留的明文 API Key
+	if _, err := core.EncryptPlaintextKeys(db, sp, log); err != nil {
+		log.Errorf("Failed to encrypt plaintext API keys: %v", err)
+	}
+
+	// 将旧版本保存的完整 OpenAI 接口地址拆分为 base URL + 路径
+	if _, err := core.MigrateUpstreamPaths(db, log); err != nil {
+		log.Errorf("Failed to migrate upstream paths: %v", err)
+	}
+
+	// 可选的管理端 JWT 鉴权
+	if adminJWTVerifier, err = initJWTVerifier(log); err != nil {
+		log.Fatal("Failed to initialize JWT verifier: ", err)
+	}
+
+	// 可选的管理端写操作来源检查 (逗号分隔的允许来源，"self" 表示只允许网关自身的来源)
+	if raw := os.Getenv("GATEWAY_ADMIN_ORIGINS"); raw != "" {
+		adminAllowedOrigins = splitList(raw)
+	}
 
 	// 创建 LoadBalancer (Task 1 & 2)
 	lb, err := core.NewLoadBalancer(
@@ -77,38 +97,100 @@ func main() {
 		log.Fatal("Failed to create load balancer:", err)
 	}
 
+	// 模型统计的持久化方式 (网关设置 stats_persistence)
+	statsInterval, statsOff, err := core.ParseStatsPersistence(lb.GetGatewaySettings().StatsPersistence)
+	if err != nil {
+		log.Warnf("Invalid stats persistence setting, writing stats with request logs: %v", err)
+	} else if statsOff {
+		log.Info("Stats persistence is off; model stats are kept in memory only")
+	}
+	asyncLogger.SetStatsPersistence(statsInterval, statsOff)
+
 	// 【Task C】 创建代理处理器 (注入依赖)
 	proxyHandler := core.NewProxyHandler(lb, httpClient, log, asyncLogger)
+	proxyHandler.SetMaxInFlight(parseMaxInFlight(os.Getenv("GATEWAY_MAX_INFLIGHT"), log))
+	providerTimeouts, err := parseProviderTimeouts(os.Getenv("GATEWAY_PROVIDER_TIMEOUTS"))
+	if err != nil {
+		log.Fatal("Invalid GATEWAY_PROVIDER_TIMEOUTS: ", err)
+	}
+	for provider, tuning := range providerTimeouts {
+		proxyHandler.SetProviderTransport(provider, tuning)
+		log.Infof("Using tuned transport for provider %s (response header: %v, TLS handshake: %v)",
+			provider, tuning.ResponseHeaderTimeout, tuning.TLSHandshakeTimeout)
+	}
+
+	// 启动时预热上游连接 (可选)，在后台进行，失败不影响启动
+	if prewarm, _ := strconv.ParseBool(os.Getenv("GATEWAY_PREWARM_CONNECTIONS")); prewarm {
+		go func() {
+			warmed, failed := proxyHandler.PrewarmConnections(context.Background())
+			log.Infof("Pre-warmed connections to %d upstream hosts (%d failed)", warmed, failed)
+		}()
+	}
 
 	// 创建Gin引擎
 	if os.Getenv("GIN_MODE") == "release" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	engine := gin.New()
+	if err := setTrustedProxies(engine, os.Getenv("GATEWAY_TRUSTED_PROXIES")); err != nil {
+		log.Fatal("Invalid GATEWAY_TRUSTED_PROXIES: ", err)
+	}
 
 	// 添加中间件
 	engine.Use(gin.RecoveryWithWriter(log.Writer()))
-	engine.Use(corsMiddleware())
+	corsOrigins := parseCORSOrigins(os.Getenv("GATEWAY_CORS_ORIGINS"))
+	if len(corsOrigins) == 1 && corsOrigins[0] == "*" {
+		log.Warn("CORS allows all origins (*). Set GATEWAY_CORS_ORIGINS to restrict browser access")
+	}
+	engine.Use(corsMiddleware(corsOrigins))
 	
 	// 【Task 3】 添加 IP 限流中间件
 	engine.Use(RateLimitMiddleware())
 
+	// 可选的路径前缀 (部署在共享 Ingress 的子路径下时使用)
+	basePath, err := normalizeBasePath(os.Getenv("GATEWAY_BASE_PATH"))
+	if err != nil {
+		log.Fatal("Invalid GATEWAY_BASE_PATH: ", err)
+	}
+	root := engine.Group(basePath)
+
+	// Idempotency-Key: 重放成功响应的时长，0 表示不启用
+	idempotencyTTL, err := parseIdempotencyTTL(os.Getenv("GATEWAY_IDEMPOTENCY_TTL"))
+	if err != nil {
+		log.Fatal("Invalid GATEWAY_IDEMPOTENCY_TTL: ", err)
+	}
+	var idempotencyCache *IdempotencyCache
+	if idempotencyTTL > 0 {
+		idempotencyCache = NewIdempotencyCache(idempotencyTTL)
+	}
+	idempotent := IdempotencyMiddleware(idempotencyCache)
+
 	// 【Task B】 为业务接口单独添加请求日志中间件 (使用异步日志器)
-	api := engine.Group("/")
-	api.Use(RequestLoggerMiddleware(asyncLogger))
+	api := root.Group("/")
+	api.Use(BodyLimitMiddleware(lb), RequestLoggerMiddleware(asyncLogger))
 	{
 		// 路由处理逻辑下沉到 ProxyHandler
-		api.POST("/v1/chat/completions", verifyAdminToken(lb), proxyHandler.HandleProxyRequest())
-		api.POST("/v1/images/generations", verifyAdminToken(lb), proxyHandler.HandleProxyRequest()) // Support Image Gen
+		api.POST("/v1/chat/completions", verifyAdminToken(lb), idempotent, ChatRequestValidationMiddleware(lb), proxyHandler.HandleProxyRequest())
+		api.GET("/v1/chat/completions", verifyAdminToken(lb), EventSourceRequestMiddleware(), ChatRequestValidationMiddleware(lb), proxyHandler.HandleProxyRequest()) // EventSource: ?request=...&token=...
+		api.POST("/v1/images/generations", verifyAdminToken(lb), idempotent, proxyHandler.HandleProxyRequest()) // Support Image Gen
+		api.POST("/v1/embeddings", verifyAdminToken(lb), idempotent, proxyHandler.HandleEmbeddings)             // 超过 embedding_batch_size 的输入自动拆分批次
+		api.GET("/v1/chat/ws", verifyAdminToken(lb), proxyHandler.HandleChatWebSocket)             // WebSocket 流式聊天 (浏览器可用 ?token= 鉴权)
+		api.GET("/v1/models/*id", verifyAdminToken(lb), handleGetModel(lb)) // id 可能包含 "/" (如 meta-llama/Llama-3)
 		
 		// Inbound Adapters (Reverse Conversion)
-		api.POST("/v1/messages", verifyAdminToken(lb), proxyHandler.HandleClaudeMessage)
+		api.POST("/v1/messages", verifyAdminToken(lb), idempotent, proxyHandler.HandleClaudeMessage)
 		// Capture "gemini-pro:generateContent" as a single param ":model"
-		api.POST("/v1beta/models/:model", verifyAdminToken(lb), proxyHandler.HandleGeminiGenerateContent)
+		api.POST("/v1beta/models/:model", verifyAdminToken(lb), idempotent, proxyHandler.HandleGeminiGenerateContent)
+	}
+
+	// 仪表板离线资源 (无外网环境)
+	offlineAssets, _ := strconv.ParseBool(os.Getenv("GATEWAY_OFFLINE_ASSETS"))
+	if missing := missingDashboardAssets(); offlineAssets && len(missing) > 0 {
+		log.Warnf("GATEWAY_OFFLINE_ASSETS is set but %v are not embedded, run `go generate ./cmd` before building", missing)
 	}
 
 	// 设置路由
-	setupRoutes(engine, lb, proxyHandler)
+	setupRoutes(root, lb, proxyHandler, offlineAssets)
 
 	// 获取端口
 	gatewaySettings := lb.GetGatewaySettings()
@@ -118,18 +200,66 @@ func main() {
 	}
 
 	// 创建HTTP服务器
+	// 不设置 WriteTimeout: 它限制的是整个响应的写出时间，会截断持续数分钟的 SSE 流式响应
+	timeouts, err := parseServerTimeouts(os.Getenv)
+	if err != nil {
+		log.Fatalf("Invalid server timeout: %v", err)
+	}
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: engine,
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           engine,
+		ReadHeaderTimeout: timeouts.ReadHeader,
+		ReadTimeout:       timeouts.Read,
+		IdleTimeout:       timeouts.Idle,
+	}
+
+	// TLS: 同时设置证书与私钥时使用 HTTPS，可选在另一个端口将 HTTP 重定向到 HTTPS
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	useTLS, err := tlsEnabled(certFile, keyFile)
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
+	var redirectServer *http.Server
+	if raw := os.Getenv("TLS_HTTP_REDIRECT_PORT"); raw != "" {
+		redirectPort, err := parsePort(raw)
+		switch {
+		case err != nil:
+			log.Fatalf("Invalid TLS_HTTP_REDIRECT_PORT: %v", err)
+		case !useTLS:
+			log.Warn("TLS_HTTP_REDIRECT_PORT is ignored because TLS is not enabled")
+		default:
+			redirectServer = &http.Server{
+				Addr:              fmt.Sprintf(":%d", redirectPort),
+				Handler:           httpsRedirectHandler(port),
+				ReadHeaderTimeout: timeouts.ReadHeader,
+				ReadTimeout:       timeouts.Read,
+				IdleTimeout:       timeouts.Idle,
+			}
+		}
 	}
 
 	// 启动服务器
 	go func() {
-		log.Infof("Starting LLM Gateway on port %d", port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if useTLS {
+			log.Infof("Starting LLM Gateway on port %d (HTTPS)", port)
+			err = server.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			log.Infof("Starting LLM Gateway on port %d", port)
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatal("Failed to start server:", err)
 		}
 	}()
+	if redirectServer != nil {
+		go func() {
+			log.Infof("Redirecting HTTP on %s to HTTPS", redirectServer.Addr)
+			if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatal("Failed to start HTTP redirect server:", err)
+			}
+		}()
+	}
 
 	// 等待中断信号以优雅地关闭服务器
 	quit := make(chan os.Signal, 1)
@@ -137,17 +267,241 @@ func main() {
 	<-quit
 	log.Info("Shutting down server...")
 
-	// 设置超时以完成正在进行的请求
+	// 设置超时以完成正在进行的请求；依次停止接收新请求、等待代理请求结束、刷新日志与统计
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	if redirectServer != nil {
+		if err := redirectServer.Shutdown(ctx); err != nil {
+			log.Errorf("HTTP redirect server forced to shutdown: %v", err)
+		}
+	}
 	if err := server.Shutdown(ctx); err != nil {
-		log.Fatal("Server forced to shutdown:", err)
+		log.Errorf("Server forced to shutdown: %v", err)
+	}
+
+	if n := proxyHandler.InFlight(); n > 0 {
+		log.Infof("Waiting for %d in-flight proxy requests...", n)
+	}
+	if err := proxyHandler.WaitIdle(ctx); err != nil {
+		log.Warnf("Stopped waiting for proxy requests: %v", err)
+	}
+
+	if drained, err := asyncLogger.Shutdown(ctx); err != nil {
+		log.Warnf("Request logs not fully flushed: %v", err)
+	} else {
+		log.Infof("Flushed %d pending request logs", drained)
 	}
 
 	log.Info("Server exited")
 }
 
+// defaultIdempotencyTTL Idempotency-Key 默认的重放时长
+const defaultIdempotencyTTL = 5 * time.Minute
+
+// parseIdempotencyTTL 解析 GATEWAY_IDEMPOTENCY_TTL (Go duration)，未设置时使用默认值，0 表示不启用
+func parseIdempotencyTTL(raw string) (time.Duration, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return defaultIdempotencyTTL, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", raw)
+	}
+	return d, nil
+}
+
+// serverTimeouts HTTP 服务端超时，0 表示不限制
+type serverTimeouts struct {
+	ReadHeader time.Duration // 读取请求头 (防御 slowloris)
+	Read       time.Duration // 读取整个请求 (含请求体)
+	Idle       time.Duration // Keep-Alive 连接的空闲时间
+}
+
+// parseServerTimeouts 读取 GATEWAY_READ_HEADER_TIMEOUT / GATEWAY_READ_TIMEOUT / GATEWAY_IDLE_TIMEOUT
+// (Go duration 字符串)，未设置时分别使用 10s / 60s / 120s
+func parseServerTimeouts(getenv func(string) string) (serverTimeouts, error) {
+	t := serverTimeouts{ReadHeader: 10 * time.Second, Read: 60 * time.Second, Idle: 120 * time.Second}
+	for name, target := range map[string]*time.Duration{
+		"GATEWAY_READ_HEADER_TIMEOUT": &t.ReadHeader,
+		"GATEWAY_READ_TIMEOUT":        &t.Read,
+		"GATEWAY_IDLE_TIMEOUT":        &t.Idle,
+	} {
+		raw := strings.TrimSpace(getenv(name))
+		if raw == "" {
+			continue
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			return serverTimeouts{}, fmt.Errorf("%s: invalid duration %q", name, raw)
+		}
+		*target = d
+	}
+	return t, nil
+}
+
+// tlsEnabled 检查 TLS_CERT_FILE / TLS_KEY_FILE：都未设置时使用 HTTP，只设置其一时返回错误
+func tlsEnabled(certFile, keyFile string) (bool, error) {
+	switch {
+	case certFile == "" && keyFile == "":
+		return false, nil
+	case certFile == "" || keyFile == "":
+		return false, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	return true, nil
+}
+
+// parsePort 解析端口号 (1-65535)
+func parsePort(raw string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", raw)
+	}
+	return port, nil
+}
+
+// httpsRedirectHandler 将 HTTP 请求重定向到 HTTPS 端口，保留主机名、路径与查询参数
+// 使用 308 以便 POST 请求在重定向后保持方法与请求体
+func httpsRedirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6
+		}
+		target := url.URL{Scheme: "https", Host: host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, target.String(), http.StatusPermanentRedirect)
+	})
+}
+
+// defaultMaxInFlight 默认的全局并发上限 (每个请求约占用客户端与上游两个连接，低于常见的 ulimit 1024)
+const defaultMaxInFlight = 400
+
+// parseMaxInFlight 解析 GATEWAY_MAX_INFLIGHT，未设置或无效时使用默认值，0 表示不限制
+func parseMaxInFlight(raw string, log *logrus.Logger) int {
+	if raw == "" {
+		return defaultMaxInFlight
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		log.Warnf("Invalid GATEWAY_MAX_INFLIGHT %q, using %d", raw, defaultMaxInFlight)
+		return defaultMaxInFlight
+	}
+	return n
+}
+
+// parseProviderTimeouts 解析 GATEWAY_PROVIDER_TIMEOUTS (JSON，键为提供商名，时长为 Go duration 字符串)
+// 例如 {"gemini": {"response_header_timeout": "180s", "tls_handshake_timeout": "15s"}}
+func parseProviderTimeouts(raw string) (map[string]core.TransportTuning, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var cfg map[string]struct {
+		ResponseHeaderTimeout string `json:"response_header_timeout"`
+		TLSHandshakeTimeout   string `json:"tls_handshake_timeout"`
+	}
+	if err := json.Unmarshal([]byte(raw), &cfg); err != nil {
+		return nil, err
+	}
+
+	parse := func(provider, field, v string) (time.Duration, error) {
+		if v == "" {
+			return 0, nil
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("%s.%s: invalid duration %q", provider, field, v)
+		}
+		return d, nil
+	}
+
+	tunings := make(map[string]core.TransportTuning, len(cfg))
+	for provider, c := range cfg {
+		var t core.TransportTuning
+		var err error
+		if t.ResponseHeaderTimeout, err = parse(provider, "response_header_timeout", c.ResponseHeaderTimeout); err != nil {
+			return nil, err
+		}
+		if t.TLSHandshakeTimeout, err = parse(provider, "tls_handshake_timeout", c.TLSHandshakeTimeout); err != nil {
+			return nil, err
+		}
+		tunings[provider] = t
+	}
+	return tunings, nil
+}
+
+// logFilePath 日志文件路径，为空表示不写文件 (系统日志接口读取该文件)
+var logFilePath = "gateway.log"
+
+// initLogger 根据环境变量创建日志器
+// LOG_LEVEL: 日志级别 (默认 info)；LOG_FORMAT: json (默认) 或 text；
+// LOG_FILE: 日志文件路径 (默认 gateway.log，设为空字符串则只输出到 Stdout)；
+// LOG_MAX_SIZE_MB: 单个日志文件大小上限 (默认 10)，超出后轮转为 .old；
+// LOG_MAX_BACKUPS: 保留的编号备份数 (.1 ~ .N，默认 1 即仅保留 .old)；LOG_COMPRESS=true 时压缩编号备份
+// 写入文件时同时返回轮转器，调用方负责在退出时关闭
+func initLogger() (*logrus.Logger, *core.LogRotator) {
+	log := logrus.New()
+	log.SetLevel(logrus.InfoLevel)
+	log.SetFormatter(&logrus.JSONFormatter{})
+
+	if v := os.Getenv("LOG_FORMAT"); strings.EqualFold(v, "text") {
+		log.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	} else if v != "" && !strings.EqualFold(v, "json") {
+		log.Warnf("Unknown LOG_FORMAT %q, using json", v)
+	}
+
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if level, err := logrus.ParseLevel(v); err == nil {
+			log.SetLevel(level)
+		} else {
+			log.Warnf("Unknown LOG_LEVEL %q, using info", v)
+		}
+	}
+
+	if v, ok := os.LookupEnv("LOG_FILE"); ok {
+		logFilePath = strings.TrimSpace(v)
+	}
+	if logFilePath == "" {
+		log.SetOutput(os.Stdout)
+		return log, nil
+	}
+
+	maxSizeMB := 10
+	if v := os.Getenv("LOG_MAX_SIZE_MB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			maxSizeMB = n
+		} else {
+			log.Warnf("Invalid LOG_MAX_SIZE_MB %q, using %d", v, maxSizeMB)
+		}
+	}
+
+	// 同时输出到文件（供前端查看）和 Stdout（供 Docker 查看）
+	// 使用带轮转的文件写入器，确保轻量化
+	rotator, err := core.NewLogRotator(logFilePath, maxSizeMB)
+	if err != nil {
+		log.Warnf("Failed to init log rotator for %s, logging to stdout only: %v", logFilePath, err)
+		log.SetOutput(os.Stdout)
+		return log, nil
+	}
+	if v := os.Getenv("LOG_MAX_BACKUPS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			rotator.MaxBackups = n
+		} else {
+			log.Warnf("Invalid LOG_MAX_BACKUPS %q, keeping a single backup", v)
+		}
+	}
+	rotator.Compress, _ = strconv.ParseBool(os.Getenv("LOG_COMPRESS"))
+
+	log.SetOutput(io.MultiWriter(os.Stdout, rotator))
+	return log, rotator
+}
+
 // initDatabase 初始化数据库
 func initDatabase(log *logrus.Logger) (*gorm.DB, error) {
 	// 打开数据库连接 - 【优化】只记录错误，不打印 SQL 语句
@@ -194,20 +548,109 @@ func initDatabase(log *logrus.Logger) (*gorm.DB, error) {
 	return db, nil
 }
 
-// setupRoutes 设置路由
-func setupRoutes(engine *gin.Engine, lb *core.LoadBalancer, proxyHandler *core.ProxyHandler) {
+// initSecretProvider 初始化密钥加解密组件
+// 密钥来源优先级: GATEWAY_SECRET_KEY 环境变量 > gateway.key 文件；均未配置时使用明文模式。
+// 轮换密钥时通过 GATEWAY_SECRET_KEY_VERSION 指定当前版本，旧密钥以 GATEWAY_SECRET_KEY_V{N} 提供。
+func initSecretProvider(log *logrus.Logger) (core.SecretProvider, error) {
+	key, source, err := security.LoadSecretKey(
+		security.EnvKeySource{Var: "GATEWAY_SECRET_KEY"},
+		security.FileKeySource{Path: "gateway.key"},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		log.Info("🔓 Encryption DISABLED (no GATEWAY_SECRET_KEY or gateway.key found)")
+		return core.NewNoOpSecretProvider(), nil
+	}
+
+	current := 1
+	if v := os.Getenv("GATEWAY_SECRET_KEY_VERSION"); v != "" {
+		if current, err = strconv.Atoi(v); err != nil || current < 1 {
+			return nil, fmt.Errorf("invalid GATEWAY_SECRET_KEY_VERSION: %q", v)
+		}
+	}
+
+	keys := map[int]string{current: key}
+	for version := 1; version < current; version++ {
+		name := fmt.Sprintf("GATEWAY_SECRET_KEY_V%d", version)
+		oldKey, found, _ := security.EnvKeySource{Var: name}.LoadKey()
+		if !found {
+			continue
+		}
+		if keys[version], err = security.ParseSecretKey(oldKey); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	sp, err := security.NewVersionedAESSecretProvider(keys, current)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("🔐 Encryption ENABLED (key source: %s, version: v%d)", source, current)
+	return sp, nil
+}
+
+// initJWTVerifier 根据环境变量初始化管理端 JWT 鉴权
+// 未设置 GATEWAY_JWT_SECRET 与 GATEWAY_JWT_JWKS_URL 时返回 nil (不启用)
+func initJWTVerifier(log *logrus.Logger) (*security.JWTVerifier, error) {
+	cfg := security.JWTConfig{
+		Secret:        os.Getenv("GATEWAY_JWT_SECRET"),
+		JWKSURL:       os.Getenv("GATEWAY_JWT_JWKS_URL"),
+		Issuer:        os.Getenv("GATEWAY_JWT_ISSUER"),
+		Audience:      os.Getenv("GATEWAY_JWT_AUDIENCE"),
+		IdentityClaim: os.Getenv("GATEWAY_JWT_IDENTITY_CLAIM"),
+		ScopeClaim:    os.Getenv("GATEWAY_JWT_SCOPE_CLAIM"),
+		GroupsClaim:   os.Getenv("GATEWAY_JWT_GROUPS_CLAIM"),
+	}
+	if cfg.Secret == "" && cfg.JWKSURL == "" {
+		return nil, nil
+	}
+
+	v, err := security.NewJWTVerifier(cfg)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("🪪 JWT admin auth ENABLED (issuer: %q, jwks: %q)", cfg.Issuer, cfg.JWKSURL)
+	return v, nil
+}
+
+// normalizeBasePath 规范化 GATEWAY_BASE_PATH: "llm/" -> "/llm"，未配置时返回 ""
+func normalizeBasePath(raw string) (string, error) {
+	p := strings.Trim(strings.TrimSpace(raw), "/")
+	if p == "" {
+		return "", nil
+	}
+	for _, r := range p {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("/-_.", r)) {
+			return "", fmt.Errorf("unsupported character %q in %q", r, raw)
+		}
+	}
+	return "/" + p, nil
+}
+
+// setupRoutes 设置路由 (root 为带路径前缀的根路由组)
+// offlineAssets 为 true 时仪表板使用内嵌的静态资源，不访问 CDN
+func setupRoutes(root *gin.RouterGroup, lb *core.LoadBalancer, proxyHandler *core.ProxyHandler, offlineAssets bool) {
+	basePath := strings.TrimSuffix(root.BasePath(), "/")
+
 	// 公开路由 - 无需鉴权，无访问日志
-	engine.GET("/", handleRoot(lb))
-	engine.GET("/health", handleHealth(lb))
-	engine.GET("/demo", handleDashboard())
-	engine.GET("/dashboard", handleDashboard())
+	root.GET("/", handleRoot(lb, basePath))
+	root.GET("/health", handleHealth(lb, proxyHandler))
+	root.GET("/demo", handleDashboard(basePath, offlineAssets))
+	root.GET("/dashboard", handleDashboard(basePath, offlineAssets))
+	root.GET("/dashboard/assets/:name", handleDashboardAsset())
 
 	// 管理API路由组
-	admin := engine.Group("/admin")
+	admin := root.Group("/admin")
+	if adminAllowedOrigins != nil {
+		admin.Use(AdminOriginMiddleware(adminAllowedOrigins))
+	}
 	admin.Use(func(c *gin.Context) {
 		c.Set("db", lb.GetDB())
 		AdminAuthMiddleware()(c)
 	})
+	admin.Use(RejectScopedKeysMiddleware())
 	{
 		// 模型组管理
 		admin.GET("/model-groups", handleListModelGroups(lb))
@@ -215,6 +658,19 @@ func setupRoutes(engine *gin.Engine, lb *core.LoadBalancer, proxyHandler *core.P
 		admin.GET("/model-groups/:group_id", handleGetModelGroup(lb))
 		admin.PUT("/model-groups/:group_id", handleUpdateModelGroup(lb))
 		admin.DELETE("/model-groups/:group_id", handleDeleteModelGroup(lb))
+		admin.POST("/model-groups/:group_id/clone", handleCloneModelGroup(lb))
+
+		// 模型别名
+		admin.GET("/model-aliases", handleListModelAliases(lb))
+		admin.POST("/model-aliases", handleCreateModelAlias(lb))
+		admin.PUT("/model-aliases/:alias", handleUpdateModelAlias(lb))
+		admin.DELETE("/model-aliases/:alias", handleDeleteModelAlias(lb))
+
+		// 策略列表
+		admin.GET("/strategies", handleListStrategies(lb))
+		admin.POST("/config/validate", handleValidateConfig(lb))
+		admin.POST("/route/preview", handleRoutePreview(lb))
+		admin.GET("/debug/resolve", handleDebugResolve(lb))
 
 		// 模型管理
 		admin.POST("/model-groups/:group_id/models", handleCreateModel(lb))
@@ -223,30 +679,103 @@ func setupRoutes(engine *gin.Engine, lb *core.LoadBalancer, proxyHandler *core.P
 
 		// API Key管理
 		admin.POST("/models/:model_id/keys", handleCreateAPIKey(lb))
+		admin.PUT("/keys/:key_id", handleUpdateAPIKey(lb))
 		admin.DELETE("/keys/:key_id", handleDeleteAPIKey(lb))
+		admin.POST("/keys/:key_id/reactivate", handleReactivateAPIKey(lb))
+		admin.GET("/keys/status", handleGetKeyStatus(lb))
+		admin.POST("/keys/rotate-encryption", handleRotateEncryption(lb))
+		admin.POST("/keys/encrypt-all", handleEncryptAllKeys(lb))
+
+		// 回收站 (软删除记录)
+		admin.GET("/trash", handleListTrash(lb))
+		admin.POST("/trash/restore", handleRestoreTrash(lb))
 
 		// 统计信息
-		admin.GET("/stats", handleStats(lb))
+		admin.GET("/stats", handleStats(lb, proxyHandler))
+		admin.GET("/stats/timeseries", handleStatsTimeseries(lb))
 		// 日志查询
 		admin.GET("/logs", handleGetRequestLogs(lb))
 		admin.GET("/system-logs", handleGetSystemLogs())
+		admin.GET("/audit", handleListAuditLogs(lb))
 
 		// 配置重载
 		admin.POST("/reload", handleReload(lb))
 
 		// Admin Key 管理
-		admin.GET("/admin-keys", handleListAdminKeys())
-		admin.POST("/admin-keys", handleCreateAdminKey())
-		admin.DELETE("/admin-keys/:id", handleDeleteAdminKey())
+		admin.GET("/admin-keys", handleListAdminKeys(lb))
+		admin.POST("/admin-keys", handleCreateAdminKey(lb))
+		admin.PUT("/admin-keys/:id", handleUpdateAdminKey(lb))
+		admin.GET("/admin-keys/:id/groups", handleGetAdminKeyGroups(lb))
+		admin.PUT("/admin-keys/:id/groups", handleSetAdminKeyGroups(lb))
+		admin.DELETE("/admin-keys/:id", handleDeleteAdminKey(lb))
+	}
+}
+
+const (
+	corsAllowMethods  = "GET, POST, PUT, DELETE, OPTIONS"
+	corsAllowHeaders  = "Origin, Content-Type, Content-Length, Accept, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key, X-Request-Timeout-Ms, X-Request-ID"
+	corsExposeHeaders = "X-Request-ID"
+)
+
+// splitList 解析逗号分隔的配置项，忽略空白项
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// setTrustedProxies 配置可信代理 (GATEWAY_TRUSTED_PROXIES，逗号分隔的 IP/CIDR)
+// 只有直连对端属于可信代理时才采用 X-Forwarded-For / X-Real-IP，否则使用 socket 地址，
+// 防止客户端伪造 IP 绕过限流。未配置时不信任任何代理。
+func setTrustedProxies(engine *gin.Engine, raw string) error {
+	return engine.SetTrustedProxies(splitList(raw))
+}
+
+// parseCORSOrigins 解析 GATEWAY_CORS_ORIGINS (逗号分隔)，未配置时返回 ["*"]
+func parseCORSOrigins(raw string) []string {
+	var origins []string
+	for _, o := range splitList(raw) {
+		if o = strings.TrimRight(o, "/"); o != "" {
+			origins = append(origins, o)
+		}
 	}
+	if len(origins) == 0 {
+		return []string{"*"}
+	}
+	return origins
 }
 
 // corsMiddleware CORS中间件
-func corsMiddleware() gin.HandlerFunc {
+// 允许列表包含 "*" 时对任意来源放行，但不允许携带凭据 (浏览器也不接受 "*" + credentials)；
+// 否则仅回显列表中的 Origin 并允许凭据，其余来源不返回 Allow-Origin 头，由浏览器拦截。
+func corsMiddleware(origins []string) gin.HandlerFunc {
+	allowAll := false
+	allowed := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		if o == "*" {
+			allowAll = true
+		}
+		allowed[o] = true
+	}
+
 	return func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key")
+		origin := c.GetHeader("Origin")
+		if allowAll {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Header("Vary", "Origin")
+			if origin != "" && allowed[origin] {
+				c.Header("Access-Control-Allow-Origin", origin)
+				c.Header("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		c.Header("Access-Control-Allow-Methods", corsAllowMethods)
+		c.Header("Access-Control-Allow-Headers", corsAllowHeaders)
+		c.Header("Access-Control-Expose-Headers", corsExposeHeaders)
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
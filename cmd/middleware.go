@@ -2,9 +2,17 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"llm-gateway/core"
+	"llm-gateway/core/security"
 	"llm-gateway/models"
+	"net/http"
+	"net/url"
 	"strings"
 	"sync"
 	"time"
@@ -15,7 +23,11 @@ import (
 	"gorm.io/gorm"
 )
 
+// adminJWTVerifier 管理端 JWT 校验器，未配置时为 nil (仅使用数据库中的 Admin Key)
+var adminJWTVerifier *security.JWTVerifier
+
 // AdminAuthMiddleware 管理员鉴权中间件
+// 配置了 JWT 时，JWT 格式的 token 按 JWT 校验，其余 token 仍查询数据库中的 Admin Key
 func AdminAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if c.Request.Method == "OPTIONS" {
@@ -46,6 +58,29 @@ func AdminAuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if adminJWTVerifier != nil && security.LooksLikeJWT(token) {
+			identity, err := adminJWTVerifier.Verify(token)
+			if err != nil {
+				c.AbortWithStatusJSON(401, models.ErrorResponse{
+					Error: models.ErrorDetail{Message: "Invalid token: " + err.Error(), Type: "authentication_error"},
+				})
+				return
+			}
+			// 与 Admin Key 设置相同的 Context 键；JWT 调用者没有数据库记录，admin_id 为 0
+			c.Set("admin_id", uint(0))
+			c.Set("admin_name", identity.Subject)
+			c.Set("admin_auth", "jwt")
+			c.Set("admin_app_name", "")
+			c.Set("admin_app_referer", "")
+			if len(identity.Groups) > 0 {
+				c.Set("admin_groups", identity.Groups)
+			} else if identity.HasScope(core.ScopeAdmin) {
+				c.Set("admin_scope", core.ScopeAdmin)
+			}
+			c.Next()
+			return
+		}
+
 		db, exists := c.Get("db")
 		if !exists {
 			c.AbortWithStatus(500)
@@ -62,10 +97,87 @@ func AdminAuthMiddleware() gin.HandlerFunc {
 
 		c.Set("admin_id", adminKey.ID)
 		c.Set("admin_name", adminKey.Name)
+		c.Set("admin_auth", "admin_key")
+		c.Set("admin_app_name", adminKey.AppName)
+		c.Set("admin_app_referer", adminKey.AppReferer)
+
+		// 限定了可访问组的密钥 (为空时不限制)
+		var groups []string
+		if err := db.(*gorm.DB).Model(&models.AdminKeyGroup{}).Where("admin_key_id = ?", adminKey.ID).Pluck("group_id", &groups).Error; err != nil {
+			c.AbortWithStatus(500)
+			return
+		}
+		if len(groups) > 0 {
+			c.Set("admin_groups", groups)
+		} else if adminKey.IsAdmin {
+			c.Set("admin_scope", core.ScopeAdmin)
+		}
+		c.Next()
+	}
+}
+
+// RejectScopedKeysMiddleware 拒绝限定了可访问组的密钥访问管理接口 (否则可以解除自身限制或修改其他组的配置)
+func RejectScopedKeysMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(c.GetStringSlice("admin_groups")) > 0 {
+			c.AbortWithStatusJSON(403, models.ErrorResponse{
+				Error: models.ErrorDetail{Message: "This API key is restricted to specific model groups and cannot access the admin API", Type: "permission_error"},
+			})
+			return
+		}
 		c.Next()
 	}
 }
 
+// adminAllowedOrigins 允许对管理接口发起写操作的跨站来源 (GATEWAY_ADMIN_ORIGINS)，nil 表示不检查来源
+var adminAllowedOrigins []string
+
+// AdminOriginMiddleware 管理接口写操作 (POST/PUT/PATCH/DELETE) 的来源检查
+// 威胁模型: 恶意网页借用户浏览器向网关发起管理操作。仪表板把 Admin Key 存在 localStorage 并以
+// Bearer 头发送，其他站点本身拿不到 Key，因此这里是纵深防御，主要防范携带凭据的代理/扩展
+// 或同一浏览器中误配置的 CORS。Origin (缺失时取 Referer) 与请求 Host 相同或在允许列表中时放行；
+// 两者都没有的请求 (curl、SDK 等非浏览器客户端) 放行；GET 等只读请求不受影响
+func AdminOriginMiddleware(origins []string) gin.HandlerFunc {
+	allowed := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		allowed[strings.TrimRight(o, "/")] = true
+	}
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			c.Next()
+			return
+		}
+
+		origin := requestOrigin(c.Request)
+		if origin == "" || allowed[origin] || sameOrigin(origin, c.Request.Host) {
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(403, models.ErrorResponse{
+			Error: models.ErrorDetail{Message: "Cross-origin admin request rejected: " + origin, Type: "permission_error"},
+		})
+	}
+}
+
+// requestOrigin 返回请求的 Origin，缺失时从 Referer 中提取 scheme://host，两者都没有时返回空字符串
+func requestOrigin(r *http.Request) string {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		return strings.TrimRight(origin, "/")
+	}
+	if ref, err := url.Parse(r.Header.Get("Referer")); err == nil && ref.Host != "" {
+		return ref.Scheme + "://" + ref.Host
+	}
+	return ""
+}
+
+// sameOrigin 判断来源的 host (含端口) 是否与请求的 Host 一致
+func sameOrigin(origin, host string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, host)
+}
+
 // RequestLoggerMiddleware 异步请求日志中间件
 func RequestLoggerMiddleware(asyncLogger *core.AsyncRequestLogger) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -86,6 +198,7 @@ func RequestLoggerMiddleware(asyncLogger *core.AsyncRequestLogger) gin.HandlerFu
 		// 记录所有非 OPTIONS 请求 (Task B: Unified Logging)
 		if asyncLogger != nil && c.Request.Method != "OPTIONS" {
 			logEntry := &models.RequestLog{
+				RequestID:  c.GetString("request_id"), // 由 ProxyHandler 设置
 				CreatedAt:  start,
 				Method:     c.Request.Method,
 				Path:       c.Request.URL.Path,
@@ -93,6 +206,11 @@ func RequestLoggerMiddleware(asyncLogger *core.AsyncRequestLogger) gin.HandlerFu
 				Duration:   latency.Milliseconds(),
 				IP:         clientIP,
 				UserAgent:  c.Request.UserAgent(),
+
+				RequestType: models.RequestTypeChat,
+			}
+			if requestType := c.GetString("request_type"); requestType != "" {
+				logEntry.RequestType = requestType
 			}
 			
 			// 尝试从 Context 获取路由信息 (由 ProxyHandler 设置)
@@ -106,6 +224,16 @@ func RequestLoggerMiddleware(asyncLogger *core.AsyncRequestLogger) gin.HandlerFu
 				}
 			}
 
+			// Token 用量 (由 Adapter 设置)
+			if u, exists := c.Get("usage"); exists {
+				if usage, ok := u.(*models.ChatCompletionUsage); ok && usage != nil {
+					logEntry.PromptTokens = usage.PromptTokens
+					logEntry.CompletionTokens = usage.CompletionTokens
+					logEntry.CacheReadTokens = usage.CacheReadTokens
+					logEntry.CacheWriteTokens = usage.CacheWriteTokens
+				}
+			}
+
 			if statusCode >= 400 && len(bodyBytes) > 0 {
 				// [Optimization] Truncate error message to avoid DB bloat
 				errMsg := string(bodyBytes)
@@ -114,12 +242,161 @@ func RequestLoggerMiddleware(asyncLogger *core.AsyncRequestLogger) gin.HandlerFu
 				}
 				logEntry.ErrorMsg = errMsg
 			}
+			// 流式响应首字节耗时 (由 ProxyHandler 设置)
+			if v, exists := c.Get("ttft"); exists {
+				if ttft, ok := v.(time.Duration); ok {
+					ms := ttft.Milliseconds()
+					logEntry.TTFT = &ms
+				}
+			}
+
+			// 优先使用 ProxyHandler 记录的失败分类 (重试耗尽时为最后一次上游失败的类型)
+			if statusCode >= 400 {
+				logEntry.ErrorType = c.GetString("error_type")
+				if logEntry.ErrorType == "" {
+					logEntry.ErrorType = core.ClassifyError(statusCode, nil)
+				}
+			}
 			
 			asyncLogger.Log(logEntry)
 		}
 	}
 }
 
+// abortInvalidRequest 以 OpenAI 错误格式中断请求
+func abortInvalidRequest(c *gin.Context, status int, message, param string) {
+	c.AbortWithStatusJSON(status, models.ErrorResponse{
+		Error: models.ErrorDetail{Message: message, Type: "invalid_request_error", Param: param},
+	})
+}
+
+// maxQueryRequestBytes GET 请求中 request 参数 (编码后) 的最大长度
+// 反向代理通常将请求行限制在 8KB 左右，更大的请求应使用 POST 或 WebSocket
+const maxQueryRequestBytes = 64 * 1024
+
+// EventSourceRequestMiddleware 将 GET /v1/chat/completions 的 ?request= 参数转换为 JSON 请求体
+// 浏览器 EventSource 只能发起 GET 请求，请求体以 URL 编码的 JSON 或 base64 (url/std) 编码的 JSON 传入，
+// 并强制开启 stream；后续的校验与代理逻辑与 POST 完全相同
+func EventSourceRequestMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw := c.Query("request")
+		if raw == "" {
+			abortInvalidRequest(c, 400, "Missing required query parameter: 'request'", "request")
+			return
+		}
+		if len(raw) > maxQueryRequestBytes {
+			abortInvalidRequest(c, 414, fmt.Sprintf("Query parameter 'request' too large (max %d bytes), use POST instead", maxQueryRequestBytes), "request")
+			return
+		}
+
+		body := []byte(raw)
+		if !json.Valid(body) {
+			decoded, err := decodeBase64Param(raw)
+			if err != nil || !json.Valid(decoded) {
+				abortInvalidRequest(c, 400, "Query parameter 'request' must be JSON or base64-encoded JSON", "request")
+				return
+			}
+			body = decoded
+		}
+
+		var req map[string]interface{}
+		if err := json.Unmarshal(body, &req); err != nil {
+			abortInvalidRequest(c, 400, "Invalid JSON in 'request': "+err.Error(), "request")
+			return
+		}
+		req["stream"] = true
+		body, _ = json.Marshal(req)
+
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Request.ContentLength = int64(len(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Next()
+	}
+}
+
+// decodeBase64Param 解码 base64url 或标准 base64 (填充可省略)
+// 标准编码中的 "+" 未转义时会被查询参数解析为空格，这里还原
+func decodeBase64Param(raw string) ([]byte, error) {
+	s := strings.TrimRight(raw, "=")
+	if decoded, err := base64.RawURLEncoding.DecodeString(s); err == nil {
+		return decoded, nil
+	}
+	return base64.RawStdEncoding.DecodeString(strings.ReplaceAll(s, " ", "+"))
+}
+
+// readLimitedBody 读取请求体并放回供后续读取，超过 maxBytes (<= 0 表示不限制) 时返回 413，读取失败返回 400
+func readLimitedBody(c *gin.Context, maxBytes int64) ([]byte, bool) {
+	if maxBytes > 0 && c.Request.ContentLength > maxBytes {
+		abortInvalidRequest(c, 413, fmt.Sprintf("Request body too large (max %d bytes)", maxBytes), "")
+		return nil, false
+	}
+
+	body := c.Request.Body
+	if maxBytes > 0 {
+		body = http.MaxBytesReader(c.Writer, body, maxBytes)
+	}
+	bodyBytes, err := io.ReadAll(body)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			abortInvalidRequest(c, 413, fmt.Sprintf("Request body too large (max %d bytes)", maxBytes), "")
+			return nil, false
+		}
+		abortInvalidRequest(c, 400, "Failed to read request body", "")
+		return nil, false
+	}
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+	return bodyBytes, true
+}
+
+// BodyLimitMiddleware 请求体大小限制中间件，需注册在请求日志与幂等中间件之前
+// 二者会将请求体完整读入内存，过大的请求在这里直接返回 413
+func BodyLimitMiddleware(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+		if _, ok := readLimitedBody(c, lb.GetGatewaySettings().MaxBodyBytes); !ok {
+			return
+		}
+		c.Next()
+	}
+}
+
+// ChatRequestValidationMiddleware 聊天请求体大小限制与基础校验中间件
+// 在路由到上游之前拒绝过大 (413) 或明显无效 (400) 的请求
+func ChatRequestValidationMiddleware(lb *core.LoadBalancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bodyBytes, ok := readLimitedBody(c, lb.GetGatewaySettings().MaxBodyBytes)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			Model    string `json:"model"`
+			Messages []struct {
+				Role string `json:"role"`
+			} `json:"messages"`
+		}
+		if err := json.Unmarshal(bodyBytes, &req); err != nil {
+			abortInvalidRequest(c, 400, "Invalid JSON body: "+err.Error(), "")
+			return
+		}
+
+		roles := make([]string, len(req.Messages))
+		for i, msg := range req.Messages {
+			roles[i] = msg.Role
+		}
+		if detail := core.ValidateChatRequest(req.Model, roles); detail != nil {
+			c.AbortWithStatusJSON(400, models.ErrorResponse{Error: *detail})
+			return
+		}
+
+		c.Next()
+	}
+}
+
 // client 包装限流器及其最后访问时间
 type client struct {
 	limiter  *rate.Limiter
@@ -196,4 +473,170 @@ func RateLimitMiddleware() gin.HandlerFunc {
 
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+// idempotencyMaxBody 单个可重放响应的最大缓存大小，超过时不缓存 (重复请求会重新调用上游)
+const idempotencyMaxBody = 4 << 20
+
+// idempotencyEntry 一个 Idempotency-Key 对应的请求；done 关闭后 status/header/body 可读
+type idempotencyEntry struct {
+	done      chan struct{}
+	bodyHash  [32]byte
+	cached    bool // 结果是否可重放 (2xx 且未超过大小上限)
+	status    int
+	header    http.Header
+	body      []byte
+	expiresAt time.Time
+}
+
+// IdempotencyCache 按 (管理员身份, Idempotency-Key) 缓存进行中与已完成的响应
+type IdempotencyCache struct {
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+	ttl     time.Duration
+}
+
+// NewIdempotencyCache 创建幂等缓存，已完成的响应保留 ttl
+func NewIdempotencyCache(ttl time.Duration) *IdempotencyCache {
+	cache := &IdempotencyCache{entries: make(map[string]*idempotencyEntry), ttl: ttl}
+	go cache.cleanup()
+	return cache
+}
+
+// cleanup 每分钟清理一次过期的响应
+func (ic *IdempotencyCache) cleanup() {
+	for {
+		time.Sleep(time.Minute)
+		now := time.Now()
+		ic.mu.Lock()
+		for key, entry := range ic.entries {
+			if !entry.expiresAt.IsZero() && now.After(entry.expiresAt) {
+				delete(ic.entries, key)
+			}
+		}
+		ic.mu.Unlock()
+	}
+}
+
+// acquire 返回 key 对应的已有请求 (owner 为 false)，不存在或已过期时登记新的请求 (owner 为 true)
+func (ic *IdempotencyCache) acquire(key string, bodyHash [32]byte) (entry *idempotencyEntry, owner bool) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if entry, ok := ic.entries[key]; ok && (entry.expiresAt.IsZero() || time.Now().Before(entry.expiresAt)) {
+		return entry, false
+	}
+	entry = &idempotencyEntry{done: make(chan struct{}), bodyHash: bodyHash}
+	ic.entries[key] = entry
+	return entry, true
+}
+
+// finish 结束请求：可重放的结果保留 ttl，否则移除，等待中的重复请求随后重新执行
+func (ic *IdempotencyCache) finish(key string, entry *idempotencyEntry) {
+	ic.mu.Lock()
+	if entry.cached {
+		entry.expiresAt = time.Now().Add(ic.ttl)
+	} else if ic.entries[key] == entry {
+		delete(ic.entries, key)
+	}
+	ic.mu.Unlock()
+	close(entry.done)
+}
+
+// captureWriter 在写给客户端的同时缓存响应体 (超过上限后停止缓存)
+type captureWriter struct {
+	gin.ResponseWriter
+	buf      bytes.Buffer
+	overflow bool
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	if !w.overflow {
+		if w.buf.Len()+len(b) > idempotencyMaxBody {
+			w.overflow = true
+			w.buf.Reset()
+		} else {
+			w.buf.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// IdempotencyMiddleware 支持 Idempotency-Key 请求头 (需在鉴权之后)
+// 同一管理员在 TTL 内使用相同的 Key 重复请求时直接重放首次的成功响应 (带 Idempotent-Replayed: true)，
+// 首次请求仍在处理时等待其结果；相同 Key 的请求体不同时返回 422。cache 为 nil 时不启用
+func IdempotencyMiddleware(cache *IdempotencyCache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idemKey := c.GetHeader("Idempotency-Key")
+		if cache == nil || idemKey == "" {
+			c.Next()
+			return
+		}
+		if len(idemKey) > 255 {
+			abortInvalidRequest(c, 400, "Idempotency-Key must be at most 255 characters", "Idempotency-Key")
+			return
+		}
+
+		var body []byte
+		if c.Request.Body != nil {
+			body, _ = io.ReadAll(c.Request.Body)
+			c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+		}
+		bodyHash := sha256.Sum256(body)
+		identity := c.GetString("admin_name")
+		if id, ok := c.Get("admin_id"); ok {
+			identity = fmt.Sprint(id)
+		}
+		key := c.GetString("admin_auth") + ":" + identity + ":" + idemKey
+
+		for {
+			entry, owner := cache.acquire(key, bodyHash)
+			if owner {
+				runIdempotent(c, cache, key, entry)
+				return
+			}
+			if entry.bodyHash != bodyHash {
+				abortInvalidRequest(c, 422, "Idempotency-Key was already used with a different request body", "Idempotency-Key")
+				return
+			}
+
+			select {
+			case <-entry.done:
+			case <-c.Request.Context().Done():
+				c.Abort()
+				return
+			}
+			if entry.cached {
+				for k, v := range entry.header {
+					c.Writer.Header()[k] = v
+				}
+				c.Header("Idempotent-Replayed", "true")
+				c.Writer.WriteHeader(entry.status)
+				c.Writer.Write(entry.body)
+				c.Abort()
+				return
+			}
+			// 首次请求失败，未缓存结果：重新获取 (由本请求或其他重复请求重新执行)
+		}
+	}
+}
+
+// runIdempotent 执行首次请求并记录可重放的结果
+func runIdempotent(c *gin.Context, cache *IdempotencyCache, key string, entry *idempotencyEntry) {
+	writer := &captureWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	defer func() {
+		c.Writer = writer.ResponseWriter
+		status := writer.Status()
+		if status >= 200 && status < 300 && !writer.overflow && !c.IsAborted() {
+			entry.cached = true
+			entry.status = status
+			entry.header = writer.Header().Clone()
+			entry.body = writer.buf.Bytes()
+		}
+		cache.finish(key, entry)
+	}()
+	c.Next()
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"llm-gateway/core/security"
+	"llm-gateway/models"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChatRequestValidationMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lb := newTestLoadBalancer(t)
+	lb.GetDB().Model(&models.GatewaySettings{}).Where("1 = 1").Update("max_body_bytes", 256)
+	assert.NoError(t, lb.RefreshData())
+
+	engine := gin.New()
+	engine.POST("/v1/chat/completions", ChatRequestValidationMiddleware(lb), func(c *gin.Context) {
+		c.Status(200)
+	})
+
+	validMessages := []gin.H{{"role": "user", "content": "hi"}}
+
+	w := doJSON(engine, http.MethodPost, "/v1/chat/completions", gin.H{"model": "g", "messages": validMessages})
+	assert.Equal(t, 200, w.Code)
+
+	w = doJSON(engine, http.MethodPost, "/v1/chat/completions", gin.H{"model": "g", "messages": []gin.H{{"role": "user", "content": strings.Repeat("x", 512)}}})
+	assert.Equal(t, 413, w.Code)
+
+	w = doJSON(engine, http.MethodPost, "/v1/chat/completions", gin.H{"model": "g", "messages": []gin.H{}})
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "messages")
+
+	w = doJSON(engine, http.MethodPost, "/v1/chat/completions", gin.H{"model": "g", "messages": []gin.H{{"role": "robot", "content": "hi"}}})
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "messages[0].role")
+
+	// developer (新版 system) 与 function (旧版 tool) 角色
+	w = doJSON(engine, http.MethodPost, "/v1/chat/completions", gin.H{"model": "g", "messages": []gin.H{{"role": "developer", "content": "be brief"}, {"role": "user", "content": "hi"}}})
+	assert.Equal(t, 200, w.Code)
+	w = doJSON(engine, http.MethodPost, "/v1/chat/completions", gin.H{"model": "g", "messages": []gin.H{{"role": "function", "name": "f", "content": "{}"}}})
+	assert.Equal(t, 200, w.Code)
+
+	w = doJSON(engine, http.MethodPost, "/v1/chat/completions", gin.H{"messages": validMessages})
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lb := newTestLoadBalancer(t)
+	lb.GetDB().Model(&models.GatewaySettings{}).Where("1 = 1").Update("max_body_bytes", 256)
+	assert.NoError(t, lb.RefreshData())
+
+	// 过大的请求体在后续中间件读取之前被拒绝
+	var reached int
+	engine := gin.New()
+	engine.Use(BodyLimitMiddleware(lb), func(c *gin.Context) {
+		reached++
+		c.Next()
+	})
+	engine.POST("/v1/chat/completions", ChatRequestValidationMiddleware(lb), func(c *gin.Context) {
+		c.Status(200)
+	})
+
+	w := doJSON(engine, http.MethodPost, "/v1/chat/completions", gin.H{"model": "g", "messages": []gin.H{{"role": "user", "content": strings.Repeat("x", 512)}}})
+	assert.Equal(t, 413, w.Code)
+	assert.Equal(t, 0, reached)
+
+	// 未声明 Content-Length 的请求同样受限
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(strings.Repeat("x", 512)))
+	req.ContentLength = -1
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	assert.Equal(t, 413, w.Code)
+	assert.Equal(t, 0, reached)
+
+	// 未超限的请求体可被后续中间件再次读取
+	w = doJSON(engine, http.MethodPost, "/v1/chat/completions", gin.H{"model": "g", "messages": []gin.H{{"role": "user", "content": "hi"}}})
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, 1, reached)
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	newEngine := func(origins []string) *gin.Engine {
+		engine := gin.New()
+		engine.Use(corsMiddleware(origins))
+		engine.GET("/ping", func(c *gin.Context) { c.Status(200) })
+		return engine
+	}
+	request := func(engine *gin.Engine, method, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/ping", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	// 默认: 通配且不允许凭据
+	assert.Equal(t, []string{"*"}, parseCORSOrigins(""))
+	w := request(newEngine(parseCORSOrigins("")), http.MethodGet, "https://evil.example.com")
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+
+	origins := parseCORSOrigins(" https://app.example.com/ , https://admin.example.com")
+	assert.Equal(t, []string{"https://app.example.com", "https://admin.example.com"}, origins)
+	engine := newEngine(origins)
+
+	// 允许列表内: 回显 Origin 并允许凭据
+	w = request(engine, http.MethodGet, "https://app.example.com")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "Origin", w.Header().Get("Vary"))
+
+	// 列表外: 不返回跨域头
+	w = request(engine, http.MethodGet, "https://evil.example.com")
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+
+	// 预检请求
+	w = request(engine, http.MethodOptions, "https://admin.example.com")
+	assert.Equal(t, 204, w.Code)
+	assert.Equal(t, "https://admin.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, corsAllowMethods, w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "Authorization")
+}
+
+func TestAdminOriginMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(AdminOriginMiddleware([]string{"https://admin.example.com/"}))
+	engine.Any("/admin/model-groups", func(c *gin.Context) { c.Status(200) })
+
+	request := func(method string, headers map[string]string) int {
+		req := httptest.NewRequest(method, "http://gateway.local:8000/admin/model-groups", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// 同源、允许列表内与非浏览器客户端放行
+	assert.Equal(t, 200, request(http.MethodPost, map[string]string{"Origin": "http://gateway.local:8000"}))
+	assert.Equal(t, 200, request(http.MethodPut, map[string]string{"Origin": "https://admin.example.com"}))
+	assert.Equal(t, 200, request(http.MethodDelete, map[string]string{"Referer": "https://admin.example.com/dashboard"}))
+	assert.Equal(t, 200, request(http.MethodPost, nil))
+
+	// 跨站写操作被拒绝，只读请求不受影响
+	assert.Equal(t, 403, request(http.MethodPost, map[string]string{"Origin": "https://evil.example.com"}))
+	assert.Equal(t, 403, request(http.MethodDelete, map[string]string{"Referer": "https://evil.example.com/page"}))
+	assert.Equal(t, 403, request(http.MethodPost, map[string]string{"Origin": "null"}))
+	assert.Equal(t, 200, request(http.MethodGet, map[string]string{"Origin": "https://evil.example.com"}))
+}
+
+func TestAdminAuthMiddleware_JWT(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lb := newTestLoadBalancer(t)
+	lb.GetDB().Create(&models.AdminKey{Name: "root", Key: "sk-admin-test"})
+
+	v, err := security.NewJWTVerifier(security.JWTConfig{Secret: "s3cret", GroupsClaim: "groups"})
+	assert.NoError(t, err)
+	adminJWTVerifier = v
+	defer func() { adminJWTVerifier = nil }()
+
+	engine := gin.New()
+	engine.GET("/whoami", verifyAdminToken(lb), func(c *gin.Context) {
+		c.JSON(200, gin.H{"name": c.GetString("admin_name"), "auth": c.GetString("admin_auth")})
+	})
+	engine.GET("/scope", verifyAdminToken(lb), func(c *gin.Context) {
+		_, hasID := c.Get("admin_id")
+		c.JSON(200, gin.H{"has_id": hasID, "scope": c.GetString("admin_scope"), "groups": c.GetStringSlice("admin_groups")})
+	})
+	get := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "alice", "exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("s3cret"))
+	w := get(token)
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{"name":"alice","auth":"jwt"}`, w.Body.String())
+
+	// scope 与 groups claim 映射到与 Admin Key 相同的 Context 键，限定了组时不具有管理员权限
+	scoped := func(claims jwt.MapClaims) string {
+		claims["sub"] = "alice"
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("s3cret"))
+		req := httptest.NewRequest(http.MethodGet, "/scope", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+	assert.JSONEq(t, `{"has_id":true,"scope":"","groups":null}`, scoped(jwt.MapClaims{}))
+	assert.JSONEq(t, `{"has_id":true,"scope":"admin","groups":null}`, scoped(jwt.MapClaims{"scope": "openid admin"}))
+	assert.JSONEq(t, `{"has_id":true,"scope":"","groups":["team-a"]}`, scoped(jwt.MapClaims{"scope": "admin", "groups": []string{"team-a"}}))
+
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "mallory", "exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("wrong"))
+	assert.Equal(t, 401, get(forged).Code)
+
+	// 非 JWT 的 token 回退到数据库 Admin Key
+	w = get("sk-admin-test")
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{"name":"root","auth":"admin_key"}`, w.Body.String())
+	assert.Equal(t, 401, get("sk-admin-unknown").Code)
+}
+
+func TestSetTrustedProxies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	engine := gin.New()
+	assert.NoError(t, setTrustedProxies(engine, "10.0.0.0/8, 192.168.1.1"))
+	engine.GET("/ip", func(c *gin.Context) { c.String(200, c.ClientIP()) })
+
+	clientIP := func(remoteAddr string, headers map[string]string) string {
+		req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+		req.RemoteAddr = remoteAddr
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	// 可信代理: 采用转发头
+	assert.Equal(t, "1.2.3.4", clientIP("10.1.2.3:5000", map[string]string{"X-Forwarded-For": "1.2.3.4"}))
+	assert.Equal(t, "1.2.3.4", clientIP("192.168.1.1:5000", map[string]string{"X-Real-IP": "1.2.3.4"}))
+	// 多级代理时跳过可信跳，取最后一个不可信地址 (客户端伪造的最左项被忽略)
+	assert.Equal(t, "1.2.3.4", clientIP("10.1.2.3:5000", map[string]string{"X-Forwarded-For": "6.6.6.6, 1.2.3.4, 10.0.0.9"}))
+
+	// 不可信对端: 忽略转发头
+	assert.Equal(t, "8.8.8.8", clientIP("8.8.8.8:5000", map[string]string{"X-Forwarded-For": "1.2.3.4"}))
+	assert.Equal(t, "8.8.8.8", clientIP("8.8.8.8:5000", map[string]string{"X-Real-IP": "1.2.3.4"}))
+
+	// 未配置时不信任任何代理
+	untrusted := gin.New()
+	assert.NoError(t, setTrustedProxies(untrusted, ""))
+	untrusted.GET("/ip", func(c *gin.Context) { c.String(200, c.ClientIP()) })
+	req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+	req.RemoteAddr = "10.1.2.3:5000"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	w := httptest.NewRecorder()
+	untrusted.ServeHTTP(w, req)
+	assert.Equal(t, "10.1.2.3", w.Body.String())
+
+	assert.Error(t, setTrustedProxies(gin.New(), "not-an-ip"))
+}
+
+func TestInitLogger_Env(t *testing.T) {
+	defer func(path string) { logFilePath = path }(logFilePath)
+
+	t.Setenv("LOG_LEVEL", "debug")
+	t.Setenv("LOG_FORMAT", "text")
+	t.Setenv("LOG_FILE", "")
+	log, rotator := initLogger()
+	assert.Nil(t, rotator)
+	assert.Equal(t, logrus.DebugLevel, log.GetLevel())
+	assert.IsType(t, &logrus.TextFormatter{}, log.Formatter)
+	assert.Equal(t, "", logFilePath)
+
+	// 无效值回退到默认行为
+	t.Setenv("LOG_LEVEL", "loud")
+	t.Setenv("LOG_FORMAT", "xml")
+	log, _ = initLogger()
+	assert.Equal(t, logrus.InfoLevel, log.GetLevel())
+	assert.IsType(t, &logrus.JSONFormatter{}, log.Formatter)
+}
+
+func TestParseProviderTimeouts(t *testing.T) {
+	tunings, err := parseProviderTimeouts("")
+	assert.NoError(t, err)
+	assert.Empty(t, tunings)
+
+	tunings, err = parseProviderTimeouts(`{"gemini": {"response_header_timeout": "3m", "tls_handshake_timeout": "15s"}, "openai": {"response_header_timeout": "90s"}}`)
+	assert.NoError(t, err)
+	assert.Equal(t, 3*time.Minute, tunings["gemini"].ResponseHeaderTimeout)
+	assert.Equal(t, 15*time.Second, tunings["gemini"].TLSHandshakeTimeout)
+	assert.Equal(t, 90*time.Second, tunings["openai"].ResponseHeaderTimeout)
+	assert.Zero(t, tunings["openai"].TLSHandshakeTimeout)
+
+	_, err = parseProviderTimeouts(`{"gemini": {"response_header_timeout": "soon"}}`)
+	assert.Error(t, err)
+	_, err = parseProviderTimeouts(`not json`)
+	assert.Error(t, err)
+}
+
+func TestParseServerTimeouts(t *testing.T) {
+	env := map[string]string{}
+	getenv := func(k string) string { return env[k] }
+
+	timeouts, err := parseServerTimeouts(getenv)
+	assert.NoError(t, err)
+	assert.Equal(t, serverTimeouts{ReadHeader: 10 * time.Second, Read: 60 * time.Second, Idle: 120 * time.Second}, timeouts)
+
+	env["GATEWAY_READ_TIMEOUT"] = "0"
+	env["GATEWAY_IDLE_TIMEOUT"] = "5m"
+	timeouts, err = parseServerTimeouts(getenv)
+	assert.NoError(t, err)
+	assert.Zero(t, timeouts.Read)
+	assert.Equal(t, 5*time.Minute, timeouts.Idle)
+
+	env["GATEWAY_READ_HEADER_TIMEOUT"] = "-1s"
+	_, err = parseServerTimeouts(getenv)
+	assert.Error(t, err)
+}
+
+func TestTLSConfig(t *testing.T) {
+	enabled, err := tlsEnabled("", "")
+	assert.NoError(t, err)
+	assert.False(t, enabled)
+	enabled, err = tlsEnabled("cert.pem", "key.pem")
+	assert.NoError(t, err)
+	assert.True(t, enabled)
+	_, err = tlsEnabled("cert.pem", "")
+	assert.Error(t, err)
+
+	port, err := parsePort("8080")
+	assert.NoError(t, err)
+	assert.Equal(t, 8080, port)
+	for _, raw := range []string{"", "http", "0", "70000"} {
+		_, err = parsePort(raw)
+		assert.Error(t, err, raw)
+	}
+
+	cases := []struct {
+		httpsPort    int
+		host, target string
+	}{
+		{8443, "gw.example.com:8080", "https://gw.example.com:8443/v1/models?x=1"},
+		{443, "gw.example.com", "https://gw.example.com/v1/models?x=1"},
+		{443, "[::1]:8080", "https://[::1]/v1/models?x=1"},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "http://"+tc.host+"/v1/models?x=1", nil)
+		w := httptest.NewRecorder()
+		httpsRedirectHandler(tc.httpsPort).ServeHTTP(w, req)
+		assert.Equal(t, http.StatusPermanentRedirect, w.Code)
+		assert.Equal(t, tc.target, w.Header().Get("Location"))
+	}
+}
+
+func TestEventSourceRequestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/v1/chat/completions", EventSourceRequestMiddleware(), func(c *gin.Context) {
+		var req models.ChatCompletionRequest
+		assert.NoError(t, c.ShouldBindJSON(&req))
+		c.JSON(200, req)
+	})
+
+	payload := `{"model":"g","messages":[{"role":"user","content":"hi?>"}]}`
+	for name, param := range map[string]string{
+		"json":      url.QueryEscape(payload),
+		"base64url": base64.RawURLEncoding.EncodeToString([]byte(payload)),
+		"base64":    base64.StdEncoding.EncodeToString([]byte(payload)), // "+" 未转义
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := doJSON(engine, http.MethodGet, "/v1/chat/completions?request="+param, nil)
+			assert.Equal(t, 200, w.Code)
+			var req models.ChatCompletionRequest
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &req))
+			assert.Equal(t, "g", req.Model)
+			assert.Equal(t, "hi?>", req.Messages[0].Content)
+			assert.True(t, req.Stream)
+		})
+	}
+
+	assert.Equal(t, 400, doJSON(engine, http.MethodGet, "/v1/chat/completions", nil).Code)
+	assert.Equal(t, 400, doJSON(engine, http.MethodGet, "/v1/chat/completions?request=not-json", nil).Code)
+	assert.Equal(t, 414, doJSON(engine, http.MethodGet, "/v1/chat/completions?request="+strings.Repeat("a", maxQueryRequestBytes+1), nil).Code)
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cache := NewIdempotencyCache(time.Minute)
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Set("admin_auth", "admin_key")
+		c.Set("admin_id", uint(1))
+		if c.GetHeader("X-Admin") != "" {
+			c.Set("admin_id", uint(2))
+		}
+	})
+	engine.POST("/v1/chat/completions", IdempotencyMiddleware(cache), func(c *gin.Context) {
+		n := calls.Add(1)
+		if c.Query("slow") != "" {
+			<-release
+		}
+		if c.Query("fail") != "" {
+			c.JSON(503, gin.H{"call": n})
+			return
+		}
+		c.JSON(200, gin.H{"call": n})
+	})
+
+	post := func(path, key, body string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	first := post("/v1/chat/completions", "k1", `{"model":"a"}`)
+	assert.Equal(t, 200, first.Code)
+	replay := post("/v1/chat/completions", "k1", `{"model":"a"}`)
+	assert.Equal(t, 200, replay.Code)
+	assert.Equal(t, "true", replay.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Body.String(), replay.Body.String())
+	assert.Equal(t, int32(1), calls.Load())
+
+	// 相同 Key 不同请求体返回 422；不同管理员的 Key 互不影响
+	assert.Equal(t, 422, post("/v1/chat/completions", "k1", `{"model":"b"}`).Code)
+	assert.Equal(t, 200, post("/v1/chat/completions", "k1", `{"model":"a"}`, "X-Admin", "2").Code)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// 失败的响应不缓存，重复请求重新执行
+	assert.Equal(t, 503, post("/v1/chat/completions?fail=1", "k2", `{}`).Code)
+	retry := post("/v1/chat/completions?fail=1", "k2", `{}`)
+	assert.Empty(t, retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, int32(4), calls.Load())
+
+	// 首次请求进行中时，重复请求等待其结果
+	results := make(chan *httptest.ResponseRecorder, 2)
+	go func() { results <- post("/v1/chat/completions?slow=1", "k3", `{}`) }()
+	assert.Eventually(t, func() bool { return calls.Load() == 5 }, time.Second, 5*time.Millisecond)
+	go func() { results <- post("/v1/chat/completions?slow=1", "k3", `{}`) }()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	a, b := <-results, <-results
+	assert.Equal(t, a.Body.String(), b.Body.String())
+	assert.Equal(t, int32(5), calls.Load())
+}
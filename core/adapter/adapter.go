@@ -1,8 +1,17 @@
 package adapter
 
 import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
 	"llm-gateway/models"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -15,6 +24,224 @@ type ProviderAdapter interface {
 
 	// HandleResponse 处理上游响应并将其转换为标准响应（支持流式和非流式）
 	HandleResponse(c *gin.Context, resp *http.Response, isStream bool) error
+
+	// Capabilities 返回适配器支持的请求特性 (模型可通过 ModelConfig.Capabilities 覆盖)
+	Capabilities() models.Capabilities
+}
+
+// isSystemRole 判断消息是否为系统提示词 (OpenAI 新版本使用 developer 代替 system)
+func isSystemRole(role string) bool {
+	return role == "system" || role == "developer"
+}
+
+// DecodeResponseBody 透明解压上游响应 (gzip/deflate)，并移除 Content-Encoding 与 Content-Length
+// http.Transport 只在自己添加 Accept-Encoding 时才自动解压，上游主动压缩时需要在此处理，
+// 否则剥离 Content-Encoding 后原样转发的压缩内容会被客户端当作明文解析
+func DecodeResponseBody(resp *http.Response) error {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	var reader io.Reader
+	switch encoding {
+	case "", "identity":
+		return nil
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return fmt.Errorf("failed to decode gzip response: %w", err)
+		}
+		reader = gz
+	case "deflate":
+		// 规范要求 zlib 格式，但部分服务端发送裸 deflate 流
+		br := bufio.NewReader(resp.Body)
+		if header, err := br.Peek(2); err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return fmt.Errorf("failed to decode deflate response: %w", err)
+			}
+			reader = zr
+		} else {
+			reader = flate.NewReader(br)
+		}
+	default:
+		return fmt.Errorf("unsupported upstream Content-Encoding: %s", encoding)
+	}
+
+	resp.Body = &decodedBody{Reader: reader, body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// decodedBody 读取解压后的内容，关闭时同时关闭原始 Body
+type decodedBody struct {
+	io.Reader
+	body io.ReadCloser
+}
+
+func (b *decodedBody) Close() error {
+	if c, ok := b.Reader.(io.Closer); ok {
+		c.Close()
+	}
+	return b.body.Close()
+}
+
+// 各适配器默认追加到 base URL 之后的路径，可被 ModelConfig.UpstreamPath 覆盖
+// Gemini 路径支持 {model} (上游模型名) 与 {action} (generateContent / streamGenerateContent) 占位符
+const (
+	OpenAIDefaultPath = "/chat/completions"
+	ClaudeDefaultPath = "/messages"
+	GeminiDefaultPath = "/models/{model}:{action}"
+
+	// OpenAIEmbeddingsPath /v1/embeddings 请求默认追加的路径
+	OpenAIEmbeddingsPath = "/embeddings"
+)
+
+// openAIEndpointMarkers URL 中出现这些片段时视为已包含完整接口路径 (兼容旧配置)
+var openAIEndpointMarkers = []string{"/chat/completions", "/images/", "/audio/", "/embeddings"}
+
+// joinUpstreamPath 将路径拼接到 base URL 之后，路径中的查询参数与 base URL 的查询参数合并
+func joinUpstreamPath(baseURL, path string) (*url.URL, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream url: %w", err)
+	}
+	p, err := url.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream path: %w", err)
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(p.Path, "/")
+	u.RawPath = ""
+	if p.RawQuery != "" {
+		q := u.Query()
+		for k, v := range p.Query() {
+			q[k] = v
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u, nil
+}
+
+// SplitOpenAIUpstreamURL 将旧版本保存的完整 OpenAI 接口地址拆分为 base URL 与路径，
+// 拆分后按新规则拼接得到的地址与旧规则完全一致。无需拆分 (已是 base URL) 时 ok 为 false。
+// 路径为默认的 /chat/completions 且不带查询参数时返回空路径
+func SplitOpenAIUpstreamURL(rawURL string) (base, path string, ok bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Path == "" || u.Path == "/" {
+		return "", "", false
+	}
+
+	split := -1
+	for _, marker := range openAIEndpointMarkers {
+		if i := strings.Index(u.Path, marker); i >= 0 && (split < 0 || i < split) {
+			split = i
+		}
+	}
+	if split < 0 {
+		// 旧规则只对 /v1 结尾的地址追加 /chat/completions，其余路径原样请求
+		if strings.HasSuffix(u.Path, "/v1") || strings.HasSuffix(u.Path, "/v1/") {
+			return "", "", false
+		}
+		split = 0
+	}
+
+	path = u.Path[split:]
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	if path == OpenAIDefaultPath {
+		path = ""
+	}
+	u.Path = u.Path[:split]
+	u.RawPath = ""
+	u.RawQuery = ""
+	return u.String(), path, true
+}
+
+// UnsupportedParamError 请求参数无法被上游提供商支持 (如 Claude 不支持 n > 1)
+// 代理层遇到此错误时直接返回 400，而不是重试或返回 500
+type UnsupportedParamError struct {
+	Param   string
+	Message string
+}
+
+func (e *UnsupportedParamError) Error() string {
+	return e.Message
+}
+
+// MalformedResponseError 上游返回 200 但响应体无法解析，此时尚未向客户端写出任何内容
+// 代理层遇到此错误时换用其他 Key/模型重试，重试耗尽时返回 502
+type MalformedResponseError struct {
+	Provider string
+	Body     string // 原始响应体 (截断)，用于日志
+	Err      error
+}
+
+func (e *MalformedResponseError) Error() string {
+	return fmt.Sprintf("malformed %s upstream response: %v", e.Provider, e.Err)
+}
+
+func (e *MalformedResponseError) Unwrap() error {
+	return e.Err
+}
+
+// malformedBodyLimit 日志中记录的原始响应体的最大长度
+const malformedBodyLimit = 512
+
+// malformedResponse 构造 MalformedResponseError，原始响应体截断到 malformedBodyLimit 字节
+func malformedResponse(provider string, body []byte, err error) error {
+	raw := string(body)
+	if len(raw) > malformedBodyLimit {
+		raw = raw[:malformedBodyLimit] + "...(truncated)"
+	}
+	return &MalformedResponseError{Provider: provider, Body: raw, Err: err}
+}
+
+// ToolChoice OpenAI tool_choice 的统一表示
+// Mode 取值: "auto"、"none"、"required"、"function" (指定函数，Name 为函数名)
+type ToolChoice struct {
+	Mode string
+	Name string
+}
+
+// ParseToolChoice 解析 OpenAI tool_choice (字符串或 {"type":"function","function":{"name":...}})
+// 未指定或无法识别时返回 nil
+func ParseToolChoice(v interface{}) *ToolChoice {
+	switch tc := v.(type) {
+	case string:
+		switch tc {
+		case "auto", "none", "required":
+			return &ToolChoice{Mode: tc}
+		}
+	case map[string]interface{}:
+		if fn, ok := tc["function"].(map[string]interface{}); ok {
+			if name, ok := fn["name"].(string); ok && name != "" {
+				return &ToolChoice{Mode: "function", Name: name}
+			}
+		}
+	}
+	return nil
+}
+
+// wantsStreamUsage 客户端是否通过 stream_options.include_usage 要求在流式响应中返回用量
+func wantsStreamUsage(req models.ChatCompletionRequest) bool {
+	return req.Stream && req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+}
+
+// usageChunk 构造 OpenAI 规范的流式用量事件: choices 为空数组，usage 为整个请求的用量，
+// 作为最后一个 chunk 紧接在 [DONE] 之前发送
+func usageChunk(id, model string, created int64, usage *models.ChatCompletionUsage) []byte {
+	chunk := models.ChatCompletionResponse{
+		ID:      id,
+		Object:  "chat.completion.chunk",
+		Created: created,
+		Model:   model,
+		Choices: []models.ChatCompletionChoice{},
+		Usage:   usage,
+	}
+	b, _ := json.Marshal(chunk)
+	return []byte(fmt.Sprintf("data: %s\n\n", b))
 }
 
 // StreamScanner 通用流式解析接口 (Task 3)
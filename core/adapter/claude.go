@@ -14,37 +14,84 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-type ClaudeAdapter struct{}
+type ClaudeAdapter struct {
+	// DefaultMaxTokens 客户端未指定 max_tokens 时使用的值 (Claude 必填)，<= 0 时为 4096
+	DefaultMaxTokens int
+
+	// UpstreamPath 追加到 base URL 之后的接口路径，留空使用 /messages
+	UpstreamPath string
+
+	// structuredTool response_format json_schema 映射成的工具名，响应时将其调用参数还原为文本内容
+	structuredTool string
+	// includeUsage 客户端设置了 stream_options.include_usage，流末尾发送用量 chunk
+	includeUsage bool
+}
+
+// structuredOutputTool json_schema 未指定 name 时使用的工具名
+const structuredOutputTool = "json_response"
 
 func NewClaudeAdapter() *ClaudeAdapter {
 	return &ClaudeAdapter{}
 }
 
+// Capabilities image_url 转换为 image 块，json_schema 通过强制工具调用实现
+func (a *ClaudeAdapter) Capabilities() models.Capabilities {
+	return models.Capabilities{Tools: true, Vision: true, JSONSchema: true, Streaming: true}
+}
+
 // ConvertRequest OpenAI -> Claude
 func (a *ClaudeAdapter) ConvertRequest(ctx *gin.Context, originalReq models.ChatCompletionRequest, apiKey string, baseURL string, upstreamModel string) (*http.Request, error) {
+	// Claude 每次请求只返回一个结果
+	if originalReq.N != nil && *originalReq.N > 1 {
+		return nil, &UnsupportedParamError{Param: "n", Message: "n > 1 is not supported by Claude upstreams"}
+	}
+
 	claudeReq := ClaudeRequest{
 		Model:    upstreamModel,
 		Messages: make([]ClaudeMessage, 0),
 		Stream:   originalReq.Stream,
 	}
 
+	// 是否使用了 Prompt Caching (content part 上的 cache_control 扩展字段)
+	usesCache := false
+
 	// 1. Extract System Prompt
 	var systemPromptBuilder strings.Builder
+	var systemBlocks []ClaudeContentBlock
 	for _, msg := range originalReq.Messages {
-		if msg.Role == "system" {
+		if isSystemRole(msg.Role) {
 			if systemPromptBuilder.Len() > 0 {
 				systemPromptBuilder.WriteString("\n")
 			}
 			systemPromptBuilder.WriteString(msg.StringContent())
+
+			// 保留分段及缓存标记，以便按块缓存
+			if listContent, ok := msg.Content.([]interface{}); ok {
+				for _, item := range listContent {
+					itemMap, ok := item.(map[string]interface{})
+					if !ok {
+						continue
+					}
+					if textVal, ok := itemMap["text"].(string); ok {
+						block := ClaudeContentBlock{Type: "text", Text: textVal, CacheControl: parseCacheControl(itemMap)}
+						usesCache = usesCache || block.CacheControl != nil
+						systemBlocks = append(systemBlocks, block)
+					}
+				}
+			} else if text := msg.StringContent(); text != "" {
+				systemBlocks = append(systemBlocks, ClaudeContentBlock{Type: "text", Text: text})
+			}
 		}
 	}
-	if systemPromptBuilder.Len() > 0 {
+	if usesCache {
+		claudeReq.System = systemBlocks
+	} else if systemPromptBuilder.Len() > 0 {
 		claudeReq.System = systemPromptBuilder.String()
 	}
 
 	// 2. Transform Messages
 	for _, msg := range originalReq.Messages {
-		if msg.Role == "system" {
+		if isSystemRole(msg.Role) {
 			continue
 		}
 
@@ -78,7 +125,9 @@ func (a *ClaudeAdapter) ConvertRequest(ctx *gin.Context, originalReq models.Chat
 					typeVal, _ := itemMap["type"].(string)
 					if typeVal == "text" {
 						if textVal, ok := itemMap["text"].(string); ok {
-							blocks = append(blocks, ClaudeContentBlock{Type: "text", Text: textVal})
+							block := ClaudeContentBlock{Type: "text", Text: textVal, CacheControl: parseCacheControl(itemMap)}
+							usesCache = usesCache || block.CacheControl != nil
+							blocks = append(blocks, block)
 						}
 					} else if typeVal == "image_url" {
 						if imageUrlMap, ok := itemMap["image_url"].(map[string]interface{}); ok {
@@ -140,9 +189,62 @@ func (a *ClaudeAdapter) ConvertRequest(ctx *gin.Context, originalReq models.Chat
 		}
 	}
 
+	// 3a. Tool Choice (仅在定义了工具时设置，否则 Claude 会报错)
+	if len(claudeReq.Tools) > 0 {
+		if tc := ParseToolChoice(originalReq.ToolChoice); tc != nil {
+			switch tc.Mode {
+			case "auto":
+				claudeReq.ToolChoice = &ClaudeToolChoice{Type: "auto"}
+			case "none":
+				claudeReq.ToolChoice = &ClaudeToolChoice{Type: "none"}
+			case "required":
+				claudeReq.ToolChoice = &ClaudeToolChoice{Type: "any"}
+			case "function":
+				claudeReq.ToolChoice = &ClaudeToolChoice{Type: "tool", Name: tc.Name}
+			}
+		}
+
+		// parallel_tool_calls: false -> disable_parallel_tool_use: true (客户端未指定时保持默认)
+		if originalReq.ParallelToolCalls != nil && !*originalReq.ParallelToolCalls {
+			choice, ok := claudeReq.ToolChoice.(*ClaudeToolChoice)
+			if !ok {
+				choice = &ClaudeToolChoice{Type: "auto"}
+				claudeReq.ToolChoice = choice
+			}
+			if choice.Type != "none" {
+				choice.DisableParallelToolUse = true
+			}
+		}
+	}
+
+	// 3b. response_format: json_schema -> 以该 schema 为参数的工具 (Claude 没有原生的结构化输出)
+	// 没有其他工具时强制调用，响应中的调用参数即为符合 schema 的 JSON
+	if rf := originalReq.ResponseFormat; rf != nil && rf.Type == "json_schema" && rf.JSONSchema != nil {
+		name := rf.JSONSchema.Name
+		if name == "" {
+			name = structuredOutputTool
+		}
+		schema := rf.JSONSchema.Schema
+		if schema == nil {
+			schema = map[string]interface{}{"type": "object"}
+		}
+		claudeReq.Tools = append(claudeReq.Tools, ClaudeTool{
+			Name:        name,
+			Description: rf.JSONSchema.Description,
+			InputSchema: schema,
+		})
+		if len(claudeReq.Tools) == 1 {
+			claudeReq.ToolChoice = &ClaudeToolChoice{Type: "tool", Name: name}
+		}
+		a.structuredTool = name
+	}
+	a.includeUsage = wantsStreamUsage(originalReq)
+
 	// 4. Transform Config
 	if originalReq.MaxTokens != nil {
 		claudeReq.MaxTokens = *originalReq.MaxTokens
+	} else if a.DefaultMaxTokens > 0 {
+		claudeReq.MaxTokens = a.DefaultMaxTokens
 	} else {
 		claudeReq.MaxTokens = 4096 // Default safe limit
 	}
@@ -152,6 +254,28 @@ func (a *ClaudeAdapter) ConvertRequest(ctx *gin.Context, originalReq models.Chat
 	if originalReq.TopP != nil {
 		claudeReq.TopP = *originalReq.TopP
 	}
+	if stops := originalReq.StopSequences(); len(stops) > 0 {
+		claudeReq.StopSequences = stops
+	}
+	// seed: Claude 不支持，直接忽略 (不报错)
+
+	// 4a. Extended thinking: max_tokens 必须大于思考预算，且不能修改采样参数或强制调用工具
+	if thinking := claudeThinking(originalReq); thinking != nil {
+		claudeReq.Thinking = thinking
+		if claudeReq.MaxTokens <= thinking.BudgetTokens {
+			claudeReq.MaxTokens += thinking.BudgetTokens
+		}
+		claudeReq.Temperature = 0
+		claudeReq.TopP = 0
+		claudeReq.TopK = 0
+		if choice, ok := claudeReq.ToolChoice.(*ClaudeToolChoice); ok && (choice.Type == "any" || choice.Type == "tool") {
+			if a.structuredTool == "" || choice.Name != a.structuredTool {
+				return nil, &UnsupportedParamError{Param: "tool_choice", Message: "forcing a tool call is not supported together with Claude extended thinking"}
+			}
+			// 结构化输出退化为自动选择，该工具是唯一的工具
+			claudeReq.ToolChoice = &ClaudeToolChoice{Type: "auto"}
+		}
+	}
 
 	// Build Request
 	reqBodyBytes, err := json.Marshal(claudeReq)
@@ -159,7 +283,16 @@ func (a *ClaudeAdapter) ConvertRequest(ctx *gin.Context, originalReq models.Chat
 		return nil, fmt.Errorf("marshal claude req error: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx.Request.Context(), "POST", baseURL+"/messages", bytes.NewBuffer(reqBodyBytes))
+	path := a.UpstreamPath
+	if path == "" {
+		path = ClaudeDefaultPath
+	}
+	u, err := joinUpstreamPath(baseURL, path)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx.Request.Context(), "POST", u.String(), bytes.NewBuffer(reqBodyBytes))
 	if err != nil {
 		return nil, fmt.Errorf("create req error: %w", err)
 	}
@@ -167,12 +300,75 @@ func (a *ClaudeAdapter) ConvertRequest(ctx *gin.Context, originalReq models.Chat
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("x-api-key", apiKey)
 	req.Header.Set("anthropic-version", "2023-06-01")
+	if usesCache {
+		req.Header.Set("anthropic-beta", PromptCachingBeta)
+	}
 
 	return req, nil
 }
 
+// minThinkingBudget Claude 允许的最小思考预算
+const minThinkingBudget = 1024
+
+// reasoningEffortBudgets reasoning_effort 对应的思考预算
+var reasoningEffortBudgets = map[string]int{
+	"minimal": minThinkingBudget,
+	"low":     minThinkingBudget,
+	"medium":  4096,
+	"high":    16384,
+}
+
+// claudeThinking 根据 thinking 扩展字段或 reasoning_effort 生成 extended thinking 配置，不启用时返回 nil
+func claudeThinking(req models.ChatCompletionRequest) *ClaudeThinking {
+	if t := req.Thinking; t != nil {
+		if t.Type != "enabled" {
+			return nil
+		}
+		budget := t.BudgetTokens
+		if budget == 0 {
+			budget = reasoningEffortBudgets["medium"]
+		}
+		return &ClaudeThinking{Type: "enabled", BudgetTokens: max(budget, minThinkingBudget)}
+	}
+	if budget, ok := reasoningEffortBudgets[strings.ToLower(req.ReasoningEffort)]; ok {
+		return &ClaudeThinking{Type: "enabled", BudgetTokens: budget}
+	}
+	return nil
+}
+
+// PromptCachingBeta Anthropic Prompt Caching 的 beta 标识
+const PromptCachingBeta = "prompt-caching-2024-07-31"
+
+// parseCacheControl 读取 OpenAI content part 上的 cache_control 扩展字段
+// 例如: {"type": "text", "text": "...", "cache_control": {"type": "ephemeral"}}
+func parseCacheControl(part map[string]interface{}) *ClaudeCacheControl {
+	cc, ok := part["cache_control"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	typ, _ := cc["type"].(string)
+	if typ == "" {
+		typ = "ephemeral"
+	}
+	return &ClaudeCacheControl{Type: typ}
+}
+
+// convertUsage Claude Usage -> OpenAI Usage (包含缓存读写 Token)
+func convertUsage(u *ClaudeUsage) *models.ChatCompletionUsage {
+	return &models.ChatCompletionUsage{
+		PromptTokens:     u.InputTokens,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      u.InputTokens + u.OutputTokens,
+		CacheReadTokens:  u.CacheReadInputTokens,
+		CacheWriteTokens: u.CacheCreationInputTokens,
+	}
+}
+
 // HandleResponse Claude -> OpenAI
 func (a *ClaudeAdapter) HandleResponse(c *gin.Context, resp *http.Response, isStream bool) error {
+	if err := DecodeResponseBody(resp); err != nil {
+		return err
+	}
 	if isStream {
 		return a.handleStreamResponse(c, resp)
 	}
@@ -194,7 +390,7 @@ func (a *ClaudeAdapter) handleNormalResponse(c *gin.Context, resp *http.Response
 
 	var claudeResp ClaudeResponse
 	if err := json.Unmarshal(bodyBytes, &claudeResp); err != nil {
-		return err
+		return malformedResponse("claude", bodyBytes, err)
 	}
 
 	openaiResp := models.ChatCompletionResponse{
@@ -203,20 +399,23 @@ func (a *ClaudeAdapter) handleNormalResponse(c *gin.Context, resp *http.Response
 		Created: time.Now().Unix(),
 		Model:   claudeResp.Model,
 		Choices: []models.ChatCompletionChoice{},
-		Usage: &models.ChatCompletionUsage{
-			PromptTokens:     claudeResp.Usage.InputTokens,
-			CompletionTokens: claudeResp.Usage.OutputTokens,
-			TotalTokens:      claudeResp.Usage.InputTokens + claudeResp.Usage.OutputTokens,
-		},
+		Usage:   convertUsage(&claudeResp.Usage),
 	}
+	c.Set("usage", openaiResp.Usage)
 
 	// Process Content & Tool Calls
-	var contentBuilder strings.Builder
+	var contentBuilder, reasoningBuilder strings.Builder
 	var toolCalls []models.ChatToolCall
 
 	for _, block := range claudeResp.Content {
 		if block.Type == "text" {
 			contentBuilder.WriteString(block.Text)
+		} else if block.Type == "thinking" {
+			reasoningBuilder.WriteString(block.Thinking)
+		} else if block.Type == "tool_use" && a.structuredTool != "" && block.Name == a.structuredTool {
+			// 结构化输出: 工具参数作为正文返回
+			argsBytes, _ := json.Marshal(block.Input)
+			contentBuilder.WriteString(string(argsBytes))
 		} else if block.Type == "tool_use" {
 			argsBytes, _ := json.Marshal(block.Input)
 			toolCalls = append(toolCalls, models.ChatToolCall{
@@ -233,14 +432,17 @@ func (a *ClaudeAdapter) handleNormalResponse(c *gin.Context, resp *http.Response
 	choice := models.ChatCompletionChoice{
 		Index: 0,
 		Message: models.ChatMessage{
-			Role:    "assistant",
-			Content: contentBuilder.String(),
+			Role:             "assistant",
+			Content:          contentBuilder.String(),
+			ReasoningContent: reasoningBuilder.String(),
 		},
 		FinishReason: mapStopReason(claudeResp.StopReason),
 	}
 
 	if len(toolCalls) > 0 {
 		choice.Message.ToolCalls = toolCalls
+	} else if a.structuredTool != "" && choice.FinishReason == "tool_calls" {
+		choice.FinishReason = "stop"
 	}
 
 	openaiResp.Choices = append(openaiResp.Choices, choice)
@@ -275,18 +477,31 @@ type ClaudeStreamScanner struct {
 	err          error
 	currentIdx   int
 	isFirstChunk bool
+	model        string
+	usage        ClaudeUsage // message_start 提供输入/缓存 Token，message_delta 提供输出 Token
+	done         bool
+
+	IncludeUsage bool // 流结束时 ([DONE] 之前) 单独发送一个 choices 为空的用量 chunk
+
+	StructuredTool string // 结构化输出使用的工具名，其参数增量作为 content 发送
+	structuredIdx  int    // 该工具对应的 content block 序号，-1 表示尚未出现
+	sentToolCalls  bool
 }
 
 func NewClaudeStreamScanner(r io.Reader) *ClaudeStreamScanner {
 	return &ClaudeStreamScanner{
 		scanner:      bufio.NewScanner(r),
 		created:      time.Now().Unix(),
-		currentIdx:   0,
-		isFirstChunk: true,
+		currentIdx:    0,
+		isFirstChunk:  true,
+		structuredIdx: -1,
 	}
 }
 
 func (s *ClaudeStreamScanner) Scan() bool {
+	if s.done {
+		return false
+	}
 	for s.scanner.Scan() {
 		line := s.scanner.Text()
 		if !strings.HasPrefix(line, "event: ") {
@@ -322,14 +537,19 @@ func (s *ClaudeStreamScanner) Scan() bool {
 		case "message_start":
 			if event.Message != nil {
 				s.requestID = event.Message.ID
+				s.model = event.Message.Model
+				s.usage = event.Message.Usage
 				chunk.ID = s.requestID
 				chunk.Model = event.Message.Model
 				chunk.Choices[0].Delta.Role = "assistant"
 				hasContent = true
 			}
 		case "content_block_start":
-			if event.ContentBlock != nil && event.ContentBlock.Type == "tool_use" {
+			if event.ContentBlock != nil && event.ContentBlock.Type == "tool_use" && s.StructuredTool != "" && event.ContentBlock.Name == s.StructuredTool {
+				s.structuredIdx = event.Index
+			} else if event.ContentBlock != nil && event.ContentBlock.Type == "tool_use" {
 				// Start of tool use
+				s.sentToolCalls = true
 				chunk.ID = s.requestID
 				chunk.Choices[0].Delta.ToolCalls = []models.ChatToolCall{
 					{
@@ -350,6 +570,12 @@ func (s *ClaudeStreamScanner) Scan() bool {
 				if event.Delta.Type == "text_delta" {
 					chunk.Choices[0].Delta.Content = event.Delta.Text
 					hasContent = true
+				} else if event.Delta.Type == "thinking_delta" {
+					chunk.Choices[0].Delta.ReasoningContent = event.Delta.Thinking
+					hasContent = true
+				} else if event.Delta.Type == "input_json_delta" && event.Index == s.structuredIdx {
+					chunk.Choices[0].Delta.Content = event.Delta.PartialJson
+					hasContent = true
 				} else if event.Delta.Type == "input_json_delta" {
 					chunk.Choices[0].Delta.ToolCalls = []models.ChatToolCall{
 						{
@@ -366,23 +592,20 @@ func (s *ClaudeStreamScanner) Scan() bool {
 			if event.Delta != nil && event.Delta.StopReason != nil {
 				chunk.ID = s.requestID
 				chunk.Choices[0].FinishReason = mapStopReason(event.Delta.StopReason)
+				if s.structuredIdx >= 0 && !s.sentToolCalls && chunk.Choices[0].FinishReason == "tool_calls" {
+					chunk.Choices[0].FinishReason = "stop"
+				}
 				hasContent = true
 			}
 			if event.Usage != nil {
-				// Usage in stream is usually handled by a final chunk in OpenAI
-				// We can emit it here
-				// Note: standard OpenAI clients might expect usage in a separate chunk with empty choices, 
-				// or attached to the last chunk. Let's attach to this one if possible, or emit separate.
-				// But here we are inside a single loop iteration.
-				// Let's just set it.
-				chunk.Usage = &models.ChatCompletionUsage{
-					PromptTokens:     event.Usage.InputTokens,
-					CompletionTokens: event.Usage.OutputTokens,
-					TotalTokens:      event.Usage.InputTokens + event.Usage.OutputTokens,
+				// 用量在流结束时按 OpenAI 规范单独发送
+				s.usage.OutputTokens = event.Usage.OutputTokens
+				if event.Usage.InputTokens > 0 {
+					s.usage.InputTokens = event.Usage.InputTokens
 				}
 			}
 		case "message_stop":
-			return false // End of stream
+			return s.finish() // End of stream
 		case "ping":
 			continue
 		}
@@ -396,8 +619,19 @@ func (s *ClaudeStreamScanner) Scan() bool {
 
 	if s.scanner.Err() != nil {
 		s.err = s.scanner.Err()
+		return false
 	}
-	return false
+	return s.finish()
+}
+
+// finish 结束流: 客户端要求返回用量时发送最后的用量 chunk
+func (s *ClaudeStreamScanner) finish() bool {
+	s.done = true
+	if !s.IncludeUsage {
+		return false
+	}
+	s.current = usageChunk(s.requestID, s.model, s.created, s.Usage())
+	return true
 }
 
 func (s *ClaudeStreamScanner) Bytes() []byte {
@@ -408,6 +642,11 @@ func (s *ClaudeStreamScanner) Err() error {
 	return s.err
 }
 
+// Usage 返回目前累计的 Token 用量
+func (s *ClaudeStreamScanner) Usage() *models.ChatCompletionUsage {
+	return convertUsage(&s.usage)
+}
+
 func (a *ClaudeAdapter) handleStreamResponse(c *gin.Context, resp *http.Response) error {
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
@@ -416,7 +655,11 @@ func (a *ClaudeAdapter) handleStreamResponse(c *gin.Context, resp *http.Response
 	c.Status(200)
 	c.Writer.Flush()
 
-	var scanner StreamScanner = NewClaudeStreamScanner(resp.Body)
+	claudeScanner := NewClaudeStreamScanner(resp.Body)
+	claudeScanner.StructuredTool = a.structuredTool
+	claudeScanner.IncludeUsage = a.includeUsage
+	var scanner StreamScanner = claudeScanner
+	defer func() { c.Set("usage", claudeScanner.Usage()) }()
 
 	for scanner.Scan() {
 		if _, err := c.Writer.Write(scanner.Bytes()); err != nil {
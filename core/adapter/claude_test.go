@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -47,6 +48,47 @@ temp := 0.7
 	assert.Equal(t, "user", claudeReq.Messages[0].Role)
 }
 
+func TestClaudeAdapter_ConvertRequest_DeveloperRole(t *testing.T) {
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+
+	req, err := NewClaudeAdapter().ConvertRequest(ctx, models.ChatCompletionRequest{
+		Model: "gpt-4",
+		Messages: []models.ChatMessage{
+			{Role: "developer", Content: "Be brief."},
+			{Role: "user", Content: "Hello!"},
+		},
+	}, "sk-test-key", "https://api.anthropic.com/v1", "claude-3-sonnet")
+	assert.NoError(t, err)
+
+	var claudeReq ClaudeRequest
+	assert.NoError(t, json.NewDecoder(req.Body).Decode(&claudeReq))
+	assert.Equal(t, "Be brief.", claudeReq.System)
+	assert.Equal(t, 1, len(claudeReq.Messages))
+	assert.Equal(t, "user", claudeReq.Messages[0].Role)
+}
+
+func TestClaudeAdapter_ConvertRequest_UpstreamPath(t *testing.T) {
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+	originalReq := models.ChatCompletionRequest{
+		Model:    "claude",
+		Messages: []models.ChatMessage{{Role: "user", Content: "Hello!"}},
+	}
+
+	req, err := NewClaudeAdapter().ConvertRequest(ctx, originalReq, "sk-ant", "https://api.anthropic.com/v1/", "claude-3-5-sonnet")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://api.anthropic.com/v1/messages", req.URL.String())
+
+	a := NewClaudeAdapter()
+	a.UpstreamPath = "/anthropic/messages?beta=true"
+	req, err = a.ConvertRequest(ctx, originalReq, "sk-ant", "https://proxy.example.com", "claude-3-5-sonnet")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://proxy.example.com/anthropic/messages?beta=true", req.URL.String())
+}
+
 func TestClaudeAdapter_HandleResponse_Stream(t *testing.T) {
 	// 模拟 Claude SSE
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -100,3 +142,422 @@ func TestClaudeAdapter_HandleResponse_Stream(t *testing.T) {
 
 	assert.Equal(t, "Hello", fullText)
 }
+
+func TestClaudeAdapter_ConvertRequest_PromptCaching(t *testing.T) {
+	adapter := NewClaudeAdapter()
+
+	originalReq := models.ChatCompletionRequest{
+		Model: "claude",
+		Messages: []models.ChatMessage{
+			{Role: "system", Content: []interface{}{
+				map[string]interface{}{"type": "text", "text": "Long system prompt", "cache_control": map[string]interface{}{"type": "ephemeral"}},
+			}},
+			{Role: "user", Content: "Hello!"},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+
+	req, err := adapter.ConvertRequest(ctx, originalReq, "sk-test-key", "https://api.anthropic.com/v1", "claude-3-5-sonnet")
+	assert.NoError(t, err)
+	assert.Equal(t, PromptCachingBeta, req.Header.Get("anthropic-beta"))
+
+	var body map[string]interface{}
+	assert.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+	system, ok := body["system"].([]interface{})
+	assert.True(t, ok, "system should be sent as content blocks")
+	assert.Equal(t, map[string]interface{}{"type": "ephemeral"}, system[0].(map[string]interface{})["cache_control"])
+
+	// 未使用缓存时不设置 beta 头
+	originalReq.Messages[0] = models.ChatMessage{Role: "system", Content: "Short prompt"}
+	req, err = adapter.ConvertRequest(ctx, originalReq, "sk-test-key", "https://api.anthropic.com/v1", "claude-3-5-sonnet")
+	assert.NoError(t, err)
+	assert.Empty(t, req.Header.Get("anthropic-beta"))
+}
+
+func TestClaudeAdapter_HandleResponse_CacheUsage(t *testing.T) {
+	adapter := NewClaudeAdapter()
+	body := `{"id":"msg_1","type":"message","role":"assistant","model":"claude","content":[{"type":"text","text":"hi"}],
+		"usage":{"input_tokens":10,"output_tokens":5,"cache_creation_input_tokens":100,"cache_read_input_tokens":200}}`
+	resp := &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+
+	assert.NoError(t, adapter.HandleResponse(ctx, resp, false))
+
+	var openaiResp models.ChatCompletionResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &openaiResp))
+	assert.Equal(t, 200, openaiResp.Usage.CacheReadTokens)
+	assert.Equal(t, 100, openaiResp.Usage.CacheWriteTokens)
+
+	usage, exists := ctx.Get("usage")
+	assert.True(t, exists)
+	assert.Equal(t, 200, usage.(*models.ChatCompletionUsage).CacheReadTokens)
+}
+
+func TestClaudeAdapter_ConvertRequest_RejectsN(t *testing.T) {
+	adapter := NewClaudeAdapter()
+	n := 2
+	originalReq := models.ChatCompletionRequest{
+		Model:    "claude",
+		Messages: []models.ChatMessage{{Role: "user", Content: "Hello!"}},
+		N:        &n,
+	}
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+
+	_, err := adapter.ConvertRequest(ctx, originalReq, "sk-test-key", "https://api.anthropic.com/v1", "claude-3-sonnet")
+	var paramErr *UnsupportedParamError
+	assert.ErrorAs(t, err, &paramErr)
+	assert.Equal(t, "n", paramErr.Param)
+
+	// n = 1 正常转换
+	n = 1
+	_, err = adapter.ConvertRequest(ctx, originalReq, "sk-test-key", "https://api.anthropic.com/v1", "claude-3-sonnet")
+	assert.NoError(t, err)
+}
+
+func TestClaudeAdapter_ConvertRequest_Stop(t *testing.T) {
+	adapter := NewClaudeAdapter()
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+
+	for _, tc := range []struct {
+		stop     interface{}
+		expected []string
+	}{
+		{"END", []string{"END"}},
+		{[]interface{}{"END", "STOP"}, []string{"END", "STOP"}},
+	} {
+		originalReq := models.ChatCompletionRequest{
+			Model:    "claude",
+			Messages: []models.ChatMessage{{Role: "user", Content: "Hello!"}},
+			Stop:     tc.stop,
+		}
+		req, err := adapter.ConvertRequest(ctx, originalReq, "sk-test-key", "https://api.anthropic.com/v1", "claude-3-sonnet")
+		assert.NoError(t, err)
+
+		var claudeReq ClaudeRequest
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&claudeReq))
+		assert.Equal(t, tc.expected, claudeReq.StopSequences)
+	}
+}
+
+func TestClaudeAdapter_ConvertRequest_ToolChoice(t *testing.T) {
+	adapter := NewClaudeAdapter()
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+
+	tools := []models.ChatTool{{Type: "function", Function: models.ChatToolFunction{Name: "get_weather"}}}
+	for _, tc := range []struct {
+		choice   interface{}
+		expected map[string]interface{}
+	}{
+		{"auto", map[string]interface{}{"type": "auto"}},
+		{"none", map[string]interface{}{"type": "none"}},
+		{"required", map[string]interface{}{"type": "any"}},
+		{map[string]interface{}{"type": "function", "function": map[string]interface{}{"name": "get_weather"}},
+			map[string]interface{}{"type": "tool", "name": "get_weather"}},
+	} {
+		originalReq := models.ChatCompletionRequest{
+			Model:      "claude",
+			Messages:   []models.ChatMessage{{Role: "user", Content: "Hello!"}},
+			Tools:      tools,
+			ToolChoice: tc.choice,
+		}
+		req, err := adapter.ConvertRequest(ctx, originalReq, "sk-test-key", "https://api.anthropic.com/v1", "claude-3-sonnet")
+		assert.NoError(t, err)
+
+		var body map[string]interface{}
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+		assert.Equal(t, tc.expected, body["tool_choice"])
+	}
+
+	// 没有工具时不设置 tool_choice
+	req, err := adapter.ConvertRequest(ctx, models.ChatCompletionRequest{
+		Model:      "claude",
+		Messages:   []models.ChatMessage{{Role: "user", Content: "Hello!"}},
+		ToolChoice: "required",
+	}, "sk-test-key", "https://api.anthropic.com/v1", "claude-3-sonnet")
+	assert.NoError(t, err)
+	var body map[string]interface{}
+	assert.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+	assert.NotContains(t, body, "tool_choice")
+}
+
+func TestClaudeAdapter_ConvertRequest_ParallelToolCalls(t *testing.T) {
+	adapter := NewClaudeAdapter()
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+
+	convert := func(req models.ChatCompletionRequest) map[string]interface{} {
+		httpReq, err := adapter.ConvertRequest(ctx, req, "sk-test-key", "https://api.anthropic.com/v1", "claude-3-sonnet")
+		assert.NoError(t, err)
+		var body map[string]interface{}
+		assert.NoError(t, json.NewDecoder(httpReq.Body).Decode(&body))
+		return body
+	}
+
+	disabled, enabled := false, true
+	tools := []models.ChatTool{{Type: "function", Function: models.ChatToolFunction{Name: "get_weather"}}}
+	base := models.ChatCompletionRequest{
+		Model:    "claude",
+		Messages: []models.ChatMessage{{Role: "user", Content: "Hello!"}},
+		Tools:    tools,
+	}
+
+	// false -> auto + disable_parallel_tool_use
+	req := base
+	req.ParallelToolCalls = &disabled
+	assert.Equal(t, map[string]interface{}{"type": "auto", "disable_parallel_tool_use": true}, convert(req)["tool_choice"])
+
+	// 与指定的 tool_choice 组合
+	req.ToolChoice = "required"
+	assert.Equal(t, map[string]interface{}{"type": "any", "disable_parallel_tool_use": true}, convert(req)["tool_choice"])
+
+	// 未指定或为 true 时不设置
+	assert.NotContains(t, convert(base), "tool_choice")
+	req = base
+	req.ParallelToolCalls = &enabled
+	assert.NotContains(t, convert(req), "tool_choice")
+
+	// 没有工具时忽略
+	req = models.ChatCompletionRequest{Model: "claude", Messages: base.Messages, ParallelToolCalls: &disabled}
+	assert.NotContains(t, convert(req), "tool_choice")
+}
+
+func TestClaudeAdapter_ConvertRequest_DefaultMaxTokens(t *testing.T) {
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+	req := models.ChatCompletionRequest{Model: "claude", Messages: []models.ChatMessage{{Role: "user", Content: "Hello!"}}}
+
+	maxTokens := func(adapter *ClaudeAdapter, req models.ChatCompletionRequest) int {
+		httpReq, err := adapter.ConvertRequest(ctx, req, "sk-test-key", "https://api.anthropic.com/v1", "claude-3-sonnet")
+		assert.NoError(t, err)
+		var body ClaudeRequest
+		assert.NoError(t, json.NewDecoder(httpReq.Body).Decode(&body))
+		return body.MaxTokens
+	}
+
+	assert.Equal(t, 4096, maxTokens(NewClaudeAdapter(), req))
+	assert.Equal(t, 8192, maxTokens(&ClaudeAdapter{DefaultMaxTokens: 8192}, req))
+
+	explicit := 100
+	req.MaxTokens = &explicit
+	assert.Equal(t, 100, maxTokens(&ClaudeAdapter{DefaultMaxTokens: 8192}, req))
+}
+
+func TestClaudeAdapter_ResponseFormatJSONSchema(t *testing.T) {
+	a := NewClaudeAdapter()
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"city": map[string]interface{}{"type": "string"}},
+	}
+	originalReq := models.ChatCompletionRequest{
+		Model:    "gpt-4",
+		Messages: []models.ChatMessage{{Role: "user", Content: "Hello!"}},
+		ResponseFormat: &models.ResponseFormat{
+			Type:       "json_schema",
+			JSONSchema: &models.JSONSchema{Name: "answer", Description: "The answer", Schema: schema},
+		},
+	}
+	req, err := a.ConvertRequest(ctx, originalReq, "sk-test", "https://api.anthropic.com/v1", "claude-3-sonnet")
+	assert.NoError(t, err)
+
+	var body map[string]interface{}
+	assert.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"name":         "answer",
+		"description":  "The answer",
+		"input_schema": schema,
+	}}, body["tools"])
+	assert.Equal(t, map[string]interface{}{"type": "tool", "name": "answer"}, body["tool_choice"])
+
+	// 工具调用参数还原为文本内容
+	resp := &http.Response{
+		StatusCode: 200,
+		Body: io.NopCloser(strings.NewReader(`{"id":"msg_1","model":"claude-3","stop_reason":"tool_use",` +
+			`"content":[{"type":"tool_use","id":"toolu_1","name":"answer","input":{"city":"Paris"}}],"usage":{"input_tokens":5,"output_tokens":3}}`)),
+	}
+	w = httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	assert.NoError(t, a.HandleResponse(c, resp, false))
+
+	var out models.ChatCompletionResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &out))
+	assert.Equal(t, `{"city":"Paris"}`, out.Choices[0].Message.Content)
+	assert.Empty(t, out.Choices[0].Message.ToolCalls)
+	assert.Equal(t, "stop", out.Choices[0].FinishReason)
+}
+
+func TestClaudeStreamScanner_StructuredTool(t *testing.T) {
+	events := "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"model\":\"claude-3\"}}\n\n" +
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"tool_use\",\"id\":\"toolu_1\",\"name\":\"answer\"}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{\\\"city\\\":\"}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"\\\"Paris\\\"}\"}}\n\n" +
+		"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"tool_use\"},\"usage\":{\"output_tokens\":3}}\n\n" +
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+
+	s := NewClaudeStreamScanner(strings.NewReader(events))
+	s.StructuredTool = "answer"
+
+	var content, finish string
+	for s.Scan() {
+		var chunk models.ChatCompletionResponse
+		data := strings.TrimSuffix(strings.TrimPrefix(string(s.Bytes()), "data: "), "\n\n")
+		assert.NoError(t, json.Unmarshal([]byte(data), &chunk))
+		delta := chunk.Choices[0].Delta
+		assert.Empty(t, delta.ToolCalls)
+		if text, ok := delta.Content.(string); ok {
+			content += text
+		}
+		if chunk.Choices[0].FinishReason != "" {
+			finish = chunk.Choices[0].FinishReason
+		}
+	}
+	assert.NoError(t, s.Err())
+	assert.Equal(t, `{"city":"Paris"}`, content)
+	assert.Equal(t, "stop", finish)
+}
+
+func TestClaudeAdapter_HandleResponse_FinalUsageChunk(t *testing.T) {
+	upstream := "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"model\":\"claude-3\",\"usage\":{\"input_tokens\":10}}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hi\"}}\n\n" +
+		"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"},\"usage\":{\"output_tokens\":3}}\n\n" +
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+
+	events := streamWithUsage(t, NewClaudeAdapter(), upstream)
+	assert.Len(t, events, 5)
+	assert.Contains(t, events[2], `"finish_reason":"stop"`)
+	assert.Contains(t, events[3], `"id":"msg_1"`)
+	assertFinalUsageChunk(t, events, 10, 3)
+
+	// 未设置 include_usage 时不发送用量 chunk
+	s := NewClaudeStreamScanner(strings.NewReader(upstream))
+	for s.Scan() {
+		assert.NotContains(t, string(s.Bytes()), "usage")
+	}
+}
+
+func TestClaudeAdapter_ConvertRequest_DropsSeed(t *testing.T) {
+	adapter := NewClaudeAdapter()
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+
+	seed := 42
+	originalReq := models.ChatCompletionRequest{
+		Model:    "claude",
+		Messages: []models.ChatMessage{{Role: "user", Content: "Hello!"}},
+		Seed:     &seed,
+	}
+	req, err := adapter.ConvertRequest(ctx, originalReq, "sk-test-key", "https://api.anthropic.com/v1", "claude-3-sonnet")
+	assert.NoError(t, err)
+
+	// Claude 不支持 seed，不能出现在上游请求中
+	var body map[string]interface{}
+	assert.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+	assert.NotContains(t, body, "seed")
+}
+
+func TestClaudeAdapter_ConvertRequest_Thinking(t *testing.T) {
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+
+	convert := func(req models.ChatCompletionRequest) (ClaudeRequest, error) {
+		httpReq, err := NewClaudeAdapter().ConvertRequest(ctx, req, "sk-ant", "https://api.anthropic.com/v1", "claude-3-7-sonnet")
+		var claudeReq ClaudeRequest
+		if err == nil {
+			assert.NoError(t, json.NewDecoder(httpReq.Body).Decode(&claudeReq))
+		}
+		return claudeReq, err
+	}
+
+	temp := 0.7
+	maxTokens := 1000
+	msgs := []models.ChatMessage{{Role: "user", Content: "Why?"}}
+
+	// reasoning_effort 映射为思考预算，max_tokens 不足时加上预算，采样参数被移除
+	claudeReq, err := convert(models.ChatCompletionRequest{Model: "claude", Messages: msgs, ReasoningEffort: "high", Temperature: &temp, MaxTokens: &maxTokens})
+	assert.NoError(t, err)
+	assert.Equal(t, &ClaudeThinking{Type: "enabled", BudgetTokens: 16384}, claudeReq.Thinking)
+	assert.Equal(t, 16384+1000, claudeReq.MaxTokens)
+	assert.Zero(t, claudeReq.Temperature)
+
+	// thinking 扩展字段优先，预算不低于 1024
+	claudeReq, err = convert(models.ChatCompletionRequest{Model: "claude", Messages: msgs, ReasoningEffort: "high",
+		Thinking: &models.ThinkingConfig{Type: "enabled", BudgetTokens: 100}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1024, claudeReq.Thinking.BudgetTokens)
+	assert.Equal(t, 4096, claudeReq.MaxTokens)
+
+	claudeReq, err = convert(models.ChatCompletionRequest{Model: "claude", Messages: msgs, Thinking: &models.ThinkingConfig{Type: "disabled"}})
+	assert.NoError(t, err)
+	assert.Nil(t, claudeReq.Thinking)
+
+	// 不能与强制工具调用同时使用
+	_, err = convert(models.ChatCompletionRequest{Model: "claude", Messages: msgs, ReasoningEffort: "low", ToolChoice: "required",
+		Tools: []models.ChatTool{{Type: "function", Function: models.ChatToolFunction{Name: "lookup"}}}})
+	var paramErr *UnsupportedParamError
+	assert.ErrorAs(t, err, &paramErr)
+	assert.Equal(t, "tool_choice", paramErr.Param)
+}
+
+func TestClaudeAdapter_HandleResponse_Thinking(t *testing.T) {
+	body := `{"id":"msg_1","type":"message","role":"assistant","model":"claude-3-7-sonnet","stop_reason":"end_turn",
+		"content":[{"type":"thinking","thinking":"Let me think.","signature":"sig"},{"type":"text","text":"Because."}],
+		"usage":{"input_tokens":5,"output_tokens":7}}`
+	resp := &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	assert.NoError(t, NewClaudeAdapter().HandleResponse(c, resp, false))
+
+	var out models.ChatCompletionResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &out))
+	assert.Equal(t, "Because.", out.Choices[0].Message.Content)
+	assert.Equal(t, "Let me think.", out.Choices[0].Message.ReasoningContent)
+}
+
+func TestClaudeStreamScanner_ThinkingDelta(t *testing.T) {
+	events := "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"model\":\"claude-3-7-sonnet\"}}\n\n" +
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"thinking\",\"thinking\":\"\"}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"thinking_delta\",\"thinking\":\"Let me \"}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"thinking_delta\",\"thinking\":\"think.\"}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"signature_delta\",\"signature\":\"sig\"}}\n\n" +
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":1,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"text_delta\",\"text\":\"Because.\"}}\n\n" +
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+
+	s := NewClaudeStreamScanner(strings.NewReader(events))
+	var reasoning, content string
+	chunks := 0
+	for s.Scan() {
+		var chunk models.ChatCompletionResponse
+		data := strings.TrimSuffix(strings.TrimPrefix(string(s.Bytes()), "data: "), "\n\n")
+		assert.NoError(t, json.Unmarshal([]byte(data), &chunk))
+		reasoning += chunk.Choices[0].Delta.ReasoningContent
+		if text, ok := chunk.Choices[0].Delta.Content.(string); ok {
+			content += text
+		}
+		chunks++
+	}
+	assert.NoError(t, s.Err())
+	assert.Equal(t, "Let me think.", reasoning)
+	assert.Equal(t, "Because.", content)
+	assert.Equal(t, 4, chunks) // role + 2 个 thinking_delta + text_delta，signature_delta 不输出
+}
@@ -1,11 +1,13 @@
 package adapter
 
+import "strings"
+
 // Claude Request Structures
 
 type ClaudeRequest struct {
 	Model         string                 `json:"model"`
 	Messages      []ClaudeMessage        `json:"messages"`
-	System        string                 `json:"system,omitempty"`
+	System        interface{}            `json:"system,omitempty"` // string or []ClaudeContentBlock (prompt caching)
 	MaxTokens     int                    `json:"max_tokens,omitempty"`
 	Metadata      map[string]interface{} `json:"metadata,omitempty"`
 	StopSequences []string               `json:"stop_sequences,omitempty"`
@@ -15,6 +17,21 @@ type ClaudeRequest struct {
 	TopK          int                    `json:"top_k,omitempty"`
 	Tools         []ClaudeTool           `json:"tools,omitempty"`
 	ToolChoice    interface{}            `json:"tool_choice,omitempty"` // map or string ("auto", "any")
+	Thinking      *ClaudeThinking        `json:"thinking,omitempty"`
+}
+
+// ClaudeThinking extended thinking 配置 (Claude 3.7+)
+type ClaudeThinking struct {
+	Type         string `json:"type"` // "enabled"
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+// ClaudeToolChoice Claude tool_choice
+type ClaudeToolChoice struct {
+	Type string `json:"type"`           // "auto", "any", "tool", "none"
+	Name string `json:"name,omitempty"` // for "tool"
+
+	DisableParallelToolUse bool `json:"disable_parallel_tool_use,omitempty"`
 }
 
 type ClaudeMessage struct {
@@ -23,7 +40,7 @@ type ClaudeMessage struct {
 }
 
 type ClaudeContentBlock struct {
-	Type   string        `json:"type"`             // "text", "image", "tool_use", "tool_result"
+	Type   string        `json:"type"`             // "text", "image", "tool_use", "tool_result", "thinking"
 	Text   string        `json:"text,omitempty"`   // for "text"
 	Source *ClaudeSource `json:"source,omitempty"` // for "image"
 	
@@ -36,6 +53,43 @@ type ClaudeContentBlock struct {
 	ToolUseID string      `json:"tool_use_id,omitempty"`
 	Content   interface{} `json:"content,omitempty"` // string or []ClaudeContentBlock
 	IsError   bool        `json:"is_error,omitempty"`
+
+	// Prompt Caching
+	CacheControl *ClaudeCacheControl `json:"cache_control,omitempty"`
+
+	// Extended Thinking
+	Thinking  string `json:"thinking,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// ClaudeCacheControl 提示词缓存标记 (目前仅支持 "ephemeral")
+type ClaudeCacheControl struct {
+	Type string `json:"type"`
+}
+
+// SystemText 返回 System 的纯文本 (兼容 string 与 content block 数组两种格式)
+func (r *ClaudeRequest) SystemText() string {
+	switch v := r.System.(type) {
+	case string:
+		return v
+	case []ClaudeContentBlock:
+		parts := make([]string, 0, len(v))
+		for _, b := range v {
+			parts = append(parts, b.Text)
+		}
+		return strings.Join(parts, "\n")
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			if m, ok := item.(map[string]interface{}); ok {
+				if text, ok := m["text"].(string); ok {
+					parts = append(parts, text)
+				}
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
 }
 
 type ClaudeSource struct {
@@ -64,8 +118,10 @@ type ClaudeResponse struct {
 }
 
 type ClaudeUsage struct {
-	InputTokens  int `json:"input_tokens"`
-	OutputTokens int `json:"output_tokens"`
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
 }
 
 // Streaming Events
@@ -80,8 +136,9 @@ type ClaudeStreamEvent struct {
 }
 
 type ClaudeDelta struct {
-	Type         string  `json:"type"` // text_delta, input_json_delta
+	Type         string  `json:"type"` // text_delta, input_json_delta, thinking_delta, signature_delta
 	Text         string  `json:"text,omitempty"`
+	Thinking     string  `json:"thinking,omitempty"`
 	PartialJson  string  `json:"partial_json,omitempty"`
 	StopReason   *string `json:"stop_reason,omitempty"`
 	StopSequence *string `json:"stop_sequence,omitempty"`
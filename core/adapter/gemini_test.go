@@ -4,7 +4,7 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
-
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -12,6 +12,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"llm-gateway/models"
 )
 
 func TestGeminiAdapter_HandleResponse_Stream(t *testing.T) {
@@ -81,3 +82,430 @@ func TestGeminiAdapter_HandleResponse_Stream(t *testing.T) {
 	assert.Equal(t, "Hello World", fullText, "Content should be reassembled correctly")
 	assert.Contains(t, w.Header().Get("Content-Type"), "text/event-stream")
 }
+
+func TestGeminiAdapter_ConvertRequest_IdentityPatch(t *testing.T) {
+	originalReq := models.ChatCompletionRequest{
+		Model: "gpt-4",
+		Messages: []models.ChatMessage{
+			{Role: "system", Content: "Be concise."},
+			{Role: "user", Content: "Hello!"},
+		},
+	}
+
+	convert := func(a *GeminiAdapter) GeminiRequest {
+		w := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(w)
+		ctx.Request = httptest.NewRequest("POST", "/", nil)
+
+		req, err := a.ConvertRequest(ctx, originalReq, "test-key", "https://generativelanguage.googleapis.com/v1beta", "gemini-pro")
+		assert.NoError(t, err)
+
+		var geminiReq GeminiRequest
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&geminiReq))
+		return geminiReq
+	}
+
+	systemText := func(r GeminiRequest) string {
+		var sb strings.Builder
+		for _, p := range r.SystemInstruction.Parts {
+			sb.WriteString(p.Text)
+		}
+		return sb.String()
+	}
+
+	// 默认关闭
+	off := convert(NewGeminiAdapter())
+	assert.NotContains(t, systemText(off), "[IDENTITY_PATCH]")
+	assert.Contains(t, systemText(off), "Be concise.")
+
+	// 按模型开启，使用默认模板
+	on := NewGeminiAdapter()
+	on.IdentityPatch = true
+	assert.Contains(t, systemText(convert(on)), "native gemini-pro model")
+
+	// 自定义补丁文本
+	custom := NewGeminiAdapter()
+	custom.IdentityPatch = true
+	custom.IdentityPatchText = "You are {model}."
+	assert.Contains(t, systemText(convert(custom)), "You are gemini-pro.")
+}
+
+func TestGeminiAdapter_ConvertRequest_NoSystemInstruction(t *testing.T) {
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+
+	originalReq := models.ChatCompletionRequest{
+		Model:    "gpt-4",
+		Messages: []models.ChatMessage{{Role: "user", Content: "Hello!"}},
+	}
+	req, err := NewGeminiAdapter().ConvertRequest(ctx, originalReq, "test-key", "https://generativelanguage.googleapis.com/v1beta", "gemini-pro")
+	assert.NoError(t, err)
+
+	var geminiReq GeminiRequest
+	assert.NoError(t, json.NewDecoder(req.Body).Decode(&geminiReq))
+	assert.Nil(t, geminiReq.SystemInstruction)
+	assert.Len(t, geminiReq.Contents, 1)
+}
+
+func TestGeminiAdapter_N(t *testing.T) {
+	a := NewGeminiAdapter()
+	n := 2
+	originalReq := models.ChatCompletionRequest{
+		Model:    "gpt-4",
+		Messages: []models.ChatMessage{{Role: "user", Content: "Hello!"}},
+		N:        &n,
+	}
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+
+	// n 映射为 candidateCount
+	req, err := a.ConvertRequest(ctx, originalReq, "test-key", "https://generativelanguage.googleapis.com/v1beta", "gemini-pro")
+	assert.NoError(t, err)
+	var geminiReq GeminiRequest
+	assert.NoError(t, json.NewDecoder(req.Body).Decode(&geminiReq))
+	assert.Equal(t, 2, geminiReq.GenerationConfig.CandidateCount)
+
+	// 超过上限时按上限请求
+	many := 20
+	originalReq.N = &many
+	req, err = a.ConvertRequest(ctx, originalReq, "test-key", "https://generativelanguage.googleapis.com/v1beta", "gemini-pro")
+	assert.NoError(t, err)
+	assert.NoError(t, json.NewDecoder(req.Body).Decode(&geminiReq))
+	assert.Equal(t, geminiMaxCandidates, geminiReq.GenerationConfig.CandidateCount)
+	originalReq.N = &n
+
+	// 流式请求不支持多个候选
+	originalReq.Stream = true
+	_, err = a.ConvertRequest(ctx, originalReq, "test-key", "https://generativelanguage.googleapis.com/v1beta", "gemini-pro")
+	var paramErr *UnsupportedParamError
+	assert.ErrorAs(t, err, &paramErr)
+	assert.Equal(t, "n", paramErr.Param)
+
+	// 多个候选映射为多个 choice
+	body := `{"candidates":[{"index":0,"content":{"parts":[{"text":"A"}]}},{"index":1,"content":{"parts":[{"text":"B"}]}}]}`
+	resp := &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}
+	assert.NoError(t, a.HandleResponse(ctx, resp, false))
+
+	var openaiResp models.ChatCompletionResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &openaiResp))
+	assert.Len(t, openaiResp.Choices, 2)
+	assert.Equal(t, 1, openaiResp.Choices[1].Index)
+	assert.Equal(t, "B", openaiResp.Choices[1].Message.Content)
+}
+
+func TestGeminiAdapter_ConvertRequest_UpstreamPath(t *testing.T) {
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+	originalReq := models.ChatCompletionRequest{
+		Model:    "gpt-4",
+		Messages: []models.ChatMessage{{Role: "user", Content: "Hello!"}},
+		Stream:   true,
+	}
+
+	a := NewGeminiAdapter()
+	a.UpstreamPath = "/v1/projects/p/locations/us/publishers/google/models/{model}:{action}"
+	req, err := a.ConvertRequest(ctx, originalReq, "test-key", "https://aiplatform.example.com/", "gemini-pro")
+	assert.NoError(t, err)
+	assert.Equal(t, "/v1/projects/p/locations/us/publishers/google/models/gemini-pro:streamGenerateContent", req.URL.Path)
+	assert.Equal(t, "test-key", req.URL.Query().Get("key"))
+	assert.Equal(t, "sse", req.URL.Query().Get("alt"))
+}
+
+func TestGeminiAdapter_ConvertRequest_Stop(t *testing.T) {
+	a := NewGeminiAdapter()
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+
+	for _, tc := range []struct {
+		stop     interface{}
+		expected []string
+	}{
+		{"END", []string{"END"}},
+		{[]interface{}{"END", "STOP"}, []string{"END", "STOP"}},
+		{[]string{"END"}, []string{"END"}}, // 来自 inbound mapper
+	} {
+		originalReq := models.ChatCompletionRequest{
+			Model:    "gpt-4",
+			Messages: []models.ChatMessage{{Role: "user", Content: "Hello!"}},
+			Stop:     tc.stop,
+		}
+		req, err := a.ConvertRequest(ctx, originalReq, "test-key", "https://generativelanguage.googleapis.com/v1beta", "gemini-pro")
+		assert.NoError(t, err)
+
+		var geminiReq GeminiRequest
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&geminiReq))
+		assert.Equal(t, tc.expected, geminiReq.GenerationConfig.StopSequences)
+	}
+}
+
+func TestGeminiAdapter_ConvertRequest_ToolChoice(t *testing.T) {
+	a := NewGeminiAdapter()
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+
+	tools := []models.ChatTool{{Type: "function", Function: models.ChatToolFunction{Name: "get_weather"}}}
+	for _, tc := range []struct {
+		choice  interface{}
+		mode    string
+		allowed []string
+	}{
+		{nil, "AUTO", nil},
+		{"auto", "AUTO", nil},
+		{"none", "NONE", nil},
+		{"required", "ANY", nil},
+		{map[string]interface{}{"type": "function", "function": map[string]interface{}{"name": "get_weather"}}, "ANY", []string{"get_weather"}},
+	} {
+		originalReq := models.ChatCompletionRequest{
+			Model:      "gpt-4",
+			Messages:   []models.ChatMessage{{Role: "user", Content: "Hello!"}},
+			Tools:      tools,
+			ToolChoice: tc.choice,
+		}
+		req, err := a.ConvertRequest(ctx, originalReq, "test-key", "https://generativelanguage.googleapis.com/v1beta", "gemini-pro")
+		assert.NoError(t, err)
+
+		var geminiReq GeminiRequest
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&geminiReq))
+		assert.Equal(t, tc.mode, geminiReq.ToolConfig.FunctionCallingConfig.Mode)
+		assert.Equal(t, tc.allowed, geminiReq.ToolConfig.FunctionCallingConfig.AllowedFunctionNames)
+	}
+}
+
+// scanGeminiStream 用 GeminiStreamScanner 解析 SSE 文本，返回转换后的 OpenAI chunk
+func scanGeminiStream(t *testing.T, s *GeminiStreamScanner) []models.ChatCompletionResponse {
+	var chunks []models.ChatCompletionResponse
+	for s.Scan() {
+		var chunk models.ChatCompletionResponse
+		data := strings.TrimSuffix(strings.TrimPrefix(string(s.Bytes()), "data: "), "\n\n")
+		assert.NoError(t, json.Unmarshal([]byte(data), &chunk))
+		chunks = append(chunks, chunk)
+	}
+	assert.NoError(t, s.Err())
+	return chunks
+}
+
+func TestGeminiStreamScanner_Thoughts(t *testing.T) {
+	stream := "data: " + `{"candidates":[{"content":{"parts":[{"text":"Let me think.","thought":true}]}}]}` + "\n\n" +
+		"data: " + `{"candidates":[{"content":{"parts":[{"text":"Checking.","thought":true},{"text":"Answer: 4"}]},"finishReason":"STOP"}]}` + "\n\n"
+
+	var reasoning, content string
+	for _, chunk := range scanGeminiStream(t, NewGeminiStreamScanner(strings.NewReader(stream))) {
+		reasoning += chunk.Choices[0].Delta.ReasoningContent
+		if s, ok := chunk.Choices[0].Delta.Content.(string); ok {
+			content += s
+		}
+	}
+	assert.Equal(t, "Let me think.Checking.", reasoning)
+	assert.Equal(t, "Answer: 4", content)
+
+	// 关闭思考输出时只保留正文
+	s := NewGeminiStreamScanner(strings.NewReader(stream))
+	s.HideThoughts = true
+	chunks := scanGeminiStream(t, s)
+	assert.Len(t, chunks, 1)
+	assert.Equal(t, "Answer: 4", chunks[0].Choices[0].Delta.Content)
+	assert.Empty(t, chunks[0].Choices[0].Delta.ReasoningContent)
+}
+
+func TestGeminiStreamScanner_FunctionCall(t *testing.T) {
+	stream := "data: " + `{"candidates":[{"content":{"parts":[{"text":"Checking the weather."}]}}]}` + "\n\n" +
+		"data: " + `{"candidates":[{"content":{"parts":[{"functionCall":{"name":"get_weather","args":{"city":"Paris"}}},{"functionCall":{"name":"get_time","args":{}}}]},"finishReason":"STOP"}]}` + "\n\n"
+
+	chunks := scanGeminiStream(t, NewGeminiStreamScanner(strings.NewReader(stream)))
+	assert.Len(t, chunks, 2)
+
+	last := chunks[1].Choices[0]
+	assert.Equal(t, "tool_calls", last.FinishReason)
+	assert.Len(t, last.Delta.ToolCalls, 2)
+	for i, call := range last.Delta.ToolCalls {
+		assert.Equal(t, i, *call.Index)
+		assert.True(t, strings.HasPrefix(call.ID, "call_"))
+		assert.Equal(t, "function", call.Type)
+	}
+	assert.NotEqual(t, last.Delta.ToolCalls[0].ID, last.Delta.ToolCalls[1].ID)
+	assert.Equal(t, "get_weather", last.Delta.ToolCalls[0].Function.Name)
+	assert.JSONEq(t, `{"city":"Paris"}`, last.Delta.ToolCalls[0].Function.Arguments)
+}
+
+func TestGeminiAdapter_HandleResponse_FinalUsageChunk(t *testing.T) {
+	// Gemini 每个事件都可能带累计用量，且最后一个事件同时带有正文与用量
+	upstream := "data: " + `{"candidates":[{"content":{"parts":[{"text":"Hel"}]}}],"usageMetadata":{"promptTokenCount":4,"candidatesTokenCount":1,"totalTokenCount":5}}` + "\n\n" +
+		"data: " + `{"candidates":[{"content":{"parts":[{"text":"lo"}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":4,"candidatesTokenCount":2,"totalTokenCount":6}}` + "\n\n"
+
+	events := streamWithUsage(t, NewGeminiAdapter(), upstream)
+	assert.Len(t, events, 4)
+	assertFinalUsageChunk(t, events, 4, 2)
+
+	// 未设置 include_usage 时不发送用量 chunk
+	chunks := scanGeminiStream(t, NewGeminiStreamScanner(strings.NewReader(upstream)))
+	assert.Len(t, chunks, 2)
+	for _, chunk := range chunks {
+		assert.Nil(t, chunk.Usage)
+	}
+}
+
+func TestGeminiAdapter_ConvertRequest_ResponseFormat(t *testing.T) {
+	a := NewGeminiAdapter()
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+
+	originalReq := models.ChatCompletionRequest{
+		Model:    "gpt-4",
+		Messages: []models.ChatMessage{{Role: "user", Content: "Hello!"}},
+		ResponseFormat: &models.ResponseFormat{
+			Type: "json_schema",
+			JSONSchema: &models.JSONSchema{
+				Name: "answer",
+				Schema: map[string]interface{}{
+					"type":                 "object",
+					"properties":           map[string]interface{}{"city": map[string]interface{}{"type": "string"}},
+					"required":             []interface{}{"city"},
+					"additionalProperties": false,
+				},
+			},
+		},
+	}
+	req, err := a.ConvertRequest(ctx, originalReq, "test-key", "https://generativelanguage.googleapis.com/v1beta", "gemini-pro")
+	assert.NoError(t, err)
+
+	var body map[string]interface{}
+	assert.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+	config := body["generationConfig"].(map[string]interface{})
+	assert.Equal(t, "application/json", config["responseMimeType"])
+	// Gemini 不支持 additionalProperties，需要清洗掉
+	assert.Equal(t, map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"city": map[string]interface{}{"type": "string"}},
+		"required":   []interface{}{"city"},
+	}, config["responseSchema"])
+
+	// json_object 只设置 MIME 类型
+	originalReq.ResponseFormat = &models.ResponseFormat{Type: "json_object"}
+	req, err = a.ConvertRequest(ctx, originalReq, "test-key", "https://generativelanguage.googleapis.com/v1beta", "gemini-pro")
+	assert.NoError(t, err)
+
+	var geminiReq GeminiRequest
+	assert.NoError(t, json.NewDecoder(req.Body).Decode(&geminiReq))
+	assert.Equal(t, "application/json", geminiReq.GenerationConfig.ResponseMimeType)
+	assert.Nil(t, geminiReq.GenerationConfig.ResponseSchema)
+}
+
+func TestGeminiAdapter_ConvertRequest_Seed(t *testing.T) {
+	a := NewGeminiAdapter()
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+
+	seed := 42
+	originalReq := models.ChatCompletionRequest{
+		Model:    "gpt-4",
+		Messages: []models.ChatMessage{{Role: "user", Content: "Hello!"}},
+		Seed:     &seed,
+	}
+	req, err := a.ConvertRequest(ctx, originalReq, "test-key", "https://generativelanguage.googleapis.com/v1beta", "gemini-pro")
+	assert.NoError(t, err)
+
+	var geminiReq GeminiRequest
+	assert.NoError(t, json.NewDecoder(req.Body).Decode(&geminiReq))
+	assert.Equal(t, 42, *geminiReq.GenerationConfig.Seed)
+}
+
+func TestGeminiAdapter_ConvertRequest_GoogleSearch(t *testing.T) {
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+
+	convert := func(a *GeminiAdapter, names ...string) GeminiRequest {
+		originalReq := models.ChatCompletionRequest{
+			Model:    "gpt-4",
+			Messages: []models.ChatMessage{{Role: "user", Content: "Hello!"}},
+		}
+		for _, name := range names {
+			originalReq.Tools = append(originalReq.Tools, models.ChatTool{Type: "function", Function: models.ChatToolFunction{Name: name}})
+		}
+		req, err := a.ConvertRequest(ctx, originalReq, "test-key", "https://generativelanguage.googleapis.com/v1beta", "gemini-pro")
+		assert.NoError(t, err)
+		var geminiReq GeminiRequest
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&geminiReq))
+		return geminiReq
+	}
+
+	t.Run("search only", func(t *testing.T) {
+		geminiReq := convert(NewGeminiAdapter(), "web_search")
+		assert.Len(t, geminiReq.Tools, 1)
+		assert.NotNil(t, geminiReq.Tools[0].GoogleSearch)
+		assert.Empty(t, geminiReq.Tools[0].FunctionDeclarations)
+
+		// 自定义触发名后，默认名按普通函数转发
+		a := NewGeminiAdapter()
+		a.Search = &models.GoogleSearchConfig{TriggerTools: []string{"search"}}
+		geminiReq = convert(a, "search")
+		assert.NotNil(t, geminiReq.Tools[0].GoogleSearch)
+		geminiReq = convert(a, "web_search")
+		assert.Nil(t, geminiReq.Tools[0].GoogleSearch)
+		assert.Equal(t, "web_search", geminiReq.Tools[0].FunctionDeclarations[0].Name)
+
+		threshold := 0.3
+		a.Search = &models.GoogleSearchConfig{DynamicThreshold: &threshold}
+		geminiReq = convert(a, "google_search")
+		assert.Nil(t, geminiReq.Tools[0].GoogleSearch)
+		assert.Equal(t, &GeminiDynamicRetrievalConfig{Mode: "MODE_DYNAMIC", DynamicThreshold: 0.3}, geminiReq.Tools[0].GoogleSearchRetrieval.DynamicRetrievalConfig)
+	})
+
+	t.Run("mixed tools", func(t *testing.T) {
+		// 默认存在其他函数时放弃搜索
+		geminiReq := convert(NewGeminiAdapter(), "web_search", "get_weather")
+		assert.Len(t, geminiReq.Tools, 1)
+		assert.Equal(t, "get_weather", geminiReq.Tools[0].FunctionDeclarations[0].Name)
+
+		a := NewGeminiAdapter()
+		a.Search = &models.GoogleSearchConfig{WithFunctions: true}
+		geminiReq = convert(a, "web_search", "get_weather")
+		assert.Len(t, geminiReq.Tools, 2)
+		assert.Len(t, geminiReq.Tools[0].FunctionDeclarations, 1)
+		assert.NotNil(t, geminiReq.Tools[1].GoogleSearch)
+		assert.Equal(t, "AUTO", geminiReq.ToolConfig.FunctionCallingConfig.Mode)
+	})
+}
+
+func TestGeminiAdapter_HandleResponse_EchoesUpstreamModel(t *testing.T) {
+	convert := func(a *GeminiAdapter, stream bool) (*gin.Context, *httptest.ResponseRecorder) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/", nil)
+		req := models.ChatCompletionRequest{Model: "my-group", Stream: stream, Messages: []models.ChatMessage{{Role: "user", Content: "Hello!"}}}
+		_, err := a.ConvertRequest(c, req, "test-key", "https://generativelanguage.googleapis.com/v1beta", "gemini-2.5-flash")
+		assert.NoError(t, err)
+		return c, w
+	}
+
+	// 非流式
+	a := NewGeminiAdapter()
+	c, w := convert(a, false)
+	body := `{"candidates":[{"content":{"parts":[{"text":"Hi"}]},"finishReason":"STOP"}]}`
+	assert.NoError(t, a.HandleResponse(c, &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, false))
+	var resp models.ChatCompletionResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "gemini-2.5-flash", resp.Model)
+
+	// 流式 (包括用量 chunk)
+	a = NewGeminiAdapter()
+	c, w = convert(a, true)
+	a.includeUsage = true
+	upstream := "data: " + `{"candidates":[{"content":{"parts":[{"text":"Hi"}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":1,"candidatesTokenCount":1,"totalTokenCount":2}}` + "\n\n"
+	assert.NoError(t, a.HandleResponse(c, &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(upstream))}, true))
+	events := strings.Split(strings.TrimSpace(w.Body.String()), "\n\n")
+	assert.Len(t, events, 3)
+	for _, event := range events[:len(events)-1] {
+		var chunk models.ChatCompletionResponse
+		assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &chunk))
+		assert.Equal(t, "gemini-2.5-flash", chunk.Model)
+	}
+}
@@ -47,11 +47,26 @@ type GeminiConfig struct {
 	MaxOutputTokens int      `json:"maxOutputTokens,omitempty"`
 	StopSequences   []string `json:"stopSequences,omitempty"`
 	CandidateCount  int      `json:"candidateCount,omitempty"`
+	Seed            *int     `json:"seed,omitempty"`
+	// 结构化输出 (response_format)
+	ResponseMimeType string                 `json:"responseMimeType,omitempty"`
+	ResponseSchema   map[string]interface{} `json:"responseSchema,omitempty"`
 }
 
 type GeminiTool struct {
 	FunctionDeclarations []GeminiFunctionDeclaration `json:"functionDeclarations,omitempty"`
 	GoogleSearch         interface{}                 `json:"googleSearch,omitempty"` // Empty object {} if enabled
+	// Gemini 1.5 的搜索接地，支持动态检索阈值
+	GoogleSearchRetrieval *GeminiSearchRetrieval `json:"googleSearchRetrieval,omitempty"`
+}
+
+type GeminiSearchRetrieval struct {
+	DynamicRetrievalConfig *GeminiDynamicRetrievalConfig `json:"dynamicRetrievalConfig,omitempty"`
+}
+
+type GeminiDynamicRetrievalConfig struct {
+	Mode             string  `json:"mode"` // MODE_DYNAMIC, MODE_UNSPECIFIED
+	DynamicThreshold float64 `json:"dynamicThreshold"`
 }
 
 type GeminiFunctionDeclaration struct {
@@ -65,7 +80,8 @@ type GeminiToolConfig struct {
 }
 
 type GeminiFunctionCallingConfig struct {
-	Mode string `json:"mode,omitempty"` // ANY, AUTO, NONE
+	Mode                 string   `json:"mode,omitempty"` // ANY, AUTO, NONE
+	AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"` // 仅 ANY 模式有效
 }
 
 // Gemini Response Structures
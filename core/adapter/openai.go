@@ -1,6 +1,7 @@
 package adapter
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
@@ -14,15 +15,64 @@ import (
 	)
 
 // OpenAIAdapter OpenAI 协议（透传模式）
-type OpenAIAdapter struct{}
+type OpenAIAdapter struct {
+	// UpstreamPath 追加到 base URL 之后的接口路径，留空使用 /chat/completions
+	UpstreamPath string
+
+	// includeUsage 客户端设置了 stream_options.include_usage，用量统一作为 [DONE] 之前的最后一个 chunk 发送
+	includeUsage bool
+}
 
 func NewOpenAIAdapter() *OpenAIAdapter {
 	return &OpenAIAdapter{}
 }
 
+// Capabilities 原样透传，默认认为上游支持全部特性 (纯文本模型需在模型上声明)
+func (a *OpenAIAdapter) Capabilities() models.Capabilities {
+	return models.Capabilities{Tools: true, Vision: true, JSONSchema: true, Streaming: true}
+}
+
+// upstreamURL 拼接上游地址: base URL + UpstreamPath (默认 /chat/completions)
+// 未配置路径且 base URL 已包含接口路径 (如 /v1/images/generations) 时原样使用，兼容完整地址的写法
+func (a *OpenAIAdapter) upstreamURL(baseURL string) (*url.URL, error) {
+	path := a.UpstreamPath
+	if path == "" {
+		u, err := url.Parse(baseURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream url: %w", err)
+		}
+		for _, marker := range openAIEndpointMarkers {
+			if strings.Contains(u.Path, marker) {
+				return u, nil
+			}
+		}
+		path = OpenAIDefaultPath
+	}
+	return joinUpstreamPath(baseURL, path)
+}
+
+// EmbeddingsURL 返回向量接口地址: 配置了 UpstreamPath 或 base URL 已包含 /embeddings 时直接使用，否则追加 /embeddings
+func (a *OpenAIAdapter) EmbeddingsURL(baseURL string) (*url.URL, error) {
+	if a.UpstreamPath == "" {
+		u, err := url.Parse(baseURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream url: %w", err)
+		}
+		if strings.Contains(u.Path, OpenAIEmbeddingsPath) {
+			return u, nil
+		}
+		return joinUpstreamPath(baseURL, OpenAIEmbeddingsPath)
+	}
+	return joinUpstreamPath(baseURL, a.UpstreamPath)
+}
+
 func (a *OpenAIAdapter) ConvertRequest(ctx *gin.Context, originalReq models.ChatCompletionRequest, apiKey string, baseURL string, upstreamModel string) (*http.Request, error) {
 	// 关键修复：将请求中的模型名替换为上游识别的名称
 	originalReq.Model = upstreamModel
+	// 网关扩展字段不转发给上游
+	originalReq.RequestTimeout = nil
+	originalReq.Thinking = nil
+	a.includeUsage = wantsStreamUsage(originalReq)
 	
 	// [Sanitization]
 	// If this looks like an image request (has Prompt), ensure Messages is nil
@@ -40,10 +90,9 @@ func (a *OpenAIAdapter) ConvertRequest(ctx *gin.Context, originalReq models.Chat
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 	
-	// 验证 URL
-	u, err := url.Parse(baseURL)
+	u, err := a.upstreamURL(baseURL)
 	if err != nil {
-		return nil, fmt.Errorf("invalid upstream url: %w", err)
+		return nil, err
 	}
 
 	// [Debug] Log the actual payload being sent
@@ -52,22 +101,6 @@ func (a *OpenAIAdapter) ConvertRequest(ctx *gin.Context, originalReq models.Chat
 	// fmt.Printf("[OpenAIAdapter] Prompt Type: %T\n", originalReq.Prompt)
 	// fmt.Printf("[OpenAIAdapter] Payload: %s\n\n", string(reqBodyBytes))
 
-	// [Auto-Append Endpoint]
-	// Only append /chat/completions if it looks like a base URL.
-	// We avoid appending if the user has already specified a specific endpoint (like /v1/images/generations).
-	path := u.Path
-	if !strings.Contains(path, "/chat/completions") && 
-	   !strings.Contains(path, "/images/") && 
-	   !strings.Contains(path, "/audio/") && 
-	   !strings.Contains(path, "/embeddings") {
-		
-		// If path is empty, root, or ends in /v1, append chat completions
-		if path == "" || path == "/" || strings.HasSuffix(path, "/v1") || strings.HasSuffix(path, "/v1/") {
-			basePath := strings.TrimSuffix(path, "/")
-			u.Path = basePath + "/chat/completions"
-		}
-	}
-
 	req, err := http.NewRequestWithContext(ctx.Request.Context(), "POST", u.String(), bytes.NewBuffer(reqBodyBytes))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
@@ -81,6 +114,11 @@ func (a *OpenAIAdapter) ConvertRequest(ctx *gin.Context, originalReq models.Chat
 }
 
 func (a *OpenAIAdapter) HandleResponse(c *gin.Context, resp *http.Response, isStream bool) error {
+	// 透传前先解压，下面剥离 Content-Encoding 后 Body 必须是明文
+	if err := DecodeResponseBody(resp); err != nil {
+		return err
+	}
+
 	// 复制响应头
 	for k, v := range resp.Header {
 		if k == "Content-Length" || k == "Content-Encoding" || k == "Connection" {
@@ -107,13 +145,221 @@ func (a *OpenAIAdapter) HandleResponse(c *gin.Context, resp *http.Response, isSt
 		c.Header("X-Accel-Buffering", "no")
 		c.Writer.Flush()
 
-		// 这里可以复用 ProxyHandler 中的流式处理逻辑，或者直接 Copy
-		// 为了简单起见，这里直接 Copy，实际项目中应该调用 shared utility 来处理 DeepSeek reasoning 等特殊逻辑
-		_, err := io.Copy(c.Writer, resp.Body)
-		return err
+		oaiScanner := NewOpenAIStreamScanner(resp.Body)
+		oaiScanner.IncludeUsage = a.includeUsage
+		var scanner StreamScanner = oaiScanner
+		defer func() {
+			if usage := oaiScanner.Usage(); usage != nil {
+				c.Set("usage", usage)
+			}
+		}()
+
+		for scanner.Scan() {
+			if _, err := c.Writer.Write(scanner.Bytes()); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+		}
+		return scanner.Err()
 	} else {
-		// 普通响应直接 Copy
-		_, err := io.Copy(c.Writer, resp.Body)
+		// 普通响应原样转发，同时记录 usage (用于请求日志与组级 Token 限流)
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		var parsed struct {
+			Usage *models.ChatCompletionUsage `json:"usage"`
+		}
+		if json.Unmarshal(body, &parsed) == nil && parsed.Usage != nil {
+			c.Set("usage", parsed.Usage)
+		}
+		_, err = c.Writer.Write(body)
 		return err
 	}
-}
\ No newline at end of file
+}
+
+// OpenAIStreamScanner 逐个事件解析 OpenAI 兼容的 SSE 流
+// 原样转发每个 data 事件，记录 usage；同一个 delta 中同时带有 reasoning_content 与 content 时
+// (部分 DeepSeek 兼容上游)，拆成先思考、后正文的两个 chunk，避免客户端只读取其中一个字段
+type OpenAIStreamScanner struct {
+	scanner *bufio.Scanner
+	current []byte
+	pending [][]byte // 拆分后尚未返回的事件
+	err     error
+	usage   *models.ChatCompletionUsage
+
+	// IncludeUsage 为 true 时，上游附在内容 chunk 上的 usage 会被移出，
+	// 在 [DONE] 之前 (或流结束时) 作为 choices 为空的独立 chunk 发送；上游已按规范单独发送时原样透传
+	IncludeUsage bool
+	usageSent    bool
+	last         models.ChatCompletionResponse // 最近一个 chunk，用于填充用量 chunk 的 id/model/created
+	done         bool
+}
+
+func NewOpenAIStreamScanner(r io.Reader) *OpenAIStreamScanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024) // 透传模式下单个 chunk 可能很大 (如图片 base64)
+	return &OpenAIStreamScanner{scanner: scanner}
+}
+
+func (s *OpenAIStreamScanner) Scan() bool {
+	if len(s.pending) > 0 {
+		s.current, s.pending = s.pending[0], s.pending[1:]
+		return true
+	}
+
+	if s.done {
+		return false
+	}
+
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			// 注释 (": keep-alive") 等非 data 行原样转发
+			s.current = []byte(line + "\n\n")
+			return true
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			s.current = []byte("data: [DONE]\n\n")
+			if s.pendingUsage() {
+				s.current, s.pending = s.usageChunk(), [][]byte{s.current}
+			}
+			return true
+		}
+
+		var chunk models.ChatCompletionResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err == nil {
+			if chunk.Usage != nil {
+				s.usage = chunk.Usage
+			}
+			if s.IncludeUsage {
+				s.last = chunk
+				if chunk.Usage != nil && len(chunk.Choices) == 0 {
+					s.usageSent = true
+				} else if chunk.Usage != nil {
+					data = stripUsage(data)
+				}
+			}
+		}
+
+		events := splitReasoningChunk([]byte(data))
+		if events == nil {
+			s.current = []byte(fmt.Sprintf("data: %s\n\n", data))
+			return true
+		}
+		s.current, s.pending = events[0], events[1:]
+		return true
+	}
+
+	if err := s.scanner.Err(); err != nil {
+		s.err = err
+		return false
+	}
+	// 上游未发送 [DONE] 即结束时也补发用量 chunk
+	s.done = true
+	if s.pendingUsage() {
+		s.current = s.usageChunk()
+		return true
+	}
+	return false
+}
+
+// pendingUsage 是否还需要补发独立的用量 chunk
+func (s *OpenAIStreamScanner) pendingUsage() bool {
+	return s.IncludeUsage && s.usage != nil && !s.usageSent
+}
+
+func (s *OpenAIStreamScanner) usageChunk() []byte {
+	s.usageSent = true
+	return usageChunk(s.last.ID, s.last.Model, s.last.Created, s.usage)
+}
+
+// stripUsage 移除 chunk 中的 usage 字段，其余字段原样保留
+func stripUsage(data string) string {
+	var m map[string]interface{}
+	if json.Unmarshal([]byte(data), &m) != nil {
+		return data
+	}
+	delete(m, "usage")
+	b, err := json.Marshal(m)
+	if err != nil {
+		return data
+	}
+	return string(b)
+}
+
+func (s *OpenAIStreamScanner) Bytes() []byte {
+	return s.current
+}
+
+func (s *OpenAIStreamScanner) Err() error {
+	return s.err
+}
+
+// Usage 返回流中最后一次出现的 usage，上游未返回时为 nil
+func (s *OpenAIStreamScanner) Usage() *models.ChatCompletionUsage {
+	return s.usage
+}
+
+// splitReasoningChunk 将同时带有 reasoning_content 与 content 的 chunk 拆成两个 SSE 事件
+// 第一个的 delta 只保留 role 与 reasoning_content，第二个保留其余内容及 finish_reason、usage
+// 无需拆分时返回 nil
+func splitReasoningChunk(data []byte) [][]byte {
+	var chunk models.ChatCompletionResponse
+	if json.Unmarshal(data, &chunk) != nil {
+		return nil
+	}
+	split := false
+	for _, choice := range chunk.Choices {
+		if text, _ := choice.Delta.Content.(string); text != "" && choice.Delta.ReasoningContent != "" {
+			split = true
+		}
+	}
+	if !split {
+		return nil
+	}
+
+	// 分别解码为两份 map，未知字段 (如 logprobs、system_fingerprint) 原样保留
+	var reasoning, content map[string]interface{}
+	if json.Unmarshal(data, &reasoning) != nil || json.Unmarshal(data, &content) != nil {
+		return nil
+	}
+	rChoices, _ := reasoning["choices"].([]interface{})
+	cChoices, _ := content["choices"].([]interface{})
+	for i := range rChoices {
+		rChoice, _ := rChoices[i].(map[string]interface{})
+		cChoice, _ := cChoices[i].(map[string]interface{})
+		if rChoice == nil || cChoice == nil {
+			continue
+		}
+		delete(rChoice, "finish_reason")
+		delete(rChoice, "logprobs")
+		if rDelta, ok := rChoice["delta"].(map[string]interface{}); ok {
+			for k := range rDelta {
+				if k != "role" && k != "reasoning_content" {
+					delete(rDelta, k)
+				}
+			}
+		}
+		if cDelta, ok := cChoice["delta"].(map[string]interface{}); ok {
+			delete(cDelta, "reasoning_content")
+			delete(cDelta, "role")
+		}
+	}
+	delete(reasoning, "usage")
+
+	events := make([][]byte, 0, 2)
+	for _, m := range []map[string]interface{}{reasoning, content} {
+		b, err := json.Marshal(m)
+		if err != nil {
+			return nil
+		}
+		events = append(events, []byte(fmt.Sprintf("data: %s\n\n", b)))
+	}
+	return events
+}
@@ -0,0 +1,280 @@
+package adapter
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"llm-gateway/models"
+)
+
+func TestOpenAIAdapter_ConvertRequest_PassesN(t *testing.T) {
+	adapter := NewOpenAIAdapter()
+	n := 3
+	originalReq := models.ChatCompletionRequest{
+		Model:    "gpt-4",
+		Messages: []models.ChatMessage{{Role: "user", Content: "Hello!"}},
+		N:        &n,
+	}
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+
+	req, err := adapter.ConvertRequest(ctx, originalReq, "sk-test-key", "https://api.openai.com/v1", "gpt-4o")
+	assert.NoError(t, err)
+
+	var body models.ChatCompletionRequest
+	assert.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+	assert.Equal(t, "gpt-4o", body.Model)
+	assert.Equal(t, 3, *body.N)
+}
+
+func TestOpenAIAdapter_ConvertRequest_UpstreamPath(t *testing.T) {
+	originalReq := models.ChatCompletionRequest{
+		Model:    "gpt-4",
+		Messages: []models.ChatMessage{{Role: "user", Content: "Hello!"}},
+	}
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+
+	cases := []struct {
+		baseURL, path, want string
+	}{
+		{"https://api.openai.com/v1", "", "https://api.openai.com/v1/chat/completions"},
+		{"https://api.openai.com/v1/", "", "https://api.openai.com/v1/chat/completions"},
+		{"https://api.example.com", "", "https://api.example.com/chat/completions"},
+		{"https://api.openai.com/v1/images/generations", "", "https://api.openai.com/v1/images/generations"},
+		{"https://api.example.com/api/paas/v4", "/chat/completions", "https://api.example.com/api/paas/v4/chat/completions"},
+		{"https://res.example.com/openai/deployments/gpt4o", "/chat/completions?api-version=2024-06-01",
+			"https://res.example.com/openai/deployments/gpt4o/chat/completions?api-version=2024-06-01"},
+	}
+	for _, tc := range cases {
+		adapter := &OpenAIAdapter{UpstreamPath: tc.path}
+		req, err := adapter.ConvertRequest(ctx, originalReq, "sk-test-key", tc.baseURL, "gpt-4o")
+		assert.NoError(t, err)
+		assert.Equal(t, tc.want, req.URL.String(), tc.baseURL)
+	}
+}
+
+func TestSplitOpenAIUpstreamURL(t *testing.T) {
+	cases := []struct {
+		raw, base, path string
+		ok              bool
+	}{
+		{"https://api.openai.com/v1", "", "", false},
+		{"https://api.openai.com", "", "", false},
+		{"https://api.openai.com/v1/chat/completions", "https://api.openai.com/v1", "", true},
+		{"https://api.openai.com/v1/images/generations", "https://api.openai.com/v1", "/images/generations", true},
+		{"https://res.example.com/openai/deployments/d/chat/completions?api-version=1",
+			"https://res.example.com/openai/deployments/d", "/chat/completions?api-version=1", true},
+		{"https://proxy.example.com/api", "https://proxy.example.com", "/api", true},
+	}
+	for _, tc := range cases {
+		base, path, ok := SplitOpenAIUpstreamURL(tc.raw)
+		assert.Equal(t, tc.ok, ok, tc.raw)
+		assert.Equal(t, tc.base, base, tc.raw)
+		assert.Equal(t, tc.path, path, tc.raw)
+
+		// 旧规则对这些地址原样请求，拆分后拼接结果必须一致
+		if ok {
+			u, err := (&OpenAIAdapter{UpstreamPath: path}).upstreamURL(base)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.raw, u.String())
+		}
+	}
+}
+
+func TestOpenAIAdapter_HandleResponse_CompressedUpstream(t *testing.T) {
+	payload := `{"id":"chatcmpl-1","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":"hi"}}]}`
+
+	var gz, raw bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(payload))
+	zw.Close()
+	fw, _ := flate.NewWriter(&raw, flate.DefaultCompression)
+	fw.Write([]byte(payload))
+	fw.Close()
+
+	for encoding, body := range map[string][]byte{"gzip": gz.Bytes(), "deflate": raw.Bytes()} {
+		t.Run(encoding, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode: 200,
+				Header: http.Header{
+					"Content-Type":     {"application/json"},
+					"Content-Encoding": {encoding},
+					"Content-Length":   {fmt.Sprint(len(body))},
+				},
+				Body: io.NopCloser(bytes.NewReader(body)),
+			}
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			assert.NoError(t, NewOpenAIAdapter().HandleResponse(c, resp, false))
+
+			assert.Empty(t, w.Header().Get("Content-Encoding"))
+			var out models.ChatCompletionResponse
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &out), "client must receive valid JSON")
+			assert.Equal(t, "hi", out.Choices[0].Message.Content)
+		})
+	}
+}
+
+func TestOpenAIAdapter_ConvertRequest_PassesSeed(t *testing.T) {
+	adapter := NewOpenAIAdapter()
+	seed := 42
+	originalReq := models.ChatCompletionRequest{
+		Model:    "gpt-4",
+		Messages: []models.ChatMessage{{Role: "user", Content: "Hello!"}},
+		Seed:     &seed,
+	}
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+
+	req, err := adapter.ConvertRequest(ctx, originalReq, "sk-test-key", "https://api.openai.com/v1", "gpt-4o")
+	assert.NoError(t, err)
+
+	var body models.ChatCompletionRequest
+	assert.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+	assert.Equal(t, 42, *body.Seed)
+}
+
+func TestOpenAIAdapter_HandleResponse_ReasoningStream(t *testing.T) {
+	// DeepSeek 风格：先输出 reasoning_content，过渡 chunk 中同时带有两个字段
+	upstream := `data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"role":"assistant","reasoning_content":"Let me"}}]}
+
+: keep-alive
+
+data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"reasoning_content":" think.","content":"The"}}]}
+
+data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":" answer"},"finish_reason":"stop"}]}
+
+data: {"id":"c1","object":"chat.completion.chunk","choices":[],"usage":{"prompt_tokens":5,"completion_tokens":7,"total_tokens":12}}
+
+data: [DONE]
+
+`
+	resp := &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"Content-Type": {"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(upstream)),
+	}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	assert.NoError(t, NewOpenAIAdapter().HandleResponse(c, resp, true))
+
+	var events []string
+	var reasoning, content string
+	for _, event := range strings.Split(strings.TrimSpace(w.Body.String()), "\n\n") {
+		events = append(events, event)
+		data, ok := strings.CutPrefix(event, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk models.ChatCompletionResponse
+		assert.NoError(t, json.Unmarshal([]byte(data), &chunk))
+		for _, choice := range chunk.Choices {
+			text, _ := choice.Delta.Content.(string)
+			// 每个 chunk 只带其中一个字段
+			assert.False(t, text != "" && choice.Delta.ReasoningContent != "", event)
+			reasoning += choice.Delta.ReasoningContent
+			content += text
+		}
+	}
+
+	assert.Len(t, events, 7) // 过渡 chunk 拆成两个
+	assert.Equal(t, ": keep-alive", events[1])
+	assert.Equal(t, "data: [DONE]", events[6])
+	assert.Equal(t, "Let me think.", reasoning)
+	assert.Equal(t, "The answer", content)
+
+	usage, ok := c.Get("usage")
+	assert.True(t, ok)
+	assert.Equal(t, 12, usage.(*models.ChatCompletionUsage).TotalTokens)
+}
+
+// streamWithUsage 调用 ConvertRequest (stream_options.include_usage=true) 后用 HandleResponse 处理上游流，返回写给客户端的 SSE 事件
+func streamWithUsage(t *testing.T, a ProviderAdapter, upstream string) []string {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/", nil)
+	req := models.ChatCompletionRequest{
+		Model:         "m",
+		Stream:        true,
+		StreamOptions: &models.StreamOptions{IncludeUsage: true},
+		Messages:      []models.ChatMessage{{Role: "user", Content: "Hello!"}},
+	}
+	_, err := a.ConvertRequest(c, req, "sk-test", "https://upstream.example.com/v1", "m")
+	assert.NoError(t, err)
+
+	resp := &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(upstream))}
+	assert.NoError(t, a.HandleResponse(c, resp, true))
+	return strings.Split(strings.TrimSpace(w.Body.String()), "\n\n")
+}
+
+// assertFinalUsageChunk 断言流以 "choices 为空 + usage" 的 chunk 加 [DONE] 结尾，且其他 chunk 不带 usage
+func assertFinalUsageChunk(t *testing.T, events []string, prompt, completion int) {
+	if !assert.GreaterOrEqual(t, len(events), 2) {
+		return
+	}
+	assert.Equal(t, "data: [DONE]", events[len(events)-1])
+
+	for i, event := range events[:len(events)-1] {
+		data, ok := strings.CutPrefix(event, "data: ")
+		if !ok {
+			continue
+		}
+		var chunk map[string]json.RawMessage
+		assert.NoError(t, json.Unmarshal([]byte(data), &chunk))
+		if i < len(events)-2 {
+			assert.NotContains(t, chunk, "usage", event)
+			continue
+		}
+		assert.Equal(t, `"chat.completion.chunk"`, string(chunk["object"]))
+		assert.Equal(t, "[]", string(chunk["choices"]))
+		var usage models.ChatCompletionUsage
+		assert.NoError(t, json.Unmarshal(chunk["usage"], &usage))
+		assert.Equal(t, prompt, usage.PromptTokens)
+		assert.Equal(t, completion, usage.CompletionTokens)
+		assert.Equal(t, prompt+completion, usage.TotalTokens)
+	}
+}
+
+func TestOpenAIAdapter_HandleResponse_FinalUsageChunk(t *testing.T) {
+	// 上游把 usage 附在最后一个内容 chunk 上：移出为独立 chunk
+	upstream := `data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"Hi"}}]}
+
+data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}
+
+data: [DONE]
+
+`
+	events := streamWithUsage(t, NewOpenAIAdapter(), upstream)
+	assert.Len(t, events, 4)
+	assert.Contains(t, events[2], `"id":"c1"`)
+	assertFinalUsageChunk(t, events, 5, 2)
+
+	// 上游已按规范发送时原样透传，不重复发送
+	upstream = `data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"Hi"},"finish_reason":"stop"}]}
+
+data: {"id":"c1","object":"chat.completion.chunk","choices":[],"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}
+
+data: [DONE]
+
+`
+	events = streamWithUsage(t, NewOpenAIAdapter(), upstream)
+	assert.Len(t, events, 3)
+	assertFinalUsageChunk(t, events, 5, 2)
+}
@@ -0,0 +1,37 @@
+package core
+
+import (
+	"fmt"
+	"llm-gateway/models"
+)
+
+// chatRoles OpenAI 聊天消息允许的角色 (developer 为新版 system，function 为旧版 tool)
+var chatRoles = map[string]bool{
+	"system":    true,
+	"developer": true,
+	"user":      true,
+	"assistant": true,
+	"tool":      true,
+	"function":  true,
+}
+
+// ValidateChatRequest 聊天请求的基础校验 (模型、非空消息列表与消息角色)，通过时返回 nil
+// HTTP 接口与 WebSocket 共用，保证两者拒绝的请求一致
+func ValidateChatRequest(model string, roles []string) *models.ErrorDetail {
+	if model == "" {
+		return &models.ErrorDetail{Message: "Missing required parameter: 'model'", Type: "invalid_request_error", Param: "model"}
+	}
+	if len(roles) == 0 {
+		return &models.ErrorDetail{Message: "'messages' must be a non-empty array", Type: "invalid_request_error", Param: "messages"}
+	}
+	for i, role := range roles {
+		if !chatRoles[role] {
+			return &models.ErrorDetail{
+				Message: fmt.Sprintf("Invalid role '%s' in messages[%d]", role, i),
+				Type:    "invalid_request_error",
+				Param:   fmt.Sprintf("messages[%d].role", i),
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"llm-gateway/core/adapter"
+	"llm-gateway/models"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentBlockedError 内容被过滤器拒绝
+type ContentBlockedError struct {
+	Reason string
+}
+
+func (e *ContentBlockedError) Error() string {
+	return "content blocked: " + e.Reason
+}
+
+// RegexFilter 基于正则的内置内容过滤器
+type RegexFilter struct {
+	patterns    []*regexp.Regexp
+	redact      bool
+	replacement string
+}
+
+// NewRegexFilter 根据配置编译过滤规则，cfg 为 nil 时返回 nil
+func NewRegexFilter(cfg *models.ContentFilterConfig) (*RegexFilter, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	f := &RegexFilter{replacement: cfg.Replacement}
+	switch cfg.Action {
+	case "", "block":
+	case "redact":
+		f.redact = true
+	default:
+		return nil, fmt.Errorf("unknown filter action %q (expected block or redact)", cfg.Action)
+	}
+	if f.replacement == "" {
+		f.replacement = "[REDACTED]"
+	}
+
+	for _, p := range cfg.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter pattern %q: %w", p, err)
+		}
+		f.patterns = append(f.patterns, re)
+	}
+	return f, nil
+}
+
+// filterText 返回处理后的文本；block 模式下命中时返回 *ContentBlockedError
+func (f *RegexFilter) filterText(text string) (string, error) {
+	for _, re := range f.patterns {
+		if !re.MatchString(text) {
+			continue
+		}
+		if !f.redact {
+			return text, &ContentBlockedError{Reason: fmt.Sprintf("matched pattern %q", re.String())}
+		}
+		text = re.ReplaceAllString(text, f.replacement)
+	}
+	return text, nil
+}
+
+// FilterResponse 实现 ResponseFilter，检查每个 choice 的文本内容
+func (f *RegexFilter) FilterResponse(resp *models.ChatCompletionResponse) (bool, error) {
+	modified := false
+	for i := range resp.Choices {
+		msg := &resp.Choices[i].Message
+		text, ok := msg.Content.(string)
+		if !ok || text == "" {
+			continue
+		}
+		filtered, err := f.filterText(text)
+		if err != nil {
+			return false, err
+		}
+		if filtered != text {
+			msg.Content = filtered
+			modified = true
+		}
+	}
+	return modified, nil
+}
+
+// handleResponse 交给适配器写出上游响应，上游的 x-ratelimit-* 响应头透传给客户端；
+// 开启 echo_request_model 时将响应中的 model 改写为客户端请求的模型名
+// 配置了响应过滤器时，非流式的 200 响应先缓冲并过滤：被拒绝时返回 400，被改写时写出改写后的内容
+func (h *ProxyHandler) handleResponse(c *gin.Context, adp adapter.ProviderAdapter, resp *http.Response, stream bool) (err error) {
+	copyRateLimitHeaders(c.Writer.Header(), resp.Header)
+
+	if model := h.echoRequestModel(c); model != "" {
+		echo := newModelEchoWriter(c.Writer, model, stream)
+		c.Writer = echo
+		defer func() {
+			c.Writer = echo.ResponseWriter
+			if finishErr := echo.finish(); err == nil {
+				err = finishErr
+			}
+		}()
+	}
+
+	filter := h.responseFilter
+	if filter == nil {
+		filter = h.lb.ResponseFilter()
+	}
+	if filter == nil || stream || resp.StatusCode != 200 {
+		return adp.HandleResponse(c, resp, stream)
+	}
+
+	original := c.Writer
+	buffer := NewResponseInterceptor(false)
+	c.Writer = buffer
+	err = adp.HandleResponse(c, resp, false)
+	c.Writer = original
+	if isMalformedResponse(err) {
+		return err // 尚未写出任何内容，由调用方重试或返回 502
+	}
+
+	for k, v := range buffer.Header() {
+		c.Writer.Header()[k] = v
+	}
+	body := buffer.body.Bytes()
+
+	var chatResp models.ChatCompletionResponse
+	if buffer.Status() == 200 && json.Unmarshal(body, &chatResp) == nil && len(chatResp.Choices) > 0 {
+		modified, filterErr := filter.FilterResponse(&chatResp)
+		var blocked *ContentBlockedError
+		switch {
+		case errors.As(filterErr, &blocked):
+			h.reqLog(c).Warnf("Response blocked by content filter: %s", blocked.Reason)
+			c.JSON(400, models.ErrorResponse{Error: models.ErrorDetail{
+				Message: "The response was blocked by the gateway content filter",
+				Type:    "invalid_request_error",
+				Code:    "content_filter",
+			}})
+			return err
+		case filterErr != nil:
+			// 过滤器自身故障时拒绝放行，避免未经审核的内容泄露
+			h.reqLog(c).Errorf("Response filter failed: %v", filterErr)
+			c.JSON(500, gin.H{"error": "Response filter failed"})
+			return err
+		case modified:
+			c.JSON(200, chatResp)
+			return err
+		}
+	}
+
+	contentType := c.Writer.Header().Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	c.Data(buffer.Status(), contentType, body)
+	return err
+}
+
+// FilterRequest 实现 RequestFilter，检查每条消息的文本内容 (包括多模态消息中的 text 部分)
+// 改写时复制消息切片，不影响调用方持有的原请求
+func (f *RegexFilter) FilterRequest(req *models.ChatCompletionRequest) (bool, error) {
+	var messages []models.ChatMessage // 首次改写时复制
+	setContent := func(i int, content interface{}) {
+		if messages == nil {
+			messages = append([]models.ChatMessage(nil), req.Messages...)
+		}
+		messages[i].Content = content
+	}
+
+	for i, msg := range req.Messages {
+		switch content := msg.Content.(type) {
+		case string:
+			filtered, err := f.filterText(content)
+			if err != nil {
+				return false, err
+			}
+			if filtered != content {
+				setContent(i, filtered)
+			}
+		case []interface{}:
+			var parts []interface{}
+			for j, part := range content {
+				p, ok := part.(map[string]interface{})
+				if !ok || p["type"] != "text" {
+					continue
+				}
+				text, _ := p["text"].(string)
+				filtered, err := f.filterText(text)
+				if err != nil {
+					return false, err
+				}
+				if filtered == text {
+					continue
+				}
+				if parts == nil {
+					parts = append([]interface{}(nil), content...)
+				}
+				copied := make(map[string]interface{}, len(p))
+				for k, v := range p {
+					copied[k] = v
+				}
+				copied["text"] = filtered
+				parts[j] = copied
+			}
+			if parts != nil {
+				setContent(i, parts)
+			}
+		}
+	}
+
+	if messages == nil {
+		return false, nil
+	}
+	req.Messages = messages
+	return true, nil
+}
+
+// filterRequest 执行请求过滤，被拒绝或过滤失败时写出错误响应并返回 false
+func (h *ProxyHandler) filterRequest(c *gin.Context, req *models.ChatCompletionRequest) bool {
+	filter := h.requestFilter
+	if filter == nil {
+		filter = h.lb.RequestFilter()
+	}
+	if filter == nil {
+		return true
+	}
+
+	_, err := filter.FilterRequest(req)
+	var blocked *ContentBlockedError
+	switch {
+	case errors.As(err, &blocked):
+		h.reqLog(c).Warnf("Request blocked by content filter: %s", blocked.Reason)
+		c.JSON(400, models.ErrorResponse{Error: models.ErrorDetail{
+			Message: "The request was blocked by the gateway content policy",
+			Type:    "invalid_request_error",
+			Code:    "content_filter",
+		}})
+		return false
+	case err != nil:
+		h.reqLog(c).Errorf("Request filter failed: %v", err)
+		c.JSON(500, gin.H{"error": "Request filter failed"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"llm-gateway/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// newFilterTestHandler 创建上游固定返回 content 的 ProxyHandler
+func newFilterTestHandler(t *testing.T, responseFilter string, content string) *ProxyHandler {
+	gin.SetMode(gin.TestMode)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":"chatcmpl-1","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":%q},"finish_reason":"stop"}]}`, content)
+	}))
+	t.Cleanup(upstream.Close)
+
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000, ResponseFilter: responseFilter})
+
+	group := models.ModelGroup{GroupID: "filter-group", Strategy: "fallback"}
+	db.Create(&group)
+	m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID}
+	db.Create(&m)
+	db.Create(&models.APIKey{KeyValue: "sk-filter", ModelConfigID: m.ID})
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	return NewProxyHandler(lb, &http.Client{}, logger, nil)
+}
+
+func doFilteredRequest(h *ProxyHandler) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	h.ProxyRequest(c, models.ChatCompletionRequest{
+		Model:    "filter-group",
+		Messages: []models.ChatMessage{{Role: "user", Content: "hi"}},
+	})
+	return w
+}
+
+func TestResponseFilter_Redact(t *testing.T) {
+	h := newFilterTestHandler(t, `{"patterns":["\\d{3}-\\d{2}-\\d{4}"],"action":"redact"}`, "Your SSN is 123-45-6789.")
+	w := doFilteredRequest(h)
+	assert.Equal(t, 200, w.Code)
+
+	var resp models.ChatCompletionResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "Your SSN is [REDACTED].", resp.Choices[0].Message.Content)
+}
+
+func TestResponseFilter_Block(t *testing.T) {
+	h := newFilterTestHandler(t, `{"patterns":["(?i)secret plan"]}`, "Here is the Secret Plan.")
+	w := doFilteredRequest(h)
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "content_filter")
+
+	// 未命中时原样透传
+	t.Run("pass", func(t *testing.T) {
+		h := newFilterTestHandler(t, `{"patterns":["(?i)secret plan"]}`, "Nothing to see.")
+		w := doFilteredRequest(h)
+		assert.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), `"content":"Nothing to see."`)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	})
+}
+
+// stubResponseFilter 模拟外部审核服务
+type stubResponseFilter struct{ calls int }
+
+func (s *stubResponseFilter) FilterResponse(resp *models.ChatCompletionResponse) (bool, error) {
+	s.calls++
+	return false, &ContentBlockedError{Reason: "flagged by moderation"}
+}
+
+func TestResponseFilter_Custom(t *testing.T) {
+	h := newFilterTestHandler(t, "", "Anything.")
+	assert.Equal(t, 200, doFilteredRequest(h).Code)
+
+	stub := &stubResponseFilter{}
+	h.SetResponseFilter(stub)
+	assert.Equal(t, 400, doFilteredRequest(h).Code)
+	assert.Equal(t, 1, stub.calls)
+}
+
+func TestNewRegexFilter_Invalid(t *testing.T) {
+	_, err := NewRegexFilter(&models.ContentFilterConfig{Patterns: []string{"("}})
+	assert.Error(t, err)
+	_, err = NewRegexFilter(&models.ContentFilterConfig{Patterns: []string{"x"}, Action: "drop"})
+	assert.Error(t, err)
+
+	f, err := NewRegexFilter(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, f)
+}
+
+func TestRequestFilter_BlockAndAllow(t *testing.T) {
+	h := newFilterTestHandler(t, "", "Hello.")
+	f, err := NewRegexFilter(&models.ContentFilterConfig{Patterns: []string{`(?i)\bhi\b`}})
+	assert.NoError(t, err)
+	h.SetRequestFilter(f)
+
+	w := doFilteredRequest(h)
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"content_filter"`)
+
+	// 未命中时正常路由
+	f, err = NewRegexFilter(&models.ContentFilterConfig{Patterns: []string{"banned"}})
+	assert.NoError(t, err)
+	h.SetRequestFilter(f)
+	assert.Equal(t, 200, doFilteredRequest(h).Code)
+}
+
+func TestRegexFilter_FilterRequestRedact(t *testing.T) {
+	f, err := NewRegexFilter(&models.ContentFilterConfig{Patterns: []string{`\S+@\S+\.com`}, Action: "redact", Replacement: "<email>"})
+	assert.NoError(t, err)
+
+	parts := []interface{}{
+		map[string]interface{}{"type": "text", "text": "mail bob@example.com"},
+		map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "http://x"}},
+	}
+	original := []models.ChatMessage{
+		{Role: "system", Content: "be nice"},
+		{Role: "user", Content: "I am alice@example.com"},
+		{Role: "user", Content: parts},
+	}
+	req := models.ChatCompletionRequest{Messages: original}
+
+	modified, err := f.FilterRequest(&req)
+	assert.NoError(t, err)
+	assert.True(t, modified)
+	assert.Equal(t, "be nice", req.Messages[0].Content)
+	assert.Equal(t, "I am <email>", req.Messages[1].Content)
+	assert.Equal(t, "mail <email>", req.Messages[2].Content.([]interface{})[0].(map[string]interface{})["text"])
+
+	// 调用方持有的消息不被修改
+	assert.Equal(t, "I am alice@example.com", original[1].Content)
+	assert.Equal(t, "mail bob@example.com", parts[0].(map[string]interface{})["text"])
+}
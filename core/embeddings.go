@@ -0,0 +1,297 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"llm-gateway/core/adapter"
+	"llm-gateway/models"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultEmbeddingBatchSize 未配置 embedding_batch_size 时单次上游请求的最大输入条数
+	defaultEmbeddingBatchSize = 2048
+	// embeddingConcurrency 同一请求内并发发往上游的批次数
+	embeddingConcurrency = 4
+)
+
+// errEmbeddingsUnsupported 路由到的模型不是 OpenAI 兼容接口
+var errEmbeddingsUnsupported = errors.New("embeddings are only supported for OpenAI-compatible providers")
+
+// embeddingStatusError 上游返回了不需要重试的非 200 响应 (如 400)，原样透传给客户端
+type embeddingStatusError struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func (e *embeddingStatusError) Error() string {
+	return fmt.Sprintf("upstream returned status %d", e.status)
+}
+
+// embeddingBatch 一个上游批次，inputs 对应原始输入中从 start 开始的部分
+type embeddingBatch struct {
+	start  int
+	inputs []interface{}
+
+	resp    *models.EmbeddingResponse
+	routing *models.RoutingInfo
+	err     error
+}
+
+// HandleEmbeddings 处理 OpenAI 兼容的 /v1/embeddings 请求
+// 输入条数超过 embedding_batch_size 时拆分为多个批次，通过 Key 池并发请求后按原始顺序合并；
+// 失败的批次换用其他 Key 重试，任一批次最终失败时整个请求失败
+func (h *ProxyHandler) HandleEmbeddings(c *gin.Context) {
+	startTime := time.Now()
+	bindRequestID(c)
+	c.Set(requestTypeKey, models.RequestTypeEmbeddings)
+
+	var req models.EmbeddingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeEmbeddingError(c, 400, "Invalid request body: "+err.Error(), "invalid_request_error", "")
+		return
+	}
+	defer func() {
+		h.logAccess(c, req.Model, startTime, 0)
+	}()
+
+	if !h.checkGroupAccess(c, req.Model) {
+		return
+	}
+
+	inputs, err := splitEmbeddingInput(req.Input)
+	if err != nil {
+		writeEmbeddingError(c, 400, err.Error(), "invalid_request_error", "")
+		return
+	}
+
+	if !h.acquireSlot(c) {
+		return
+	}
+	defer h.releaseSlot()
+
+	batchSize := defaultEmbeddingBatchSize
+	if settings := h.lb.GetGatewaySettings(); settings != nil && settings.EmbeddingBatchSize > 0 {
+		batchSize = settings.EmbeddingBatchSize
+	}
+	var batches []*embeddingBatch
+	for start := 0; start < len(inputs); start += batchSize {
+		end := min(start+batchSize, len(inputs))
+		batches = append(batches, &embeddingBatch{start: start, inputs: inputs[start:end]})
+	}
+	if len(batches) > 1 {
+		h.reqLog(c).Infof("Splitting %d embedding inputs into %d batches", len(inputs), len(batches))
+	}
+
+	// 任一批次最终失败时取消其余批次
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	sem := make(chan struct{}, embeddingConcurrency)
+	var wg sync.WaitGroup
+	for _, batch := range batches {
+		wg.Add(1)
+		go func(batch *embeddingBatch) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				batch.err = ctx.Err()
+				return
+			}
+			batch.resp, batch.routing, batch.err = h.embedBatch(ctx, c, req, batch.inputs)
+			if batch.err != nil {
+				cancel()
+			}
+		}(batch)
+	}
+	wg.Wait()
+
+	// 路由信息与用量供请求日志中间件统计 (按第一个批次的模型记录)
+	if batches[0].routing != nil {
+		c.Set("routing_info", batches[0].routing)
+	}
+	if err := firstEmbeddingError(batches); err != nil {
+		h.writeEmbeddingFailure(c, err)
+		return
+	}
+
+	result := models.EmbeddingResponse{Object: "list", Model: batches[0].resp.Model}
+	for _, batch := range batches {
+		data := batch.resp.Data
+		sort.Slice(data, func(i, j int) bool { return data[i].Index < data[j].Index })
+		for _, d := range data {
+			d.Index += batch.start
+			if d.Object == "" {
+				d.Object = "embedding"
+			}
+			result.Data = append(result.Data, d)
+		}
+		result.Usage.PromptTokens += batch.resp.Usage.PromptTokens
+		result.Usage.TotalTokens += batch.resp.Usage.TotalTokens
+	}
+	c.Set("usage", &models.ChatCompletionUsage{PromptTokens: result.Usage.PromptTokens, TotalTokens: result.Usage.TotalTokens})
+	c.JSON(200, result)
+}
+
+// embedBatch 请求一个批次，网络错误、429、5xx 与无法解析的响应换用其他 Key/模型重试
+func (h *ProxyHandler) embedBatch(ctx context.Context, c *gin.Context, req models.EmbeddingRequest, inputs []interface{}) (*models.EmbeddingResponse, *models.RoutingInfo, error) {
+	var lastErr error
+	maxRetries := h.lb.MaxRetries(req.Model)
+	for i := 0; i < maxRetries; i++ {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		routing, err := h.lb.Route(req.Model)
+		if err != nil {
+			h.reqLog(c).Warnf("[Embeddings] Routing failed: %v", err)
+			if errors.Is(err, ErrGroupNotFound) {
+				return nil, nil, err
+			}
+			if lastErr == nil {
+				lastErr = err
+			}
+			break
+		}
+		resp, err := h.embedOnce(ctx, c, routing, req, inputs)
+		h.lb.ReleaseKey(routing)
+		if err == nil {
+			return resp, routing, nil
+		}
+		var statusErr *embeddingStatusError
+		if errors.As(err, &statusErr) || errors.Is(err, errEmbeddingsUnsupported) || ctx.Err() != nil {
+			return nil, routing, err
+		}
+		h.reqLog(c).Warnf("[Embeddings] Attempt %d failed for %d inputs: %v", i+1, len(inputs), err)
+		lastErr = err
+	}
+	return nil, nil, fmt.Errorf("Upstream unavailable after %d retries. Last error: %v", maxRetries, lastErr)
+}
+
+// embedOnce 使用选定的路由发出一次向量请求
+func (h *ProxyHandler) embedOnce(ctx context.Context, c *gin.Context, routing *models.RoutingInfo, req models.EmbeddingRequest, inputs []interface{}) (*models.EmbeddingResponse, error) {
+	if KnownProvider(routing.Provider) && !strings.EqualFold(routing.Provider, "openai") {
+		return nil, errEmbeddingsUnsupported
+	}
+	u, err := (&adapter.OpenAIAdapter{UpstreamPath: routing.UpstreamPath}).EmbeddingsURL(routing.UpstreamURL)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Model = routing.UpstreamModel
+	req.Input = inputs
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+routing.APIKey)
+	httpReq.Header.Set("User-Agent", "LLM-Gateway/2.0")
+
+	resp, err := h.clientFor(routing.Provider).Do(httpReq)
+	// 因其他批次失败或客户端断开而取消的请求不惩罚 Key
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if failErr := h.checkUpstream(c, routing, resp, err); failErr != nil {
+		return nil, failErr
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, &embeddingStatusError{status: resp.StatusCode, header: resp.Header, body: data}
+	}
+	var result models.EmbeddingResponse
+	if err := json.Unmarshal(data, &result); err != nil || len(result.Data) != len(inputs) {
+		if err == nil {
+			err = fmt.Errorf("got %d embeddings for %d inputs", len(result.Data), len(inputs))
+		}
+		h.lb.keyManager.MarkCooldown(routing.APIKey, 5*time.Second)
+		return nil, fmt.Errorf("malformed embeddings response: %w", err)
+	}
+	return &result, nil
+}
+
+// splitEmbeddingInput 将 input 展开为独立的输入列表 (字符串或 Token 数组)
+func splitEmbeddingInput(input interface{}) ([]interface{}, error) {
+	switch v := input.(type) {
+	case string:
+		return []interface{}{v}, nil
+	case []interface{}:
+		if len(v) == 0 {
+			return nil, errors.New("input must not be empty")
+		}
+		// 数字数组是单个已分词的输入
+		if _, ok := v[0].(float64); ok {
+			return []interface{}{v}, nil
+		}
+		for i, item := range v {
+			switch item.(type) {
+			case string, []interface{}:
+			default:
+				return nil, fmt.Errorf("input[%d] must be a string or an array of tokens", i)
+			}
+		}
+		return v, nil
+	}
+	return nil, errors.New("input must be a string or an array")
+}
+
+// firstEmbeddingError 按批次顺序返回第一个失败原因 (忽略因其他批次失败而被取消的批次)
+func firstEmbeddingError(batches []*embeddingBatch) error {
+	var canceled error
+	for _, batch := range batches {
+		switch {
+		case batch.err == nil:
+		case errors.Is(batch.err, context.Canceled):
+			canceled = batch.err
+		default:
+			return batch.err
+		}
+	}
+	return canceled
+}
+
+// writeEmbeddingFailure 按失败原因返回响应: 上游非重试错误原样透传，其余返回 OpenAI 格式错误
+func (h *ProxyHandler) writeEmbeddingFailure(c *gin.Context, err error) {
+	h.reqLog(c).Errorf("[Embeddings] Request failed: %v", err)
+	var statusErr *embeddingStatusError
+	switch {
+	case errors.As(err, &statusErr):
+		copyRateLimitHeaders(c.Writer.Header(), statusErr.header)
+		c.Data(statusErr.status, "application/json", statusErr.body)
+	case errors.Is(err, errEmbeddingsUnsupported):
+		writeEmbeddingError(c, 400, err.Error(), "invalid_request_error", "model")
+	case errors.Is(err, ErrGroupNotFound):
+		writeEmbeddingError(c, 404, err.Error(), "invalid_request_error", "model")
+	default:
+		writeEmbeddingError(c, 502, err.Error(), "upstream_error", "")
+	}
+}
+
+func writeEmbeddingError(c *gin.Context, status int, message, errType, param string) {
+	c.JSON(status, models.ErrorResponse{Error: models.ErrorDetail{
+		Message: message,
+		Type:    errType,
+		Param:   param,
+	}})
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"llm-gateway/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestSplitEmbeddingInput(t *testing.T) {
+	inputs, err := splitEmbeddingInput("hello")
+	assert.NoError(t, err)
+	assert.Len(t, inputs, 1)
+
+	inputs, err = splitEmbeddingInput([]interface{}{"a", "b", []interface{}{1.0, 2.0}})
+	assert.NoError(t, err)
+	assert.Len(t, inputs, 3)
+
+	// 单个 Token 数组视为一条输入
+	inputs, err = splitEmbeddingInput([]interface{}{1.0, 2.0, 3.0})
+	assert.NoError(t, err)
+	assert.Len(t, inputs, 1)
+
+	_, err = splitEmbeddingInput([]interface{}{})
+	assert.Error(t, err)
+	_, err = splitEmbeddingInput([]interface{}{"a", map[string]interface{}{}})
+	assert.Error(t, err)
+	_, err = splitEmbeddingInput(42.0)
+	assert.Error(t, err)
+}
+
+func TestHandleEmbeddings_SplitsBatches(t *testing.T) {
+	var mu sync.Mutex
+	var batchSizes []int
+	failed := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/embeddings", r.URL.Path)
+		var req struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		assert.Equal(t, "text-embedding-3-small", req.Model)
+
+		mu.Lock()
+		// 第一个批次在 sk-emb-bad 上失败一次，应换用其他 Key 重试
+		if r.Header.Get("Authorization") == "Bearer sk-emb-bad" && !failed {
+			failed = true
+			mu.Unlock()
+			w.WriteHeader(500)
+			return
+		}
+		batchSizes = append(batchSizes, len(req.Input))
+		mu.Unlock()
+
+		// 倒序返回，网关按 index 重新排序
+		var data []string
+		for i := len(req.Input) - 1; i >= 0; i-- {
+			value := strings.TrimPrefix(req.Input[i], "text-")
+			data = append(data, fmt.Sprintf(`{"object":"embedding","index":%d,"embedding":[%s]}`, i, value))
+		}
+		fmt.Fprintf(w, `{"object":"list","model":"text-embedding-3-small","data":[%s],"usage":{"prompt_tokens":%d,"total_tokens":%d}}`,
+			strings.Join(data, ","), len(req.Input), len(req.Input))
+	}))
+	defer upstream.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:embeddings_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000, EmbeddingBatchSize: 3})
+
+	group := models.ModelGroup{GroupID: "embed-group", Strategy: "fallback"}
+	db.Create(&group)
+	m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "text-embedding-3-small", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID}
+	db.Create(&m)
+	db.Create(&models.APIKey{KeyValue: "sk-emb-bad", ModelConfigID: m.ID})
+	db.Create(&models.APIKey{KeyValue: "sk-emb-good", ModelConfigID: m.ID})
+
+	claudeGroup := models.ModelGroup{GroupID: "embed-claude", Strategy: "fallback"}
+	db.Create(&claudeGroup)
+	cm := models.ModelConfig{ProviderName: "claude", UpstreamModel: "claude-3", UpstreamURL: upstream.URL, ModelGroupID: claudeGroup.ID}
+	db.Create(&cm)
+	db.Create(&models.APIKey{KeyValue: "sk-emb-claude", ModelConfigID: cm.ID})
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	embed := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/v1/embeddings", strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		h.HandleEmbeddings(c)
+		return w
+	}
+
+	var inputs []string
+	for i := 0; i < 7; i++ {
+		inputs = append(inputs, fmt.Sprintf(`"text-%d"`, i))
+	}
+	w := embed(`{"model":"embed-group","input":[` + strings.Join(inputs, ",") + `]}`)
+	assert.Equal(t, 200, w.Code)
+
+	var resp models.EmbeddingResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "list", resp.Object)
+	assert.Len(t, resp.Data, 7)
+	for i, d := range resp.Data {
+		assert.Equal(t, i, d.Index)
+		assert.JSONEq(t, fmt.Sprintf("[%d]", i), string(d.Embedding))
+	}
+	assert.Equal(t, 7, resp.Usage.PromptTokens)
+	assert.True(t, failed)
+	assert.ElementsMatch(t, []int{3, 3, 1}, batchSizes)
+
+	// 非 OpenAI 兼容的上游与未知组
+	assert.Equal(t, 400, embed(`{"model":"embed-claude","input":"hi"}`).Code)
+	assert.Equal(t, 404, embed(`{"model":"missing","input":"hi"}`).Code)
+	assert.Equal(t, 400, embed(`{"model":"embed-group","input":[]}`).Code)
+
+	// 请求日志按 embeddings 类型记录，不计入聊天统计
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/embeddings", strings.NewReader(`{"model":"embed-group","input":"text-1"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	h.HandleEmbeddings(c)
+	assert.Equal(t, models.RequestTypeEmbeddings, c.GetString("request_type"))
+}
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"fmt"
+	"llm-gateway/models"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tokenBucket 令牌桶: 容量为每分钟上限，按 容量/60 每秒匀速补充
+// 余额可以为负 (请求结束后按实际用量补扣)，此时需等补充回正后才能放行新的请求
+type tokenBucket struct {
+	capacity float64
+	tokens   float64
+	updated  time.Time
+}
+
+func newTokenBucket(perMinute int, now time.Time) *tokenBucket {
+	return &tokenBucket{capacity: float64(perMinute), tokens: float64(perMinute), updated: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.capacity, b.tokens+elapsed*b.capacity/60)
+	}
+	b.updated = now
+}
+
+// wait 返回余额达到 n 所需的等待时间，0 表示可以立即扣除
+// n 超过容量时按容量计算，避免单个大请求永远无法通过
+func (b *tokenBucket) wait(n float64) time.Duration {
+	n = math.Min(n, b.capacity)
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / (b.capacity / 60) * float64(time.Second))
+}
+
+// groupBuckets 一个组的请求数与 Token 数令牌桶 (未配置的限制为 nil)
+type groupBuckets struct {
+	rpm, tpm int
+	requests *tokenBucket
+	tokens   *tokenBucket
+}
+
+// GroupRateLimiter 按模型组的每分钟请求数 (requests_per_minute) 与 Token 数 (tokens_per_minute) 限流
+type GroupRateLimiter struct {
+	mu     sync.Mutex
+	groups map[string]*groupBuckets
+}
+
+func NewGroupRateLimiter() *GroupRateLimiter {
+	return &GroupRateLimiter{groups: make(map[string]*groupBuckets)}
+}
+
+// Acquire 在组的限额内预扣一个请求与 estimate 个 Token，超出时不扣除并返回需要等待的时间
+// rpm/tpm 为 0 表示不限制；组的限额变化时重新创建令牌桶
+func (l *GroupRateLimiter) Acquire(groupID string, rpm, tpm, estimate int, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	g := l.groups[groupID]
+	if g == nil || g.rpm != rpm || g.tpm != tpm {
+		g = &groupBuckets{rpm: rpm, tpm: tpm}
+		if rpm > 0 {
+			g.requests = newTokenBucket(rpm, now)
+		}
+		if tpm > 0 {
+			g.tokens = newTokenBucket(tpm, now)
+		}
+		l.groups[groupID] = g
+	}
+
+	var wait time.Duration
+	if g.requests != nil {
+		g.requests.refill(now)
+		wait = max(wait, g.requests.wait(1))
+	}
+	if g.tokens != nil {
+		g.tokens.refill(now)
+		wait = max(wait, g.tokens.wait(float64(estimate)))
+	}
+	if wait > 0 {
+		return false, wait
+	}
+	if g.requests != nil {
+		g.requests.tokens--
+	}
+	if g.tokens != nil {
+		g.tokens.tokens -= float64(estimate)
+	}
+	return true, 0
+}
+
+// Reconcile 请求结束后按实际 Token 用量修正预扣的估算值 (多退少补)
+func (l *GroupRateLimiter) Reconcile(groupID string, estimate, actual int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if g := l.groups[groupID]; g != nil && g.tokens != nil {
+		g.tokens.tokens = math.Min(g.tokens.capacity, g.tokens.tokens+float64(estimate-actual))
+	}
+}
+
+// estimateRequestTokens 粗略估算请求的输入 Token 数 (约 4 个字符一个 Token)
+func estimateRequestTokens(req models.ChatCompletionRequest) int {
+	chars := 0
+	for i := range req.Messages {
+		chars += utf8.RuneCountInString(req.Messages[i].StringContent())
+	}
+	return chars/4 + 1
+}
+
+// acquireGroupBudget 检查请求所在组的每分钟请求数与 Token 数限额，超出时返回 429 (带 Retry-After)
+// 放行时返回请求结束后调用的回调，按实际用量修正预扣的 Token
+func (h *ProxyHandler) acquireGroupBudget(c *gin.Context, req models.ChatCompletionRequest) (func(), bool) {
+	groupID, rpm, tpm := h.lb.GroupRateLimits(req.Model)
+	if rpm <= 0 && tpm <= 0 {
+		return func() {}, true
+	}
+
+	estimate := estimateRequestTokens(req)
+	if ok, wait := h.lb.groupLimiter.Acquire(groupID, rpm, tpm, estimate, time.Now()); !ok {
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		h.reqLog(c).Warnf("Group %s rate limit exceeded, retry after %ds", groupID, retryAfter)
+		c.Set(errorTypeKey, ErrorTypeRateLimit)
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.JSON(429, models.ErrorResponse{Error: models.ErrorDetail{
+			Message: fmt.Sprintf("Rate limit exceeded for model group '%s', please retry after %d seconds", groupID, retryAfter),
+			Type:    "rate_limit_error",
+			Code:    "group_rate_limit_exceeded",
+		}})
+		return nil, false
+	}
+
+	return func() {
+		actual := estimate
+		if u, exists := c.Get("usage"); exists {
+			if usage, ok := u.(*models.ChatCompletionUsage); ok && usage != nil {
+				actual = usage.TotalTokens
+				if actual == 0 {
+					actual = usage.PromptTokens + usage.CompletionTokens
+				}
+			}
+		} else if c.Writer.Status() != 200 {
+			// 失败且上游未返回用量的请求不消耗 Token 额度
+			actual = 0
+		}
+		h.lb.groupLimiter.Reconcile(groupID, estimate, actual)
+	}, true
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"llm-gateway/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestGroupRateLimiter(t *testing.T) {
+	l := NewGroupRateLimiter()
+	now := time.Now()
+
+	// 请求数: 每分钟 2 次，补充速度为每 30 秒 1 次
+	ok, _ := l.Acquire("g", 2, 0, 100, now)
+	assert.True(t, ok)
+	ok, _ = l.Acquire("g", 2, 0, 100, now)
+	assert.True(t, ok)
+	ok, wait := l.Acquire("g", 2, 0, 100, now)
+	assert.False(t, ok)
+	assert.Equal(t, 30*time.Second, wait)
+	ok, _ = l.Acquire("g", 2, 0, 100, now.Add(30*time.Second))
+	assert.True(t, ok)
+
+	// Token 数: 预扣估算值，结束后按实际用量多退少补
+	ok, _ = l.Acquire("t", 0, 600, 400, now)
+	assert.True(t, ok)
+	ok, wait = l.Acquire("t", 0, 600, 400, now)
+	assert.False(t, ok)
+	assert.Equal(t, 20*time.Second, wait) // 缺 200 个，每秒补充 10 个
+	l.Reconcile("t", 400, 100)
+	ok, _ = l.Acquire("t", 0, 600, 400, now)
+	assert.True(t, ok)
+	l.Reconcile("t", 400, 700) // 实际用量超出估算，余额为负
+	ok, wait = l.Acquire("t", 0, 600, 1, now)
+	assert.False(t, ok)
+	assert.Equal(t, 20100*time.Millisecond, wait) // 余额 -200，补充到 1 需要 20.1 秒
+
+	// 超过容量的请求按容量计算，桶满时可以通过
+	ok, _ = l.Acquire("big", 0, 600, 5000, now)
+	assert.True(t, ok)
+
+	// 限额变化时重新开始计算
+	ok, _ = l.Acquire("g", 3, 0, 100, now)
+	assert.True(t, ok)
+}
+
+func TestProxyRequest_GroupRateLimit(t *testing.T) {
+	var hits int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		fmt.Fprint(w, `{"id":"1","object":"chat.completion","model":"gpt-4","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":5,"total_tokens":10}}`)
+	}))
+	defer upstream.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:group_rate_limit_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	for _, group := range []models.ModelGroup{
+		{GroupID: "rpm-limited", Strategy: "fallback", RequestsPerMinute: 1},
+		{GroupID: "tpm-limited", Strategy: "fallback", TokensPerMinute: 100},
+	} {
+		db.Create(&group)
+		m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID}
+		db.Create(&m)
+		db.Create(&models.APIKey{KeyValue: "sk-" + group.GroupID, ModelConfigID: m.ID})
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	proxy := func(model, content string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		h.ProxyRequest(c, models.ChatCompletionRequest{Model: model, Messages: []models.ChatMessage{{Role: "user", Content: content}}})
+		return w
+	}
+
+	assert.Equal(t, 200, proxy("rpm-limited", "hi").Code)
+	w := proxy("rpm-limited", "hi")
+	assert.Equal(t, 429, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	var errResp models.ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &errResp))
+	assert.Equal(t, "group_rate_limit_exceeded", errResp.Error.Code)
+	assert.Equal(t, 1, hits)
+
+	// 估算 300 个字符约 76 Token，实际用量 10 Token，修正后仍可继续请求
+	long := strings.Repeat("x", 300)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, 200, proxy("tpm-limited", long).Code)
+	}
+	assert.Equal(t, 4, hits)
+}
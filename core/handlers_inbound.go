@@ -3,18 +3,30 @@ package core
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"llm-gateway/core/adapter"
 	"llm-gateway/core/mapper"
 	"llm-gateway/models"
 	"net/http"
 	"strings"
+	"time"
 
 	stdPkgNet "net"
 
 	"github.com/gin-gonic/gin"
 )
 
+// 流式转发的背压: 缓冲区写满后写入方阻塞等待消费者，超过 interceptorSendTimeout 视为消费者停滞
+const (
+	interceptorStreamBuffer = 1024
+	interceptorSendTimeout  = 30 * time.Second
+)
+
+// errStreamConsumerStalled 消费者长时间未读取流式数据，上游请求已被中止
+var errStreamConsumerStalled = errors.New("stream consumer stalled, upstream aborted")
+
 // ResponseInterceptor captures the response from ProxyHandler
 type ResponseInterceptor struct {
 	gin.ResponseWriter
@@ -25,30 +37,71 @@ type ResponseInterceptor struct {
 	// Streaming support
 	isStream   bool
 	streamChan chan []byte
+
+	// 由 bind 设置: ctx 取消后写入立即失败；消费者停滞超时时调用 cancel 中止上游请求
+	ctx         context.Context
+	cancel      context.CancelFunc
+	sendTimeout time.Duration
 }
 
 func NewResponseInterceptor(isStream bool) *ResponseInterceptor {
 	return &ResponseInterceptor{
-		body:       bytes.NewBufferString(""),
-		headers:    make(http.Header),
-		statusCode: 200,
-		isStream:   isStream,
-		streamChan: make(chan []byte, 1024), // [FIX-03] Increased buffer to avoid blocking
+		body:        bytes.NewBufferString(""),
+		headers:     make(http.Header),
+		statusCode:  200,
+		isStream:    isStream,
+		streamChan:  make(chan []byte, interceptorStreamBuffer), // [FIX-03] Increased buffer to avoid blocking
+		ctx:         context.Background(),
+		sendTimeout: interceptorSendTimeout,
 	}
 }
 
+// bind 绑定内部 ProxyRequest 使用的请求 Context 及其取消函数
+func (w *ResponseInterceptor) bind(ctx context.Context, cancel context.CancelFunc) {
+	w.ctx = ctx
+	w.cancel = cancel
+}
+
 func (w *ResponseInterceptor) Write(b []byte) (int, error) {
 	if w.isStream {
 		// Send a copy to channel
 		// We must copy because b might be reused
 		bCopy := make([]byte, len(b))
 		copy(bCopy, b)
-		w.streamChan <- bCopy
+		if err := w.send(bCopy); err != nil {
+			return 0, err
+		}
 		return len(b), nil
 	}
 	return w.body.Write(b)
 }
 
+// send 将数据交给消费者: 缓冲区有空位时直接写入，否则最多阻塞 sendTimeout；
+// 请求已取消时立即返回错误，超时则中止上游请求，避免写入方 goroutine 永久阻塞
+func (w *ResponseInterceptor) send(b []byte) error {
+	select {
+	case w.streamChan <- b:
+		return nil
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	default:
+	}
+
+	timer := time.NewTimer(w.sendTimeout)
+	defer timer.Stop()
+	select {
+	case w.streamChan <- b:
+		return nil
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	case <-timer.C:
+		if w.cancel != nil {
+			w.cancel()
+		}
+		return errStreamConsumerStalled
+	}
+}
+
 func (w *ResponseInterceptor) WriteHeader(statusCode int) {
 	w.statusCode = statusCode
 }
@@ -98,8 +151,13 @@ func (h *ProxyHandler) HandleClaudeMessage(c *gin.Context) {
 	
 	// Create a fake context that shares the Request but writes to Interceptor
 	// We clone the request context to ensure cancellation works
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	interceptor.bind(ctx, cancel)
+	bindRequestID(c) // 与内部 ProxyRequest 共用同一请求 ID
 	fakeC, _ := gin.CreateTestContext(interceptor)
-	fakeC.Request = c.Request
+	fakeC.Request = c.Request.WithContext(ctx)
+	inheritAdminAuth(fakeC, c) // 强制路由等请求头仅对管理员凭证生效
 	
 	if cReq.Stream {
 		// --- Streaming Mode ---
@@ -121,6 +179,7 @@ func (h *ProxyHandler) HandleClaudeMessage(c *gin.Context) {
 		
 		// Buffer for incomplete lines
 		var lineBuffer string
+		streamMapper := mapper.NewClaudeStreamMapper()
 
 		for chunk := range interceptor.streamChan {
 			lineBuffer += string(chunk)
@@ -149,27 +208,34 @@ func (h *ProxyHandler) HandleClaudeMessage(c *gin.Context) {
 					if strings.HasPrefix(line, "data: ") {
 						dataStr := strings.TrimPrefix(line, "data: ")
 						if dataStr == "[DONE]" {
-                            // Claude done event
-							c.Writer.Write([]byte("event: message_stop\ndata: {\"type\": \"message_stop\"}\n\n"))
+							// 关闭内容块并发送 message_delta / message_stop
+							for _, evt := range streamMapper.Finish() {
+								c.Writer.Write([]byte(evt))
+							}
 							c.Writer.Flush()
 							continue
 						}
-						
-							var oResp models.ChatCompletionResponse
-							if err := json.Unmarshal([]byte(dataStr), &oResp); err == nil {
-								// Map to Claude Events
-								// Since we don't track index easily in stateless mapper, we pass index 0
-								events := mapper.OpenAIStreamToClaudeEvent(oResp, 0)
-								for _, evt := range events {
-									c.Writer.Write([]byte(evt))
-								}
-								c.Writer.Flush()
+
+						var oResp models.ChatCompletionResponse
+						if err := json.Unmarshal([]byte(dataStr), &oResp); err == nil {
+							for _, evt := range streamMapper.Map(oResp) {
+								c.Writer.Write([]byte(evt))
 							}
+							c.Writer.Flush()
 						}
+					}
 				}
 			}
 		}
 
+		// 上游未发送 [DONE] 就结束时也要补全终止事件，避免 SDK 客户端挂起
+		if events := streamMapper.Finish(); len(events) > 0 {
+			for _, evt := range events {
+				c.Writer.Write([]byte(evt))
+			}
+			c.Writer.Flush()
+		}
+
 	} else {
 		// --- Normal Mode ---
 		h.ProxyRequest(fakeC, oReq)
@@ -216,11 +282,19 @@ func (h *ProxyHandler) HandleGeminiGenerateContent(c *gin.Context) {
     // Check if stream based on URL
     isStream := strings.Contains(c.Request.URL.Path, "streamGenerateContent")
     oReq.Stream = isStream
+	if isStream {
+		oReq.StreamOptions = &models.StreamOptions{IncludeUsage: true} // 映射为最后一个事件的 usageMetadata
+	}
 
 	// 2. Prepare Interceptor
 	interceptor := NewResponseInterceptor(isStream)
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	interceptor.bind(ctx, cancel)
+	bindRequestID(c) // 与内部 ProxyRequest 共用同一请求 ID
 	fakeC, _ := gin.CreateTestContext(interceptor)
-	fakeC.Request = c.Request
+	fakeC.Request = c.Request.WithContext(ctx)
+	inheritAdminAuth(fakeC, c) // 强制路由等请求头仅对管理员凭证生效
 
 	if isStream {
 		// --- Streaming Mode ---
@@ -0,0 +1,285 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"llm-gateway/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// claudeStreamMessage 按 Anthropic SDK 的 Message.Accumulate 规则重建出的消息
+type claudeStreamMessage struct {
+	ID         string
+	Content    []map[string]interface{}
+	StopReason string
+	Usage      map[string]interface{}
+}
+
+// accumulateClaudeStream 解析 Claude SSE 并校验事件顺序:
+// message_start 必须最先出现，content_block_start 的 index 必须连续，
+// delta 只能发往打开且类型匹配的块，最后以 message_delta + message_stop 结束。
+func accumulateClaudeStream(r io.Reader) (*claudeStreamMessage, error) {
+	var msg *claudeStreamMessage
+	var eventName string
+	open := -1
+	partialJSON := map[int]string{}
+	stopped := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "event: ") {
+			eventName = strings.TrimPrefix(line, "event: ")
+			continue
+		}
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		if stopped {
+			return nil, fmt.Errorf("event after message_stop: %s", line)
+		}
+
+		var evt struct {
+			Type         string                 `json:"type"`
+			Index        *int                   `json:"index"`
+			Message      map[string]interface{} `json:"message"`
+			ContentBlock map[string]interface{} `json:"content_block"`
+			Delta        map[string]interface{} `json:"delta"`
+			Usage        map[string]interface{} `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &evt); err != nil {
+			return nil, err
+		}
+		if evt.Type != eventName {
+			return nil, fmt.Errorf("event name %q does not match data type %q", eventName, evt.Type)
+		}
+		if msg == nil && evt.Type != "message_start" {
+			return nil, fmt.Errorf("unexpected %s before message_start", evt.Type)
+		}
+
+		switch evt.Type {
+		case "message_start":
+			if msg != nil {
+				return nil, fmt.Errorf("duplicate message_start")
+			}
+			id, _ := evt.Message["id"].(string)
+			msg = &claudeStreamMessage{ID: id}
+		case "content_block_start":
+			if evt.Index == nil || *evt.Index != len(msg.Content) || open != -1 {
+				return nil, fmt.Errorf("content_block_start out of order: %v", evt.Index)
+			}
+			msg.Content = append(msg.Content, evt.ContentBlock)
+			open = *evt.Index
+		case "content_block_delta":
+			if evt.Index == nil || *evt.Index != open {
+				return nil, fmt.Errorf("delta for block %v which is not open", evt.Index)
+			}
+			block := msg.Content[open]
+			switch evt.Delta["type"] {
+			case "text_delta":
+				text, ok := block["text"].(string)
+				if !ok || block["type"] != "text" {
+					return nil, fmt.Errorf("text_delta for non-text block")
+				}
+				block["text"] = text + evt.Delta["text"].(string)
+			case "input_json_delta":
+				if block["type"] != "tool_use" {
+					return nil, fmt.Errorf("input_json_delta for non-tool block")
+				}
+				partialJSON[open] += evt.Delta["partial_json"].(string)
+			default:
+				return nil, fmt.Errorf("unknown delta type %v", evt.Delta["type"])
+			}
+		case "content_block_stop":
+			if evt.Index == nil || *evt.Index != open {
+				return nil, fmt.Errorf("content_block_stop for block %v which is not open", evt.Index)
+			}
+			if raw, ok := partialJSON[open]; ok {
+				var input interface{}
+				if err := json.Unmarshal([]byte(raw), &input); err != nil {
+					return nil, fmt.Errorf("invalid tool input JSON %q: %v", raw, err)
+				}
+				msg.Content[open]["input"] = input
+			}
+			open = -1
+		case "message_delta":
+			if open != -1 {
+				return nil, fmt.Errorf("message_delta while block %d is open", open)
+			}
+			msg.StopReason, _ = evt.Delta["stop_reason"].(string)
+			msg.Usage = evt.Usage
+		case "message_stop":
+			if msg.StopReason == "" {
+				return nil, fmt.Errorf("message_stop without stop_reason")
+			}
+			stopped = true
+		}
+	}
+	if !stopped {
+		return nil, fmt.Errorf("stream ended without message_stop")
+	}
+	return msg, scanner.Err()
+}
+
+func TestHandleClaudeMessage_StreamToolUse(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{
+			`{"id":"chatcmpl-1","model":"gpt-4","choices":[{"delta":{"role":"assistant","content":"Let me check."}}]}`,
+			`{"id":"chatcmpl-1","model":"gpt-4","choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":""}}]}}]}`,
+			`{"id":"chatcmpl-1","model":"gpt-4","choices":[{"delta":{"tool_calls":[{"index":0,"type":"function","function":{"name":"","arguments":"{\"city\":"}}]}}]}`,
+			`{"id":"chatcmpl-1","model":"gpt-4","choices":[{"delta":{"tool_calls":[{"index":0,"type":"function","function":{"name":"","arguments":"\"Paris\"}"}}]}}]}`,
+			`{"id":"chatcmpl-1","model":"gpt-4","choices":[{"delta":{},"finish_reason":"tool_calls"}]}`,
+			`{"id":"chatcmpl-1","model":"gpt-4","choices":[],"usage":{"prompt_tokens":12,"completion_tokens":7,"total_tokens":19}}`,
+		} {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:claude_inbound_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	group := models.ModelGroup{GroupID: "claude-in", Strategy: "round_robin"}
+	db.Create(&group)
+	m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID}
+	db.Create(&m)
+	db.Create(&models.APIKey{KeyValue: "sk-claude-in", ModelConfigID: m.ID})
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.POST("/v1/messages", h.HandleClaudeMessage)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	body := `{"model":"claude-in","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"Weather in Paris?"}],
+		"tools":[{"name":"get_weather","input_schema":{"type":"object"}}]}`
+	resp, err := http.Post(server.URL+"/v1/messages", "application/json", strings.NewReader(body))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	msg, err := accumulateClaudeStream(resp.Body)
+	assert.NoError(t, err)
+	if assert.NotNil(t, msg) {
+		assert.Equal(t, "chatcmpl-1", msg.ID)
+		assert.Equal(t, "tool_use", msg.StopReason)
+		assert.Len(t, msg.Content, 2)
+		assert.Equal(t, "Let me check.", msg.Content[0]["text"])
+		assert.Equal(t, "tool_use", msg.Content[1]["type"])
+		assert.Equal(t, "call_1", msg.Content[1]["id"])
+		assert.Equal(t, "get_weather", msg.Content[1]["name"])
+		assert.Equal(t, map[string]interface{}{"city": "Paris"}, msg.Content[1]["input"])
+		assert.Equal(t, float64(7), msg.Usage["output_tokens"])
+	}
+}
+
+func TestResponseInterceptor_StalledConsumer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := NewResponseInterceptor(true)
+	w.bind(ctx, cancel)
+	w.sendTimeout = 20 * time.Millisecond
+
+	// 缓冲区未满时不阻塞
+	for i := 0; i < interceptorStreamBuffer; i++ {
+		_, err := w.Write([]byte("data: {}\n\n"))
+		assert.NoError(t, err)
+	}
+
+	// 消费者不再读取: 超时后中止上游请求
+	_, err := w.Write([]byte("data: {}\n\n"))
+	assert.ErrorIs(t, err, errStreamConsumerStalled)
+	assert.Error(t, ctx.Err())
+
+	// 请求取消后写入立即失败
+	start := time.Now()
+	_, err = w.Write([]byte("data: {}\n\n"))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), w.sendTimeout)
+}
+
+func TestHandleClaudeMessage_ConcurrentStreams(t *testing.T) {
+	const streams, chunks = 50, 100
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < chunks; i++ {
+			fmt.Fprintf(w, "data: %s\n\n", `{"id":"chatcmpl-1","model":"gpt-4","choices":[{"delta":{"content":"x"}}]}`)
+		}
+		fmt.Fprintf(w, "data: %s\n\n", `{"id":"chatcmpl-1","model":"gpt-4","choices":[{"delta":{},"finish_reason":"stop"}]}`)
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:claude_inbound_concurrent_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	group := models.ModelGroup{GroupID: "claude-concurrent", Strategy: "round_robin"}
+	db.Create(&group)
+	m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID}
+	db.Create(&m)
+	db.Create(&models.APIKey{KeyValue: "sk-claude-concurrent", ModelConfigID: m.ID})
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.POST("/v1/messages", h.HandleClaudeMessage)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	body := `{"model":"claude-concurrent","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	var wg sync.WaitGroup
+	errs := make(chan error, streams)
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Post(server.URL+"/v1/messages", "application/json", strings.NewReader(body))
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer resp.Body.Close()
+			msg, err := accumulateClaudeStream(resp.Body)
+			if err == nil && msg.Content[0]["text"] != strings.Repeat("x", chunks) {
+				err = fmt.Errorf("incomplete stream: %v", msg.Content[0]["text"])
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Zero(t, h.InFlight())
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"context"
+	"llm-gateway/models"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+var wsUpgrader = websocket.Upgrader{
+	// 鉴权由 Token 完成，不限制来源
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// HandleChatWebSocket 通过 WebSocket 提供流式聊天
+// 客户端连接后发送一条 OpenAI 格式的聊天请求，服务端将每个流式 chunk 作为一条文本消息推送，
+// 最后推送 "[DONE]" 并关闭连接。客户端提前关闭连接时取消上游请求。
+func (h *ProxyHandler) HandleChatWebSocket(c *gin.Context) {
+	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		h.logger.Warnf("[WS] Upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// 与 HTTP 接口相同的请求体大小上限，超出时连接以 1009 (message too big) 关闭
+	if maxBytes := h.lb.GetGatewaySettings().MaxBodyBytes; maxBytes > 0 {
+		conn.SetReadLimit(maxBytes)
+	}
+
+	var req models.ChatCompletionRequest
+	if err := conn.ReadJSON(&req); err != nil {
+		conn.WriteJSON(models.ErrorResponse{
+			Error: models.ErrorDetail{Message: "Invalid chat request", Type: "invalid_request_error"},
+		})
+		return
+	}
+	roles := make([]string, len(req.Messages))
+	for i, msg := range req.Messages {
+		roles[i] = msg.Role
+	}
+	if detail := ValidateChatRequest(req.Model, roles); detail != nil {
+		conn.WriteJSON(models.ErrorResponse{Error: *detail})
+		return
+	}
+	req.Stream = true
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	// 持续读取以感知客户端关闭，关闭后取消上游请求
+	go func() {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	interceptor := NewResponseInterceptor(true)
+	interceptor.bind(ctx, cancel)
+	requestID(c) // 内部 ProxyRequest 共用同一请求 ID
+	fakeC, _ := gin.CreateTestContext(interceptor)
+	fakeC.Request = c.Request.WithContext(ctx)
+	inheritAdminAuth(fakeC, c) // 强制路由等请求头仅对管理员凭证生效
+
+	go func() {
+		defer close(interceptor.streamChan)
+		h.ProxyRequest(fakeC, req)
+	}()
+
+	// SSE -> WebSocket 消息
+	closed := false
+	send := func(payload string) {
+		if closed || payload == "" {
+			return
+		}
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(payload)); err != nil {
+			closed = true
+			cancel()
+		}
+	}
+
+	var buffer string
+	for chunk := range interceptor.streamChan {
+		// 错误响应 (非 SSE) 原样转发
+		if interceptor.statusCode != 200 {
+			send(string(chunk))
+			continue
+		}
+
+		buffer += string(chunk)
+		for {
+			idx := strings.Index(buffer, "\n\n")
+			if idx == -1 {
+				break
+			}
+			event := strings.TrimSpace(buffer[:idx])
+			buffer = buffer[idx+2:]
+			send(strings.TrimSpace(strings.TrimPrefix(event, "data:")))
+		}
+	}
+	send(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(buffer), "data:")))
+
+	// 为日志中间件回传路由信息与 Token 用量
+	for _, key := range []string{"routing_info", "usage", errorTypeKey} {
+		if val, exists := fakeC.Get(key); exists {
+			c.Set(key, val)
+		}
+	}
+
+	if !closed {
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}
+}
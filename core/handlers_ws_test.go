@@ -0,0 +1,128 @@
+package core
+
+import (
+	"fmt"
+	"llm-gateway/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestHandleChatWebSocket_StreamsChunks(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"1\",\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"id\":\"1\",\"choices\":[{\"delta\":{\"content\":\"lo\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:ws_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	group := models.ModelGroup{GroupID: "ws-group", Strategy: "round_robin"}
+	db.Create(&group)
+	m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID}
+	db.Create(&m)
+	db.Create(&models.APIKey{KeyValue: "sk-ws", ModelConfigID: m.ID})
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/v1/chat/ws", h.HandleChatWebSocket)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat/ws", nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	assert.NoError(t, conn.WriteJSON(models.ChatCompletionRequest{
+		Model:    "ws-group",
+		Messages: []models.ChatMessage{{Role: "user", Content: "hi"}},
+	}))
+
+	var messages []string
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		messages = append(messages, string(msg))
+	}
+
+	assert.Len(t, messages, 3)
+	assert.Contains(t, messages[0], "Hel")
+	assert.Contains(t, messages[1], "lo")
+	assert.Equal(t, "[DONE]", messages[2])
+}
+
+func TestHandleChatWebSocket_RejectsInvalidRequests(t *testing.T) {
+	var upstreamHits int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHits++
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:ws_invalid_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000, MaxBodyBytes: 256})
+
+	group := models.ModelGroup{GroupID: "ws-group", Strategy: "round_robin"}
+	db.Create(&group)
+	m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID}
+	db.Create(&m)
+	db.Create(&models.APIKey{KeyValue: "sk-ws", ModelConfigID: m.ID})
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/v1/chat/ws", h.HandleChatWebSocket)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	// 发送请求并返回服务端的第一条消息
+	send := func(req models.ChatCompletionRequest) (string, error) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat/ws", nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+		assert.NoError(t, conn.WriteJSON(req))
+		_, msg, err := conn.ReadMessage()
+		return string(msg), err
+	}
+
+	// 超过 max_body_bytes 的请求帧，连接以 1009 关闭
+	_, err = send(models.ChatCompletionRequest{Model: "ws-group", Messages: []models.ChatMessage{{Role: "user", Content: strings.Repeat("x", 512)}}})
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig), "%v", err)
+
+	// 与 HTTP 接口相同的模型与角色校验
+	msg, _ := send(models.ChatCompletionRequest{Model: "ws-group", Messages: []models.ChatMessage{{Role: "robot", Content: "hi"}}})
+	assert.Contains(t, msg, "messages[0].role")
+	msg, _ = send(models.ChatCompletionRequest{Messages: []models.ChatMessage{{Role: "user", Content: "hi"}}})
+	assert.Contains(t, msg, "'model'")
+
+	assert.Equal(t, 0, upstreamHits)
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// defaultHealthWindow 每个模型保留的最近请求结果数 (N)
+	defaultHealthWindow = 20
+	// healthMinSamples 样本数不足时不判定为不健康，避免偶发错误导致降级
+	healthMinSamples = 5
+	// healthSampleTTL 超过该时间的结果不再计入，被跳过的模型可以自然恢复
+	healthSampleTTL = 5 * time.Minute
+)
+
+// ModelHealth 按模型记录最近 N 次上游请求结果 (滑动窗口)，用于计算近期错误率 (线程安全)
+type ModelHealth struct {
+	mu      sync.Mutex
+	window  int
+	results map[uint][]healthSample // ModelConfigID -> 环形缓冲
+	next    map[uint]int
+}
+
+type healthSample struct {
+	at      time.Time
+	success bool
+}
+
+func NewModelHealth(window int) *ModelHealth {
+	if window <= 0 {
+		window = defaultHealthWindow
+	}
+	return &ModelHealth{
+		window:  window,
+		results: make(map[uint][]healthSample),
+		next:    make(map[uint]int),
+	}
+}
+
+// SetWindow 调整窗口大小，已有记录被清空
+func (h *ModelHealth) SetWindow(window int) {
+	if window <= 0 {
+		window = defaultHealthWindow
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if window == h.window {
+		return
+	}
+	h.window = window
+	h.results = make(map[uint][]healthSample)
+	h.next = make(map[uint]int)
+}
+
+// Record 记录一次上游请求结果
+func (h *ModelHealth) Record(modelID uint, success bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sample := healthSample{at: time.Now(), success: success}
+	buf := h.results[modelID]
+	if len(buf) < h.window {
+		h.results[modelID] = append(buf, sample)
+		return
+	}
+	idx := h.next[modelID]
+	buf[idx] = sample
+	h.next[modelID] = (idx + 1) % h.window
+}
+
+// ErrorRate 返回模型近期的错误率与有效样本数，没有样本时为 0
+func (h *ModelHealth) ErrorRate(modelID uint) (rate float64, samples int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cutoff := time.Now().Add(-healthSampleTTL)
+	errors := 0
+	for _, s := range h.results[modelID] {
+		if s.at.Before(cutoff) {
+			continue
+		}
+		samples++
+		if !s.success {
+			errors++
+		}
+	}
+	if samples == 0 {
+		return 0, 0
+	}
+	return float64(errors) / float64(samples), samples
+}
+
+// Unhealthy 判断错误率是否超过阈值 (threshold <= 0 表示不启用)
+func (h *ModelHealth) Unhealthy(modelID uint, threshold float64) bool {
+	if threshold <= 0 {
+		return false
+	}
+	rate, samples := h.ErrorRate(modelID)
+	h.mu.Lock()
+	minSamples := min(healthMinSamples, h.window)
+	h.mu.Unlock()
+	return samples >= minSamples && rate > threshold
+}
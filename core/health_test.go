@@ -0,0 +1,93 @@
+package core
+
+import (
+	"llm-gateway/models"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestModelHealth_Window(t *testing.T) {
+	h := NewModelHealth(10)
+
+	// 样本不足时不判定为不健康
+	for i := 0; i < 4; i++ {
+		h.Record(1, false)
+	}
+	assert.False(t, h.Unhealthy(1, 0.5))
+
+	h.Record(1, false)
+	rate, samples := h.ErrorRate(1)
+	assert.Equal(t, 1.0, rate)
+	assert.Equal(t, 5, samples)
+	assert.True(t, h.Unhealthy(1, 0.5))
+	assert.False(t, h.Unhealthy(1, 0)) // 阈值为 0 表示关闭
+
+	// 只保留最近 10 次结果
+	for i := 0; i < 10; i++ {
+		h.Record(1, true)
+	}
+	rate, samples = h.ErrorRate(1)
+	assert.Equal(t, 0.0, rate)
+	assert.Equal(t, 10, samples)
+}
+
+func TestRoute_SkipsUnhealthyModels(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:health_route?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	for _, g := range []models.ModelGroup{
+		{GroupID: "health-rr", Strategy: "round_robin", ErrorRateThreshold: 0.5},
+		{GroupID: "health-skip", Strategy: "fallback", ErrorRateThreshold: 0.5, UnhealthyAction: "skip"},
+	} {
+		db.Create(&g)
+		for _, name := range []string{"model-a", "model-b"} {
+			mc := models.ModelConfig{ProviderName: "openai", UpstreamModel: name, UpstreamURL: "https://api.openai.com", ModelGroupID: g.ID}
+			db.Create(&mc)
+			db.Create(&models.APIKey{KeyValue: "sk-" + g.GroupID + name, ModelConfigID: mc.ID})
+		}
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+
+	markFailing := func(m *models.ModelConfig) {
+		for i := 0; i < healthMinSamples; i++ {
+			lb.RecordModelResult(m.ID, false)
+		}
+	}
+
+	// 轮询时跳过错误率过高的模型
+	rr := lb.groupStates["health-rr"]
+	markFailing(rr.Models[0])
+	for i := 0; i < 4; i++ {
+		routing, err := lb.Route("health-rr")
+		assert.NoError(t, err)
+		assert.Equal(t, "model-b", routing.UpstreamModel)
+	}
+	// 固定序号不受影响
+	pinned, err := lb.Route("health-rr$1")
+	assert.NoError(t, err)
+	assert.Equal(t, "model-a", pinned.UpstreamModel)
+
+	// 全部超过阈值: deprioritize 仍可路由，skip 返回错误
+	markFailing(rr.Models[1])
+	_, err = lb.Route("health-rr")
+	assert.NoError(t, err)
+
+	skip := lb.groupStates["health-skip"]
+	markFailing(skip.Models[0])
+	routing, err := lb.Route("health-skip")
+	assert.NoError(t, err)
+	assert.Equal(t, "model-b", routing.UpstreamModel)
+	markFailing(skip.Models[1])
+	_, err = lb.Route("health-skip")
+	assert.ErrorIs(t, err, ErrNoHealthyModels)
+}
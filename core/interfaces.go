@@ -23,6 +23,31 @@ type KeyManager interface {
 	IsAvailable(key string) bool
 	MarkCooldown(key string, duration time.Duration)
 	MarkDead(key string)
+	MarkAvailable(key string)
+	GetState(key string) KeyState
+
+	// RecordRateLimit / GetRateLimit 记录与查询上游最近一次返回的限流信息
+	RecordRateLimit(key string, info *models.RateLimitInfo)
+	GetRateLimit(key string) *models.RateLimitInfo
+
+	// AcquireLeastLoaded / Release / InFlight 统计每个 Key 进行中的请求数，用于优先选择负载最低的 Key
+	AcquireLeastLoaded(keys []string, acquire bool) string
+	Release(key string)
+	InFlight(key string) int
+}
+
+// RequestFilter 请求内容过滤钩子，在路由前执行 (如拦截违禁词、脱敏 PII)
+type RequestFilter interface {
+	// FilterRequest 检查请求，可改写消息 (modified=true)，实现方不得修改调用方持有的消息切片；
+	// 返回 *ContentBlockedError 时拒绝该请求
+	FilterRequest(req *models.ChatCompletionRequest) (modified bool, err error)
+}
+
+// ResponseFilter 响应内容过滤钩子 (仅非流式响应)，可用于接入外部审核服务
+type ResponseFilter interface {
+	// FilterResponse 检查响应，可就地改写内容 (modified=true)；
+	// 返回 *ContentBlockedError 时拒绝该响应
+	FilterResponse(resp *models.ChatCompletionResponse) (modified bool, err error)
 }
 
 // SecretProvider 抽象密钥加解密 (Task 4)
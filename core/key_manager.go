@@ -1,6 +1,7 @@
 package core
 
 import (
+	"llm-gateway/models"
 	"sync"
 	"time"
 )
@@ -14,6 +15,18 @@ const (
 	KeyStatusDead
 )
 
+// String 返回状态名称，用于 API 输出
+func (s KeyStatusType) String() string {
+	switch s {
+	case KeyStatusCooldown:
+		return "cooldown"
+	case KeyStatusDead:
+		return "dead"
+	default:
+		return "available"
+	}
+}
+
 // KeyState Key的状态信息
 type KeyState struct {
 	Status    KeyStatusType
@@ -22,8 +35,10 @@ type KeyState struct {
 
 // KeyStateManager Key状态管理器 (线程安全)
 type KeyStateManager struct {
-	states map[string]KeyState // Key -> State
-	mutex  sync.RWMutex
+	states     map[string]KeyState              // Key -> State
+	rateLimits map[string]*models.RateLimitInfo // Key -> 最近一次上游限流信息
+	inFlight   map[string]int                   // Key -> 进行中的请求数 (仅 least_loaded_keys 组统计)
+	mutex      sync.RWMutex
 }
 
 // GlobalKeyManager 全局Key管理器单例
@@ -31,7 +46,9 @@ var GlobalKeyManager = NewKeyStateManager()
 
 func NewKeyStateManager() *KeyStateManager {
 	return &KeyStateManager{
-		states: make(map[string]KeyState),
+		states:     make(map[string]KeyState),
+		rateLimits: make(map[string]*models.RateLimitInfo),
+		inFlight:   make(map[string]int),
 	}
 }
 
@@ -88,3 +105,71 @@ func (m *KeyStateManager) IsAvailable(key string) bool {
 
 	return true
 }
+
+// GetState 获取Key的当前状态 (冷却已结束的视为可用)
+func (m *KeyStateManager) GetState(key string) KeyState {
+	m.mutex.RLock()
+	state, exists := m.states[key]
+	m.mutex.RUnlock()
+
+	if !exists {
+		return KeyState{Status: KeyStatusAvailable}
+	}
+	if state.Status == KeyStatusCooldown && time.Now().After(state.UnlockTime) {
+		return KeyState{Status: KeyStatusAvailable}
+	}
+	return state
+}
+
+// RecordRateLimit 记录上游最近一次返回的限流信息
+func (m *KeyStateManager) RecordRateLimit(key string, info *models.RateLimitInfo) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.rateLimits[key] = info
+}
+
+// GetRateLimit 获取 Key 最近一次的限流信息，从未收到时返回 nil
+func (m *KeyStateManager) GetRateLimit(key string) *models.RateLimitInfo {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.rateLimits[key]
+}
+
+// AcquireLeastLoaded 从候选 Key 中选出进行中请求数最少的一个并将其计数加一
+// 计数相同时取靠前的 Key (调用方按轮询/权重顺序传入)；acquire 为 false 时只选择不计数
+func (m *KeyStateManager) AcquireLeastLoaded(keys []string, acquire bool) string {
+	if len(keys) == 0 {
+		return ""
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	best := keys[0]
+	for _, k := range keys[1:] {
+		if m.inFlight[k] < m.inFlight[best] {
+			best = k
+		}
+	}
+	if acquire {
+		m.inFlight[best]++
+	}
+	return best
+}
+
+// Release 请求结束 (无论成功与否) 时将 Key 的进行中请求数减一
+func (m *KeyStateManager) Release(key string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.inFlight[key] <= 1 {
+		delete(m.inFlight, key)
+		return
+	}
+	m.inFlight[key]--
+}
+
+// InFlight 返回 Key 当前进行中的请求数
+func (m *KeyStateManager) InFlight(key string) int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.inFlight[key]
+}
@@ -0,0 +1,26 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyStateManager_GetState(t *testing.T) {
+	km := NewKeyStateManager()
+
+	assert.Equal(t, KeyStatusAvailable, km.GetState("sk-A").Status)
+
+	km.MarkCooldown("sk-A", time.Minute)
+	state := km.GetState("sk-A")
+	assert.Equal(t, "cooldown", state.Status.String())
+	assert.True(t, state.UnlockTime.After(time.Now()))
+
+	// 冷却已过期视为可用
+	km.MarkCooldown("sk-B", -time.Second)
+	assert.Equal(t, KeyStatusAvailable, km.GetState("sk-B").Status)
+
+	km.MarkDead("sk-C")
+	assert.Equal(t, "dead", km.GetState("sk-C").Status.String())
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"fmt"
+	"llm-gateway/core/security"
+	"llm-gateway/models"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// EncryptPlaintextKeys 将无法解密的 (即明文存储的) API Key 原地加密
+// 用于清理早期版本遗留的明文/密文混合数据，整个过程在一个事务中完成。
+// 使用 NoOpSecretProvider 时解密永远成功，因此不会产生任何修改。
+func EncryptPlaintextKeys(db *gorm.DB, sp SecretProvider, logger *logrus.Logger) (int, error) {
+	migrated := 0
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var keys []models.APIKey
+		if err := tx.Unscoped().Find(&keys).Error; err != nil {
+			return fmt.Errorf("failed to query API keys: %w", err)
+		}
+
+		for i := range keys {
+			if _, err := sp.Decrypt(keys[i].KeyValue); err == nil {
+				continue
+			}
+			// 带版本前缀说明是密文 (可能缺少旧版本密钥)，不能当作明文再次加密
+			if security.HasVersionPrefix(keys[i].KeyValue) {
+				logger.Warnf("API key %d is encrypted with an unknown key version, skipping", keys[i].ID)
+				continue
+			}
+
+			encrypted, err := sp.Encrypt(keys[i].KeyValue)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt API key %d: %w", keys[i].ID, err)
+			}
+			if err := tx.Unscoped().Model(&keys[i]).Update("key_value", encrypted).Error; err != nil {
+				return fmt.Errorf("failed to update API key %d: %w", keys[i].ID, err)
+			}
+			migrated++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if migrated > 0 {
+		logger.Infof("🔐 Encrypted %d plaintext API keys", migrated)
+	}
+	return migrated, nil
+}
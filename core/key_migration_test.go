@@ -0,0 +1,69 @@
+package core
+
+import (
+	"llm-gateway/core/security"
+	"llm-gateway/models"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestEncryptPlaintextKeys(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:key_migration_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+
+	sp, err := security.NewAESSecretProvider("0123456789abcdef0123456789abcdef")
+	assert.NoError(t, err)
+	encrypted, err := sp.Encrypt("sk-already-encrypted")
+	assert.NoError(t, err)
+
+	plain := models.APIKey{KeyValue: "sk-plain", ModelConfigID: 1}
+	deleted := models.APIKey{KeyValue: "sk-deleted", ModelConfigID: 1}
+	current := models.APIKey{KeyValue: encrypted, ModelConfigID: 1}
+	unknown := models.APIKey{KeyValue: "v9:c29tZS1vdGhlci1rZXk=", ModelConfigID: 1}
+	for _, key := range []*models.APIKey{&plain, &deleted, &current, &unknown} {
+		assert.NoError(t, db.Create(key).Error)
+	}
+	db.Delete(&deleted)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	valueOf := func(id uint) string {
+		var key models.APIKey
+		assert.NoError(t, db.Unscoped().First(&key, id).Error)
+		return key.KeyValue
+	}
+
+	// 明文 (包括已软删除的) 被加密，可解密回原值
+	migrated, err := EncryptPlaintextKeys(db, sp, logger)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, migrated)
+	for id, want := range map[uint]string{plain.ID: "sk-plain", deleted.ID: "sk-deleted"} {
+		value := valueOf(id)
+		assert.True(t, strings.HasPrefix(value, "v1:"))
+		decrypted, err := sp.Decrypt(value)
+		assert.NoError(t, err)
+		assert.Equal(t, want, decrypted)
+	}
+
+	// 已加密的密文与未知版本的 v{N}: 密文保持不变
+	assert.Equal(t, encrypted, valueOf(current.ID))
+	assert.Equal(t, "v9:c29tZS1vdGhlci1rZXk=", valueOf(unknown.ID))
+
+	// 再次执行不会重复加密
+	plainValue := valueOf(plain.ID)
+	migrated, err = EncryptPlaintextKeys(db, sp, logger)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, migrated)
+	assert.Equal(t, plainValue, valueOf(plain.ID))
+
+	// NoOpSecretProvider 不修改任何数据
+	migrated, err = EncryptPlaintextKeys(db, NewNoOpSecretProvider(), logger)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, migrated)
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"llm-gateway/models"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -22,10 +24,16 @@ type GroupState struct {
 	Config   *models.ModelGroup
 	Models   []*models.ModelConfig // 预处理后的列表
 	Keys     map[uint][]string     // ModelID -> Decrypted Keys
+	KeyWeights map[uint][]int      // ModelID -> 与 Keys 一一对应的权重 (>= 1)
+	Overrides map[uint]*models.RequestOverrides // ModelID -> 解析后的请求改写规则
+	Search    map[uint]*models.GoogleSearchConfig // ModelID -> 解析后的联网搜索配置
+	Capabilities map[uint]models.Capabilities // ModelID -> 提供商默认能力叠加模型声明后的能力
 	
 	// Atomic counter specific to this group
 	// 替代了原本低效的全局锁 globalRRMutex
 	RequestCounter atomic.Uint64 
+	// Key 轮询使用独立计数器，避免与模型选择共用计数导致偶数个模型时轮询失效
+	KeyCounter atomic.Uint64
 }
 
 // LoadBalancer (原 StatelessModelRouter)
@@ -43,7 +51,13 @@ type LoadBalancer struct {
 	// 内部状态
 	mu              sync.RWMutex
 	groupStates     map[string]*GroupState // GroupID -> State
+	aliases         map[string]string      // Alias -> 路由目标
 	gatewaySettings *models.GatewaySettings
+	requestFilter   *RegexFilter // 由 GatewaySettings.RequestFilter 编译，未配置时为 nil
+	responseFilter  *RegexFilter // 由 GatewaySettings.ResponseFilter 编译，未配置时为 nil
+	retryableErrors []string     // 由 GatewaySettings.RetryableErrors 解析 (已转为小写)
+	health          *ModelHealth // 各模型近期错误率 (滑动窗口)
+	groupLimiter    *GroupRateLimiter // 组级每分钟请求数/Token 数限流
 }
 
 // NewLoadBalancer 构造函数强制要求依赖注入
@@ -60,11 +74,14 @@ func NewLoadBalancer(
 		secretProvider: sp,
 		strategies:     make(map[string]Strategy),
 		groupStates:    make(map[string]*GroupState),
+		health:         NewModelHealth(defaultHealthWindow),
+		groupLimiter:   NewGroupRateLimiter(),
 	}
 	
 	// 注册默认策略
 	lb.RegisterStrategy(&RoundRobinStrategy{})
 	lb.RegisterStrategy(&FallbackStrategy{})
+	lb.RegisterStrategy(&RaceStrategy{})
 	
 	// 加载数据
 	if err := lb.RefreshData(); err != nil {
@@ -78,6 +95,22 @@ func (lb *LoadBalancer) RegisterStrategy(s Strategy) {
 	lb.strategies[s.Name()] = s
 }
 
+// RegisteredStrategies 返回已注册的策略名称 (按字母排序)
+func (lb *LoadBalancer) RegisteredStrategies() []string {
+	names := make([]string, 0, len(lb.strategies))
+	for name := range lb.strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasStrategy 判断策略名称是否已注册
+func (lb *LoadBalancer) HasStrategy(name string) bool {
+	_, ok := lb.strategies[name]
+	return ok
+}
+
 // RefreshData 重新加载数据
 func (lb *LoadBalancer) RefreshData() error {
 	lb.mu.Lock()
@@ -88,6 +121,13 @@ func (lb *LoadBalancer) RefreshData() error {
 		return fmt.Errorf("failed to load gateway settings: %w", err)
 	}
 	lb.gatewaySettings = &settings
+	lb.health.SetWindow(settings.HealthWindow)
+	models.SetKeyMasking(settings.KeyMaskPrefix, settings.KeyMaskSuffix)
+
+	// 规则无效时记录错误并禁用过滤，不影响其他配置的加载
+	lb.requestFilter = lb.loadContentFilter("request", settings.RequestFilter)
+	lb.responseFilter = lb.loadContentFilter("response", settings.ResponseFilter)
+	lb.retryableErrors = lb.loadRetryableErrors(settings.RetryableErrors)
 
 	var groups []models.ModelGroup
 	// Preload necessary data
@@ -95,6 +135,15 @@ func (lb *LoadBalancer) RefreshData() error {
 		return fmt.Errorf("failed to load model groups: %w", err)
 	}
 
+	var aliases []models.ModelAlias
+	if err := lb.db.Find(&aliases).Error; err != nil {
+		return fmt.Errorf("failed to load model aliases: %w", err)
+	}
+	lb.aliases = make(map[string]string, len(aliases))
+	for _, a := range aliases {
+		lb.aliases[a.Alias] = a.Target
+	}
+
 	newGroupStates := make(map[string]*GroupState)
 
 	for _, g := range groups {
@@ -105,13 +154,34 @@ func (lb *LoadBalancer) RefreshData() error {
 			Config: &groupCopy,
 			Models: make([]*models.ModelConfig, 0),
 			Keys:   make(map[uint][]string),
+			KeyWeights: make(map[uint][]int),
+			Overrides: make(map[uint]*models.RequestOverrides),
+			Search:    make(map[uint]*models.GoogleSearchConfig),
+			Capabilities: make(map[uint]models.Capabilities),
 		}
 
 		for i := range g.Models {
 			mc := &g.Models[i]
 			state.Models = append(state.Models, mc)
+
+			if overrides, err := models.ParseRequestOverrides(mc.RequestOverrides); err != nil {
+				lb.logger.Errorf("Invalid request overrides for model %s: %v", mc.UpstreamModel, err)
+			} else if overrides != nil {
+				state.Overrides[mc.ID] = overrides
+			}
+			if search, err := models.ParseGoogleSearchConfig(mc.GoogleSearch); err != nil {
+				lb.logger.Errorf("Invalid google search config for model %s: %v", mc.UpstreamModel, err)
+			} else if search != nil {
+				state.Search[mc.ID] = search
+			}
+			caps, err := models.ParseCapabilityOverrides(mc.Capabilities)
+			if err != nil {
+				lb.logger.Errorf("Invalid capabilities for model %s: %v", mc.UpstreamModel, err)
+			}
+			state.Capabilities[mc.ID] = caps.Apply(ProviderCapabilities(mc.ProviderName))
 			
 			decryptedKeys := make([]string, 0)
+			weights := make([]int, 0)
 			for _, k := range mc.APIKeys {
 				// Decrypt key
 				val, err := lb.secretProvider.Decrypt(k.KeyValue)
@@ -120,9 +190,25 @@ func (lb *LoadBalancer) RefreshData() error {
 					continue
 				}
 				decryptedKeys = append(decryptedKeys, val)
+				weights = append(weights, max(k.Weight, 1))
 			}
 			state.Keys[mc.ID] = decryptedKeys
+			state.KeyWeights[mc.ID] = weights
 		}
+
+		// 保留已有组的轮询计数器，避免重载后轮询从头开始破坏公平性
+		if old, ok := lb.groupStates[g.GroupID]; ok {
+			state.RequestCounter.Store(old.RequestCounter.Load())
+			state.KeyCounter.Store(old.KeyCounter.Load())
+		}
+		// 按优先级排序，优先级相同时保持 ID 顺序
+		sort.SliceStable(state.Models, func(i, j int) bool {
+			a, b := state.Models[i], state.Models[j]
+			if a.Priority != b.Priority {
+				return a.Priority < b.Priority
+			}
+			return a.ID < b.ID
+		})
 		newGroupStates[g.GroupID] = state
 	}
 
@@ -131,13 +217,31 @@ func (lb *LoadBalancer) RefreshData() error {
 	return nil
 }
 
-// Route 执行路由逻辑
-func (lb *LoadBalancer) Route(requestModel string) (*models.RoutingInfo, error) {
-	// [Feature] Model Pinning: "group$index"
-	// Example: "Ai-code$2" -> Use 2nd model in "Ai-code" group
-	var groupID string
-	var pinIndex int = -1
+// weightedIndex 将计数映射到按权重展开的槽位上，返回对应的 Key 下标
+// 例如权重 [3, 1] 时每 4 次请求中前 3 次选择第一个 Key
+func weightedIndex(weights []int, count uint64) int {
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	if total == 0 {
+		return int(count % uint64(max(len(weights), 1)))
+	}
+	slot := int(count % uint64(total))
+	for i, w := range weights {
+		if slot < w {
+			return i
+		}
+		slot -= w
+	}
+	return 0
+}
 
+// parseRequestModel 解析请求中的模型名
+// [Feature] Model Pinning: "group$index"
+// Example: "Ai-code$2" -> Use 2nd model in "Ai-code" group
+func parseRequestModel(requestModel string) (groupID string, pinIndex int) {
+	pinIndex = -1
 	if idx := strings.Index(requestModel, "$"); idx != -1 {
 		groupID = requestModel[:idx]
 		if i, err := strconv.Atoi(requestModel[idx+1:]); err == nil && i > 0 {
@@ -146,13 +250,217 @@ func (lb *LoadBalancer) Route(requestModel string) (*models.RoutingInfo, error)
 	} else {
 		groupID = requestModel
 	}
+	return groupID, pinIndex
+}
+
+// resolveModelLocked 解析请求模型并定位组 (调用方需持有读锁)
+// 优先按别名替换为路由目标；组不存在且配置了 DefaultGroup 时改用默认组 (忽略模型序号)，fallback 为 true
+func (lb *LoadBalancer) resolveModelLocked(requestModel string) (state *GroupState, pinIndex int, fallback bool) {
+	if target, ok := lb.aliases[requestModel]; ok {
+		requestModel = target
+	}
+	groupID, pinIndex := parseRequestModel(requestModel)
+	if state, ok := lb.groupStates[groupID]; ok {
+		return state, pinIndex, false
+	}
+	if def := lb.gatewaySettings.DefaultGroup; def != "" {
+		if state, ok := lb.groupStates[def]; ok {
+			return state, -1, true
+		}
+	}
+	return nil, pinIndex, false
+}
+
+// ForcedModel 校验强制路由的组与模型序号 (从 1 开始，0 表示按组策略选择)，返回可直接传给 Route 的模型名
+// groupID 为空时使用请求模型所在的组
+func (lb *LoadBalancer) ForcedModel(requestModel, groupID string, index int) (string, error) {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	if groupID == "" {
+		state, _, fallback := lb.resolveModelLocked(requestModel)
+		if state == nil || fallback {
+			return "", fmt.Errorf("group of model %q not found", requestModel)
+		}
+		groupID = state.Config.GroupID
+	}
+	state, ok := lb.groupStates[groupID]
+	if !ok || len(state.Models) == 0 {
+		return "", fmt.Errorf("group %q not found or has no models", groupID)
+	}
+	if index < 0 || index > len(state.Models) {
+		return "", fmt.Errorf("model index %d out of range for group %s (1-%d)", index, groupID, len(state.Models))
+	}
+	if index == 0 {
+		return groupID, nil
+	}
+	return fmt.Sprintf("%s$%d", groupID, index), nil
+}
 
+// RaceCount 返回竞速策略的并发数 K
+// 非 race 策略、指定了模型序号 (Pinning) 或组不存在时返回 0
+func (lb *LoadBalancer) RaceCount(requestModel string) int {
 	lb.mu.RLock()
-	state, exists := lb.groupStates[groupID]
+	state, pinIndex, _ := lb.resolveModelLocked(requestModel)
 	lb.mu.RUnlock()
 
-	if !exists || len(state.Models) == 0 {
-		return nil, ErrGroupNotFound
+	if state == nil || pinIndex != -1 || state.Config.Strategy != "race" {
+		return 0
+	}
+	if state.Config.RaceCount < 1 {
+		return 1
+	}
+	return state.Config.RaceCount
+}
+
+// ContextLimits 返回请求模型适用的消息条数与内容字符数上限 (组设置优先，其次为网关全局设置)，0 表示不限制
+func (lb *LoadBalancer) ContextLimits(requestModel string) (maxMessages, maxChars int) {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	if settings := lb.gatewaySettings; settings != nil {
+		maxMessages, maxChars = settings.MaxMessages, settings.MaxContextChars
+	}
+	state, _, _ := lb.resolveModelLocked(requestModel)
+	if state == nil {
+		return
+	}
+	if state.Config.MaxMessages > 0 {
+		maxMessages = state.Config.MaxMessages
+	}
+	if state.Config.MaxContextChars > 0 {
+		maxChars = state.Config.MaxContextChars
+	}
+	return
+}
+
+// AliasGroup 返回别名路由到的组 ID，不是别名时 ok 为 false
+func (lb *LoadBalancer) AliasGroup(alias string) (groupID string, ok bool) {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	target, ok := lb.aliases[alias]
+	if !ok {
+		return "", false
+	}
+	groupID, _ = parseRequestModel(target)
+	return groupID, true
+}
+
+// GroupOf 返回请求模型 (组 ID、别名或上游模型名) 解析出的组 ID，无法解析时返回空字符串
+func (lb *LoadBalancer) GroupOf(requestModel string) string {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	state, _, _ := lb.resolveModelLocked(requestModel)
+	if state == nil {
+		return ""
+	}
+	return state.Config.GroupID
+}
+
+// GroupRateLimits 返回请求模型所在组的每分钟请求数与 Token 数上限 (0 表示不限制)，组不存在时 groupID 为空
+func (lb *LoadBalancer) GroupRateLimits(requestModel string) (groupID string, rpm, tpm int) {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	state, _, _ := lb.resolveModelLocked(requestModel)
+	if state == nil {
+		return "", 0, 0
+	}
+	return state.Config.GroupID, state.Config.RequestsPerMinute, state.Config.TokensPerMinute
+}
+
+// ExhaustedPolicy 返回请求模型所在组的 on_exhausted 策略与固定回复内容，组不存在时 policy 为空
+func (lb *LoadBalancer) ExhaustedPolicy(requestModel string) (groupID, policy, message string) {
+	lb.mu.RLock()
+	state, _, _ := lb.resolveModelLocked(requestModel)
+	lb.mu.RUnlock()
+
+	if state == nil {
+		return "", "", ""
+	}
+	return state.Config.GroupID, state.Config.OnExhausted, state.Config.ExhaustedMessage
+}
+
+// CalculateMaxRetries 根据 Key 总数计算最大重试次数
+// 结果为 totalKeys * multiplier (向上取整)，并限制在 [minRetries, maxRetries] 区间内
+func CalculateMaxRetries(totalKeys int, multiplier float64, minRetries, maxRetries int) int {
+	if minRetries < 1 {
+		minRetries = 1
+	}
+	if maxRetries < minRetries {
+		maxRetries = minRetries
+	}
+
+	retries := int(math.Ceil(float64(totalKeys) * multiplier))
+	if retries < minRetries {
+		return minRetries
+	}
+	if retries > maxRetries {
+		return maxRetries
+	}
+	return retries
+}
+
+// MaxRetries 返回请求模型对应组的最大重试次数
+// 组内配置了 MaxRetries 时直接使用，否则按全局策略与组内 Key 总数计算
+func (lb *LoadBalancer) MaxRetries(requestModel string) int {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	state, _, _ := lb.resolveModelLocked(requestModel)
+	return lb.maxRetriesLocked(state)
+}
+
+func (lb *LoadBalancer) maxRetriesLocked(state *GroupState) int {
+	settings := lb.gatewaySettings
+	if state == nil {
+		return CalculateMaxRetries(0, settings.RetryMultiplier, settings.MinRetries, settings.MaxRetries)
+	}
+	if state.Config.MaxRetries > 0 {
+		return state.Config.MaxRetries
+	}
+
+	totalKeys := 0
+	for _, keys := range state.Keys {
+		totalKeys += len(keys)
+	}
+	return CalculateMaxRetries(totalKeys, settings.RetryMultiplier, settings.MinRetries, settings.MaxRetries)
+}
+
+// Route 执行路由逻辑
+func (lb *LoadBalancer) Route(requestModel string) (*models.RoutingInfo, error) {
+	return lb.RouteFor(requestModel, models.Capabilities{})
+}
+
+// RouteFor 只在支持 required 中全部特性的模型之间路由，没有这样的模型时返回 *CapabilityError
+func (lb *LoadBalancer) RouteFor(requestModel string, required models.Capabilities) (*models.RoutingInfo, error) {
+	routing, _, err := lb.route(requestModel, false, required)
+	return routing, err
+}
+
+// PreviewRoute 路由预览 (Dry Run)
+// 返回下一次请求将会选中的模型 (及其在组内从 0 开始的序号) 与 Key，
+// 但不推进轮询计数器，也不修改 Key 状态
+func (lb *LoadBalancer) PreviewRoute(requestModel string) (*models.RoutingInfo, int, error) {
+	return lb.route(requestModel, true, models.Capabilities{})
+}
+
+func (lb *LoadBalancer) route(requestModel string, dryRun bool, required models.Capabilities) (*models.RoutingInfo, int, error) {
+	// dryRun 时只读取计数器的下一个值
+	nextCount := func(counter *atomic.Uint64) uint64 {
+		if dryRun {
+			return counter.Load() + 1
+		}
+		return counter.Add(1)
+	}
+
+	lb.mu.RLock()
+	state, pinIndex, fallback := lb.resolveModelLocked(requestModel)
+	lb.mu.RUnlock()
+
+	if state == nil || len(state.Models) == 0 {
+		return nil, -1, ErrGroupNotFound
+	}
+	groupID := state.Config.GroupID
+	if fallback && !dryRun {
+		lb.logger.Infof("Unknown model %q, routing to default group %s", requestModel, groupID)
 	}
 
 	var selectedModel *models.ModelConfig
@@ -162,9 +470,12 @@ func (lb *LoadBalancer) Route(requestModel string) (*models.RoutingInfo, error)
 	if pinIndex != -1 {
 		// Bypass strategy, force select
 		if pinIndex >= len(state.Models) {
-			return nil, fmt.Errorf("model index %d out of bounds for group %s", pinIndex+1, groupID)
+			return nil, -1, fmt.Errorf("model index %d out of bounds for group %s", pinIndex+1, groupID)
 		}
 		selectedModel = state.Models[pinIndex]
+		if missing := state.Capabilities[selectedModel.ID].Missing(required); len(missing) > 0 {
+			return nil, -1, &CapabilityError{GroupID: groupID, Missing: missing}
+		}
 	} else {
 		// Use Strategy
 		strategyName := state.Config.Strategy
@@ -175,17 +486,24 @@ func (lb *LoadBalancer) Route(requestModel string) (*models.RoutingInfo, error)
 		if !ok {
 			strategy = lb.strategies["round_robin"]
 		}
-		currentCount := state.RequestCounter.Add(1)
-		selectedModel, err = strategy.Select(state.Models, currentCount)
+		currentCount := nextCount(&state.RequestCounter)
+		var candidates []*models.ModelConfig
+		if candidates, err = lb.healthyModels(state); err != nil {
+			return nil, -1, err
+		}
+		if candidates, err = capableModels(state, candidates, required); err != nil {
+			return nil, -1, err
+		}
+		selectedModel, err = strategy.Select(candidates, currentCount)
 		if err != nil {
-			return nil, err
+			return nil, -1, err
 		}
 	}
 
 	// 4. 选择 Key (保留原有逻辑，但从预解密的 state.Keys 中读取)
 	keys := state.Keys[selectedModel.ID]
 	if len(keys) == 0 {
-		return nil, fmt.Errorf("no API keys for model %s", selectedModel.UpstreamModel)
+		return nil, -1, fmt.Errorf("no API keys for model %s", selectedModel.UpstreamModel)
 	}
 
 	// 寻找第一个可用的 Key
@@ -197,24 +515,49 @@ func (lb *LoadBalancer) Route(requestModel string) (*models.RoutingInfo, error)
 	
 	// 注意：之前这里逻辑复杂化了，导致了 currentCount=0 时 -1 的 panic
 	// 统一逻辑：每次 Route 都消耗一个计数（即使是 Pinning），用来转动 Key
-	count := state.RequestCounter.Add(1)
+	count := nextCount(&state.KeyCounter)
+	// 按权重确定起始 Key (权重均为 1 时等价于 count % len)，不可用时依次尝试后续 Key
+	start := weightedIndex(state.KeyWeights[selectedModel.ID], count)
+	// least_loaded_keys: 收集全部可用 Key (保持轮询顺序以便计数相同时轮转)，再选择进行中请求最少的
+	leastLoaded := state.Config.LeastLoadedKeys
+	var availableKeys []string
 
 	for i := 0; i < len(keys); i++ {
-		// (count + i) 可能会很大，但 % len 会将其限制在 [0, len-1]
-		// 我们不需要减 1，因为 count 是任意的起始点，只要它是递增的就行
-		idx := (int(count) + i) % len(keys)
+		// (start + i) 可能会很大，但 % len 会将其限制在 [0, len-1]
+		idx := (start + i) % len(keys)
 		// 防止负数索引 (尽管 uint64 转 int 在极值时可能变负，但概率极低，防御一下)
 		if idx < 0 { idx = -idx }
 		
 		k := keys[idx]
-		if lb.keyManager.IsAvailable(k) {
+		var available bool
+		if dryRun {
+			available = lb.keyManager.GetState(k).Status == KeyStatusAvailable
+		} else {
+			available = lb.keyManager.IsAvailable(k)
+		}
+		if available {
+			if leastLoaded {
+				availableKeys = append(availableKeys, k)
+				continue
+			}
 			finalKey = k
 			break
 		}
 	}
+	if leastLoaded {
+		finalKey = lb.keyManager.AcquireLeastLoaded(availableKeys, !dryRun)
+	}
 
 	if finalKey == "" {
-		return nil, fmt.Errorf("all keys for model %s are in cooldown or dead", selectedModel.UpstreamModel)
+		return nil, -1, fmt.Errorf("all keys for model %s are in cooldown or dead", selectedModel.UpstreamModel)
+	}
+
+	modelIndex := -1
+	for i, m := range state.Models {
+		if m == selectedModel {
+			modelIndex = i
+			break
+		}
 	}
 
 	return &models.RoutingInfo{
@@ -223,10 +566,224 @@ func (lb *LoadBalancer) Route(requestModel string) (*models.RoutingInfo, error)
 		Provider:      selectedModel.ProviderName,
 		UpstreamURL:   selectedModel.UpstreamURL,
 		UpstreamModel: selectedModel.UpstreamModel,
+		UpstreamPath:  selectedModel.UpstreamPath,
 		ModelConfigID: selectedModel.ID,
 		APIKey:        finalKey,
 		Timeout:       selectedModel.Timeout,
-	}, nil
+
+		IdentityPatch:     selectedModel.IdentityPatch,
+		IdentityPatchText: selectedModel.IdentityPatchText,
+		HideThoughts:      selectedModel.HideThoughts,
+		GoogleSearch:      state.Search[selectedModel.ID],
+
+		RequestOverrides: state.Overrides[selectedModel.ID],
+
+		MaxOutputTokens:  selectedModel.MaxOutputTokens,
+		DefaultMaxTokens: selectedModel.DefaultMaxTokens,
+
+		KeyAcquired: leastLoaded && !dryRun,
+	}, modelIndex, nil
+}
+
+// ReleaseKey 请求结束后释放 Route 占用的 Key 进行中计数 (仅 least_loaded_keys 组)，重复调用无副作用
+func (lb *LoadBalancer) ReleaseKey(routing *models.RoutingInfo) {
+	if routing == nil || !routing.KeyAcquired {
+		return
+	}
+	routing.KeyAcquired = false
+	lb.keyManager.Release(routing.APIKey)
+}
+
+// ErrNoHealthyModels 组内所有模型的近期错误率都超过阈值 (unhealthy_action 为 skip 时)
+var ErrNoHealthyModels = errors.New("all models in group exceed the error rate threshold")
+
+// healthyModels 返回参与策略选择的模型：组配置了 error_rate_threshold 时排除近期错误率超过阈值的模型
+// 全部超过阈值时，deprioritize (默认) 仍使用全部模型，skip 返回 ErrNoHealthyModels
+func (lb *LoadBalancer) healthyModels(state *GroupState) ([]*models.ModelConfig, error) {
+	threshold := state.Config.ErrorRateThreshold
+	if threshold <= 0 {
+		return state.Models, nil
+	}
+	healthy := make([]*models.ModelConfig, 0, len(state.Models))
+	for _, m := range state.Models {
+		if !lb.health.Unhealthy(m.ID, threshold) {
+			healthy = append(healthy, m)
+		}
+	}
+	if len(healthy) > 0 {
+		return healthy, nil
+	}
+	if state.Config.UnhealthyAction == "skip" {
+		return nil, ErrNoHealthyModels
+	}
+	return state.Models, nil
+}
+
+// CapabilityError 请求用到的特性没有可用的模型支持
+type CapabilityError struct {
+	GroupID string
+	Missing []string
+}
+
+func (e *CapabilityError) Error() string {
+	return fmt.Sprintf("no model in group %s supports %s", e.GroupID, strings.Join(e.Missing, ", "))
+}
+
+// capableModels 从候选模型中排除不支持 required 的模型
+func capableModels(state *GroupState, candidates []*models.ModelConfig, required models.Capabilities) ([]*models.ModelConfig, error) {
+	if required == (models.Capabilities{}) {
+		return candidates, nil
+	}
+	capable := make([]*models.ModelConfig, 0, len(candidates))
+	var union models.Capabilities
+	for _, m := range candidates {
+		caps := state.Capabilities[m.ID]
+		union = union.Union(caps)
+		if len(caps.Missing(required)) == 0 {
+			capable = append(capable, m)
+		}
+	}
+	if len(capable) == 0 {
+		missing := union.Missing(required)
+		if len(missing) == 0 {
+			// 每项特性都有模型支持，但没有单个模型同时支持全部
+			missing = models.Capabilities{}.Missing(required)
+		}
+		return nil, &CapabilityError{GroupID: state.Config.GroupID, Missing: missing}
+	}
+	return capable, nil
+}
+
+// CheckCapabilities 检查请求模型所在组 (固定序号时为该模型) 是否有模型支持 required 中的全部特性，
+// 不考虑模型健康状态；组不存在时返回 nil，由路由阶段报告
+func (lb *LoadBalancer) CheckCapabilities(requestModel string, required models.Capabilities) error {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	state, pinIndex, _ := lb.resolveModelLocked(requestModel)
+	if state == nil || len(state.Models) == 0 {
+		return nil
+	}
+	candidates := state.Models
+	if pinIndex >= 0 && pinIndex < len(state.Models) {
+		candidates = state.Models[pinIndex : pinIndex+1]
+	}
+	_, err := capableModels(state, candidates, required)
+	return err
+}
+
+// ModelCapabilities 返回模型的能力 (提供商默认能力叠加模型声明)
+func ModelCapabilities(mc *models.ModelConfig) models.Capabilities {
+	overrides, _ := models.ParseCapabilityOverrides(mc.Capabilities)
+	return overrides.Apply(ProviderCapabilities(mc.ProviderName))
+}
+
+// RecordModelResult 记录一次上游请求结果，用于计算模型近期错误率
+func (lb *LoadBalancer) RecordModelResult(modelID uint, success bool) {
+	lb.health.Record(modelID, success)
+}
+
+// ResolveModel 路由诊断：返回模型名解析出的组、策略、组内模型及每个 Key (脱敏) 的当前状态，
+// 并给出下一次请求将命中的模型与 Key。只读，不推进计数器
+func (lb *LoadBalancer) ResolveModel(requestModel string) (*models.ModelResolution, error) {
+	lb.mu.RLock()
+	target := requestModel
+	if t, ok := lb.aliases[requestModel]; ok {
+		target = t
+	}
+	state, pinIndex, fallback := lb.resolveModelLocked(requestModel)
+	if state == nil || len(state.Models) == 0 {
+		lb.mu.RUnlock()
+		return nil, ErrGroupNotFound
+	}
+
+	strategy := state.Config.Strategy
+	if _, ok := lb.strategies[strategy]; !ok {
+		strategy = "round_robin"
+	}
+	res := &models.ModelResolution{
+		Model:        requestModel,
+		Target:       target,
+		GroupID:      state.Config.GroupID,
+		DefaultGroup: fallback,
+		PinIndex:     pinIndex + 1,
+		Strategy:     strategy,
+		MaxRetries:   lb.maxRetriesLocked(state),
+		Models:       make([]models.ResolvedModel, 0, len(state.Models)),
+	}
+	for i, m := range state.Models {
+		entry := models.ResolvedModel{
+			Index:         i + 1,
+			ModelID:       m.ID,
+			ProviderName:  m.ProviderName,
+			UpstreamURL:   m.UpstreamURL,
+			UpstreamModel: m.UpstreamModel,
+			Priority:      m.Priority,
+			Keys:          make([]models.KeyStatusInfo, 0, len(state.Keys[m.ID])),
+		}
+		for _, k := range state.Keys[m.ID] {
+			ks := lb.keyManager.GetState(k)
+			info := models.KeyStatusInfo{KeyPreview: models.MaskAPIKey(k), Status: ks.Status.String(), RateLimit: lb.keyManager.GetRateLimit(k), InFlight: lb.keyManager.InFlight(k)}
+			switch ks.Status {
+			case KeyStatusAvailable:
+				entry.AvailableKeys++
+			case KeyStatusCooldown:
+				unlock := ks.UnlockTime
+				info.CooldownUntil = &unlock
+			}
+			entry.Keys = append(entry.Keys, info)
+		}
+		res.Models = append(res.Models, entry)
+	}
+	lb.mu.RUnlock()
+
+	routing, modelIndex, err := lb.PreviewRoute(requestModel)
+	if err != nil {
+		res.RouteError = err.Error()
+	} else {
+		res.NextModelIndex = modelIndex + 1
+		res.NextKeyPreview = models.MaskAPIKey(routing.APIKey)
+	}
+	return res, nil
+}
+
+// GetKeyState 查询 Key (明文) 的当前健康状态
+func (lb *LoadBalancer) GetKeyState(key string) KeyState {
+	return lb.keyManager.GetState(key)
+}
+
+// GetKeyRateLimit 查询 Key (明文) 最近一次的上游限流信息，从未收到时返回 nil
+func (lb *LoadBalancer) GetKeyRateLimit(key string) *models.RateLimitInfo {
+	return lb.keyManager.GetRateLimit(key)
+}
+
+// ReactivateKey 手动恢复 Key (明文) 为可用状态，清除冷却/失效标记
+func (lb *LoadBalancer) ReactivateKey(key string) {
+	lb.keyManager.MarkAvailable(key)
+}
+
+// ServableGroups 返回至少有一个可用 Key 的组数量 (只读，不修改 Key 状态)
+func (lb *LoadBalancer) ServableGroups() int {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	servable := 0
+	for _, state := range lb.groupStates {
+		if lb.groupServableLocked(state) {
+			servable++
+		}
+	}
+	return servable
+}
+
+func (lb *LoadBalancer) groupServableLocked(state *GroupState) bool {
+	for _, m := range state.Models {
+		for _, k := range state.Keys[m.ID] {
+			if lb.keyManager.GetState(k).Status == KeyStatusAvailable {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func (lb *LoadBalancer) GetGatewaySettings() *models.GatewaySettings {
@@ -235,6 +792,38 @@ func (lb *LoadBalancer) GetGatewaySettings() *models.GatewaySettings {
 	return lb.gatewaySettings
 }
 
+func (lb *LoadBalancer) loadContentFilter(name, raw string) *RegexFilter {
+	cfg, err := models.ParseContentFilterConfig(raw)
+	if err == nil {
+		var f *RegexFilter
+		if f, err = NewRegexFilter(cfg); err == nil {
+			return f
+		}
+	}
+	lb.logger.Errorf("Invalid %s filter config, filter disabled: %v", name, err)
+	return nil
+}
+
+// RequestFilter 返回按网关设置配置的请求过滤器，未配置时返回 nil
+func (lb *LoadBalancer) RequestFilter() RequestFilter {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	if lb.requestFilter == nil {
+		return nil
+	}
+	return lb.requestFilter
+}
+
+// ResponseFilter 返回按网关设置配置的响应过滤器，未配置时返回 nil
+func (lb *LoadBalancer) ResponseFilter() ResponseFilter {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	if lb.responseFilter == nil {
+		return nil
+	}
+	return lb.responseFilter
+}
+
 func (lb *LoadBalancer) GetDB() *gorm.DB {
 	return lb.db
 }
@@ -258,9 +847,39 @@ func (lb *LoadBalancer) GetTotalStats() map[string]interface{} {
 	lb.mu.RLock()
 	defer lb.mu.RUnlock()
 	
+	// 各组实际生效的最大重试次数，便于核对重试策略
+	groupRetries := make(map[string]int, len(lb.groupStates))
+	for groupID, state := range lb.groupStates {
+		groupRetries[groupID] = lb.maxRetriesLocked(state)
+	}
+
+	// 各模型近期错误率 (最近 N 次上游请求)
+	modelHealth := make(map[string][]map[string]interface{}, len(lb.groupStates))
+	for groupID, state := range lb.groupStates {
+		entries := make([]map[string]interface{}, 0, len(state.Models))
+		for _, m := range state.Models {
+			rate, samples := lb.health.ErrorRate(m.ID)
+			entries = append(entries, map[string]interface{}{
+				"model_id":       m.ID,
+				"upstream_model": m.UpstreamModel,
+				"error_rate":     rate,
+				"samples":        samples,
+				"unhealthy":      lb.health.Unhealthy(m.ID, state.Config.ErrorRateThreshold),
+			})
+		}
+		modelHealth[groupID] = entries
+	}
+
 	return map[string]interface{}{
 		"groups_count": len(lb.groupStates),
+		"model_health": modelHealth,
 		"uptime":       "N/A", // 可以稍后添加 uptime
+		"retry_policy": map[string]interface{}{
+			"multiplier":  lb.gatewaySettings.RetryMultiplier,
+			"min_retries": lb.gatewaySettings.MinRetries,
+			"max_retries": lb.gatewaySettings.MaxRetries,
+			"groups":      groupRetries,
+		},
 	}
 }
 
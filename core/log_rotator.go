@@ -1,8 +1,13 @@
 package core
 
 import (
+	"compress/gzip"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -13,6 +18,12 @@ type LogRotator struct {
 	file       *os.File
 	mu         sync.Mutex
 	currentSize int64
+
+	// MaxBackups 保留的备份数量。<= 1 时使用乒乓策略 (仅保留 .old)；
+	// 否则按 .1 (最新) ... .N 编号保留，超出的旧备份被删除
+	MaxBackups int
+	// Compress 是否将编号备份压缩为 .gz (仅 MaxBackups > 1 时生效)
+	Compress bool
 }
 
 // NewLogRotator 创建新的日志轮转器 (maxSize in MB)
@@ -65,6 +76,13 @@ func (r *LogRotator) rotate() error {
 		r.file.Close()
 	}
 
+	if r.MaxBackups > 1 {
+		if err := r.rotateNumbered(); err != nil {
+			return err
+		}
+		return r.openFile()
+	}
+
 	// 乒乓轮转策略: 只保留一个备份
 	// 1. 删除旧的备份 (gateway.log.old)
 	backupName := r.filename + ".old"
@@ -79,6 +97,82 @@ func (r *LogRotator) rotate() error {
 	return r.openFile()
 }
 
+// backupName 返回第 n 个编号备份的文件名 (gateway.log.1 或 gateway.log.1.gz)
+func (r *LogRotator) backupName(n int, compressed bool) string {
+	name := fmt.Sprintf("%s.%d", r.filename, n)
+	if compressed {
+		name += ".gz"
+	}
+	return name
+}
+
+// rotateNumbered 编号轮转: gateway.log.(N-1) -> .N, ..., gateway.log -> .1
+func (r *LogRotator) rotateNumbered() error {
+	r.pruneBackups(r.MaxBackups - 1)
+
+	for n := r.MaxBackups - 1; n >= 1; n-- {
+		for _, gz := range []bool{false, true} {
+			if _, err := os.Stat(r.backupName(n, gz)); err == nil {
+				os.Rename(r.backupName(n, gz), r.backupName(n+1, gz))
+			}
+		}
+	}
+
+	first := r.backupName(1, false)
+	if err := os.Rename(r.filename, first); err != nil {
+		return err
+	}
+	if r.Compress {
+		// 同步压缩: 在持有锁期间完成，避免与下一次轮转的重命名冲突
+		if err := gzipFile(first); err != nil {
+			fmt.Fprintf(os.Stderr, "Log compression failed: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// pruneBackups 删除编号大于 keep 的备份 (包括调小 MaxBackups 后遗留的文件)
+func (r *LogRotator) pruneBackups(keep int) {
+	matches, _ := filepath.Glob(r.filename + ".*")
+	prefix := r.filename + "."
+	for _, m := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(m, prefix), ".gz")
+		if n, err := strconv.Atoi(suffix); err == nil && n > keep {
+			os.Remove(m)
+		}
+	}
+}
+
+// gzipFile 将文件压缩为 name.gz 并删除原文件
+func gzipFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		zw.Close()
+		dst.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
 func (r *LogRotator) Close() error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogRotator_RotatesPastMaxSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.log")
+	r, err := NewLogRotator(path, 1)
+	assert.NoError(t, err)
+	r.maxSize = 100 // 测试中使用字节级上限
+
+	first := strings.Repeat("a", 60) + "\n"
+	second := strings.Repeat("b", 60) + "\n"
+	_, err = r.Write([]byte(first))
+	assert.NoError(t, err)
+	_, err = r.Write([]byte(second)) // 超出上限，先轮转再写入
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+
+	old, err := os.ReadFile(path + ".old")
+	assert.NoError(t, err)
+	assert.Equal(t, first, string(old))
+
+	current, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, second, string(current))
+}
+
+func TestLogRotator_ConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.log")
+	r, err := NewLogRotator(path, 1)
+	assert.NoError(t, err)
+	r.maxSize = 1000
+
+	line := strings.Repeat("x", 49) + "\n"
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				r.Write([]byte(line))
+			}
+		}()
+	}
+	wg.Wait()
+	assert.NoError(t, r.Close())
+
+	// 每次写入都是完整的一行，没有交错
+	for _, name := range []string{path, path + ".old"} {
+		data, err := os.ReadFile(name)
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, len(data), 1000)
+		for _, l := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+			assert.Equal(t, strings.TrimSuffix(line, "\n"), l)
+		}
+	}
+}
+
+func TestLogRotator_NumberedBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.log")
+	r, err := NewLogRotator(path, 1)
+	assert.NoError(t, err)
+	r.maxSize = 10
+	r.MaxBackups = 3
+
+	// 每次写入都超过上限，触发一次轮转
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n", "fifth\n"} {
+		_, err := r.Write([]byte(strings.Repeat(line, 2)))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, r.Close())
+
+	read := func(name string) string {
+		data, _ := os.ReadFile(name)
+		return string(data)
+	}
+	assert.Equal(t, "fifth\nfifth\n", read(path))
+	assert.Equal(t, "fourth\nfourth\n", read(path+".1"))
+	assert.Equal(t, "third\nthird\n", read(path+".2"))
+	assert.Equal(t, "second\nsecond\n", read(path+".3"))
+	assert.NoFileExists(t, path+".4")
+	assert.NoFileExists(t, path+".old")
+}
+
+func TestLogRotator_CompressAndPrune(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "gateway.log")
+	// 之前使用更大 MaxBackups 时遗留的备份
+	os.WriteFile(path+".5.gz", []byte("stale"), 0666)
+
+	r, err := NewLogRotator(path, 1)
+	assert.NoError(t, err)
+	r.maxSize = 10
+	r.MaxBackups = 2
+	r.Compress = true
+
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		_, err := r.Write([]byte(line + line))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, r.Close())
+
+	assert.NoFileExists(t, path+".5.gz")
+	assert.NoFileExists(t, path+".1")
+	assert.NoFileExists(t, path+".3.gz")
+
+	gunzip := func(name string) string {
+		f, err := os.Open(name)
+		if !assert.NoError(t, err) {
+			return ""
+		}
+		defer f.Close()
+		zr, err := gzip.NewReader(f)
+		if !assert.NoError(t, err) {
+			return ""
+		}
+		data, _ := io.ReadAll(zr)
+		return string(data)
+	}
+	assert.Equal(t, "cccccccc\ncccccccc\n", gunzip(path+".1.gz"))
+	assert.Equal(t, "bbbbbbbb\nbbbbbbbb\n", gunzip(path+".2.gz"))
+}
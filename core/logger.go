@@ -1,7 +1,12 @@
 package core
 
 import (
+	"context"
+	"fmt"
 	"llm-gateway/models"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,6 +23,75 @@ type AsyncRequestLogger struct {
 	flushTime time.Duration
 	wg        sync.WaitGroup
 	quit      chan struct{}
+	closeOnce sync.Once
+	closed    chan struct{} // Worker 退出并完成刷新后关闭
+	drained   int           // 关闭时刷新的剩余日志条数
+
+	// 统计持久化: statsInterval 为 0 时随每批日志写入，> 0 时按间隔批量写入；
+	// statsOff 为 true 时不写数据库，统计只保存在 live 中
+	statsMu       sync.Mutex
+	statsInterval time.Duration
+	statsOff      bool
+	pendingStats  map[uint]*statDelta
+	lastStatsSync time.Time
+	live          map[uint]*LiveModelStats
+}
+
+// LiveModelStats 内存中的模型统计 (stats_persistence 为 off 时)，字段含义与 ModelStats 相同
+type LiveModelStats struct {
+	ModelGroupID  uint    `json:"model_group_id"`
+	ModelConfigID uint    `json:"model_config_id"`
+	Success       int     `json:"success"`
+	Error         int     `json:"error"`
+	TotalLatency  float64 `json:"total_latency"` // 毫秒
+	RequestCount  int     `json:"request_count"`
+	TotalTTFT     float64 `json:"total_ttft"` // 毫秒
+	TTFTCount     int     `json:"ttft_count"`
+
+	CacheReadTokens  int64 `json:"cache_read_tokens"`
+	CacheWriteTokens int64 `json:"cache_write_tokens"`
+
+	EmbeddingRequests int     `json:"embedding_requests"`
+	EmbeddingErrors   int     `json:"embedding_errors"`
+	EmbeddingLatency  float64 `json:"embedding_latency"` // 毫秒
+}
+
+// statDelta 一个模型在一次刷新周期内的统计增量
+type statDelta struct {
+	Success      int
+	Error        int
+	TotalLatency float64
+	RequestCount int
+	TotalTTFT    float64
+	TTFTCount    int
+	ModelGroupID uint
+
+	CacheReadTokens  int64
+	CacheWriteTokens int64
+
+	EmbeddingRequests int
+	EmbeddingErrors   int
+	EmbeddingLatency  float64
+}
+
+// StatsPersistenceOff 网关设置 stats_persistence 的取值，表示统计只保存在内存中
+const StatsPersistenceOff = "off"
+
+// ParseStatsPersistence 解析 stats_persistence 设置: 空值表示随请求日志一起写入 (默认)，
+// "off" 表示不写数据库，正整数表示按该秒数间隔批量写入
+func ParseStatsPersistence(value string) (interval time.Duration, off bool, err error) {
+	value = strings.TrimSpace(value)
+	switch {
+	case value == "":
+		return 0, false, nil
+	case strings.EqualFold(value, StatsPersistenceOff):
+		return 0, true, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return 0, false, fmt.Errorf("stats_persistence must be %q or a positive number of seconds, got %q", StatsPersistenceOff, value)
+	}
+	return time.Duration(seconds) * time.Second, false, nil
 }
 
 // NewAsyncRequestLogger 创建新的异步日志记录器
@@ -29,11 +103,44 @@ func NewAsyncRequestLogger(db *gorm.DB, logger *logrus.Logger) *AsyncRequestLogg
 		batchSize: 100,             // 批量插入大小
 		flushTime: 5 * time.Second, // 最长等待时间
 		quit:      make(chan struct{}),
+
+		pendingStats:  make(map[uint]*statDelta),
+		lastStatsSync: time.Now(),
+		live:          make(map[uint]*LiveModelStats),
 	}
 	l.startWorker()
 	return l
 }
 
+// SetStatsPersistence 设置统计的持久化方式 (见 ParseStatsPersistence)
+// 从持久化切换为 off 之前尚未写入的增量仍会写入数据库
+func (l *AsyncRequestLogger) SetStatsPersistence(interval time.Duration, off bool) {
+	l.statsMu.Lock()
+	defer l.statsMu.Unlock()
+	l.statsInterval = interval
+	l.statsOff = off
+}
+
+// StatsInMemory 统计是否只保存在内存中 (stats_persistence 为 off)
+func (l *AsyncRequestLogger) StatsInMemory() bool {
+	l.statsMu.Lock()
+	defer l.statsMu.Unlock()
+	return l.statsOff
+}
+
+// LiveStats 返回 stats_persistence 为 off 时进程启动以来的模型统计 (按 ModelConfigID 排序)
+// 已刷新的日志才会计入，因此最多滞后一个刷新周期
+func (l *AsyncRequestLogger) LiveStats() []LiveModelStats {
+	l.statsMu.Lock()
+	defer l.statsMu.Unlock()
+	stats := make([]LiveModelStats, 0, len(l.live))
+	for _, s := range l.live {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ModelConfigID < stats[j].ModelConfigID })
+	return stats
+}
+
 // Log 提交日志到队列
 func (l *AsyncRequestLogger) Log(log *models.RequestLog) {
 	select {
@@ -59,6 +166,9 @@ func (l *AsyncRequestLogger) workerLoop() {
 	var batch []*models.RequestLog
 	timer := time.NewTicker(l.flushTime)
 	defer timer.Stop()
+	// 按间隔写入统计时的检查周期
+	statsTimer := time.NewTicker(time.Second)
+	defer statsTimer.Stop()
 
 	for {
 		select {
@@ -73,11 +183,24 @@ func (l *AsyncRequestLogger) workerLoop() {
 				l.flush(batch)
 				batch = nil
 			}
+		case <-statsTimer.C:
+			l.flushStats(false)
 		case <-l.quit:
-			// 退出前刷新剩余日志
+			// 退出前取出队列中剩余的日志，与当前批次一起刷新 (包括统计增量)
+		drain:
+			for {
+				select {
+				case log := <-l.logChan:
+					batch = append(batch, log)
+				default:
+					break drain
+				}
+			}
+			l.drained = len(batch)
 			if len(batch) > 0 {
 				l.flush(batch)
 			}
+			l.flushStats(true)
 			return
 		}
 	}
@@ -112,49 +235,119 @@ func (l *AsyncRequestLogger) flush(logs []*models.RequestLog) {
 		}
 	}()
 
-	// 3. 聚合统计更新
-	type statDelta struct {
-		Success       int
-		Error         int
-		TotalLatency  float64
-		RequestCount  int
-		ModelGroupID  uint
-	}
-	statsMap := make(map[uint]*statDelta)
+	// 3. 聚合统计增量，按 stats_persistence 写入数据库或只保存在内存中
+	l.addStats(logs)
+	l.flushStats(false)
+}
+
+// addStats 将一批日志聚合为待写入的统计增量 (off 时累加到内存统计)
+func (l *AsyncRequestLogger) addStats(logs []*models.RequestLog) {
+	l.statsMu.Lock()
+	defer l.statsMu.Unlock()
 
 	for _, log := range logs {
 		if log.ModelConfigID == 0 {
 			continue
 		}
-		delta, exists := statsMap[log.ModelConfigID]
+		if l.statsOff {
+			stat, exists := l.live[log.ModelConfigID]
+			if !exists {
+				stat = &LiveModelStats{ModelConfigID: log.ModelConfigID, ModelGroupID: log.ModelGroupID}
+				l.live[log.ModelConfigID] = stat
+			}
+			if log.RequestType == models.RequestTypeEmbeddings {
+				stat.EmbeddingRequests++
+				if !log.IsSuccess() {
+					stat.EmbeddingErrors++
+				}
+				stat.EmbeddingLatency += float64(log.Duration)
+				continue
+			}
+			stat.RequestCount++
+			if log.IsSuccess() {
+				stat.Success++
+			} else {
+				stat.Error++
+			}
+			stat.TotalLatency += float64(log.Duration)
+			if log.TTFT != nil {
+				stat.TotalTTFT += float64(*log.TTFT)
+				stat.TTFTCount++
+			}
+			stat.CacheReadTokens += int64(log.CacheReadTokens)
+			stat.CacheWriteTokens += int64(log.CacheWriteTokens)
+			continue
+		}
+
+		delta, exists := l.pendingStats[log.ModelConfigID]
 		if !exists {
 			delta = &statDelta{ModelGroupID: log.ModelGroupID}
-			statsMap[log.ModelConfigID] = delta
+			l.pendingStats[log.ModelConfigID] = delta
+		}
+		// Embedding 请求单独计数，聊天的成功率与延迟统计不受影响
+		if log.RequestType == models.RequestTypeEmbeddings {
+			delta.EmbeddingRequests++
+			if !log.IsSuccess() {
+				delta.EmbeddingErrors++
+			}
+			delta.EmbeddingLatency += float64(log.Duration)
+			continue
 		}
 		delta.RequestCount++
-		if log.StatusCode >= 200 && log.StatusCode < 500 && log.StatusCode != 429 {
+		if log.IsSuccess() {
 			delta.Success++
 		} else {
 			delta.Error++
 		}
 		delta.TotalLatency += float64(log.Duration)
+		if log.TTFT != nil {
+			delta.TotalTTFT += float64(*log.TTFT)
+			delta.TTFTCount++
+		}
+		delta.CacheReadTokens += int64(log.CacheReadTokens)
+		delta.CacheWriteTokens += int64(log.CacheWriteTokens)
 	}
+}
+
+// flushStats 将待写入的统计增量写入数据库；force 为 false 时只在到达写入间隔后写入
+func (l *AsyncRequestLogger) flushStats(force bool) {
+	l.statsMu.Lock()
+	if len(l.pendingStats) == 0 || (!force && time.Since(l.lastStatsSync) < l.statsInterval) {
+		l.statsMu.Unlock()
+		return
+	}
+	statsMap := l.pendingStats
+	l.pendingStats = make(map[uint]*statDelta)
+	l.lastStatsSync = time.Now()
+	l.statsMu.Unlock()
+
+	// 执行更新 (Robust Upsert)
+	// 同一批次的增量在一个事务中以原子自增写入，避免读-改-写覆盖并发更新
+	err := l.db.Transaction(func(tx *gorm.DB) error {
+		for modelID, delta := range statsMap {
+			result := tx.Model(&models.ModelStats{}).Where("model_config_id = ?", modelID).Updates(map[string]interface{}{
+				"success":        gorm.Expr("success + ?", delta.Success),
+				"error":          gorm.Expr("error + ?", delta.Error),
+				"total_latency":  gorm.Expr("total_latency + ?", delta.TotalLatency),
+				"request_count":  gorm.Expr("request_count + ?", delta.RequestCount),
+				"total_requests": gorm.Expr("total_requests + ?", delta.RequestCount),
+				"total_ttft":     gorm.Expr("total_ttft + ?", delta.TotalTTFT),
+				"ttft_count":     gorm.Expr("ttft_count + ?", delta.TTFTCount),
+
+				"cache_read_tokens":  gorm.Expr("cache_read_tokens + ?", delta.CacheReadTokens),
+				"cache_write_tokens": gorm.Expr("cache_write_tokens + ?", delta.CacheWriteTokens),
+
+				"embedding_requests": gorm.Expr("embedding_requests + ?", delta.EmbeddingRequests),
+				"embedding_errors":   gorm.Expr("embedding_errors + ?", delta.EmbeddingErrors),
+				"embedding_latency":  gorm.Expr("embedding_latency + ?", delta.EmbeddingLatency),
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				continue
+			}
 
-	// 3. 执行更新 (Robust Upsert)
-	for modelID, delta := range statsMap {
-		// First try to find existing stat
-		var stat models.ModelStats
-		err := l.db.Where("model_config_id = ?", modelID).First(&stat).Error
-		
-		if err == nil {
-			// Update existing
-			stat.Success += delta.Success
-			stat.Error += delta.Error
-			stat.TotalLatency += delta.TotalLatency
-			stat.RequestCount += delta.RequestCount
-			stat.TotalRequests += int64(delta.RequestCount)
-			l.db.Save(&stat)
-		} else {
 			// Create new
 			newStat := models.ModelStats{
 				ModelConfigID: modelID,
@@ -164,15 +357,49 @@ func (l *AsyncRequestLogger) flush(logs []*models.RequestLog) {
 				TotalLatency:  delta.TotalLatency,
 				RequestCount:  delta.RequestCount,
 				TotalRequests: int64(delta.RequestCount),
+				TotalTTFT:     delta.TotalTTFT,
+				TTFTCount:     delta.TTFTCount,
+
+				CacheReadTokens:  delta.CacheReadTokens,
+				CacheWriteTokens: delta.CacheWriteTokens,
+
+				EmbeddingRequests: delta.EmbeddingRequests,
+				EmbeddingErrors:   delta.EmbeddingErrors,
+				EmbeddingLatency:  delta.EmbeddingLatency,
+			}
+			if err := tx.Create(&newStat).Error; err != nil {
+				return err
 			}
-			l.db.Create(&newStat)
 		}
+		return nil
+	})
+	if err != nil {
+		l.logger.Errorf("[Logger] Failed to update model stats: %v", err)
 	}
 }
 
 // Close 关闭日志记录器
 func (l *AsyncRequestLogger) Close() {
-	close(l.quit)
-	l.wg.Wait()
-	close(l.logChan)
+	l.Shutdown(context.Background())
+}
+
+// Shutdown 停止 Worker 并刷新剩余的日志与统计增量，返回刷新的日志条数
+// ctx 到期时不再等待 (Worker 仍会在后台完成刷新)；可重复调用
+func (l *AsyncRequestLogger) Shutdown(ctx context.Context) (int, error) {
+	l.closeOnce.Do(func() {
+		l.closed = make(chan struct{})
+		close(l.quit)
+		go func() {
+			l.wg.Wait()
+			close(l.logChan)
+			close(l.closed)
+		}()
+	})
+
+	select {
+	case <-l.closed:
+		return l.drained, nil
+	case <-ctx.Done():
+		return 0, fmt.Errorf("timed out flushing request logs: %w", ctx.Err())
+	}
 }
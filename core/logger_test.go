@@ -0,0 +1,174 @@
+package core
+
+import (
+	"context"
+	"llm-gateway/models"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestAsyncRequestLogger_CloseFlushesStats(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:async_logger_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	l := NewAsyncRequestLogger(db, logger)
+
+	// 已有统计行按增量累加，新模型创建统计行
+	db.Create(&models.ModelStats{ModelConfigID: 1, ModelGroupID: 1, Success: 5, RequestCount: 5, TotalRequests: 5})
+	for _, status := range []int{200, 200, 500} {
+		l.Log(&models.RequestLog{ModelConfigID: 1, ModelGroupID: 1, StatusCode: status, Duration: 10})
+	}
+	// 首字节耗时只统计记录了 TTFT 的流式请求
+	ttft := int64(15)
+	l.Log(&models.RequestLog{ModelConfigID: 2, ModelGroupID: 1, StatusCode: 200, Duration: 20, TTFT: &ttft, CacheReadTokens: 80, CacheWriteTokens: 20})
+
+	// 未到刷新周期，关闭时应写入全部待处理的日志与统计
+	l.Close()
+
+	var count int64
+	db.Model(&models.RequestLog{}).Count(&count)
+	assert.Equal(t, int64(4), count)
+
+	var stat models.ModelStats
+	assert.NoError(t, db.Where("model_config_id = ?", 1).First(&stat).Error)
+	assert.Equal(t, 7, stat.Success)
+	assert.Equal(t, 1, stat.Error)
+	assert.Equal(t, 8, stat.RequestCount)
+	assert.Equal(t, int64(8), stat.TotalRequests)
+	assert.Equal(t, 30.0, stat.TotalLatency)
+	assert.Equal(t, 0, stat.TTFTCount)
+
+	var created models.ModelStats
+	assert.NoError(t, db.Where("model_config_id = ?", 2).First(&created).Error)
+	assert.Equal(t, 1, created.Success)
+	assert.Equal(t, 1, created.TTFTCount)
+	assert.Equal(t, 15.0, created.TotalTTFT)
+	assert.Equal(t, int64(80), created.CacheReadTokens)
+	assert.Equal(t, int64(20), created.CacheWriteTokens)
+}
+
+func TestAsyncRequestLogger_Shutdown(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:async_logger_shutdown_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	l := NewAsyncRequestLogger(db, logger)
+	for i := 0; i < 3; i++ {
+		l.Log(&models.RequestLog{ModelConfigID: 1, ModelGroupID: 1, StatusCode: 200})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	drained, err := l.Shutdown(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, drained)
+
+	// 重复关闭不会 panic
+	_, err = l.Shutdown(ctx)
+	assert.NoError(t, err)
+	l.Close()
+}
+
+func TestParseStatsPersistence(t *testing.T) {
+	interval, off, err := ParseStatsPersistence("")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), interval)
+	assert.False(t, off)
+
+	_, off, err = ParseStatsPersistence("OFF")
+	assert.NoError(t, err)
+	assert.True(t, off)
+
+	interval, _, err = ParseStatsPersistence("30")
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, interval)
+
+	for _, bad := range []string{"0", "-5", "1m"} {
+		_, _, err = ParseStatsPersistence(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestAsyncRequestLogger_StatsPersistence(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:async_logger_stats_persistence?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	statCount := func() int64 {
+		var n int64
+		db.Model(&models.ModelStats{}).Count(&n)
+		return n
+	}
+
+	// off: 统计只保存在内存中，关闭时也不写数据库
+	l := NewAsyncRequestLogger(db, logger)
+	l.SetStatsPersistence(0, true)
+	l.Log(&models.RequestLog{ModelConfigID: 1, ModelGroupID: 1, StatusCode: 200, Duration: 10})
+	l.Log(&models.RequestLog{ModelConfigID: 1, ModelGroupID: 1, StatusCode: 500, Duration: 30, CacheReadTokens: 5})
+	l.Close()
+	assert.Equal(t, []LiveModelStats{{ModelGroupID: 1, ModelConfigID: 1, Success: 1, Error: 1, TotalLatency: 40, RequestCount: 2, CacheReadTokens: 5}}, l.LiveStats())
+	assert.True(t, l.StatsInMemory())
+	assert.Equal(t, int64(0), statCount())
+
+	// 按间隔写入: 未到间隔时不写入，关闭时写入剩余增量
+	l = NewAsyncRequestLogger(db, logger)
+	l.SetStatsPersistence(time.Hour, false)
+	l.Log(&models.RequestLog{ModelConfigID: 2, ModelGroupID: 1, StatusCode: 200})
+	l.flush([]*models.RequestLog{{ModelConfigID: 2, ModelGroupID: 1, StatusCode: 200}})
+	assert.Equal(t, int64(0), statCount())
+	l.Close()
+	var stat models.ModelStats
+	assert.NoError(t, db.Where("model_config_id = ?", 2).First(&stat).Error)
+	assert.Equal(t, 2, stat.RequestCount)
+	assert.Empty(t, l.LiveStats())
+}
+
+func TestAsyncRequestLogger_EmbeddingStats(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:async_logger_embedding_stats?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	logs := func(l *AsyncRequestLogger) {
+		l.Log(&models.RequestLog{ModelConfigID: 1, ModelGroupID: 1, StatusCode: 200, Duration: 100, RequestType: models.RequestTypeChat})
+		l.Log(&models.RequestLog{ModelConfigID: 1, ModelGroupID: 1, StatusCode: 200, Duration: 10, RequestType: models.RequestTypeEmbeddings})
+		l.Log(&models.RequestLog{ModelConfigID: 1, ModelGroupID: 1, StatusCode: 500, Duration: 20, RequestType: models.RequestTypeEmbeddings})
+	}
+
+	// Embedding 请求单独计数，聊天的请求数、错误数与延迟不受影响
+	l := NewAsyncRequestLogger(db, logger)
+	logs(l)
+	l.Close()
+	var stat models.ModelStats
+	assert.NoError(t, db.Where("model_config_id = ?", 1).First(&stat).Error)
+	assert.Equal(t, 1, stat.RequestCount)
+	assert.Equal(t, 1, stat.Success)
+	assert.Equal(t, 0, stat.Error)
+	assert.Equal(t, 100.0, stat.TotalLatency)
+	assert.Equal(t, 2, stat.EmbeddingRequests)
+	assert.Equal(t, 1, stat.EmbeddingErrors)
+	assert.Equal(t, 30.0, stat.EmbeddingLatency)
+
+	// 内存统计同样分开
+	l = NewAsyncRequestLogger(db, logger)
+	l.SetStatsPersistence(0, true)
+	logs(l)
+	l.Close()
+	assert.Equal(t, []LiveModelStats{{
+		ModelGroupID: 1, ModelConfigID: 1, Success: 1, TotalLatency: 100, RequestCount: 1,
+		EmbeddingRequests: 2, EmbeddingErrors: 1, EmbeddingLatency: 30,
+	}}, l.LiveStats())
+}
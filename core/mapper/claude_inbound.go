@@ -1,6 +1,8 @@
 package mapper
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"llm-gateway/core/adapter"
@@ -19,19 +21,27 @@ func ClaudeRequestToOpenAI(cReq adapter.ClaudeRequest) (models.ChatCompletionReq
 		TopP:        &cReq.TopP,
 		MaxTokens:   &cReq.MaxTokens,
 	}
+	if len(cReq.StopSequences) > 0 {
+		req.Stop = cReq.StopSequences
+	}
+	if cReq.Stream {
+		// message_delta 需要带上用量，要求上游在流末尾返回
+		req.StreamOptions = &models.StreamOptions{IncludeUsage: true}
+	}
 
 	// 1. System Prompt
-	if cReq.System != "" {
+	systemText := cReq.SystemText()
+	if systemText != "" {
 		req.Messages = append(req.Messages, models.ChatMessage{
 			Role:    "system",
-			Content: cReq.System,
+			Content: systemText,
 		})
 	}
 
 	// 2. Messages
 	for _, msg := range cReq.Messages {
 		role := msg.Role
-		if role == "user" && len(req.Messages) == 0 && cReq.System == "" {
+		if role == "user" && len(req.Messages) == 0 && systemText == "" {
 			// First user message
 		}
 
@@ -138,20 +148,8 @@ func OpenAIResponseToClaude(oResp models.ChatCompletionResponse) adapter.ClaudeR
 		choice := oResp.Choices[0]
 		
 		// Stop Reason Mapping
-		switch choice.FinishReason {
-		case "stop":
-			s := "end_turn"
-			cResp.StopReason = &s
-		case "length":
-			s := "max_tokens"
-			cResp.StopReason = &s
-		case "tool_calls":
-			s := "tool_use"
-			cResp.StopReason = &s
-		default:
-			s := "end_turn"
-			cResp.StopReason = &s
-		}
+		s := claudeStopReason(choice.FinishReason)
+		cResp.StopReason = &s
 
 		// Content Mapping
 		if choice.Message.Content != nil {
@@ -168,74 +166,216 @@ func OpenAIResponseToClaude(oResp models.ChatCompletionResponse) adapter.ClaudeR
 		for _, tc := range choice.Message.ToolCalls {
 			var input interface{}
 			json.Unmarshal([]byte(tc.Function.Arguments), &input)
+			if input == nil {
+				input = map[string]interface{}{}
+			}
 			
 			cResp.Content = append(cResp.Content, adapter.ClaudeContentBlock{
 				Type:  "tool_use",
-				ID:    tc.ID,
+				ID:    claudeToolID(tc.ID),
 				Name:  tc.Function.Name,
 				Input: input,
 			})
 		}
+		// 部分 OpenAI 兼容上游 (如 vLLM、Ollama) 返回工具调用时 finish_reason 仍为 stop
+		if len(choice.Message.ToolCalls) > 0 && s == "end_turn" {
+			s = "tool_use"
+		}
 	}
 
 	return cResp
 }
 
-// OpenAIStreamToClaudeEvent converts OpenAI chunk to Claude SSE event(s)
-// Returns a list of events because one OpenAI chunk might split into multiple Claude events
-func OpenAIStreamToClaudeEvent(chunk models.ChatCompletionResponse, index int) []string {
-	var events []string
+// claudeStopReason maps an OpenAI finish_reason to a Claude stop_reason
+func claudeStopReason(finishReason string) string {
+	switch finishReason {
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	default:
+		return "end_turn"
+	}
+}
+
+// claudeToolID 上游未返回调用 ID 时生成一个，Claude 客户端需要用它回传 tool_result
+func claudeToolID(id string) string {
+	if id != "" {
+		return id
+	}
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "toolu_" + hex.EncodeToString(b)
+}
+
+// claudeBlockEvent content_block_* 事件
+// 单独定义是因为 adapter.ClaudeStreamEvent 的 index 带 omitempty，而 SDK 要求 index 0 也必须出现
+type claudeBlockEvent struct {
+	Type         string               `json:"type"`
+	Index        int                  `json:"index"`
+	ContentBlock interface{}          `json:"content_block,omitempty"`
+	Delta        *adapter.ClaudeDelta `json:"delta,omitempty"`
+}
 
-	// 1. Message Start (Only on first chunk or if ID changes - simplified for now)
-	// Ideally we handle state outside, but here we just process deltas.
-	// We assume the caller handles "message_start".
+func claudeSSE(event string, payload interface{}) string {
+	b, _ := json.Marshal(payload)
+	return fmt.Sprintf("event: %s\ndata: %s\n\n", event, string(b))
+}
+
+// ClaudeStreamMapper converts an OpenAI chunk stream into the Claude SSE event sequence:
+// message_start -> (content_block_start -> content_block_delta* -> content_block_stop)* -> message_delta -> message_stop
+// Claude 要求同一时间只有一个打开的内容块，因此文本与每个工具调用各占一个块，切换时先关闭上一个块。
+type ClaudeStreamMapper struct {
+	started    bool
+	finished   bool
+	nextIndex  int         // 下一个内容块的 index
+	openIndex  int         // 当前打开的块，-1 表示没有
+	textIndex  int         // 当前文本块的 index，-1 表示没有
+	toolBlocks map[int]int // OpenAI tool_call index -> Claude 块 index
+	stopReason string
+	usage      *models.ChatCompletionUsage
+}
+
+// NewClaudeStreamMapper creates a mapper for a single streamed response
+func NewClaudeStreamMapper() *ClaudeStreamMapper {
+	return &ClaudeStreamMapper{openIndex: -1, textIndex: -1, toolBlocks: make(map[int]int)}
+}
+
+// Map converts one OpenAI chunk into zero or more Claude events
+func (m *ClaudeStreamMapper) Map(chunk models.ChatCompletionResponse) []string {
+	var events []string
+	if !m.started {
+		events = append(events, m.messageStart(chunk.ID, chunk.Model))
+	}
+	if chunk.Usage != nil {
+		m.usage = chunk.Usage
+	}
+	if len(chunk.Choices) == 0 {
+		return events
+	}
 
 	choice := chunk.Choices[0]
 	delta := choice.Delta
 
-	// Content Delta
-	contentStr := delta.StringContent()
-	if contentStr != "" {
-		evt := adapter.ClaudeStreamEvent{
-			Type:  "content_block_delta",
-			Index: 0, // Assume single text block for now
-			Delta: &adapter.ClaudeDelta{
-				Type: "text_delta",
-				Text: contentStr,
-			},
+	// Text
+	if text := delta.StringContent(); text != "" {
+		if m.textIndex == -1 || m.openIndex != m.textIndex {
+			events = append(events, m.closeBlock()...)
+			m.textIndex = m.openBlock()
+			events = append(events, claudeSSE("content_block_start", claudeBlockEvent{
+				Type:         "content_block_start",
+				Index:        m.textIndex,
+				ContentBlock: map[string]interface{}{"type": "text", "text": ""},
+			}))
 		}
-		b, _ := json.Marshal(evt)
-		events = append(events, fmt.Sprintf("event: content_block_delta\ndata: %s\n\n", string(b)))
+		events = append(events, claudeSSE("content_block_delta", claudeBlockEvent{
+			Type:  "content_block_delta",
+			Index: m.textIndex,
+			Delta: &adapter.ClaudeDelta{Type: "text_delta", Text: text},
+		}))
 	}
 
-	// Tool Calls
-	// OpenAI sends partial tool calls. Claude needs content_block_start (tool_use) and then input_json_delta.
-	// Complex state handling required.
-	// For MVP, we might skip complex tool streaming or handle basic cases.
-
-	// Message Delta (Stop Reason & Usage)
-	if choice.FinishReason != "" || chunk.Usage != nil {
-		evt := adapter.ClaudeStreamEvent{
-			Type:  "message_delta",
-			Delta: &adapter.ClaudeDelta{},
-			Usage: &adapter.ClaudeUsage{},
+	// Tool calls: 首个分片带 id/name，之后的分片只带 arguments 片段
+	for i, tc := range delta.ToolCalls {
+		toolIdx := i
+		if tc.Index != nil {
+			toolIdx = *tc.Index
 		}
-		
-		if choice.FinishReason != "" {
-			reason := "end_turn"
-			if choice.FinishReason == "length" { reason = "max_tokens" }
-			if choice.FinishReason == "tool_calls" { reason = "tool_use" }
-			evt.Delta.StopReason = &reason
-			evt.Delta.StopSequence = nil
+		blockIdx, ok := m.toolBlocks[toolIdx]
+		if !ok {
+			events = append(events, m.closeBlock()...)
+			blockIdx = m.openBlock()
+			m.toolBlocks[toolIdx] = blockIdx
+			events = append(events, claudeSSE("content_block_start", claudeBlockEvent{
+				Type:  "content_block_start",
+				Index: blockIdx,
+				ContentBlock: map[string]interface{}{
+					"type":  "tool_use",
+					"id":    claudeToolID(tc.ID),
+					"name":  tc.Function.Name,
+					"input": map[string]interface{}{},
+				},
+			}))
 		}
-
-		if chunk.Usage != nil {
-			evt.Usage.OutputTokens = chunk.Usage.CompletionTokens
+		if tc.Function.Arguments != "" {
+			events = append(events, claudeSSE("content_block_delta", claudeBlockEvent{
+				Type:  "content_block_delta",
+				Index: blockIdx,
+				Delta: &adapter.ClaudeDelta{Type: "input_json_delta", PartialJson: tc.Function.Arguments},
+			}))
 		}
-		
-		b, _ := json.Marshal(evt)
-		events = append(events, fmt.Sprintf("event: message_delta\ndata: %s\n\n", string(b)))
 	}
 
+	if choice.FinishReason != "" {
+		m.stopReason = claudeStopReason(choice.FinishReason)
+	}
 	return events
 }
+
+// Finish closes the open content block and emits message_delta (stop_reason + usage) and message_stop.
+// It is idempotent so callers may invoke it both on [DONE] and when the upstream stream ends.
+func (m *ClaudeStreamMapper) Finish() []string {
+	if m.finished {
+		return nil
+	}
+	m.finished = true
+
+	var events []string
+	if !m.started {
+		events = append(events, m.messageStart("", ""))
+	}
+	events = append(events, m.closeBlock()...)
+
+	stopReason := m.stopReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	// 输出了工具调用但上游 finish_reason 为 stop 时，Claude 客户端仍需 tool_use 才会执行工具
+	if stopReason == "end_turn" && len(m.toolBlocks) > 0 {
+		stopReason = "tool_use"
+	}
+	usage := &adapter.ClaudeUsage{}
+	if m.usage != nil {
+		usage.InputTokens = m.usage.PromptTokens
+		usage.OutputTokens = m.usage.CompletionTokens
+	}
+	events = append(events, claudeSSE("message_delta", adapter.ClaudeStreamEvent{
+		Type:  "message_delta",
+		Delta: &adapter.ClaudeDelta{StopReason: &stopReason},
+		Usage: usage,
+	}))
+	events = append(events, claudeSSE("message_stop", adapter.ClaudeStreamEvent{Type: "message_stop"}))
+	return events
+}
+
+func (m *ClaudeStreamMapper) messageStart(id, model string) string {
+	m.started = true
+	return claudeSSE("message_start", adapter.ClaudeStreamEvent{
+		Type: "message_start",
+		Message: &adapter.ClaudeResponse{
+			ID:      id,
+			Type:    "message",
+			Role:    "assistant",
+			Model:   model,
+			Content: []adapter.ClaudeContentBlock{},
+		},
+	})
+}
+
+func (m *ClaudeStreamMapper) openBlock() int {
+	m.openIndex = m.nextIndex
+	m.nextIndex++
+	return m.openIndex
+}
+
+func (m *ClaudeStreamMapper) closeBlock() []string {
+	if m.openIndex == -1 {
+		return nil
+	}
+	evt := claudeSSE("content_block_stop", claudeBlockEvent{Type: "content_block_stop", Index: m.openIndex})
+	if m.openIndex == m.textIndex {
+		m.textIndex = -1
+	}
+	m.openIndex = -1
+	return []string{evt}
+}
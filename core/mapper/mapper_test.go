@@ -0,0 +1,80 @@
+package mapper
+
+import (
+	"llm-gateway/core/adapter"
+	"llm-gateway/models"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInboundMappers_StopSequences(t *testing.T) {
+	cReq := adapter.ClaudeRequest{
+		Model:         "claude",
+		Messages:      []adapter.ClaudeMessage{{Role: "user", Content: "Hello!"}},
+		StopSequences: []string{"END", "STOP"},
+	}
+	req, err := ClaudeRequestToOpenAI(cReq)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"END", "STOP"}, req.StopSequences())
+
+	gReq := adapter.GeminiRequest{
+		Contents:         []adapter.GeminiContent{{Role: "user", Parts: []adapter.GeminiPart{{Text: "Hello!"}}}},
+		GenerationConfig: &adapter.GeminiConfig{StopSequences: []string{"END"}},
+	}
+	req, err = GeminiRequestToOpenAI(gReq, "gemini-pro")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"END"}, req.StopSequences())
+}
+
+func TestClaudeStreamMapper_EventOrder(t *testing.T) {
+	eventTypes := func(events []string) []string {
+		var types []string
+		for _, evt := range events {
+			types = append(types, strings.TrimPrefix(strings.SplitN(evt, "\n", 2)[0], "event: "))
+		}
+		return types
+	}
+
+	m := NewClaudeStreamMapper()
+	var events []string
+	events = append(events, m.Map(models.ChatCompletionResponse{ID: "1", Choices: []models.ChatCompletionChoice{{Delta: models.ChatMessage{Content: "Hi"}}}})...)
+	events = append(events, m.Map(models.ChatCompletionResponse{ID: "1", Choices: []models.ChatCompletionChoice{{FinishReason: "length"}}})...)
+	events = append(events, m.Finish()...)
+	assert.Equal(t, []string{"message_start", "content_block_start", "content_block_delta", "content_block_stop", "message_delta", "message_stop"}, eventTypes(events))
+	assert.Contains(t, events[1], `"index":0`)
+	assert.Contains(t, events[4], `"stop_reason":"max_tokens"`)
+
+	// Finish 幂等
+	assert.Empty(t, m.Finish())
+
+	// 上游没有任何数据时仍输出完整的消息外壳
+	assert.Equal(t, []string{"message_start", "message_delta", "message_stop"}, eventTypes(NewClaudeStreamMapper().Finish()))
+}
+
+func TestClaudeStreamMapper_ToolUseWithoutIDOrToolFinish(t *testing.T) {
+	idx := func(i int) *int { return &i }
+	m := NewClaudeStreamMapper()
+	var events []string
+	events = append(events, m.Map(models.ChatCompletionResponse{ID: "1", Choices: []models.ChatCompletionChoice{{Delta: models.ChatMessage{
+		ToolCalls: []models.ChatToolCall{{Index: idx(0), Type: "function", Function: models.ChatToolCallFunc{Name: "get_time", Arguments: "{}"}}},
+	}}}})...)
+	events = append(events, m.Map(models.ChatCompletionResponse{ID: "1", Choices: []models.ChatCompletionChoice{{FinishReason: "stop"}}})...)
+	events = append(events, m.Finish()...)
+
+	// 上游未返回调用 ID 时生成 toolu_ 前缀的 ID；finish_reason 为 stop 时仍以 tool_use 结束
+	assert.Contains(t, events[1], `"id":"toolu_`)
+	assert.Contains(t, events[1], `"name":"get_time"`)
+	assert.Contains(t, events[len(events)-2], `"stop_reason":"tool_use"`)
+
+	resp := OpenAIResponseToClaude(models.ChatCompletionResponse{Choices: []models.ChatCompletionChoice{{
+		FinishReason: "stop",
+		Message:      models.ChatMessage{Role: "assistant", ToolCalls: []models.ChatToolCall{{Type: "function", Function: models.ChatToolCallFunc{Name: "get_time"}}}},
+	}}})
+	assert.Equal(t, "tool_use", *resp.StopReason)
+	if assert.Len(t, resp.Content, 1) {
+		assert.True(t, strings.HasPrefix(resp.Content[0].ID, "toolu_"))
+		assert.Equal(t, map[string]interface{}{}, resp.Content[0].Input)
+	}
+}
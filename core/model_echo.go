@@ -0,0 +1,119 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestModelKey Context 中记录客户端原始请求模型名的键 (路由与强制路由改写之前)
+const requestModelKey = "request_model"
+
+// modelEchoWriter 将响应中的 model 字段改写为客户端请求的模型名 (网关设置 echo_request_model)
+// 流式响应逐行改写 data 事件；非流式响应缓冲完整的响应体，在 finish 时改写后写出
+type modelEchoWriter struct {
+	gin.ResponseWriter
+
+	model   string
+	stream  bool
+	pending bytes.Buffer
+}
+
+func newModelEchoWriter(w gin.ResponseWriter, model string, stream bool) *modelEchoWriter {
+	return &modelEchoWriter{ResponseWriter: w, model: model, stream: stream}
+}
+
+func (w *modelEchoWriter) Write(b []byte) (int, error) {
+	w.pending.Write(b)
+	if !w.stream {
+		return len(b), nil
+	}
+	// 只处理完整的行，不完整的部分留到下次写入
+	data := w.pending.Bytes()
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		return len(b), nil
+	}
+	var out bytes.Buffer
+	for _, line := range bytes.SplitAfter(data[:end+1], []byte("\n")) {
+		out.Write(w.rewriteLine(line))
+	}
+	w.pending.Next(end + 1)
+	if _, err := w.ResponseWriter.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (w *modelEchoWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush 非流式响应在 finish 之前不向客户端输出
+func (w *modelEchoWriter) Flush() {
+	if w.stream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+// finish 写出剩余的缓冲内容 (非流式为完整的响应体)
+func (w *modelEchoWriter) finish() error {
+	if w.pending.Len() == 0 {
+		return nil
+	}
+	data := w.pending.Bytes()
+	if w.stream {
+		data = w.rewriteLine(data)
+	} else if rewritten, ok := rewriteModelField(data, w.model); ok {
+		data = rewritten
+	}
+	w.pending.Reset()
+	_, err := w.ResponseWriter.Write(data)
+	return err
+}
+
+// rewriteLine 改写 "data: {...}" 行中的 model 字段，其余行原样返回
+func (w *modelEchoWriter) rewriteLine(line []byte) []byte {
+	payload, ok := bytes.CutPrefix(line, []byte("data:"))
+	if !ok {
+		return line
+	}
+	trimmed := bytes.TrimSpace(payload)
+	rewritten, ok := rewriteModelField(trimmed, w.model)
+	if !ok {
+		return line
+	}
+	// 保留原有的行尾
+	suffix := payload[len(bytes.TrimRight(payload, "\r\n")):]
+	out := append([]byte("data: "), rewritten...)
+	return append(out, suffix...)
+}
+
+// rewriteModelField 将 JSON 对象中已有的 model 字段替换为 model，不含该字段或不是 JSON 对象时返回 false
+func rewriteModelField(data []byte, model string) ([]byte, bool) {
+	if len(data) == 0 || data[0] != '{' {
+		return nil, false
+	}
+	var obj map[string]json.RawMessage
+	if json.Unmarshal(data, &obj) != nil {
+		return nil, false
+	}
+	if _, ok := obj["model"]; !ok {
+		return nil, false
+	}
+	obj["model"], _ = json.Marshal(model)
+	out, err := json.Marshal(obj)
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+// echoRequestModel 返回需要回显的客户端请求模型名，未开启 echo_request_model 时返回空字符串
+func (h *ProxyHandler) echoRequestModel(c *gin.Context) string {
+	if settings := h.lb.GetGatewaySettings(); settings == nil || !settings.EchoRequestModel {
+		return ""
+	}
+	return c.GetString(requestModelKey)
+}
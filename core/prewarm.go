@@ -0,0 +1,81 @@
+package core
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// prewarmTimeout 单个上游预热请求的超时时间
+const prewarmTimeout = 5 * time.Second
+
+// upstreamOrigins 返回所有模型配置中不重复的上游地址 (scheme://host) 及其提供商
+func (lb *LoadBalancer) upstreamOrigins() map[string]string {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	origins := make(map[string]string)
+	for _, state := range lb.groupStates {
+		for _, m := range state.Models {
+			u, err := url.Parse(m.UpstreamURL)
+			if err != nil || u.Host == "" {
+				continue
+			}
+			origins[u.Scheme+"://"+u.Host] = m.ProviderName
+		}
+	}
+	return origins
+}
+
+// PrewarmConnections 向每个不重复的上游地址发送一个 HEAD 请求，提前建立 TCP/TLS 连接并放入连接池，
+// 降低部署后首个请求的延迟。上游返回任意状态码都视为成功；失败只记录日志，返回成功与失败的地址数
+func (h *ProxyHandler) PrewarmConnections(ctx context.Context) (warmed, failed int) {
+	origins := h.lb.upstreamOrigins()
+	keys := make([]string, 0, len(origins))
+	for origin := range origins {
+		keys = append(keys, origin)
+	}
+	sort.Strings(keys)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, origin := range keys {
+		wg.Add(1)
+		go func(origin, provider string) {
+			defer wg.Done()
+			err := h.prewarm(ctx, origin, provider)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				h.logger.Warnf("[Prewarm] %s: %v", origin, err)
+				failed++
+				return
+			}
+			warmed++
+		}(origin, origins[origin])
+	}
+	wg.Wait()
+	return warmed, failed
+}
+
+// prewarm 使用提供商对应的 Client 发送 HEAD 请求，响应体读完后连接回到连接池
+func (h *ProxyHandler) prewarm(ctx context.Context, origin, provider string) error {
+	ctx, cancel := context.WithTimeout(ctx, prewarmTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, strings.TrimSuffix(origin, "/")+"/", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "LLM-Gateway/2.0")
+	resp, err := h.clientFor(provider).Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
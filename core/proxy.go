@@ -1,27 +1,45 @@
 package core
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"llm-gateway/core/adapter"
 	"llm-gateway/models"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
 
-const (
-	MaxRetries = 3
-)
-
 // ProxyHandler 代理请求处理器
 type ProxyHandler struct {
 	lb          *LoadBalancer
 	httpClient  *http.Client
 	logger      *logrus.Logger
 	asyncLogger *AsyncRequestLogger
+
+	// 自定义内容过滤器 (如外部审核服务)，设置后取代网关设置中的正则过滤器
+	requestFilter  RequestFilter
+	responseFilter ResponseFilter
+
+	// 按提供商调优传输层超时的 Client (键为小写的提供商名)，未配置的提供商使用 httpClient
+	providerClients map[string]*http.Client
+
+	// 全局并发上限 (保护网关进程自身的文件描述符与内存)，nil 表示不限制
+	inflightSem chan struct{}
+	inflight    atomic.Int64
 }
 
 // NewProxyHandler 创建新的代理处理器
@@ -34,27 +52,326 @@ func NewProxyHandler(lb *LoadBalancer, client *http.Client, logger *logrus.Logge
 	}
 }
 
-func getClientIP(c *gin.Context) string {
-	if xff := c.GetHeader("X-Forwarded-For"); xff != "" {
-		if idx := strings.Index(xff, ","); idx != -1 {
-			return strings.TrimSpace(xff[:idx])
+// RequestLogger 返回异步请求日志记录器，未配置时为 nil
+func (h *ProxyHandler) RequestLogger() *AsyncRequestLogger {
+	return h.asyncLogger
+}
+
+// TransportTuning 提供商级别的传输层超时，零值表示沿用默认 Client 的设置
+type TransportTuning struct {
+	ResponseHeaderTimeout time.Duration // 等待上游响应头的时间 (图像生成、长推理模型需要更长)
+	TLSHandshakeTimeout   time.Duration
+}
+
+// SetProviderTransport 为指定提供商设置独立的传输层超时
+// 基于默认 Client 的 Transport 复制一份并应用调优参数，需在开始处理请求前调用
+func (h *ProxyHandler) SetProviderTransport(provider string, tuning TransportTuning) {
+	base, ok := h.httpClient.Transport.(*http.Transport)
+	if !ok || base == nil {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+	transport := base.Clone()
+	if tuning.ResponseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = tuning.ResponseHeaderTimeout
+	}
+	if tuning.TLSHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = tuning.TLSHandshakeTimeout
+	}
+
+	client := *h.httpClient
+	client.Transport = transport
+	if h.providerClients == nil {
+		h.providerClients = make(map[string]*http.Client)
+	}
+	h.providerClients[strings.ToLower(provider)] = &client
+}
+
+// clientFor 返回提供商对应的 HTTP Client
+func (h *ProxyHandler) clientFor(provider string) *http.Client {
+	if client, ok := h.providerClients[strings.ToLower(provider)]; ok {
+		return client
+	}
+	return h.httpClient
+}
+
+// SetMaxInFlight 设置全局同时处理的请求数上限，<= 0 表示不限制；需在开始处理请求前调用
+func (h *ProxyHandler) SetMaxInFlight(n int) {
+	h.inflightSem = nil
+	if n > 0 {
+		h.inflightSem = make(chan struct{}, n)
+	}
+}
+
+// MaxInFlight 返回全局并发上限，0 表示不限制
+func (h *ProxyHandler) MaxInFlight() int {
+	return cap(h.inflightSem)
+}
+
+// InFlight 返回当前正在处理的代理请求数
+func (h *ProxyHandler) InFlight() int64 {
+	return h.inflight.Load()
+}
+
+// 强制路由请求头 (测试用)：指定组与组内模型序号 (从 1 开始)，绕过组策略
+const (
+	headerForceGroup      = "X-Force-Group"
+	headerForceModelIndex = "X-Force-Model-Index"
+)
+
+// headerUpstreamBase 请求级上游地址覆盖 (迁移验证用)：本次请求改用该地址代替模型配置的 upstream_url，仅管理员凭证可用
+const headerUpstreamBase = "X-Upstream-Base"
+
+// upstreamBaseKey Context 中记录已校验的上游地址覆盖的键
+const upstreamBaseKey = "upstream_base"
+
+// ScopeAdmin Context 中 admin_scope 的管理员取值，持有时才可使用 X-Upstream-Base
+const ScopeAdmin = "admin"
+
+// inheritAdminAuth 将管理员身份复制到内部 ProxyRequest 使用的 Context (强制路由、上游地址覆盖与审计日志依赖)
+func inheritAdminAuth(dst, src *gin.Context) {
+	dst.Set("admin_auth", src.GetString("admin_auth"))
+	dst.Set("admin_name", src.GetString("admin_name"))
+	dst.Set("admin_scope", src.GetString("admin_scope"))
+	if id, ok := src.Get("admin_id"); ok {
+		dst.Set("admin_id", id)
+	}
+	dst.Set("admin_app_name", src.GetString("admin_app_name"))
+	dst.Set("admin_app_referer", src.GetString("admin_app_referer"))
+	if groups, ok := src.Get("admin_groups"); ok {
+		dst.Set("admin_groups", groups)
+	}
+}
+
+// checkGroupAccess 密钥限定了可访问的组时，请求路由到的组不在其中返回 403
+func (h *ProxyHandler) checkGroupAccess(c *gin.Context, requestModel string) bool {
+	allowed := c.GetStringSlice("admin_groups")
+	if len(allowed) == 0 {
+		return true
+	}
+	groupID := h.lb.GroupOf(requestModel)
+	if groupID == "" || slices.Contains(allowed, groupID) {
+		// 组不存在时由路由返回原有的错误
+		return true
+	}
+
+	h.reqLog(c).Warnf("Key %s is not allowed to access group %s", c.GetString("admin_name"), groupID)
+	c.JSON(403, models.ErrorResponse{Error: models.ErrorDetail{
+		Message: fmt.Sprintf("This API key is not allowed to access model '%s'", requestModel),
+		Type:    "permission_error",
+		Param:   "model",
+		Code:    "group_not_allowed",
+	}})
+	return false
+}
+
+// 应用归属请求头 (OpenRouter 等按应用统计/计费的上游使用)，值来自管理员密钥的 app_name / app_referer
+const (
+	headerAppTitle   = "X-Title"
+	headerAppReferer = "HTTP-Referer"
+)
+
+// applyAttribution 为上游请求添加当前管理员密钥配置的应用归属请求头
+func applyAttribution(c *gin.Context, req *http.Request) {
+	if name := c.GetString("admin_app_name"); name != "" {
+		req.Header.Set(headerAppTitle, name)
+	}
+	if referer := c.GetString("admin_app_referer"); referer != "" {
+		req.Header.Set(headerAppReferer, referer)
+	}
+}
+
+// applyUpstreamBase 校验 X-Upstream-Base 请求头并记录审计日志，不具有管理员权限的凭证返回 403，地址无效返回 400
+func (h *ProxyHandler) applyUpstreamBase(c *gin.Context, req models.ChatCompletionRequest) bool {
+	base := strings.TrimSpace(c.GetHeader(headerUpstreamBase))
+	if base == "" {
+		return true
+	}
+	if c.GetString("admin_scope") != ScopeAdmin {
+		h.reqLog(c).Warnf("Rejected %s header from non-admin caller %s", headerUpstreamBase, c.GetString("admin_name"))
+		c.JSON(403, models.ErrorResponse{Error: models.ErrorDetail{
+			Message: headerUpstreamBase + " requires an admin credential",
+			Type:    "permission_error",
+		}})
+		return false
+	}
+	if u, err := url.Parse(base); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(400, models.ErrorResponse{Error: models.ErrorDetail{
+			Message: fmt.Sprintf("%s must be an absolute http(s) URL", headerUpstreamBase),
+			Type:    "invalid_request_error",
+		}})
+		return false
+	}
+
+	h.reqLog(c).Warnf("Upstream base overridden by %s: %s", c.GetString("admin_name"), base)
+	audit := models.AuditLog{
+		AdminID:   c.GetUint("admin_id"),
+		AdminName: c.GetString("admin_name"),
+		AuthType:  c.GetString("admin_auth"),
+		Action:    "override_upstream_base",
+		Target:    "group:" + req.Model,
+		Detail:    base,
+		IP:        c.ClientIP(),
+	}
+	if err := h.lb.GetDB().Create(&audit).Error; err != nil {
+		h.reqLog(c).Warnf("Failed to write audit log for %s: %v", headerUpstreamBase, err)
+	}
+	c.Set(upstreamBaseKey, base)
+	return true
+}
+
+// overrideUpstreamBase 存在已校验的上游地址覆盖时替换路由的 UpstreamURL
+func overrideUpstreamBase(c *gin.Context, routing *models.RoutingInfo) {
+	if base := c.GetString(upstreamBaseKey); base != "" {
+		routing.UpstreamURL = base
+	}
+}
+
+// applyForcedRoute 按强制路由请求头改写请求模型为 "group$index"，仅对管理员凭证生效
+// 组不存在或序号越界返回 400，限定了可访问组的密钥返回 403，均返回 false
+func (h *ProxyHandler) applyForcedRoute(c *gin.Context, req *models.ChatCompletionRequest) bool {
+	group := strings.TrimSpace(c.GetHeader(headerForceGroup))
+	rawIndex := strings.TrimSpace(c.GetHeader(headerForceModelIndex))
+	if group == "" && rawIndex == "" {
+		return true
+	}
+	if c.GetString("admin_auth") == "" {
+		h.reqLog(c).Warnf("Ignoring %s/%s headers from non-admin caller", headerForceGroup, headerForceModelIndex)
+		return true
+	}
+	if len(c.GetStringSlice("admin_groups")) > 0 {
+		h.reqLog(c).Warnf("Rejected %s/%s headers from group-restricted key %s", headerForceGroup, headerForceModelIndex, c.GetString("admin_name"))
+		c.JSON(403, models.ErrorResponse{Error: models.ErrorDetail{
+			Message: headerForceGroup + "/" + headerForceModelIndex + " cannot be used with a group-restricted API key",
+			Type:    "permission_error",
+		}})
+		return false
+	}
+
+	index := 0
+	var err error
+	if rawIndex != "" {
+		if index, err = strconv.Atoi(rawIndex); err != nil || index < 1 {
+			err = fmt.Errorf("%s must be a positive integer", headerForceModelIndex)
+		}
+	}
+	var model string
+	if err == nil {
+		model, err = h.lb.ForcedModel(req.Model, group, index)
+	}
+	if err != nil {
+		c.JSON(400, models.ErrorResponse{Error: models.ErrorDetail{
+			Message: err.Error(),
+			Type:    "invalid_request_error",
+		}})
+		return false
+	}
+
+	h.reqLog(c).Infof("Forced route: %s -> %s", req.Model, model)
+	req.Model = model
+	return true
+}
+
+// WaitIdle 等待所有正在处理的代理请求结束 (关闭服务时使用，包括已被接管的 WebSocket 连接)
+func (h *ProxyHandler) WaitIdle(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for h.InFlight() > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d requests still in flight: %w", h.InFlight(), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// acquireSlot 占用一个并发名额，已满时返回 503 与 Retry-After 并返回 false
+func (h *ProxyHandler) acquireSlot(c *gin.Context) bool {
+	if h.inflightSem != nil {
+		select {
+		case h.inflightSem <- struct{}{}:
+		default:
+			h.reqLog(c).Warnf("Rejecting request: %d requests in flight", h.MaxInFlight())
+			c.Header("Retry-After", "1")
+			c.JSON(503, models.ErrorResponse{Error: models.ErrorDetail{
+				Message: "The gateway is at capacity, please retry later",
+				Type:    "server_error",
+				Code:    "gateway_overloaded",
+			}})
+			return false
 		}
-		return strings.TrimSpace(xff)
 	}
-	if xri := c.GetHeader("X-Real-IP"); xri != "" {
-		return strings.TrimSpace(xri)
+	h.inflight.Add(1)
+	return true
+}
+
+func (h *ProxyHandler) releaseSlot() {
+	h.inflight.Add(-1)
+	if h.inflightSem != nil {
+		<-h.inflightSem
+	}
+}
+
+// SetRequestFilter 设置自定义请求过滤器，传入 nil 恢复使用网关设置中的规则
+func (h *ProxyHandler) SetRequestFilter(f RequestFilter) {
+	h.requestFilter = f
+}
+
+// SetResponseFilter 设置自定义响应过滤器，传入 nil 恢复使用网关设置中的规则
+func (h *ProxyHandler) SetResponseFilter(f ResponseFilter) {
+	h.responseFilter = f
+}
+
+// prepareUpstreamRequest 应用模型级请求改写规则，并将 max_tokens 下调到模型的输出上限
+func (h *ProxyHandler) prepareUpstreamRequest(c *gin.Context, routing *models.RoutingInfo, requestData models.ChatCompletionRequest) models.ChatCompletionRequest {
+	req := routing.RequestOverrides.Apply(requestData)
+	if limit := routing.MaxOutputTokens; limit > 0 && req.MaxTokens != nil && *req.MaxTokens > limit {
+		h.reqLog(c).Infof("Clamped max_tokens %d -> %d for %s", *req.MaxTokens, limit, routing.UpstreamModel)
+		req.MaxTokens = &limit
+	}
+	return req
+}
+
+// KnownProvider 判断提供商名称是否有专用适配器 (其余名称按 OpenAI 兼容接口处理)
+func KnownProvider(name string) bool {
+	switch strings.ToLower(name) {
+	case "openai", "gemini", "claude", "anthropic":
+		return true
+	}
+	return false
+}
+
+// ProviderCapabilities 返回提供商适配器的默认能力
+func ProviderCapabilities(name string) models.Capabilities {
+	switch strings.ToLower(name) {
+	case "gemini":
+		return adapter.NewGeminiAdapter().Capabilities()
+	case "claude", "anthropic":
+		return adapter.NewClaudeAdapter().Capabilities()
+	default:
+		return adapter.NewOpenAIAdapter().Capabilities()
 	}
-	return c.ClientIP()
 }
 
-func (h *ProxyHandler) getAdapter(provider string) adapter.ProviderAdapter {
-	switch strings.ToLower(provider) {
+func (h *ProxyHandler) getAdapter(routing *models.RoutingInfo) adapter.ProviderAdapter {
+	switch strings.ToLower(routing.Provider) {
 	case "gemini":
-		return adapter.NewGeminiAdapter()
+		gemini := adapter.NewGeminiAdapter()
+		gemini.IdentityPatch = routing.IdentityPatch
+		gemini.IdentityPatchText = routing.IdentityPatchText
+		gemini.HideThoughts = routing.HideThoughts
+		gemini.Search = routing.GoogleSearch
+		gemini.UpstreamPath = routing.UpstreamPath
+		return gemini
 	case "claude", "anthropic":
-		return adapter.NewClaudeAdapter()
+		claude := adapter.NewClaudeAdapter()
+		claude.DefaultMaxTokens = routing.DefaultMaxTokens
+		claude.UpstreamPath = routing.UpstreamPath
+		return claude
 	default:
-		return adapter.NewOpenAIAdapter()
+		openai := adapter.NewOpenAIAdapter()
+		openai.UpstreamPath = routing.UpstreamPath
+		return openai
 	}
 }
 
@@ -74,103 +391,582 @@ func (h *ProxyHandler) HandleProxyRequest() gin.HandlerFunc {
 
 // ProxyRequest 处理代理请求 (包含重试逻辑)
 func (h *ProxyHandler) ProxyRequest(c *gin.Context, requestData models.ChatCompletionRequest) {
-	// startTime := time.Now() // 保留用于耗时计算，但实际上中间件也在算
+	startTime := time.Now()
+	attempts := 0
+	bindRequestID(c)
+	defer func() {
+		h.logAccess(c, requestData.Model, startTime, attempts)
+	}()
+	c.Set(requestModelKey, requestData.Model)
+
+	// 流式请求记录首字节耗时 (TTFT)
+	if requestData.Stream {
+		original := c.Writer
+		c.Writer = newFirstByteWriter(c, startTime)
+		defer func() { c.Writer = original }()
+	}
+
+	if !h.acquireSlot(c) {
+		return
+	}
+	defer h.releaseSlot()
+
+	// 请求内容过滤 (路由前)
+	if !h.filterRequest(c, &requestData) {
+		return
+	}
+
+	if !h.applyForcedRoute(c, &requestData) {
+		return
+	}
+	if !h.applyUpstreamBase(c, requestData) {
+		return
+	}
+	if !h.checkGroupAccess(c, requestData.Model) {
+		return
+	}
+
+	// 消息条数/字符数超限的请求不发往上游，避免占用配额
+	if !h.checkContextLimits(c, requestData) {
+		return
+	}
+	if !h.checkCapabilities(c, requestData) {
+		return
+	}
+
+	// 组级限流在转发前预扣，结束后按实际用量修正
+	reconcile, ok := h.acquireGroupBudget(c, requestData)
+	if !ok {
+		return
+	}
+	defer reconcile()
+
+	// 总超时预算：截止时间传递给每次尝试的上游请求
+	budget := requestTimeoutBudget(c, requestData)
+	if budget > 0 {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), budget)
+		defer cancel()
+		originalReq := c.Request
+		c.Request = c.Request.WithContext(ctx)
+		defer func() { c.Request = originalReq }()
+	}
+
+	// 上游全部不可用时按组的 on_exhausted 策略处理，fallback_group 会切换到其他组继续尝试
+	tried := make(map[string]bool)
+	for {
+		n, exhausted := h.proxyGroup(c, requestData, budget)
+		attempts += n
+		if exhausted == nil {
+			return
+		}
+		next, ok := h.handleExhausted(c, requestData, exhausted, tried)
+		if !ok {
+			return
+		}
+		// fallback 组同样受密钥的组限制
+		if !h.checkGroupAccess(c, next) {
+			return
+		}
+		requestData.Model = next
+	}
+}
+
+// proxyGroup 在请求模型所在的组内执行竞速或重试，返回发出的上游请求数
+// 返回 error 表示组内上游全部不可用 (路由失败或重试耗尽)，此时尚未向客户端写出响应
+func (h *ProxyHandler) proxyGroup(c *gin.Context, requestData models.ChatCompletionRequest, budget time.Duration) (attempts int, exhausted error) {
+	// 竞速模式 (仅非流式)
+	if !requestData.Stream {
+		if k := h.lb.RaceCount(requestData.Model); k > 1 {
+			return h.raceRequest(c, requestData, k, budget)
+		}
+	}
 
 	var lastErr error
 	var routing *models.RoutingInfo
+	maxRetries := h.lb.MaxRetries(requestData.Model)
 	
 	// --- 重试循环 ---
-	for i := 0; i < MaxRetries; i++ {
+	for i := 0; i < maxRetries; i++ {
+		// 超时预算耗尽时不再重试
+		if budgetExceeded(c) {
+			h.writeBudgetExceeded(c, budget, attempts)
+			return
+		}
+
 		// 1. 获取路由 (每次重试都重新获取，以避开已标记为 Cooldown 的 Key)
 		var err error
-		routing, err = h.lb.Route(requestData.Model)
+		routing, err = h.lb.RouteFor(requestData.Model, models.RequiredCapabilities(requestData))
 		if err != nil {
 			// 如果连路由都找不到（比如所有 Key 都挂了），直接退出
-			h.logger.Warnf("[Attempt %d] Routing failed: %v", i+1, err)
+			h.reqLog(c).Warnf("[Attempt %d] Routing failed: %v", i+1, err)
 			lastErr = err
 			break
 		}
+		// 请求结束 (包括出错返回) 时释放 Key 的进行中计数，重试前会提前释放
+		defer h.lb.ReleaseKey(routing)
+		overrideUpstreamBase(c, routing)
 
-		h.logger.Infof("[Attempt %d] Selected upstream: %s (%s) | Key: ...%s", 
-			i+1, routing.UpstreamURL, routing.UpstreamModel,  safeKeyMask(routing.APIKey))
+		h.reqLog(c).Infof("[Attempt %d] Selected upstream: %s (%s) | Key: %s", 
+			i+1, routing.UpstreamURL, routing.UpstreamModel,  models.MaskAPIKey(routing.APIKey))
 
 		// 为中间件设置路由信息
 		c.Set("routing_info", routing)
 
 		// 2. 获取适配器
-		adp := h.getAdapter(routing.Provider)
+		adp := h.getAdapter(routing)
 		
 		// 3. 转换请求
-		req, err := adp.ConvertRequest(c, requestData, routing.APIKey, routing.UpstreamURL, routing.UpstreamModel)
+		// 应用模型级请求改写规则与输出 Token 限制
+		upstreamReq := h.prepareUpstreamRequest(c, routing, requestData)
+		req, err := adp.ConvertRequest(c, upstreamReq, routing.APIKey, routing.UpstreamURL, routing.UpstreamModel)
 		if err != nil {
-			h.logger.Errorf("Request conversion failed: %v", err)
-			c.JSON(500, gin.H{"error": "Internal Adapter Error"})
-			return // 内部错误不重试
+			h.writeConvertError(c, err)
+			return // 转换错误不重试
 		}
+		applyAttribution(c, req)
 
 		// 4. 发起请求
-		resp, err := h.httpClient.Do(req)
-		
-		// --- 错误处理与状态反馈 ---
-		if err != nil {
-			// 网络层面错误 (DNS, Timeout, Refused)
-			h.logger.Warnf("Upstream network error: %v", err)
-			h.lb.keyManager.MarkCooldown(routing.APIKey, 10*time.Second) // 短暂冷却
-			lastErr = err
-			continue // 立即重试
+		attempts++
+		resp, err := h.clientFor(routing.Provider).Do(req)
+
+		// 因超时预算耗尽而失败的请求不惩罚 Key
+		if err != nil && budgetExceeded(c) {
+			h.writeBudgetExceeded(c, budget, attempts)
+			return
 		}
 		
-		// finalRespStatusCode = resp.StatusCode // Variable removed
-
-		// 429 Too Many Requests
-		if resp.StatusCode == 429 {
-			resp.Body.Close()
-			h.logger.Warnf("Upstream 429 (Rate Limit). Marking key cooldown.")
-			h.lb.keyManager.MarkCooldown(routing.APIKey, 60*time.Second) // 标准冷却
-			lastErr = fmt.Errorf("upstream rate limit (429)")
-			continue // 重试
-		}
-
-		// 401/403 Auth Error
-		if resp.StatusCode == 401 || resp.StatusCode == 403 {
-			resp.Body.Close()
-			h.logger.Errorf("Upstream Auth Error (%d). Marking key dead.", resp.StatusCode)
-			h.lb.keyManager.MarkDead(routing.APIKey) // 永久拉黑
-			lastErr = fmt.Errorf("upstream auth error (%d)", resp.StatusCode)
+		// --- 错误处理与状态反馈 ---
+		if failErr := h.checkUpstream(c, routing, resp, err); failErr != nil {
+			lastErr = failErr
+			h.lb.ReleaseKey(routing)
 			continue // 重试
 		}
 
-		// 5xx Server Error (Optional: 可以选择重试)
-		if resp.StatusCode >= 500 {
-			resp.Body.Close()
-			h.logger.Warnf("Upstream Server Error (%d).", resp.StatusCode)
-			h.lb.keyManager.MarkCooldown(routing.APIKey, 30*time.Second) // 避开故障节点
-			lastErr = fmt.Errorf("upstream server error (%d)", resp.StatusCode)
-			continue // 重试
+		// 状态码之外，按响应体中的错误信息识别临时故障 (网关设置 retryable_errors)
+		if failErr := h.checkRetryableError(c, routing, resp, requestData.Stream); failErr != nil {
+			lastErr = failErr
+			h.lb.ReleaseKey(routing)
+			continue
 		}
 
 		// --- 成功 (200 OK 或其他非重试状态码) ---
 		defer resp.Body.Close()
 		
 		// 处理响应
-		err = adp.HandleResponse(c, resp, requestData.Stream)
+		// 仅在上游返回 200 后才进入流式模式 (写出 SSE 头)；
+		// 其余非重试状态码 (如 400) 按普通响应透传，避免客户端收到带 SSE 头的错误体
+		stream := requestData.Stream && resp.StatusCode == 200
+
+		// 空流重试 (可选)：最后一次尝试直接透传，不掩盖真正的空回复
+		if stream && i < maxRetries-1 && h.lb.GetGatewaySettings().RetryEmptyStream {
+			if retry, err := h.handleGuardedStream(c, adp, resp); retry {
+				resp.Body.Close()
+				h.reqLog(c).Warnf("[Attempt %d] Empty upstream stream (%v), retrying", i+1, err)
+				h.lb.keyManager.MarkCooldown(routing.APIKey, 5*time.Second) // 短暂避开该 Key，重试时选择其他 Key/模型
+				lastErr = errEmptyStream
+				h.lb.ReleaseKey(routing)
+				continue
+			} else if err != nil {
+				h.reqLog(c).Errorf("Failed to handle response: %v", err)
+			}
+			return
+		}
+
+		err = h.handleResponse(c, adp, resp, stream)
+		if isMalformedResponse(err) && !c.Writer.Written() {
+			// 响应体无法解析时尚未写出任何内容：换用其他 Key/模型重试，最后一次尝试返回 502
+			h.logMalformedResponse(c, i+1, err)
+			if i < maxRetries-1 {
+				h.lb.keyManager.MarkCooldown(routing.APIKey, 5*time.Second)
+				lastErr = err
+				h.lb.ReleaseKey(routing)
+				continue
+			}
+			h.writeMalformedResponse(c)
+			return
+		}
 		if err != nil {
-			h.logger.Errorf("Failed to handle response: %v", err)
+			h.reqLog(c).Errorf("Failed to handle response: %v", err)
 		}
 		
 		return
 	}
 
 	// --- 重试耗尽 ---
-	h.logger.Errorf("All %d retries failed. Last error: %v", MaxRetries, lastErr)
-	c.JSON(502, gin.H{
-		"error": fmt.Sprintf("Upstream unavailable after %d retries. Last error: %v", MaxRetries, lastErr),
+	h.reqLog(c).Errorf("All %d retries failed. Last error: %v", maxRetries, lastErr)
+	return attempts, fmt.Errorf("Upstream unavailable after %d retries. Last error: %v", maxRetries, lastErr)
+}
+
+// on_exhausted 策略取值
+const (
+	ExhaustedError          = "error"
+	ExhaustedFallbackPrefix = "fallback_group:"
+	ExhaustedStaticMessage  = "static_message"
+)
+
+// handleExhausted 按组的 on_exhausted 策略处理上游全部不可用的请求
+// 返回 true 时调用方应改用返回的组重新尝试；返回 false 时已写出响应。
+// tried 记录已尝试过的组，防止 fallback 形成循环
+func (h *ProxyHandler) handleExhausted(c *gin.Context, requestData models.ChatCompletionRequest, exhausted error, tried map[string]bool) (string, bool) {
+	groupID, policy, message := h.lb.ExhaustedPolicy(requestData.Model)
+	tried[groupID] = true
+
+	switch {
+	case strings.HasPrefix(policy, ExhaustedFallbackPrefix):
+		target := strings.TrimPrefix(policy, ExhaustedFallbackPrefix)
+		if target != "" && !tried[target] && !budgetExceeded(c) {
+			h.reqLog(c).Warnf("Group %s exhausted, falling back to group %s", groupID, target)
+			return target, true
+		}
+		h.reqLog(c).Warnf("Group %s exhausted, fallback group %q already tried", groupID, target)
+	case policy == ExhaustedStaticMessage:
+		h.reqLog(c).Warnf("Group %s exhausted, returning static message", groupID)
+		// 固定回复不计入任何模型的统计
+		c.Set("routing_info", &models.RoutingInfo{GroupID: groupID})
+		writeStaticMessage(c, requestData, message)
+		return "", false
+	}
+
+	c.JSON(502, gin.H{"error": exhausted.Error()})
+	return "", false
+}
+
+// writeStaticMessage 以 OpenAI 格式 (流式请求为 SSE) 返回固定的助手回复
+func writeStaticMessage(c *gin.Context, requestData models.ChatCompletionRequest, message string) {
+	resp := models.ChatCompletionResponse{
+		ID:      "chatcmpl-" + requestID(c),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   requestData.Model,
+		Choices: []models.ChatCompletionChoice{{
+			Message:      models.ChatMessage{Role: "assistant", Content: message},
+			FinishReason: "stop",
+		}},
+	}
+	if !requestData.Stream {
+		c.JSON(200, resp)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(200)
+
+	resp.Object = "chat.completion.chunk"
+	resp.Choices[0].Delta = resp.Choices[0].Message
+	resp.Choices[0].Message = models.ChatMessage{}
+	data, _ := json.Marshal(resp)
+	fmt.Fprintf(c.Writer, "data: %s\n\n", data)
+	fmt.Fprintf(c.Writer, "data: [DONE]\n\n")
+	c.Writer.Flush()
+}
+
+// requestTimeoutBudget 解析客户端指定的总超时预算
+// 优先使用 X-Request-Timeout-Ms 请求头，其次使用请求体中的 request_timeout (毫秒)，未指定时返回 0
+func requestTimeoutBudget(c *gin.Context, req models.ChatCompletionRequest) time.Duration {
+	if v := c.GetHeader("X-Request-Timeout-Ms"); v != "" {
+		if ms, err := strconv.Atoi(v); err == nil && ms > 0 {
+			return time.Duration(ms) * time.Millisecond
+		}
+	}
+	if req.RequestTimeout != nil && *req.RequestTimeout > 0 {
+		return time.Duration(*req.RequestTimeout) * time.Millisecond
+	}
+	return 0
+}
+
+// budgetExceeded 判断超时预算是否已耗尽
+func budgetExceeded(c *gin.Context) bool {
+	return errors.Is(c.Request.Context().Err(), context.DeadlineExceeded)
+}
+
+func (h *ProxyHandler) writeBudgetExceeded(c *gin.Context, budget time.Duration, attempts int) {
+	c.Set(errorTypeKey, ErrorTypeTimeout)
+	h.reqLog(c).Warnf("Request timeout budget of %dms exceeded after %d attempts", budget.Milliseconds(), attempts)
+	c.JSON(504, gin.H{
+		"error": fmt.Sprintf("Request timeout budget of %dms exceeded after %d attempts", budget.Milliseconds(), attempts),
 	})
 }
 
-func safeKeyMask(k string) string {
-	if len(k) < 8 {
-		return "***"
+// checkContextLimits 检查消息条数与消息内容总字符数，超出组或全局上限时返回 400
+func (h *ProxyHandler) checkContextLimits(c *gin.Context, req models.ChatCompletionRequest) bool {
+	maxMessages, maxChars := h.lb.ContextLimits(req.Model)
+
+	var message string
+	if maxMessages > 0 && len(req.Messages) > maxMessages {
+		message = fmt.Sprintf("Too many messages: %d, the maximum is %d", len(req.Messages), maxMessages)
+	} else if maxChars > 0 {
+		chars := 0
+		for i := range req.Messages {
+			chars += utf8.RuneCountInString(req.Messages[i].StringContent())
+		}
+		if chars > maxChars {
+			message = fmt.Sprintf("Messages are too long: %d characters, the maximum is %d", chars, maxChars)
+		}
+	}
+	if message == "" {
+		return true
+	}
+
+	h.reqLog(c).Warnf("Request rejected: %s", message)
+	c.JSON(400, models.ErrorResponse{Error: models.ErrorDetail{
+		Message: message,
+		Type:    "invalid_request_error",
+		Param:   "messages",
+		Code:    "context_limit_exceeded",
+	}})
+	return false
+}
+
+// checkCapabilities 请求用到了组内 (固定序号时为该模型) 没有模型支持的特性 (如图片输入发往纯文本模型) 时返回 400
+// 部分模型支持时由路由跳过不支持的模型
+func (h *ProxyHandler) checkCapabilities(c *gin.Context, req models.ChatCompletionRequest) bool {
+	err := h.lb.CheckCapabilities(req.Model, models.RequiredCapabilities(req))
+	var capErr *CapabilityError
+	if !errors.As(err, &capErr) {
+		return true
+	}
+
+	message := fmt.Sprintf("The model '%s' does not support: %s", req.Model, strings.Join(capErr.Missing, ", "))
+	h.reqLog(c).Warnf("Request rejected: %s", message)
+	c.JSON(400, models.ErrorResponse{Error: models.ErrorDetail{
+		Message: message,
+		Type:    "invalid_request_error",
+		Param:   "model",
+		Code:    "unsupported_capability",
+	}})
+	return false
+}
+
+// isMalformedResponse 判断错误是否为上游响应体无法解析 (adapter.MalformedResponseError)
+func isMalformedResponse(err error) bool {
+	var malformed *adapter.MalformedResponseError
+	return errors.As(err, &malformed)
+}
+
+// logMalformedResponse 记录无法解析的上游响应 (原始响应体已截断)
+func (h *ProxyHandler) logMalformedResponse(c *gin.Context, attempt int, err error) {
+	var malformed *adapter.MalformedResponseError
+	errors.As(err, &malformed)
+	h.reqLog(c).Warnf("[Attempt %d] %v | Body: %q", attempt, malformed, malformed.Body)
+}
+
+// writeMalformedResponse 上游响应无法解析时返回 502 (OpenAI 错误格式)
+func (h *ProxyHandler) writeMalformedResponse(c *gin.Context) {
+	c.Set(errorTypeKey, ErrorTypeServer)
+	c.JSON(502, models.ErrorResponse{Error: models.ErrorDetail{
+		Message: "The upstream provider returned a malformed response",
+		Type:    "upstream_error",
+		Code:    "bad_upstream_response",
+	}})
+}
+
+// writeConvertError 根据请求转换错误返回响应
+// 上游不支持的参数返回 400 (OpenAI 错误格式)，其余视为内部错误
+func (h *ProxyHandler) writeConvertError(c *gin.Context, err error) {
+	var paramErr *adapter.UnsupportedParamError
+	if errors.As(err, &paramErr) {
+		h.reqLog(c).Warnf("Unsupported request parameter: %v", err)
+		c.JSON(400, models.ErrorResponse{
+			Error: models.ErrorDetail{Message: paramErr.Message, Type: "invalid_request_error", Param: paramErr.Param},
+		})
+		return
+	}
+	h.reqLog(c).Errorf("Request conversion failed: %v", err)
+	c.JSON(500, gin.H{"error": "Internal Adapter Error"})
+}
+
+// 请求失败分类 (RequestLog.ErrorType)
+const (
+	ErrorTypeNetwork   = "network"
+	ErrorTypeTimeout   = "timeout"
+	ErrorTypeAuth      = "auth"
+	ErrorTypeRateLimit = "rate_limit"
+	ErrorTypeClient    = "client"
+	ErrorTypeServer    = "server"
+)
+
+// errorTypeKey Context 中记录最近一次失败分类的键，由请求日志中间件写入 RequestLog
+const errorTypeKey = "error_type"
+
+// requestTypeKey Context 中记录请求类型的键 (models.RequestTypeEmbeddings 等)，未设置时按聊天请求统计
+const requestTypeKey = "request_type"
+
+// ClassifyError 按上游 (或网关) 的状态码与网络错误对失败分类，与 checkUpstream 的处理方式一致
+// 成功 (状态码 < 400 且无错误) 时返回空字符串
+func ClassifyError(status int, err error) string {
+	if err != nil {
+		var netErr net.Error
+		if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+			return ErrorTypeTimeout
+		}
+		return ErrorTypeNetwork
+	}
+	switch {
+	case status == 401 || status == 403:
+		return ErrorTypeAuth
+	case status == 429:
+		return ErrorTypeRateLimit
+	case status == 408 || status == 504:
+		return ErrorTypeTimeout
+	case status >= 500:
+		return ErrorTypeServer
+	case status >= 400:
+		return ErrorTypeClient
+	}
+	return ""
+}
+
+// checkUpstream 检查上游响应并更新 Key 状态
+// 返回 nil 表示响应可交给适配器处理 (200 或其他非重试状态码)；
+// 返回 error 表示需要重试，此时响应 Body 已关闭。
+func (h *ProxyHandler) checkUpstream(c *gin.Context, routing *models.RoutingInfo, resp *http.Response, err error) error {
+	if err != nil {
+		// 网络层面错误 (DNS, Timeout, Refused)
+		h.reqLog(c).Warnf("Upstream network error: %v", err)
+		c.Set(errorTypeKey, ClassifyError(0, err))
+		h.lb.keyManager.MarkCooldown(routing.APIKey, 10*time.Second) // 短暂冷却
+		h.lb.RecordModelResult(routing.ModelConfigID, false)
+		return err
+	}
+
+	h.trackRateLimit(c, routing, resp)
+	// 最终透传给客户端的响应按其状态码分类 (成功时清除之前尝试的失败分类)
+	c.Set(errorTypeKey, ClassifyError(resp.StatusCode, nil))
+
+	// 429 Too Many Requests
+	if resp.StatusCode == 429 {
+		resp.Body.Close()
+		cooldown, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		if !ok {
+			cooldown = h.rateLimitCooldown()
+		}
+		h.reqLog(c).Warnf("Upstream 429 (Rate Limit). Marking key cooldown for %s.", cooldown)
+		h.lb.keyManager.MarkCooldown(routing.APIKey, cooldown)
+		return fmt.Errorf("upstream rate limit (429)")
+	}
+
+	// 401/403 Auth Error
+	if resp.StatusCode == 401 || resp.StatusCode == 403 {
+		resp.Body.Close()
+		h.reqLog(c).Errorf("Upstream Auth Error (%d). Marking key dead.", resp.StatusCode)
+		h.lb.keyManager.MarkDead(routing.APIKey) // 永久拉黑
+		return fmt.Errorf("upstream auth error (%d)", resp.StatusCode)
+	}
+
+	// 5xx Server Error (Optional: 可以选择重试)
+	if resp.StatusCode >= 500 {
+		resp.Body.Close()
+		h.reqLog(c).Warnf("Upstream Server Error (%d).", resp.StatusCode)
+		h.lb.keyManager.MarkCooldown(routing.APIKey, 30*time.Second) // 避开故障节点
+		h.lb.RecordModelResult(routing.ModelConfigID, false)
+		return fmt.Errorf("upstream server error (%d)", resp.StatusCode)
+	}
+
+	// 429/401/403 只与 Key 有关，不计入模型错误率
+	h.lb.RecordModelResult(routing.ModelConfigID, true)
+	return nil
+}
+
+// defaultRateLimitCooldown 上游 429 未给出 Retry-After 且未配置 rate_limit_cooldown 时的冷却时间
+const defaultRateLimitCooldown = 60 * time.Second
+
+// rateLimitCooldown 返回配置的 429 默认冷却时间
+func (h *ProxyHandler) rateLimitCooldown() time.Duration {
+	if settings := h.lb.GetGatewaySettings(); settings != nil && settings.RateLimitCooldown > 0 {
+		return time.Duration(settings.RateLimitCooldown) * time.Second
+	}
+	return defaultRateLimitCooldown
+}
+
+// parseRetryAfter 解析 Retry-After 头 (秒数或 HTTP 日期)，缺失或无法解析时返回 false
+// 日期已过去时返回 1 秒，避免立即重试同一个 Key
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return max(time.Duration(secs)*time.Second, time.Second), true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), time.Second), true
+	}
+	return 0, false
+}
+
+// requestID 获取当前请求的 ID (优先使用客户端传入的 X-Request-ID)，不存在或格式不合法时生成一个
+// 生成的 ID 同时写回请求头，使共享同一 Request 的内部 Context (如入站协议转换) 得到相同的 ID
+func requestID(c *gin.Context) string {
+	if id := c.GetString("request_id"); id != "" {
+		return id
 	}
-	return k[len(k)-4:]
-}
\ No newline at end of file
+	id := c.GetHeader("X-Request-ID")
+	if !validRequestID(id) {
+		b := make([]byte, 8)
+		rand.Read(b)
+		id = "req-" + hex.EncodeToString(b)
+		if c.Request != nil {
+			c.Request.Header.Set("X-Request-ID", id)
+		}
+	}
+	c.Set("request_id", id)
+	return id
+}
+
+// bindRequestID 确定请求 ID 并通过 X-Request-ID 响应头回显给客户端
+func bindRequestID(c *gin.Context) string {
+	id := requestID(c)
+	c.Header("X-Request-ID", id)
+	return id
+}
+
+// validRequestID 限制客户端传入的 ID 长度与字符集，避免日志与响应头注入
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_.:", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// reqLog 返回带 request_id 字段的日志 Entry，用于关联同一请求的多次重试
+func (h *ProxyHandler) reqLog(c *gin.Context) *logrus.Entry {
+	return h.logger.WithField("request_id", requestID(c))
+}
+
+// logAccess 输出结构化访问日志，供日志采集系统索引 (与数据库 RequestLog 相互独立)
+func (h *ProxyHandler) logAccess(c *gin.Context, requestModel string, start time.Time, attempts int) {
+	fields := logrus.Fields{
+		"request_id": requestID(c),
+		"group":      requestModel,
+		"status":     c.Writer.Status(),
+		"latency_ms": time.Since(start).Milliseconds(),
+		"attempts":   attempts,
+		"client_ip":  c.ClientIP(), // 仅在来自可信代理时采用 X-Forwarded-For
+	}
+	if rid, exists := c.Get("routing_info"); exists {
+		if r, ok := rid.(*models.RoutingInfo); ok {
+			fields["group"] = r.GroupID
+			fields["provider"] = r.Provider
+			fields["upstream_model"] = r.UpstreamModel
+		}
+	}
+
+	entry := h.logger.WithFields(fields)
+	msg := fmt.Sprintf("Proxy request %s finished with status %d in %dms (%d attempts)",
+		requestModel, fields["status"], fields["latency_ms"], attempts)
+	if c.Writer.Status() >= 400 {
+		entry.Warn(msg)
+	} else {
+		entry.Info(msg)
+	}
+}
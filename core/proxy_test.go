@@ -0,0 +1,1186 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"llm-gateway/models"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestProxyRequest_TimeoutBudget(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+			return
+		}
+		fmt.Fprint(w, `{"id":"slow"}`)
+	}))
+	defer upstream.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:budget_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	group := models.ModelGroup{GroupID: "budget-group", Strategy: "round_robin"}
+	db.Create(&group)
+	m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID}
+	db.Create(&m)
+	db.Create(&models.APIKey{KeyValue: "sk-budget", ModelConfigID: m.ID})
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	km := NewKeyStateManager()
+	lb, err := NewLoadBalancer(db, logger, km, NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	c.Request.Header.Set("X-Request-Timeout-Ms", "100")
+
+	start := time.Now()
+	h.ProxyRequest(c, models.ChatCompletionRequest{
+		Model:    "budget-group",
+		Messages: []models.ChatMessage{{Role: "user", Content: "hi"}},
+	})
+
+	assert.Equal(t, 504, w.Code)
+	assert.Contains(t, w.Body.String(), "timeout budget")
+	assert.Less(t, time.Since(start), time.Second)
+	// 超时预算耗尽不应惩罚 Key
+	assert.True(t, km.IsAvailable("sk-budget"))
+}
+
+func TestProxyRequest_StreamFailoverBeforeCommit(t *testing.T) {
+	limited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(429)
+		fmt.Fprint(w, `{"error":"rate limited"}`)
+	}))
+	defer limited.Close()
+
+	streaming := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"1\",\"choices\":[{\"delta\":{\"content\":\"ok\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer streaming.Close()
+
+	badRequest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		fmt.Fprint(w, `{"error":{"message":"bad request"}}`)
+	}))
+	defer badRequest.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:stream_failover_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	// 轮询: 第一次命中 429 的上游，第二次命中正常流式上游
+	group := models.ModelGroup{GroupID: "stream-group", Strategy: "round_robin"}
+	db.Create(&group)
+	for i, url := range []string{limited.URL, streaming.URL} {
+		m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: url + "/v1", ModelGroupID: group.ID}
+		db.Create(&m)
+		db.Create(&models.APIKey{KeyValue: fmt.Sprintf("sk-stream-%d", i), ModelConfigID: m.ID})
+	}
+
+	// 非重试错误 (400) 的 Claude 流式上游
+	claudeGroup := models.ModelGroup{GroupID: "claude-group", Strategy: "fallback"}
+	db.Create(&claudeGroup)
+	m := models.ModelConfig{ProviderName: "claude", UpstreamModel: "claude-3", UpstreamURL: badRequest.URL, ModelGroupID: claudeGroup.ID}
+	db.Create(&m)
+	db.Create(&models.APIKey{KeyValue: "sk-claude", ModelConfigID: m.ID})
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	proxy := func(model string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		h.ProxyRequest(c, models.ChatCompletionRequest{
+			Model:    model,
+			Stream:   true,
+			Messages: []models.ChatMessage{{Role: "user", Content: "hi"}},
+		})
+		return w
+	}
+
+	w := proxy("stream-group")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(w.Body.String(), "data: "), "SSE body must not be prefixed by the 429 error")
+	assert.NotContains(t, w.Body.String(), "rate limited")
+
+	w = proxy("claude-group")
+	assert.Equal(t, 400, w.Code)
+	assert.NotEqual(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "bad request")
+}
+
+func TestProxyRequest_LeastLoadedKeysReleased(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(500)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"x","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`))
+	}))
+	defer upstream.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:least_loaded_release?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000, MinRetries: 2, MaxRetries: 2})
+
+	group := models.ModelGroup{GroupID: "least-loaded-release", Strategy: "fallback", LeastLoadedKeys: true}
+	db.Create(&group)
+	m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID}
+	db.Create(&m)
+	db.Create(&models.APIKey{KeyValue: "sk-ll-1", ModelConfigID: m.ID})
+	db.Create(&models.APIKey{KeyValue: "sk-ll-2", ModelConfigID: m.ID})
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	km := NewKeyStateManager()
+	lb, err := NewLoadBalancer(db, logger, km, NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	h.ProxyRequest(c, models.ChatCompletionRequest{
+		Model:    "least-loaded-release",
+		Messages: []models.ChatMessage{{Role: "user", Content: "hi"}},
+	})
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// 失败的尝试与成功的请求结束后都已释放计数
+	assert.Equal(t, 0, km.InFlight("sk-ll-1"))
+	assert.Equal(t, 0, km.InFlight("sk-ll-2"))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	d, ok := parseRetryAfter("30", now)
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, d)
+
+	d, ok = parseRetryAfter(now.Add(90*time.Second).Format(http.TimeFormat), now)
+	assert.True(t, ok)
+	assert.Equal(t, 90*time.Second, d)
+
+	// 已过去的日期与 0 秒都至少冷却 1 秒
+	d, ok = parseRetryAfter(now.Add(-time.Minute).Format(http.TimeFormat), now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, d)
+	d, _ = parseRetryAfter("0", now)
+	assert.Equal(t, time.Second, d)
+
+	for _, v := range []string{"", "soon", "-5"} {
+		_, ok = parseRetryAfter(v, now)
+		assert.False(t, ok, v)
+	}
+}
+
+func TestProxyRequest_RetryAfterCooldown(t *testing.T) {
+	retryAfter := "5"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(429)
+	}))
+	defer upstream.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:retry_after_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000, RateLimitCooldown: 20, MinRetries: 1, MaxRetries: 1})
+
+	group := models.ModelGroup{GroupID: "retry-after-group", Strategy: "fallback"}
+	db.Create(&group)
+	m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID}
+	db.Create(&m)
+	db.Create(&models.APIKey{KeyValue: "sk-retry-after", ModelConfigID: m.ID})
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	km := NewKeyStateManager()
+	lb, err := NewLoadBalancer(db, logger, km, NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	proxy := func() time.Duration {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		h.ProxyRequest(c, models.ChatCompletionRequest{
+			Model:    "retry-after-group",
+			Messages: []models.ChatMessage{{Role: "user", Content: "hi"}},
+		})
+		assert.Equal(t, ErrorTypeRateLimit, c.GetString("error_type"))
+		state := km.GetState("sk-retry-after")
+		assert.Equal(t, KeyStatusCooldown, state.Status)
+		return time.Until(state.UnlockTime)
+	}
+
+	assert.InDelta(t, 5*time.Second, proxy(), float64(time.Second))
+
+	// 未返回 Retry-After 时使用配置的默认冷却时间
+	km.MarkAvailable("sk-retry-after")
+	retryAfter = ""
+	assert.InDelta(t, 20*time.Second, proxy(), float64(time.Second))
+}
+
+func TestProxyRequest_AppliesRequestOverrides(t *testing.T) {
+	var received models.ChatCompletionRequest
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		fmt.Fprint(w, `{"id":"ok"}`)
+	}))
+	defer upstream.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:overrides_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	group := models.ModelGroup{GroupID: "override-group", Strategy: "fallback"}
+	db.Create(&group)
+	m := models.ModelConfig{
+		ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID,
+		RequestOverrides: `{"max_tokens_cap": 100, "temperature": 0.2, "system_prompt": "Be safe."}`,
+	}
+	db.Create(&m)
+	db.Create(&models.APIKey{KeyValue: "sk-override", ModelConfigID: m.ID})
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+
+	maxTokens, temperature := 1000, 0.9
+	h.ProxyRequest(c, models.ChatCompletionRequest{
+		Model:       "override-group",
+		Messages:    []models.ChatMessage{{Role: "system", Content: "Client prompt."}, {Role: "user", Content: "hi"}},
+		MaxTokens:   &maxTokens,
+		Temperature: &temperature,
+	})
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, 100, *received.MaxTokens)
+	assert.Equal(t, 0.2, *received.Temperature)
+	assert.Len(t, received.Messages, 3)
+	assert.Equal(t, "Be safe.", received.Messages[0].Content)
+	assert.Equal(t, "Client prompt.", received.Messages[1].Content)
+}
+
+func TestProxyRequest_ClampsMaxTokens(t *testing.T) {
+	var received models.ChatCompletionRequest
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = models.ChatCompletionRequest{}
+		json.NewDecoder(r.Body).Decode(&received)
+		fmt.Fprint(w, `{"id":"ok"}`)
+	}))
+	defer upstream.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:clamp_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	group := models.ModelGroup{GroupID: "clamp-group", Strategy: "fallback"}
+	db.Create(&group)
+	m := models.ModelConfig{
+		ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID,
+		MaxOutputTokens: 512,
+	}
+	db.Create(&m)
+	db.Create(&models.APIKey{KeyValue: "sk-clamp", ModelConfigID: m.ID})
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	send := func(maxTokens *int) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		h.ProxyRequest(c, models.ChatCompletionRequest{
+			Model:     "clamp-group",
+			Messages:  []models.ChatMessage{{Role: "user", Content: "hi"}},
+			MaxTokens: maxTokens,
+		})
+		assert.Equal(t, 200, w.Code)
+	}
+
+	tooMany, fine := 4000, 100
+	send(&tooMany)
+	assert.Equal(t, 512, *received.MaxTokens)
+	assert.Equal(t, 4000, tooMany) // 不修改调用方的请求
+
+	send(&fine)
+	assert.Equal(t, 100, *received.MaxTokens)
+
+	// 未指定时不补充
+	send(nil)
+	assert.Nil(t, received.MaxTokens)
+}
+
+func TestProxyRequest_RequestIDEcho(t *testing.T) {
+	h := newFilterTestHandler(t, "", "ok")
+
+	send := func(header string) (*httptest.ResponseRecorder, *gin.Context) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		if header != "" {
+			c.Request.Header.Set("X-Request-ID", header)
+		}
+		h.ProxyRequest(c, models.ChatCompletionRequest{
+			Model:    "filter-group",
+			Messages: []models.ChatMessage{{Role: "user", Content: "hi"}},
+		})
+		return w, c
+	}
+
+	// 客户端传入的 ID 原样回显，并供 RequestLog 使用
+	w, c := send("client-trace.42")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "client-trace.42", w.Header().Get("X-Request-ID"))
+	assert.Equal(t, "client-trace.42", c.GetString("request_id"))
+
+	// 未传入或格式不合法时生成新 ID
+	for _, header := range []string{"", "bad id\r\nX-Injected: 1"} {
+		w, c = send(header)
+		id := w.Header().Get("X-Request-ID")
+		assert.True(t, strings.HasPrefix(id, "req-"), id)
+		assert.Equal(t, id, c.GetString("request_id"))
+	}
+}
+
+func TestProxyRequest_RetryEmptyStream(t *testing.T) {
+	var emptyHits int
+	empty := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		emptyHits++
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"1\",\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer empty.Close()
+
+	streaming := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"2\",\"choices\":[{\"delta\":{\"content\":\"ok\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer streaming.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:empty_stream_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	group := models.ModelGroup{GroupID: "empty-group", Strategy: "round_robin"}
+	db.Create(&group)
+	for i, url := range []string{empty.URL, streaming.URL} {
+		m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: url + "/v1", ModelGroupID: group.ID}
+		db.Create(&m)
+		db.Create(&models.APIKey{KeyValue: fmt.Sprintf("sk-empty-%d", i), ModelConfigID: m.ID})
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	km := NewKeyStateManager()
+	lb, err := NewLoadBalancer(db, logger, km, NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	proxy := func(model string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		h.ProxyRequest(c, models.ChatCompletionRequest{
+			Model:    model,
+			Stream:   true,
+			Messages: []models.ChatMessage{{Role: "user", Content: "hi"}},
+		})
+		return w
+	}
+
+	// 默认关闭：空流按成功透传
+	w := proxy("empty-group$1")
+	assert.Equal(t, 200, w.Code)
+	assert.NotContains(t, w.Body.String(), `"content":"ok"`)
+	assert.Equal(t, 1, emptyHits)
+
+	// 开启后丢弃空流并切换到下一个上游，客户端只收到有内容的流
+	db.Model(&models.GatewaySettings{}).Where("1 = 1").Update("retry_empty_stream", true)
+	assert.NoError(t, lb.RefreshData())
+	w = proxy("empty-group")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `"content":"ok"`)
+	assert.NotContains(t, w.Body.String(), `"id":"1"`)
+	assert.Equal(t, 2, emptyHits)
+	assert.False(t, km.IsAvailable("sk-empty-0"))
+}
+
+func TestProxyRequest_MaxInFlight(t *testing.T) {
+	h := newFilterTestHandler(t, "", "ok")
+	h.SetMaxInFlight(1)
+	assert.Equal(t, 1, h.MaxInFlight())
+
+	// 名额被占满时立即返回 503
+	assert.True(t, h.acquireSlot(nil))
+	w := doFilteredRequest(h)
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "gateway_overloaded")
+	assert.Equal(t, int64(1), h.InFlight())
+
+	h.releaseSlot()
+	assert.Equal(t, 200, doFilteredRequest(h).Code)
+	assert.Equal(t, int64(0), h.InFlight())
+}
+
+func TestProxyHandler_ProviderTransport(t *testing.T) {
+	base := &http.Client{Timeout: time.Minute, Transport: &http.Transport{MaxIdleConnsPerHost: 20}}
+	h := NewProxyHandler(nil, base, logrus.New(), nil)
+	h.SetProviderTransport("Gemini", TransportTuning{ResponseHeaderTimeout: 3 * time.Minute, TLSHandshakeTimeout: 15 * time.Second})
+
+	// 未配置的提供商使用默认 Client
+	assert.Same(t, base, h.clientFor("openai"))
+
+	tuned := h.clientFor("gemini")
+	assert.NotSame(t, base, tuned)
+	assert.Equal(t, time.Minute, tuned.Timeout)
+	transport := tuned.Transport.(*http.Transport)
+	assert.Equal(t, 3*time.Minute, transport.ResponseHeaderTimeout)
+	assert.Equal(t, 15*time.Second, transport.TLSHandshakeTimeout)
+	assert.Equal(t, 20, transport.MaxIdleConnsPerHost)
+
+	// 默认 Client 不受影响
+	assert.Zero(t, base.Transport.(*http.Transport).ResponseHeaderTimeout)
+}
+
+func TestProxyHandler_PrewarmConnections(t *testing.T) {
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodHead, r.Method)
+		hits.Add(1)
+		w.WriteHeader(404)
+	}))
+	defer upstream.Close()
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closed.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:prewarm_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	// 同一上游地址的不同模型只预热一次
+	for i, url := range []string{upstream.URL + "/v1", upstream.URL + "/openai/v1", closed.URL + "/v1"} {
+		group := models.ModelGroup{GroupID: fmt.Sprintf("prewarm-%d", i), Strategy: "fallback"}
+		db.Create(&group)
+		m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: url, ModelGroupID: group.ID}
+		db.Create(&m)
+		db.Create(&models.APIKey{KeyValue: fmt.Sprintf("sk-prewarm-%d", i), ModelConfigID: m.ID})
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	warmed, failed := h.PrewarmConnections(context.Background())
+	assert.Equal(t, 1, warmed)
+	assert.Equal(t, 1, failed)
+	assert.Equal(t, int32(1), hits.Load())
+}
+
+func TestProxyRequest_ForcedRoute(t *testing.T) {
+	var received models.ChatCompletionRequest
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		fmt.Fprint(w, `{"id":"ok"}`)
+	}))
+	defer upstream.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:forced_route_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	group := models.ModelGroup{GroupID: "forced-group", Strategy: "fallback"}
+	db.Create(&group)
+	for i, name := range []string{"primary", "secondary"} {
+		m := models.ModelConfig{ProviderName: "openai", UpstreamModel: name, UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID, Priority: i}
+		db.Create(&m)
+		db.Create(&models.APIKey{KeyValue: "sk-forced-" + name, ModelConfigID: m.ID})
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	proxy := func(admin bool, headers map[string]string) int {
+		received = models.ChatCompletionRequest{}
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		for k, v := range headers {
+			c.Request.Header.Set(k, v)
+		}
+		if admin {
+			c.Set("admin_auth", "admin_key")
+		}
+		h.ProxyRequest(c, models.ChatCompletionRequest{
+			Model:    "forced-group",
+			Messages: []models.ChatMessage{{Role: "user", Content: "hi"}},
+		})
+		return w.Code
+	}
+
+	assert.Equal(t, 200, proxy(true, map[string]string{"X-Force-Model-Index": "2"}))
+	assert.Equal(t, "secondary", received.Model)
+
+	assert.Equal(t, 200, proxy(true, map[string]string{"X-Force-Group": "forced-group", "X-Force-Model-Index": "2"}))
+	assert.Equal(t, "secondary", received.Model)
+
+	// 非管理员凭证忽略请求头，按组策略选择
+	assert.Equal(t, 200, proxy(false, map[string]string{"X-Force-Model-Index": "2"}))
+	assert.Equal(t, "primary", received.Model)
+
+	// 序号越界或组不存在返回 400
+	assert.Equal(t, 400, proxy(true, map[string]string{"X-Force-Model-Index": "3"}))
+	assert.Equal(t, 400, proxy(true, map[string]string{"X-Force-Model-Index": "abc"}))
+	assert.Equal(t, 400, proxy(true, map[string]string{"X-Force-Group": "missing"}))
+	assert.Empty(t, received.Model)
+}
+
+func TestProxyRequest_UpstreamBaseOverride(t *testing.T) {
+	reply := func(hits *int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*hits++
+			fmt.Fprint(w, `{"id":"1","object":"chat.completion","model":"gpt-4","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+		}))
+	}
+	var configuredHits, overrideHits int
+	configured := reply(&configuredHits)
+	defer configured.Close()
+	override := reply(&overrideHits)
+	defer override.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:upstream_base_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	group := models.ModelGroup{GroupID: "override-group", Strategy: "fallback"}
+	db.Create(&group)
+	m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: configured.URL + "/v1", ModelGroupID: group.ID}
+	db.Create(&m)
+	db.Create(&models.APIKey{KeyValue: "sk-override", ModelConfigID: m.ID})
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	proxy := func(admin bool, base string) int {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		c.Request.Header.Set("X-Upstream-Base", base)
+		c.Set("admin_auth", "admin_key")
+		c.Set("admin_name", "ops")
+		if admin {
+			c.Set("admin_scope", ScopeAdmin)
+		}
+		h.ProxyRequest(c, models.ChatCompletionRequest{
+			Model:    "override-group",
+			Messages: []models.ChatMessage{{Role: "user", Content: "hi"}},
+		})
+		return w.Code
+	}
+
+	// 管理员凭证: 本次请求改用覆盖地址，并记录审计日志
+	assert.Equal(t, 200, proxy(true, override.URL+"/v1"))
+	assert.Equal(t, 1, overrideHits)
+	assert.Equal(t, 0, configuredHits)
+	var audit models.AuditLog
+	assert.NoError(t, db.Where("action = ?", "override_upstream_base").First(&audit).Error)
+	assert.Equal(t, "ops", audit.AdminName)
+	assert.Equal(t, override.URL+"/v1", audit.Detail)
+
+	// 没有管理员权限的密钥拒绝，地址无效返回 400，均不发出上游请求
+	assert.Equal(t, 403, proxy(false, override.URL+"/v1"))
+	assert.Equal(t, 400, proxy(true, "not-a-url"))
+	assert.Equal(t, 1, overrideHits)
+
+	// 未带请求头时使用模型配置的地址
+	assert.Equal(t, 200, proxy(true, ""))
+	assert.Equal(t, 1, configuredHits)
+}
+
+func TestProxyRequest_OnExhausted(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id":"ok","choices":[{"index":0,"message":{"role":"assistant","content":"from backup"},"finish_reason":"stop"}]}`)
+	}))
+	defer healthy.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:on_exhausted_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	addGroup := func(group models.ModelGroup, url string) {
+		group.Strategy = "fallback"
+		group.MaxRetries = 1
+		db.Create(&group)
+		m := models.ModelConfig{ProviderName: "openai", UpstreamModel: group.GroupID + "-model", UpstreamURL: url + "/v1", ModelGroupID: group.ID}
+		db.Create(&m)
+		db.Create(&models.APIKey{KeyValue: "sk-" + group.GroupID, ModelConfigID: m.ID})
+	}
+	addGroup(models.ModelGroup{GroupID: "primary", OnExhausted: "fallback_group:backup"}, failing.URL)
+	addGroup(models.ModelGroup{GroupID: "backup"}, healthy.URL)
+	addGroup(models.ModelGroup{GroupID: "canned", OnExhausted: "static_message", ExhaustedMessage: "Service is busy"}, failing.URL)
+	addGroup(models.ModelGroup{GroupID: "loop-a", OnExhausted: "fallback_group:loop-b"}, failing.URL)
+	addGroup(models.ModelGroup{GroupID: "loop-b", OnExhausted: "fallback_group:loop-a"}, failing.URL)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	proxy := func(model string, stream bool) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		h.ProxyRequest(c, models.ChatCompletionRequest{
+			Model:    model,
+			Stream:   stream,
+			Messages: []models.ChatMessage{{Role: "user", Content: "hi"}},
+		})
+		return w
+	}
+
+	// fallback_group: 改用备用组
+	w := proxy("primary", false)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "from backup")
+
+	// static_message: 返回固定回复 (非流式与流式)
+	w = proxy("canned", false)
+	assert.Equal(t, 200, w.Code)
+	var resp models.ChatCompletionResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "Service is busy", resp.Choices[0].Message.Content)
+
+	w = proxy("canned", true)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `"content":"Service is busy"`)
+	assert.True(t, strings.HasSuffix(w.Body.String(), "data: [DONE]\n\n"))
+
+	// fallback 循环时只尝试一次，返回错误
+	assert.Equal(t, 502, proxy("loop-a", false).Code)
+}
+
+func TestProxyRequest_LogprobsPassthrough(t *testing.T) {
+	var received map[string]interface{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		fmt.Fprint(w, `{"id":"ok","choices":[{"index":0,"message":{"role":"assistant","content":"Hi"},"finish_reason":"stop",`+
+			`"logprobs":{"content":[{"token":"Hi","logprob":-0.01,"top_logprobs":[{"token":"Hi","logprob":-0.01}]}]}}]}`)
+	}))
+	defer upstream.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:logprobs_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	group := models.ModelGroup{GroupID: "logprobs-group", Strategy: "fallback"}
+	db.Create(&group)
+	m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4o", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID}
+	db.Create(&m)
+	db.Create(&models.APIKey{KeyValue: "sk-logprobs", ModelConfigID: m.ID})
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	logprobs, top := true, 2
+	h.ProxyRequest(c, models.ChatCompletionRequest{
+		Model:       "logprobs-group",
+		Messages:    []models.ChatMessage{{Role: "user", Content: "hi"}},
+		Logprobs:    &logprobs,
+		TopLogprobs: &top,
+	})
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, true, received["logprobs"])
+	assert.Equal(t, float64(2), received["top_logprobs"])
+
+	var resp models.ChatCompletionResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Choices, 1)
+	content := resp.Choices[0].Logprobs.(map[string]interface{})["content"].([]interface{})
+	assert.Equal(t, "Hi", content[0].(map[string]interface{})["token"])
+}
+
+func TestProxyRequest_EchoRequestModel(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req models.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream {
+			fmt.Fprint(w, `{"id":"1","object":"chat.completion","model":"gpt-4-0613","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"1\",\"model\":\"gpt-4-0613\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:echo_model_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	group := models.ModelGroup{GroupID: "echo-group", Strategy: "fallback"}
+	db.Create(&group)
+	m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID}
+	db.Create(&m)
+	db.Create(&models.APIKey{KeyValue: "sk-echo", ModelConfigID: m.ID})
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	proxy := func(stream bool) string {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		h.ProxyRequest(c, models.ChatCompletionRequest{
+			Model:    "echo-group",
+			Stream:   stream,
+			Messages: []models.ChatMessage{{Role: "user", Content: "hi"}},
+		})
+		assert.Equal(t, 200, w.Code)
+		return w.Body.String()
+	}
+
+	// 默认返回上游实际的模型名
+	assert.Contains(t, proxy(false), `"model":"gpt-4-0613"`)
+
+	db.Model(&models.GatewaySettings{}).Where("1 = 1").Update("echo_request_model", true)
+	assert.NoError(t, lb.RefreshData())
+
+	body := proxy(false)
+	var resp models.ChatCompletionResponse
+	assert.NoError(t, json.Unmarshal([]byte(body), &resp))
+	assert.Equal(t, "echo-group", resp.Model)
+	assert.Equal(t, "hi", resp.Choices[0].Message.Content)
+
+	body = proxy(true)
+	assert.Contains(t, body, `"model":"echo-group"`)
+	assert.NotContains(t, body, "gpt-4-0613")
+	assert.True(t, strings.HasSuffix(body, "data: [DONE]\n\n"))
+}
+
+func TestProxyRequest_MalformedUpstreamJSON(t *testing.T) {
+	var garbageHits int
+	garbage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		garbageHits++
+		fmt.Fprint(w, "<html>502 Bad Gateway</html>")
+	}))
+	defer garbage.Close()
+
+	valid := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id":"1","object":"chat.completion","model":"gpt-4","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer valid.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:malformed_json_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	group := models.ModelGroup{GroupID: "malformed-group", Strategy: "round_robin", MaxRetries: 1}
+	db.Create(&group)
+	claude := models.ModelConfig{ProviderName: "claude", UpstreamModel: "claude-3", UpstreamURL: garbage.URL, ModelGroupID: group.ID}
+	db.Create(&claude)
+	db.Create(&models.APIKey{KeyValue: "sk-malformed-claude", ModelConfigID: claude.ID})
+	openai := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: valid.URL + "/v1", ModelGroupID: group.ID}
+	db.Create(&openai)
+	db.Create(&models.APIKey{KeyValue: "sk-malformed-openai", ModelConfigID: openai.ID})
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	km := NewKeyStateManager()
+	lb, err := NewLoadBalancer(db, logger, km, NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	proxy := func(model string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		h.ProxyRequest(c, models.ChatCompletionRequest{
+			Model:    model,
+			Messages: []models.ChatMessage{{Role: "user", Content: "hi"}},
+		})
+		return w
+	}
+
+	// 仅一次尝试：返回 OpenAI 格式的 502，而不是空响应
+	w := proxy("malformed-group$1")
+	assert.Equal(t, 502, w.Code)
+	var errResp models.ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &errResp))
+	assert.Equal(t, "bad_upstream_response", errResp.Error.Code)
+	assert.NotContains(t, w.Body.String(), "<html>")
+	assert.Equal(t, 1, garbageHits)
+
+	// 允许重试时切换到下一个模型，出错的 Key 短暂冷却
+	db.Model(&group).Update("max_retries", 3)
+	assert.NoError(t, lb.RefreshData())
+	w = proxy("malformed-group")
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"content":"ok"`)
+	assert.Equal(t, 2, garbageHits)
+	assert.False(t, km.IsAvailable("sk-malformed-claude"))
+}
+
+func TestProxyRequest_ContextLimits(t *testing.T) {
+	var hits int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		fmt.Fprint(w, `{"id":"1","object":"chat.completion","model":"gpt-4","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer upstream.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:context_limits_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000, MaxMessages: 2, MaxContextChars: 10})
+
+	for _, group := range []models.ModelGroup{
+		{GroupID: "global-limits", Strategy: "fallback"},
+		{GroupID: "group-limits", Strategy: "fallback", MaxMessages: 3, MaxContextChars: 12},
+	} {
+		db.Create(&group)
+		m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID}
+		db.Create(&m)
+		db.Create(&models.APIKey{KeyValue: "sk-" + group.GroupID, ModelConfigID: m.ID})
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	proxy := func(model string, contents ...string) *httptest.ResponseRecorder {
+		var messages []models.ChatMessage
+		for _, content := range contents {
+			messages = append(messages, models.ChatMessage{Role: "user", Content: content})
+		}
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		h.ProxyRequest(c, models.ChatCompletionRequest{Model: model, Messages: messages})
+		return w
+	}
+	assertRejected := func(w *httptest.ResponseRecorder) {
+		assert.Equal(t, 400, w.Code)
+		var errResp models.ErrorResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &errResp))
+		assert.Equal(t, "context_limit_exceeded", errResp.Error.Code)
+		assert.Equal(t, "messages", errResp.Error.Param)
+	}
+
+	// 全局上限: 恰好达到上限时放行 (按字符而不是字节计数)
+	assert.Equal(t, 200, proxy("global-limits", "你好你好你", "12345").Code)
+	assertRejected(proxy("global-limits", "a", "b", "c"))
+	assertRejected(proxy("global-limits", "12345678901"))
+	assert.Equal(t, 1, hits)
+
+	// 组设置覆盖全局设置
+	assert.Equal(t, 200, proxy("group-limits", "a", "b", "1234567890").Code)
+	assertRejected(proxy("group-limits", "a", "b", "c", "d"))
+	assertRejected(proxy("group-limits", "1234567890123"))
+	assert.Equal(t, 2, hits)
+}
+
+func TestProxyRequest_Capabilities(t *testing.T) {
+	var upstreamModels []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req models.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		upstreamModels = append(upstreamModels, req.Model)
+		fmt.Fprintf(w, `{"id":"1","object":"chat.completion","model":%q,"choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`, req.Model)
+	}))
+	defer upstream.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:capabilities_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	group := models.ModelGroup{GroupID: "caps-group", Strategy: "fallback"}
+	db.Create(&group)
+	text := models.ModelConfig{ProviderName: "openai", UpstreamModel: "text-only", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID, Priority: 1, Capabilities: `{"vision":false}`}
+	db.Create(&text)
+	db.Create(&models.APIKey{KeyValue: "sk-text", ModelConfigID: text.ID})
+	vision := models.ModelConfig{ProviderName: "openai", UpstreamModel: "vision", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID, Priority: 2}
+	db.Create(&vision)
+	db.Create(&models.APIKey{KeyValue: "sk-vision", ModelConfigID: vision.ID})
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	image := []interface{}{
+		map[string]interface{}{"type": "text", "text": "what is this?"},
+		map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "data:image/png;base64,AAAA"}},
+	}
+	proxy := func(model string, content interface{}) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		h.ProxyRequest(c, models.ChatCompletionRequest{Model: model, Messages: []models.ChatMessage{{Role: "user", Content: content}}})
+		return w
+	}
+
+	// 纯文本请求使用优先级最高的模型，图片请求跳过不支持 vision 的模型
+	assert.Equal(t, 200, proxy("caps-group", "hi").Code)
+	assert.Equal(t, 200, proxy("caps-group", image).Code)
+	assert.Equal(t, []string{"text-only", "vision"}, upstreamModels)
+
+	// 固定到不支持的模型时直接返回 400，不发往上游
+	w := proxy("caps-group$1", image)
+	assert.Equal(t, 400, w.Code)
+	var errResp models.ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &errResp))
+	assert.Equal(t, "unsupported_capability", errResp.Error.Code)
+	assert.Contains(t, errResp.Error.Message, "vision")
+	assert.Len(t, upstreamModels, 2)
+}
+
+func TestProxyRequest_RetryableErrors(t *testing.T) {
+	// 上游按 Key 返回预设的状态码与响应体，sk-ok 正常返回
+	responses := map[string]struct {
+		status int
+		body   string
+	}{
+		"sk-overloaded-400": {400, `{"error":{"message":"The model is currently Overloaded, please try again"}}`},
+		"sk-invalid-400":    {400, `{"error":{"message":"Invalid parameter: temperature"}}`},
+		"sk-overloaded-200": {200, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`},
+	}
+	var okHits int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if resp, ok := responses[key]; ok {
+			w.WriteHeader(resp.status)
+			fmt.Fprint(w, resp.body)
+			return
+		}
+		okHits++
+		fmt.Fprint(w, `{"id":"1","object":"chat.completion","model":"gpt-4","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer upstream.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:retryable_errors_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000, RetryableErrors: `["overloaded", " "]`})
+
+	for key := range responses {
+		// 轮询: 第一次尝试命中 flaky，重试时轮到 ok
+		group := models.ModelGroup{GroupID: key, Strategy: "round_robin", MaxRetries: 2}
+		db.Create(&group)
+		flaky := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID, Priority: 1}
+		db.Create(&flaky)
+		db.Create(&models.APIKey{KeyValue: key, ModelConfigID: flaky.ID})
+		ok := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID, Priority: 2}
+		db.Create(&ok)
+		db.Create(&models.APIKey{KeyValue: "sk-ok-" + key, ModelConfigID: ok.ID})
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"overloaded"}, lb.RetryableErrors())
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	proxy := func(model string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		h.ProxyRequest(c, models.ChatCompletionRequest{Model: model, Messages: []models.ChatMessage{{Role: "user", Content: "hi"}}})
+		return w
+	}
+
+	// 命中的 400 换用下一个模型 (匹配不区分大小写)
+	w := proxy("sk-overloaded-400")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, 1, okHits)
+
+	// 未命中的 400 原样返回，不重试
+	w = proxy("sk-invalid-400")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "Invalid parameter")
+	assert.Equal(t, 1, okHits)
+
+	// 200 但响应体是错误对象
+	w = proxy("sk-overloaded-200")
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"content":"ok"`)
+	assert.Equal(t, 2, okHits)
+}
+
+func TestProxyRequest_RecordsStreamTTFT(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req models.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream {
+			fmt.Fprint(w, `{"id":"1","object":"chat.completion","model":"gpt-4","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		// 只有角色的首个 chunk 不算首字
+		fmt.Fprint(w, "data: {\"id\":\"1\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\"}}]}\n\n")
+		w.(http.Flusher).Flush()
+		time.Sleep(20 * time.Millisecond)
+		fmt.Fprint(w, "data: {\"id\":\"1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"ok\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:stream_ttft_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	group := models.ModelGroup{GroupID: "ttft-group", Strategy: "fallback"}
+	db.Create(&group)
+	m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID}
+	db.Create(&m)
+	db.Create(&models.APIKey{KeyValue: "sk-ttft", ModelConfigID: m.ID})
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	proxy := func(model string, stream bool) (*gin.Context, *httptest.ResponseRecorder) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		h.ProxyRequest(c, models.ChatCompletionRequest{
+			Model:    model,
+			Stream:   stream,
+			Messages: []models.ChatMessage{{Role: "user", Content: "hi"}},
+		})
+		return c, w
+	}
+
+	c, w := proxy("ttft-group", true)
+	assert.Equal(t, 200, w.Code)
+	ttft, ok := c.Get(ttftKey)
+	assert.True(t, ok)
+	assert.GreaterOrEqual(t, ttft.(time.Duration), 20*time.Millisecond)
+
+	// 非流式请求与错误响应不记录
+	c, _ = proxy("ttft-group", false)
+	_, ok = c.Get(ttftKey)
+	assert.False(t, ok)
+	c, w = proxy("missing-group", true)
+	assert.NotEqual(t, 200, w.Code)
+	_, ok = c.Get(ttftKey)
+	assert.False(t, ok)
+}
+
+func TestClassifyError(t *testing.T) {
+	cases := []struct {
+		status int
+		err    error
+		want   string
+	}{
+		{200, nil, ""},
+		{0, errors.New("connection refused"), ErrorTypeNetwork},
+		{0, fmt.Errorf("dial: %w", context.DeadlineExceeded), ErrorTypeTimeout},
+		{0, &net.DNSError{IsTimeout: true}, ErrorTypeTimeout},
+		{401, nil, ErrorTypeAuth},
+		{403, nil, ErrorTypeAuth},
+		{429, nil, ErrorTypeRateLimit},
+		{504, nil, ErrorTypeTimeout},
+		{502, nil, ErrorTypeServer},
+		{400, nil, ErrorTypeClient},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, ClassifyError(tc.status, tc.err), "%d %v", tc.status, tc.err)
+	}
+}
@@ -0,0 +1,179 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"llm-gateway/core/adapter"
+	"llm-gateway/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// raceResult 单个竞速请求的结果
+type raceResult struct {
+	routing *models.RoutingInfo
+	adp     adapter.ProviderAdapter
+	resp    *http.Response
+	err     error
+	idx     int // 对应 cancels 中的下标
+}
+
+// raceCandidates 选出最多 k 个不重复的 (模型, Key) 候选
+func (h *ProxyHandler) raceCandidates(requestModel string, k int, required models.Capabilities) ([]*models.RoutingInfo, error) {
+	candidates := make([]*models.RoutingInfo, 0, k)
+	seen := make(map[string]bool)
+
+	// 多尝试几次以跳过重复候选 (模型/Key 数量少于 K 时)
+	for i := 0; i < k*2 && len(candidates) < k; i++ {
+		routing, err := h.lb.RouteFor(requestModel, required)
+		if err != nil {
+			if len(candidates) == 0 {
+				return nil, err
+			}
+			break
+		}
+		id := fmt.Sprintf("%d|%s", routing.ModelConfigID, routing.APIKey)
+		if seen[id] {
+			h.lb.ReleaseKey(routing)
+			continue
+		}
+		seen[id] = true
+		candidates = append(candidates, routing)
+	}
+	return candidates, nil
+}
+
+// raceRequest 竞速模式 (仅非流式)
+// 并发请求前 K 个候选上游，返回最先响应 200 的结果并取消其余请求。
+// 只有胜出的路由信息会写入 Context，因此落败请求不会计入统计。
+// 返回实际发出的上游请求数；全部失败时返回 error 且不写出响应，由调用方按 on_exhausted 策略处理。
+func (h *ProxyHandler) raceRequest(c *gin.Context, requestData models.ChatCompletionRequest, k int, budget time.Duration) (int, error) {
+	candidates, err := h.raceCandidates(requestData.Model, k, models.RequiredCapabilities(requestData))
+	if err != nil {
+		h.reqLog(c).Warnf("[Race] Routing failed: %v", err)
+		return 0, fmt.Errorf("Upstream unavailable. Last error: %v", err)
+	}
+
+	results := make(chan raceResult, len(candidates))
+	cancels := make([]context.CancelFunc, 0, len(candidates))
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+		for _, routing := range candidates {
+			h.lb.ReleaseKey(routing)
+		}
+	}()
+
+	var convertErr error
+	for _, routing := range candidates {
+		overrideUpstreamBase(c, routing)
+		adp := h.getAdapter(routing)
+		req, err := adp.ConvertRequest(c, h.prepareUpstreamRequest(c, routing, requestData), routing.APIKey, routing.UpstreamURL, routing.UpstreamModel)
+		if err != nil {
+			h.reqLog(c).Errorf("[Race] Request conversion failed: %v", err)
+			convertErr = err
+			continue
+		}
+		applyAttribution(c, req)
+
+		// 每个请求使用独立的可取消 Context，胜出者不受其他请求取消的影响
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		cancels = append(cancels, cancel)
+		req = req.WithContext(ctx)
+
+		h.reqLog(c).Infof("[Race] Firing upstream: %s (%s) | Key: %s",
+			routing.UpstreamURL, routing.UpstreamModel, models.MaskAPIKey(routing.APIKey))
+
+		go func(routing *models.RoutingInfo, adp adapter.ProviderAdapter, req *http.Request, idx int) {
+			resp, err := h.clientFor(routing.Provider).Do(req)
+			results <- raceResult{routing: routing, adp: adp, resp: resp, err: err, idx: idx}
+		}(routing, adp, req, len(cancels)-1)
+	}
+
+	launched := len(cancels)
+	if launched == 0 {
+		h.writeConvertError(c, convertErr)
+		return 0, nil
+	}
+
+	var winner *raceResult
+	var fallback *raceResult // 非 200 但不需重试的响应 (如 400)，没有胜出者时透传给客户端
+	var lastErr error
+	received := 0
+
+	for received < launched && winner == nil {
+		r := <-results
+		received++
+
+		// 客户端已断开时不惩罚 Key
+		if r.err != nil && c.Request.Context().Err() != nil {
+			lastErr = r.err
+			continue
+		}
+		if failErr := h.checkUpstream(c, r.routing, r.resp, r.err); failErr != nil {
+			lastErr = failErr
+			continue
+		}
+		if failErr := h.checkRetryableError(c, r.routing, r.resp, false); failErr != nil {
+			lastErr = failErr
+			continue
+		}
+
+		if r.resp.StatusCode == 200 {
+			winner = &r
+		} else if fallback == nil {
+			fallback = &r
+		} else {
+			r.resp.Body.Close()
+		}
+	}
+
+	if winner == nil {
+		winner = fallback
+	} else if fallback != nil {
+		fallback.resp.Body.Close()
+	}
+
+	// 取消其余请求，并在后台关闭它们的响应 Body
+	for i, cancel := range cancels {
+		if winner == nil || i != winner.idx {
+			cancel()
+		}
+	}
+	if remaining := launched - received; remaining > 0 {
+		go func() {
+			for i := 0; i < remaining; i++ {
+				if r := <-results; r.resp != nil {
+					r.resp.Body.Close()
+				}
+			}
+		}()
+	}
+
+	if winner == nil && budgetExceeded(c) {
+		h.writeBudgetExceeded(c, budget, launched)
+		return launched, nil
+	}
+	if winner == nil {
+		h.reqLog(c).Errorf("[Race] All %d upstreams failed. Last error: %v", launched, lastErr)
+		return launched, fmt.Errorf("Upstream unavailable after racing %d upstreams. Last error: %v", launched, lastErr)
+	}
+
+	h.reqLog(c).Infof("[Race] Winner: %s (%s)", winner.routing.UpstreamURL, winner.routing.UpstreamModel)
+
+	// 为中间件设置路由信息 (仅胜出者)
+	c.Set("routing_info", winner.routing)
+	c.Set(errorTypeKey, ClassifyError(winner.resp.StatusCode, nil))
+
+	defer winner.resp.Body.Close()
+	if err := h.handleResponse(c, winner.adp, winner.resp, false); isMalformedResponse(err) && !c.Writer.Written() {
+		h.logMalformedResponse(c, 1, err)
+		h.writeMalformedResponse(c)
+	} else if err != nil {
+		h.reqLog(c).Errorf("Failed to handle response: %v", err)
+	}
+	return launched, nil
+}
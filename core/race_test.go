@@ -0,0 +1,72 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"llm-gateway/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestRaceStrategy_ReturnsFastestSuccess(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body) // 读完 Body 后服务端才能感知客户端取消
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+			return
+		}
+		fmt.Fprint(w, `{"id":"slow"}`)
+	}))
+	defer slow.Close()
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id":"fast"}`)
+	}))
+	defer fast.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:race_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	group := models.ModelGroup{GroupID: "race-group", Strategy: "race", RaceCount: 2}
+	db.Create(&group)
+	for i, url := range []string{slow.URL, fast.URL} {
+		m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: url + "/v1", ModelGroupID: group.ID}
+		db.Create(&m)
+		db.Create(&models.APIKey{KeyValue: fmt.Sprintf("sk-%d", i), ModelConfigID: m.ID})
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+
+	start := time.Now()
+	h.ProxyRequest(c, models.ChatCompletionRequest{
+		Model:    "race-group",
+		Messages: []models.ChatMessage{{Role: "user", Content: "hi"}},
+	})
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "fast")
+	assert.Less(t, time.Since(start), time.Second)
+
+	routing, ok := c.Get("routing_info")
+	assert.True(t, ok)
+	assert.Contains(t, routing.(*models.RoutingInfo).UpstreamURL, fast.URL)
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"llm-gateway/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitHeaderPrefix 上游限流响应头前缀 (OpenAI、Groq、DeepSeek 等兼容服务)
+const rateLimitHeaderPrefix = "X-Ratelimit-"
+
+// parseRateLimitHeaders 解析 x-ratelimit-remaining/limit/reset-{requests,tokens}
+// 不带后缀的 x-ratelimit-remaining 等视为请求数；没有任何限流头时返回 nil
+func parseRateLimitHeaders(header http.Header, now time.Time) *models.RateLimitInfo {
+	var info models.RateLimitInfo
+	found := false
+
+	parseInt := func(names ...string) *int {
+		for _, name := range names {
+			if v, err := strconv.Atoi(strings.TrimSpace(header.Get(name))); err == nil {
+				found = true
+				return &v
+			}
+		}
+		return nil
+	}
+	parseReset := func(names ...string) *time.Time {
+		for _, name := range names {
+			if t, ok := parseRateLimitReset(header.Get(name), now); ok {
+				found = true
+				return &t
+			}
+		}
+		return nil
+	}
+
+	info.LimitRequests = parseInt("x-ratelimit-limit-requests", "x-ratelimit-limit")
+	info.LimitTokens = parseInt("x-ratelimit-limit-tokens")
+	info.RemainingRequests = parseInt("x-ratelimit-remaining-requests", "x-ratelimit-remaining")
+	info.RemainingTokens = parseInt("x-ratelimit-remaining-tokens")
+	info.ResetRequests = parseReset("x-ratelimit-reset-requests", "x-ratelimit-reset")
+	info.ResetTokens = parseReset("x-ratelimit-reset-tokens")
+	if !found {
+		return nil
+	}
+	info.UpdatedAt = now
+	return &info
+}
+
+// parseRateLimitReset 解析重置时间：时长 ("1s"、"6m0s"、"20ms")、秒数、Unix 时间戳或 RFC3339 时间
+func parseRateLimitReset(value string, now time.Time) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+	if secs, err := strconv.ParseFloat(value, 64); err == nil && secs >= 0 {
+		// 超过 10 亿视为 Unix 时间戳
+		if secs > 1e9 {
+			return time.Unix(int64(secs), 0), true
+		}
+		return now.Add(time.Duration(secs * float64(time.Second))), true
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(d), true
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// rateLimitCooldownUntil 剩余请求数 <= minRequests 或剩余 Token 为 0 时返回应冷却到的时间
+// 同时触发时取较晚的重置时间；缺少重置时间时不冷却
+func rateLimitCooldownUntil(info *models.RateLimitInfo, minRequests int) (time.Time, bool) {
+	var until time.Time
+	if info.RemainingRequests != nil && *info.RemainingRequests <= minRequests && info.ResetRequests != nil {
+		until = *info.ResetRequests
+	}
+	if info.RemainingTokens != nil && *info.RemainingTokens <= 0 && info.ResetTokens != nil && info.ResetTokens.After(until) {
+		until = *info.ResetTokens
+	}
+	return until, !until.IsZero()
+}
+
+// trackRateLimit 记录上游返回的限流信息，额度即将耗尽时提前冷却 Key (不等上游返回 429)
+func (h *ProxyHandler) trackRateLimit(c *gin.Context, routing *models.RoutingInfo, resp *http.Response) {
+	now := time.Now()
+	info := parseRateLimitHeaders(resp.Header, now)
+	if info == nil {
+		return
+	}
+	h.lb.keyManager.RecordRateLimit(routing.APIKey, info)
+
+	// 429 由 checkUpstream 按 Retry-After 冷却
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return
+	}
+	minRequests := 0
+	if settings := h.lb.GetGatewaySettings(); settings != nil {
+		minRequests = settings.RateLimitMinRequests
+	}
+	if until, ok := rateLimitCooldownUntil(info, minRequests); ok && until.After(now) {
+		h.reqLog(c).Warnf("Upstream rate limit nearly exhausted for key %s, cooling down until %s",
+			models.MaskAPIKey(routing.APIKey), until.Format(time.RFC3339))
+		h.lb.keyManager.MarkCooldown(routing.APIKey, until.Sub(now))
+	}
+}
+
+// copyRateLimitHeaders 将上游的 x-ratelimit-* 响应头透传给客户端
+func copyRateLimitHeaders(dst, src http.Header) {
+	for k, v := range src {
+		if strings.HasPrefix(http.CanonicalHeaderKey(k), rateLimitHeaderPrefix) {
+			dst[k] = v
+		}
+	}
+}
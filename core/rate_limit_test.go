@@ -0,0 +1,100 @@
+package core
+
+import (
+	"fmt"
+	"llm-gateway/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestParseRateLimitHeaders(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Nil(t, parseRateLimitHeaders(http.Header{}, now))
+
+	h := http.Header{}
+	h.Set("x-ratelimit-limit-requests", "500")
+	h.Set("x-ratelimit-remaining-requests", "499")
+	h.Set("x-ratelimit-reset-requests", "120ms")
+	h.Set("x-ratelimit-remaining-tokens", "0")
+	h.Set("x-ratelimit-reset-tokens", "6m0s")
+	info := parseRateLimitHeaders(h, now)
+	assert.Equal(t, 500, *info.LimitRequests)
+	assert.Equal(t, 499, *info.RemainingRequests)
+	assert.Equal(t, 0, *info.RemainingTokens)
+	assert.Nil(t, info.LimitTokens)
+	assert.Equal(t, now.Add(120*time.Millisecond), *info.ResetRequests)
+	assert.Equal(t, now.Add(6*time.Minute), *info.ResetTokens)
+
+	// 剩余 Token 为 0 时冷却到 Token 重置时间
+	until, ok := rateLimitCooldownUntil(info, 0)
+	assert.True(t, ok)
+	assert.Equal(t, now.Add(6*time.Minute), until)
+
+	// 不带后缀的头视为请求数，重置时间可以是 Unix 时间戳
+	h = http.Header{}
+	h.Set("x-ratelimit-remaining", "3")
+	h.Set("x-ratelimit-reset", fmt.Sprint(now.Add(time.Hour).Unix()))
+	info = parseRateLimitHeaders(h, now)
+	assert.Equal(t, 3, *info.RemainingRequests)
+	assert.Equal(t, now.Add(time.Hour).Unix(), info.ResetRequests.Unix())
+
+	_, ok = rateLimitCooldownUntil(info, 0)
+	assert.False(t, ok)
+	_, ok = rateLimitCooldownUntil(info, 5)
+	assert.True(t, ok)
+}
+
+func TestProxyRequest_ProactiveRateLimitCooldown(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("x-ratelimit-remaining-requests", "0")
+		w.Header().Set("x-ratelimit-reset-requests", "30s")
+		fmt.Fprint(w, `{"id":"1","choices":[{"index":0,"message":{"role":"assistant","content":"ok"}}]}`)
+	}))
+	defer upstream.Close()
+
+	db, err := gorm.Open(sqlite.Open("file:rate_limit_test?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	group := models.ModelGroup{GroupID: "rate-limit-group", Strategy: "fallback"}
+	db.Create(&group)
+	m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: upstream.URL + "/v1", ModelGroupID: group.ID}
+	db.Create(&m)
+	db.Create(&models.APIKey{KeyValue: "sk-rate-limit", ModelConfigID: m.ID})
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	km := NewKeyStateManager()
+	lb, err := NewLoadBalancer(db, logger, km, NewNoOpSecretProvider())
+	assert.NoError(t, err)
+	h := NewProxyHandler(lb, &http.Client{}, logger, nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	h.ProxyRequest(c, models.ChatCompletionRequest{
+		Model:    "rate-limit-group",
+		Messages: []models.ChatMessage{{Role: "user", Content: "hi"}},
+	})
+
+	// 请求本身成功，限流头透传给客户端
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "0", w.Header().Get("X-Ratelimit-Remaining-Requests"))
+
+	// Key 提前冷却到重置时间，限流信息可查询
+	state := km.GetState("sk-rate-limit")
+	assert.Equal(t, KeyStatusCooldown, state.Status)
+	assert.InDelta(t, 30*time.Second, time.Until(state.UnlockTime), float64(time.Second))
+	assert.Equal(t, 0, *lb.GetKeyRateLimit("sk-rate-limit").RemainingRequests)
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"llm-gateway/core/adapter"
+	"llm-gateway/models"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// retryableErrorScanLimit 匹配可重试错误信息时最多读取的响应体字节数
+const retryableErrorScanLimit = 64 << 10
+
+// loadRetryableErrors 解析 GatewaySettings.RetryableErrors，配置无效时记录错误并禁用
+func (lb *LoadBalancer) loadRetryableErrors(raw string) []string {
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+	var patterns []string
+	if err := json.Unmarshal([]byte(raw), &patterns); err != nil {
+		lb.logger.Errorf("Invalid retryable_errors config, disabled: %v", err)
+		return nil
+	}
+	result := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// RetryableErrors 返回配置的可重试错误信息 (小写)，未配置时返回 nil
+func (lb *LoadBalancer) RetryableErrors() []string {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return lb.retryableErrors
+}
+
+// checkRetryableError 上游返回非 200 (或非流式请求的 200 响应体是错误对象) 且响应体包含配置的可重试错误信息时，
+// 短暂冷却 Key 并返回 error，由调用方换用其他 Key/模型重试；未命中时恢复已读取的响应体，后续处理不受影响
+func (h *ProxyHandler) checkRetryableError(c *gin.Context, routing *models.RoutingInfo, resp *http.Response, stream bool) error {
+	patterns := h.lb.RetryableErrors()
+	if len(patterns) == 0 || (resp.StatusCode == 200 && stream) {
+		return nil
+	}
+	// 上游主动压缩时先解压，否则无法匹配
+	if err := adapter.DecodeResponseBody(resp); err != nil {
+		return nil
+	}
+	head, err := io.ReadAll(io.LimitReader(resp.Body, retryableErrorScanLimit))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), resp.Body), resp.Body}
+	if err != nil || (resp.StatusCode == 200 && !isErrorBody(head)) {
+		return nil
+	}
+
+	body := strings.ToLower(string(head))
+	for _, p := range patterns {
+		if !strings.Contains(body, p) {
+			continue
+		}
+		resp.Body.Close()
+		h.reqLog(c).Warnf("Upstream error (%d) matches retryable error %q, retrying", resp.StatusCode, p)
+		c.Set(errorTypeKey, ErrorTypeServer)
+		h.lb.keyManager.MarkCooldown(routing.APIKey, 5*time.Second)
+		h.lb.RecordModelResult(routing.ModelConfigID, false)
+		return fmt.Errorf("upstream transient error (%d): %s", resp.StatusCode, p)
+	}
+	return nil
+}
+
+// isErrorBody 判断 200 响应体是否为错误对象 (OpenAI/Gemini 的 {"error": ...} 或 Claude 的 {"type": "error"})
+func isErrorBody(body []byte) bool {
+	var obj map[string]json.RawMessage
+	if json.Unmarshal(body, &obj) != nil {
+		return false
+	}
+	if e, ok := obj["error"]; ok && string(e) != "null" {
+		return true
+	}
+	return string(obj["type"]) == `"error"`
+}
@@ -97,4 +97,259 @@ func TestSmartKeyFailover(t *testing.T) {
 	
 	assert.True(t, seenA, "Should eventually select Key A after recovery")
 	assert.True(t, seenC, "Should eventually select Key C")
-}
\ No newline at end of file
+}
+func TestCalculateMaxRetries(t *testing.T) {
+	// 默认策略: Key 数 * 1.5，限制在 [3, 12]
+	assert.Equal(t, 3, CalculateMaxRetries(0, 1.5, 3, 12))
+	assert.Equal(t, 3, CalculateMaxRetries(1, 1.5, 3, 12))
+	assert.Equal(t, 3, CalculateMaxRetries(2, 1.5, 3, 12))
+	assert.Equal(t, 5, CalculateMaxRetries(3, 1.5, 3, 12))
+	assert.Equal(t, 12, CalculateMaxRetries(8, 1.5, 3, 12))
+	assert.Equal(t, 12, CalculateMaxRetries(100, 1.5, 3, 12))
+
+	// 自定义倍数与区间
+	assert.Equal(t, 40, CalculateMaxRetries(20, 2, 1, 50))
+	assert.Equal(t, 1, CalculateMaxRetries(1, 0.5, 1, 5))
+
+	// 非法区间: min 至少为 1，max 不小于 min
+	assert.Equal(t, 1, CalculateMaxRetries(0, 1.5, 0, 0))
+	assert.Equal(t, 4, CalculateMaxRetries(10, 1.5, 4, 2))
+}
+
+func TestPreviewRoute_NoSideEffects(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:preview?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	group := models.ModelGroup{GroupID: "preview-group", Strategy: "round_robin"}
+	db.Create(&group)
+	for _, name := range []string{"model-a", "model-b"} {
+		m := models.ModelConfig{ProviderName: "openai", UpstreamModel: name, UpstreamURL: "https://api.openai.com", ModelGroupID: group.ID}
+		db.Create(&m)
+		db.Create(&models.APIKey{KeyValue: "sk-" + name, ModelConfigID: m.ID})
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+
+	// 多次预览结果一致，且与下一次真实路由相同
+	preview, idx, err := lb.PreviewRoute("preview-group")
+	assert.NoError(t, err)
+	again, _, _ := lb.PreviewRoute("preview-group")
+	assert.Equal(t, preview.UpstreamModel, again.UpstreamModel)
+
+	routing, err := lb.Route("preview-group")
+	assert.NoError(t, err)
+	assert.Equal(t, preview.UpstreamModel, routing.UpstreamModel)
+	assert.Equal(t, preview.UpstreamModel, lb.groupStates["preview-group"].Models[idx].UpstreamModel)
+
+	// Pinning
+	pinned, idx, err := lb.PreviewRoute("preview-group$2")
+	assert.NoError(t, err)
+	assert.Equal(t, "model-b", pinned.UpstreamModel)
+	assert.Equal(t, 1, idx)
+}
+
+func TestRefreshData_PreservesCounters(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:reload?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	group := models.ModelGroup{GroupID: "reload-group", Strategy: "round_robin"}
+	db.Create(&group)
+	for _, name := range []string{"model-a", "model-b", "model-c"} {
+		m := models.ModelConfig{ProviderName: "openai", UpstreamModel: name, UpstreamURL: "https://api.openai.com", ModelGroupID: group.ID}
+		db.Create(&m)
+		db.Create(&models.APIKey{KeyValue: "sk-" + name, ModelConfigID: m.ID})
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err := lb.Route("reload-group")
+		assert.NoError(t, err)
+	}
+	before := lb.groupStates["reload-group"].RequestCounter.Load()
+	next, _, _ := lb.PreviewRoute("reload-group")
+
+	// 重载后计数器保留，轮询从中断处继续；新增的组从 0 开始
+	db.Create(&models.ModelGroup{GroupID: "new-group", Strategy: "round_robin"})
+	assert.NoError(t, lb.RefreshData())
+	assert.Equal(t, before, lb.groupStates["reload-group"].RequestCounter.Load())
+	assert.Equal(t, uint64(0), lb.groupStates["new-group"].RequestCounter.Load())
+
+	routing, err := lb.Route("reload-group")
+	assert.NoError(t, err)
+	assert.Equal(t, next.UpstreamModel, routing.UpstreamModel)
+}
+
+func TestRoute_DefaultGroup(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:default_group?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	group := models.ModelGroup{GroupID: "fallback-group", Strategy: "round_robin"}
+	db.Create(&group)
+	m := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: "https://api.openai.com", ModelGroupID: group.ID}
+	db.Create(&m)
+	db.Create(&models.APIKey{KeyValue: "sk-default", ModelConfigID: m.ID})
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+
+	// 未配置默认组时保持严格匹配
+	_, err = lb.Route("unknown-model")
+	assert.ErrorIs(t, err, ErrGroupNotFound)
+
+	db.Model(&models.GatewaySettings{}).Where("1 = 1").Update("default_group", "fallback-group")
+	assert.NoError(t, lb.RefreshData())
+
+	routing, err := lb.Route("unknown-model$3")
+	assert.NoError(t, err)
+	assert.Equal(t, "fallback-group", routing.GroupID)
+	assert.Equal(t, "gpt-4", routing.UpstreamModel)
+
+	// 默认组本身不存在时仍返回错误
+	db.Model(&models.GatewaySettings{}).Where("1 = 1").Update("default_group", "missing-group")
+	assert.NoError(t, lb.RefreshData())
+	_, err = lb.Route("unknown-model")
+	assert.ErrorIs(t, err, ErrGroupNotFound)
+}
+
+func TestRefreshData_PriorityOrder(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:priority?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	group := models.ModelGroup{GroupID: "priority-group", Strategy: "fallback"}
+	db.Create(&group)
+	for _, m := range []struct {
+		name     string
+		priority int
+	}{{"model-a", 0}, {"model-b", 0}, {"model-c", -1}} {
+		mc := models.ModelConfig{ProviderName: "openai", UpstreamModel: m.name, UpstreamURL: "https://api.openai.com", ModelGroupID: group.ID, Priority: m.priority}
+		db.Create(&mc)
+		db.Create(&models.APIKey{KeyValue: "sk-" + m.name, ModelConfigID: mc.ID})
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	lb, err := NewLoadBalancer(db, logger, NewKeyStateManager(), NewNoOpSecretProvider())
+	assert.NoError(t, err)
+
+	// 优先级越小越靠前，相同时按 ID
+	var order []string
+	for _, m := range lb.groupStates["priority-group"].Models {
+		order = append(order, m.UpstreamModel)
+	}
+	assert.Equal(t, []string{"model-c", "model-a", "model-b"}, order)
+
+	routing, err := lb.Route("priority-group")
+	assert.NoError(t, err)
+	assert.Equal(t, "model-c", routing.UpstreamModel)
+
+	pinned, err := lb.Route("priority-group$3")
+	assert.NoError(t, err)
+	assert.Equal(t, "model-b", pinned.UpstreamModel)
+}
+
+func TestRoute_WeightedKeys(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:weighted_keys?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	group := models.ModelGroup{GroupID: "weighted-group", Strategy: "round_robin"}
+	db.Create(&group)
+	mc := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: "https://api.openai.com", ModelGroupID: group.ID}
+	db.Create(&mc)
+	db.Create(&models.APIKey{KeyValue: "sk-heavy", ModelConfigID: mc.ID, Weight: 3})
+	db.Create(&models.APIKey{KeyValue: "sk-light", ModelConfigID: mc.ID}) // 默认权重 1
+	db.Create(&models.APIKey{KeyValue: "sk-spare", ModelConfigID: mc.ID, Weight: 2})
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	km := NewKeyStateManager()
+	lb, err := NewLoadBalancer(db, logger, km, NewNoOpSecretProvider())
+	assert.NoError(t, err)
+
+	route := func(n int) map[string]int {
+		counts := make(map[string]int)
+		for i := 0; i < n; i++ {
+			routing, err := lb.Route("weighted-group")
+			assert.NoError(t, err)
+			counts[routing.APIKey]++
+		}
+		return counts
+	}
+
+	// 按 3:1:2 分配
+	assert.Equal(t, map[string]int{"sk-heavy": 300, "sk-light": 100, "sk-spare": 200}, route(600))
+
+	// 不可用的 Key 被跳过，其份额顺延给下一个 Key
+	km.MarkDead("sk-heavy")
+	assert.Equal(t, map[string]int{"sk-light": 400, "sk-spare": 200}, route(600))
+}
+
+func TestRoute_LeastLoadedKeys(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:least_loaded_keys?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, models.AutoMigrate(db))
+	db.Create(&models.GatewaySettings{Port: 8000})
+
+	group := models.ModelGroup{GroupID: "least-loaded-group", Strategy: "round_robin", LeastLoadedKeys: true}
+	db.Create(&group)
+	mc := models.ModelConfig{ProviderName: "openai", UpstreamModel: "gpt-4", UpstreamURL: "https://api.openai.com", ModelGroupID: group.ID}
+	db.Create(&mc)
+	for _, k := range []string{"sk-1", "sk-2", "sk-3"} {
+		db.Create(&models.APIKey{KeyValue: k, ModelConfigID: mc.ID})
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	km := NewKeyStateManager()
+	lb, err := NewLoadBalancer(db, logger, km, NewNoOpSecretProvider())
+	assert.NoError(t, err)
+
+	// 请求未结束时，后续请求依次分配到空闲的 Key
+	held := make(map[string]*models.RoutingInfo)
+	for i := 0; i < 3; i++ {
+		routing, err := lb.Route("least-loaded-group")
+		assert.NoError(t, err)
+		assert.True(t, routing.KeyAcquired)
+		held[routing.APIKey] = routing
+	}
+	assert.Len(t, held, 3)
+
+	// 预览不占用计数
+	preview, _, err := lb.PreviewRoute("least-loaded-group")
+	assert.NoError(t, err)
+	assert.False(t, preview.KeyAcquired)
+
+	// 释放后该 Key 负载最低，下一次请求优先选择它；重复释放无副作用
+	lb.ReleaseKey(held["sk-2"])
+	lb.ReleaseKey(held["sk-2"])
+	assert.Equal(t, 0, km.InFlight("sk-2"))
+	routing, err := lb.Route("least-loaded-group")
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-2", routing.APIKey)
+
+	// 负载最低的 Key 不可用时跳过
+	lb.ReleaseKey(routing)
+	km.MarkDead("sk-2")
+	routing, err = lb.Route("least-loaded-group")
+	assert.NoError(t, err)
+	assert.NotEqual(t, "sk-2", routing.APIKey)
+	assert.Equal(t, 2, km.InFlight(routing.APIKey))
+}
@@ -7,30 +7,55 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 // AESSecretProvider 实现基于 AES-GCM 的加解密
+// 支持多版本密钥：始终使用当前版本加密，密文带有 "v{N}:" 前缀，
+// 解密时按前缀选择对应版本的密钥，从而可以在不停机的情况下轮换密钥。
 type AESSecretProvider struct {
-	key []byte
+	keys           map[int][]byte // version -> key
+	currentVersion int
 }
 
-// NewAESSecretProvider 创建新的 AES Secret Provider
+// NewAESSecretProvider 创建新的 AES Secret Provider (单密钥，版本 1)
 // keyStr 必须是 16, 24, 或 32 字节长的字符串（对应 AES-128, AES-192, AES-256）
 func NewAESSecretProvider(keyStr string) (*AESSecretProvider, error) {
-	key := []byte(keyStr)
-	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
-		return nil, fmt.Errorf("invalid key length: %d. Must be 16, 24, or 32 bytes", len(key))
-	}
-	return &AESSecretProvider{key: key}, nil
+	return NewVersionedAESSecretProvider(map[int]string{1: keyStr}, 1)
 }
 
-func (p *AESSecretProvider) Encrypt(plaintext string) (string, error) {
-	block, err := aes.NewCipher(p.key)
-	if err != nil {
-		return "", err
+// NewVersionedAESSecretProvider 创建支持密钥轮换的 AES Secret Provider
+// keys: 版本号 -> 密钥，current: 用于加密的当前版本
+func NewVersionedAESSecretProvider(keys map[int]string, current int) (*AESSecretProvider, error) {
+	p := &AESSecretProvider{
+		keys:           make(map[int][]byte, len(keys)),
+		currentVersion: current,
 	}
+	for version, keyStr := range keys {
+		if version < 1 {
+			return nil, fmt.Errorf("invalid key version: %d. Must be >= 1", version)
+		}
+		key := []byte(keyStr)
+		if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+			return nil, fmt.Errorf("invalid key length for version %d: %d. Must be 16, 24, or 32 bytes", version, len(key))
+		}
+		p.keys[version] = key
+	}
+	if _, ok := p.keys[current]; !ok {
+		return nil, fmt.Errorf("current key version %d not provided", current)
+	}
+	return p, nil
+}
 
-	gcm, err := cipher.NewGCM(block)
+// CurrentVersion 返回用于加密的密钥版本
+func (p *AESSecretProvider) CurrentVersion() int {
+	return p.currentVersion
+}
+
+func (p *AESSecretProvider) Encrypt(plaintext string) (string, error) {
+	gcm, err := newGCM(p.keys[p.currentVersion])
 	if err != nil {
 		return "", err
 	}
@@ -41,21 +66,68 @@ func (p *AESSecretProvider) Encrypt(plaintext string) (string, error) {
 	}
 
 	ciphertext := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
-	return base64.StdEncoding.EncodeToString(ciphertext), nil
+	return fmt.Sprintf("v%d:%s", p.currentVersion, base64.StdEncoding.EncodeToString(ciphertext)), nil
 }
 
-func (p *AESSecretProvider) Decrypt(ciphertextBase64 string) (string, error) {
-	data, err := base64.StdEncoding.DecodeString(ciphertextBase64)
+func (p *AESSecretProvider) Decrypt(ciphertext string) (string, error) {
+	version, payload, versioned := splitVersion(ciphertext)
+	if versioned {
+		key, ok := p.keys[version]
+		if !ok {
+			return "", fmt.Errorf("unknown key version: v%d", version)
+		}
+		return decryptWithKey(key, payload)
+	}
+
+	// 兼容旧的无版本前缀密文：按版本从旧到新依次尝试
+	versions := make([]int, 0, len(p.keys))
+	for v := range p.keys {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+
+	var lastErr error
+	for _, v := range versions {
+		plaintext, err := decryptWithKey(p.keys[v], ciphertext)
+		if err == nil {
+			return plaintext, nil
+		}
+		lastErr = err
+	}
+	return "", lastErr
+}
+
+// splitVersion 解析 "v{N}:base64" 格式的密文
+func splitVersion(s string) (int, string, bool) {
+	if !strings.HasPrefix(s, "v") {
+		return 0, s, false
+	}
+	idx := strings.Index(s, ":")
+	if idx == -1 {
+		return 0, s, false
+	}
+	version, err := strconv.Atoi(s[1:idx])
 	if err != nil {
-		return "", err
+		return 0, s, false
 	}
+	return version, s[idx+1:], true
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
 
-	block, err := aes.NewCipher(p.key)
+func decryptWithKey(key []byte, ciphertextBase64 string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(ciphertextBase64)
 	if err != nil {
 		return "", err
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	gcm, err := newGCM(key)
 	if err != nil {
 		return "", err
 	}
@@ -74,8 +146,8 @@ func (p *AESSecretProvider) Decrypt(ciphertextBase64 string) (string, error) {
 	return string(plaintext), nil
 }
 
-// IsBase64 简单的 Base64 格式判断
-func IsBase64(s string) bool {
-	_, err := base64.StdEncoding.DecodeString(s)
-	return err == nil
+// HasVersionPrefix 判断字符串是否为带版本前缀的密文 ("v{N}:...")
+func HasVersionPrefix(s string) bool {
+	_, _, versioned := splitVersion(s)
+	return versioned
 }
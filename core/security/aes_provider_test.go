@@ -0,0 +1,66 @@
+package security
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	oldKey = "0123456789abcdef0123456789abcdef"
+	newKey = "fedcba9876543210fedcba9876543210"
+)
+
+func TestAESSecretProvider_VersionedRoundTrip(t *testing.T) {
+	p, err := NewAESSecretProvider(oldKey)
+	assert.NoError(t, err)
+
+	enc, err := p.Encrypt("sk-secret")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(enc, "v1:"))
+
+	dec, err := p.Decrypt(enc)
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-secret", dec)
+}
+
+func TestAESSecretProvider_Rotation(t *testing.T) {
+	v1, _ := NewAESSecretProvider(oldKey)
+	oldCiphertext, _ := v1.Encrypt("sk-old")
+
+	rotated, err := NewVersionedAESSecretProvider(map[int]string{1: oldKey, 2: newKey}, 2)
+	assert.NoError(t, err)
+
+	// 旧版本密文仍可解密
+	dec, err := rotated.Decrypt(oldCiphertext)
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-old", dec)
+
+	// 新密文使用当前版本
+	enc, _ := rotated.Encrypt("sk-new")
+	assert.True(t, strings.HasPrefix(enc, "v2:"))
+
+	// 只持有旧密钥的 provider 无法解密新版本
+	_, err = v1.Decrypt(enc)
+	assert.Error(t, err)
+}
+
+func TestAESSecretProvider_LegacyUnversioned(t *testing.T) {
+	p, _ := NewAESSecretProvider(oldKey)
+	enc, _ := p.Encrypt("sk-legacy")
+	legacy := strings.TrimPrefix(enc, "v1:")
+
+	rotated, _ := NewVersionedAESSecretProvider(map[int]string{1: oldKey, 2: newKey}, 2)
+	dec, err := rotated.Decrypt(legacy)
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-legacy", dec)
+}
+
+func TestNewVersionedAESSecretProvider_Invalid(t *testing.T) {
+	_, err := NewVersionedAESSecretProvider(map[int]string{1: "short"}, 1)
+	assert.Error(t, err)
+
+	_, err = NewVersionedAESSecretProvider(map[int]string{1: oldKey}, 2)
+	assert.Error(t, err)
+}
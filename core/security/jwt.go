@@ -0,0 +1,275 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTConfig 管理端 JWT 鉴权配置
+// Secret 与 JWKSURL 至少配置一个：HS* 签名使用 Secret 校验，RS*/PS*/ES* 签名使用 JWKS 公钥校验。
+type JWTConfig struct {
+	Secret        string // HMAC 共享密钥
+	JWKSURL       string // 身份提供方的 JWKS 地址
+	Issuer        string // 非空时校验 iss
+	Audience      string // 非空时校验 aud
+	IdentityClaim string // 作为调用者身份的 claim，默认 "sub"
+	ScopeClaim    string // 权限 claim，默认 "scope"，包含 "admin" 时具有管理员权限
+	GroupsClaim   string // 可访问模型组的 claim，留空不读取 (不限制)
+}
+
+// JWTIdentity 从 JWT 中提取的调用者信息
+type JWTIdentity struct {
+	Subject string   // 身份 claim 的值
+	Scopes  []string // 权限 claim 的值
+	Groups  []string // 可访问的模型组，为空表示不限制
+}
+
+// HasScope 判断是否具有指定权限
+func (id *JWTIdentity) HasScope(scope string) bool {
+	return slices.Contains(id.Scopes, scope)
+}
+
+// JWTVerifier 校验管理端 JWT 并提取调用者身份
+type JWTVerifier struct {
+	cfg  JWTConfig
+	jwks *jwksCache
+}
+
+// NewJWTVerifier 创建 JWT 校验器
+func NewJWTVerifier(cfg JWTConfig) (*JWTVerifier, error) {
+	if cfg.Secret == "" && cfg.JWKSURL == "" {
+		return nil, errors.New("either a shared secret or a JWKS URL is required")
+	}
+	if cfg.IdentityClaim == "" {
+		cfg.IdentityClaim = "sub"
+	}
+	if cfg.ScopeClaim == "" {
+		cfg.ScopeClaim = "scope"
+	}
+
+	v := &JWTVerifier{cfg: cfg}
+	if cfg.JWKSURL != "" {
+		v.jwks = &jwksCache{
+			url:    cfg.JWKSURL,
+			client: &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+	return v, nil
+}
+
+// Verify 校验签名、有效期 (exp 必填)、iss 与 aud，返回身份、权限与可访问组 claim 的值
+func (v *JWTVerifier) Verify(tokenString string) (*JWTIdentity, error) {
+	var methods []string
+	if v.cfg.Secret != "" {
+		methods = append(methods, "HS256", "HS384", "HS512")
+	}
+	if v.jwks != nil {
+		methods = append(methods, "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512")
+	}
+
+	opts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if v.cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.cfg.Issuer))
+	}
+	if v.cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(v.cfg.Audience))
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(tokenString, claims, v.keyFunc, opts...); err != nil {
+		return nil, err
+	}
+
+	subject, ok := claims[v.cfg.IdentityClaim].(string)
+	if !ok || subject == "" {
+		return nil, fmt.Errorf("token has no %q claim", v.cfg.IdentityClaim)
+	}
+	identity := &JWTIdentity{Subject: subject, Scopes: claimStrings(claims[v.cfg.ScopeClaim])}
+	if v.cfg.GroupsClaim != "" {
+		identity.Groups = claimStrings(claims[v.cfg.GroupsClaim])
+	}
+	return identity, nil
+}
+
+// claimStrings 读取字符串列表 claim：支持空格分隔的字符串 (OAuth scope) 与字符串数组
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func (v *JWTVerifier) keyFunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return []byte(v.cfg.Secret), nil
+	}
+	kid, _ := token.Header["kid"].(string)
+	return v.jwks.key(kid)
+}
+
+// LooksLikeJWT 判断 token 是否为 JWT 格式 (三段 base64url，头部含 alg)
+// 管理员密钥 (sk-admin-xxx) 不含 "."，不会被误判
+func LooksLikeJWT(token string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	return json.Unmarshal(data, &header) == nil && header.Alg != ""
+}
+
+const (
+	jwksRefreshInterval = 10 * time.Minute
+	jwksMinRefetch      = time.Minute // 遇到未知 kid 时的最短重新拉取间隔
+)
+
+// jwksCache 缓存 JWKS 公钥，定期刷新；遇到未知 kid 时提前刷新 (用于身份提供方轮换密钥)
+type jwksCache struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+func (j *jwksCache) key(kid string) (interface{}, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	age := time.Since(j.fetchedAt)
+	_, found := j.keys[kid]
+	if j.keys == nil || age > jwksRefreshInterval || (!found && age > jwksMinRefetch) {
+		if err := j.refreshLocked(); err != nil && j.keys == nil {
+			return nil, err
+		}
+	}
+
+	if kid == "" && len(j.keys) == 1 {
+		for _, k := range j.keys {
+			return k, nil
+		}
+	}
+	if k, ok := j.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("no JWKS key found for kid %q", kid)
+}
+
+func (j *jwksCache) refreshLocked() error {
+	j.fetchedAt = time.Now()
+
+	resp, err := j.client.Get(j.url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch JWKS: status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if pub, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = pub
+		}
+	}
+	j.keys = keys
+	return nil
+}
+
+// jsonWebKey JWKS 中的单个公钥 (仅支持 RSA 与 EC)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
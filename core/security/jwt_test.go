@@ -0,0 +1,122 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func signHS256(t *testing.T, secret string, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	assert.NoError(t, err)
+	return token
+}
+
+func TestJWTVerifier_SharedSecret(t *testing.T) {
+	v, err := NewJWTVerifier(JWTConfig{Secret: "s3cret", Issuer: "https://idp.example.com", IdentityClaim: "email"})
+	assert.NoError(t, err)
+
+	exp := time.Now().Add(time.Hour).Unix()
+	token := signHS256(t, "s3cret", jwt.MapClaims{"iss": "https://idp.example.com", "email": "ops@example.com", "exp": exp})
+	assert.True(t, LooksLikeJWT(token))
+
+	identity, err := v.Verify(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "ops@example.com", identity.Subject)
+	assert.Empty(t, identity.Scopes)
+
+	// 签名错误 / 过期 / 缺少 exp / issuer 不匹配 / 缺少身份 claim
+	for _, bad := range []string{
+		signHS256(t, "wrong", jwt.MapClaims{"iss": "https://idp.example.com", "email": "a", "exp": exp}),
+		signHS256(t, "s3cret", jwt.MapClaims{"iss": "https://idp.example.com", "email": "a", "exp": time.Now().Add(-time.Minute).Unix()}),
+		signHS256(t, "s3cret", jwt.MapClaims{"iss": "https://idp.example.com", "email": "a"}),
+		signHS256(t, "s3cret", jwt.MapClaims{"iss": "https://other.example.com", "email": "a", "exp": exp}),
+		signHS256(t, "s3cret", jwt.MapClaims{"iss": "https://idp.example.com", "sub": "a", "exp": exp}),
+	} {
+		_, err := v.Verify(bad)
+		assert.Error(t, err)
+	}
+}
+
+func TestJWTVerifier_JWKS(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(priv.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(priv.E)).Bytes()),
+			}},
+		})
+	}))
+	defer srv.Close()
+
+	v, err := NewJWTVerifier(JWTConfig{JWKSURL: srv.URL, Audience: "llm-gateway"})
+	assert.NoError(t, err)
+
+	sign := func(kid string, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = kid
+		s, err := token.SignedString(priv)
+		assert.NoError(t, err)
+		return s
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	identity, err := v.Verify(sign("k1", jwt.MapClaims{"sub": "alice", "aud": "llm-gateway", "exp": exp}))
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", identity.Subject)
+
+	_, err = v.Verify(sign("unknown", jwt.MapClaims{"sub": "alice", "aud": "llm-gateway", "exp": exp}))
+	assert.Error(t, err)
+
+	_, err = v.Verify(sign("k1", jwt.MapClaims{"sub": "alice", "aud": "other", "exp": exp}))
+	assert.Error(t, err)
+
+	// 仅配置 JWKS 时不接受 HMAC 签名
+	_, err = v.Verify(signHS256(t, "anything", jwt.MapClaims{"sub": "alice", "aud": "llm-gateway", "exp": exp}))
+	assert.Error(t, err)
+}
+
+func TestJWTVerifier_ScopeAndGroups(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Unix()
+
+	// 默认读取 scope (空格分隔)，未配置 GroupsClaim 时不读取可访问组
+	v, err := NewJWTVerifier(JWTConfig{Secret: "s3cret"})
+	assert.NoError(t, err)
+	identity, err := v.Verify(signHS256(t, "s3cret", jwt.MapClaims{"sub": "alice", "scope": "openid admin", "groups": []string{"team-a"}, "exp": exp}))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"openid", "admin"}, identity.Scopes)
+	assert.True(t, identity.HasScope("admin"))
+	assert.Empty(t, identity.Groups)
+
+	// 自定义 claim，支持字符串数组
+	v, err = NewJWTVerifier(JWTConfig{Secret: "s3cret", ScopeClaim: "roles", GroupsClaim: "llm_groups"})
+	assert.NoError(t, err)
+	identity, err = v.Verify(signHS256(t, "s3cret", jwt.MapClaims{"sub": "bob", "scope": "admin", "roles": []string{"reader"}, "llm_groups": []string{"team-a", "team-b"}, "exp": exp}))
+	assert.NoError(t, err)
+	assert.False(t, identity.HasScope("admin"))
+	assert.Equal(t, []string{"team-a", "team-b"}, identity.Groups)
+}
+
+func TestLooksLikeJWT(t *testing.T) {
+	assert.False(t, LooksLikeJWT("sk-admin-0123456789abcdef"))
+	assert.False(t, LooksLikeJWT("a.b.c"))
+	assert.False(t, LooksLikeJWT(""))
+
+	_, err := NewJWTVerifier(JWTConfig{})
+	assert.Error(t, err)
+}
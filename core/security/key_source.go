@@ -0,0 +1,87 @@
+package security
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeySource 抽象加密密钥的来源 (环境变量、本地文件，未来可接入 KMS)
+type KeySource interface {
+	// Name 返回来源描述，用于日志
+	Name() string
+
+	// LoadKey 读取原始密钥，未配置时返回 found=false
+	LoadKey() (key string, found bool, err error)
+}
+
+// EnvKeySource 从环境变量读取密钥
+type EnvKeySource struct {
+	Var string
+}
+
+func (s EnvKeySource) Name() string { return "env:" + s.Var }
+
+func (s EnvKeySource) LoadKey() (string, bool, error) {
+	val, ok := os.LookupEnv(s.Var)
+	if !ok || strings.TrimSpace(val) == "" {
+		return "", false, nil
+	}
+	return strings.TrimSpace(val), true, nil
+}
+
+// FileKeySource 从本地文件读取密钥
+type FileKeySource struct {
+	Path string
+}
+
+func (s FileKeySource) Name() string { return "file:" + s.Path }
+
+func (s FileKeySource) LoadKey() (string, bool, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return strings.TrimSpace(string(data)), true, nil
+}
+
+// ParseSecretKey 校验并规范化密钥
+// 支持原始字符串 (16/24/32 字节) 或其十六进制编码 (32/48/64 个字符)
+func ParseSecretKey(raw string) (string, error) {
+	if isValidKeyLength(len(raw)) {
+		return raw, nil
+	}
+	if decoded, err := hex.DecodeString(raw); err == nil && isValidKeyLength(len(decoded)) {
+		return string(decoded), nil
+	}
+	return "", fmt.Errorf("invalid secret key length: %d. Must be 16, 24, or 32 bytes (or the hex encoding thereof)", len(raw))
+}
+
+// LoadSecretKey 按顺序从各来源读取密钥，返回第一个找到的有效密钥
+// 来源已配置但密钥无效时立即返回错误，不再尝试后续来源
+func LoadSecretKey(sources ...KeySource) (key string, source string, err error) {
+	for _, src := range sources {
+		raw, found, err := src.LoadKey()
+		if err != nil {
+			return "", src.Name(), fmt.Errorf("failed to read secret key from %s: %w", src.Name(), err)
+		}
+		if !found {
+			continue
+		}
+		key, err := ParseSecretKey(raw)
+		if err != nil {
+			return "", src.Name(), fmt.Errorf("%s: %w", src.Name(), err)
+		}
+		return key, src.Name(), nil
+	}
+	return "", "", nil
+}
+
+func isValidKeyLength(n int) bool {
+	return n == 16 || n == 24 || n == 32
+}
@@ -0,0 +1,47 @@
+package security
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSecretKey(t *testing.T) {
+	raw := "0123456789abcdef0123456789abcdef"
+
+	key, err := ParseSecretKey(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, raw, key)
+
+	key, err = ParseSecretKey(hex.EncodeToString([]byte(raw)))
+	assert.NoError(t, err)
+	assert.Equal(t, raw, key)
+
+	_, err = ParseSecretKey("too-short")
+	assert.Error(t, err)
+}
+
+func TestLoadSecretKey_EnvTakesPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.key")
+	os.WriteFile(path, []byte("fedcba9876543210fedcba9876543210\n"), 0600)
+
+	t.Setenv("TEST_GATEWAY_SECRET_KEY", "0123456789abcdef0123456789abcdef")
+	key, source, err := LoadSecretKey(EnvKeySource{Var: "TEST_GATEWAY_SECRET_KEY"}, FileKeySource{Path: path})
+	assert.NoError(t, err)
+	assert.Equal(t, "env:TEST_GATEWAY_SECRET_KEY", source)
+	assert.Equal(t, "0123456789abcdef0123456789abcdef", key)
+
+	// 环境变量未设置时回退到文件
+	key, source, err = LoadSecretKey(EnvKeySource{Var: "TEST_GATEWAY_SECRET_KEY_UNSET"}, FileKeySource{Path: path})
+	assert.NoError(t, err)
+	assert.Equal(t, "file:"+path, source)
+	assert.Equal(t, "fedcba9876543210fedcba9876543210", key)
+
+	// 配置了无效密钥时立即失败
+	t.Setenv("TEST_GATEWAY_SECRET_KEY", "invalid")
+	_, _, err = LoadSecretKey(EnvKeySource{Var: "TEST_GATEWAY_SECRET_KEY"}, FileKeySource{Path: path})
+	assert.Error(t, err)
+}
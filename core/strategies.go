@@ -37,3 +37,12 @@ func (s *FallbackStrategy) Select(configs []*models.ModelConfig, _ uint64) (*mod
 	// 总是返回优先级最高的第一个 (由调用者处理失败后的重试，或在此处结合健康检查)
 	return configs[0], nil
 }
+
+// RaceStrategy 竞速策略
+// 非流式请求由 ProxyHandler 并发发往前 K 个候选，取最先成功的响应；
+// Select 仅用于流式请求等无法竞速的场景，行为与轮询一致。
+type RaceStrategy struct {
+	RoundRobinStrategy
+}
+
+func (s *RaceStrategy) Name() string { return "race" }
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"llm-gateway/core/adapter"
+	"llm-gateway/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errEmptyStream 上游返回 200 但流中没有任何内容 (视为软失败，可重试)
+var errEmptyStream = errors.New("upstream stream closed without content")
+
+// streamGuardWriter 缓冲流式输出，直到出现第一个带内容的 chunk 才提交给客户端
+// 用于检测上游返回 200 后直接关闭流的情况：未提交时可以丢弃输出并重试下一个 Key/模型
+type streamGuardWriter struct {
+	gin.ResponseWriter // 实际的客户端 Writer
+
+	header    http.Header
+	status    int
+	pending   bytes.Buffer
+	scanner   sseContentScanner
+	committed bool
+}
+
+func newStreamGuardWriter(w gin.ResponseWriter) *streamGuardWriter {
+	return &streamGuardWriter{ResponseWriter: w, header: make(http.Header), status: http.StatusOK}
+}
+
+func (w *streamGuardWriter) Header() http.Header {
+	if w.committed {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+func (w *streamGuardWriter) WriteHeader(code int) {
+	if w.committed {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+}
+
+func (w *streamGuardWriter) WriteHeaderNow() {
+	if w.committed {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *streamGuardWriter) Write(b []byte) (int, error) {
+	if w.committed {
+		return w.ResponseWriter.Write(b)
+	}
+	w.pending.Write(b)
+	if w.scanner.scan(b) {
+		if err := w.commit(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (w *streamGuardWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *streamGuardWriter) Flush() {
+	if w.committed {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *streamGuardWriter) Status() int {
+	if w.committed {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *streamGuardWriter) Size() int {
+	if w.committed {
+		return w.ResponseWriter.Size()
+	}
+	return -1
+}
+
+func (w *streamGuardWriter) Written() bool {
+	return w.committed
+}
+
+// handleGuardedStream 通过 streamGuardWriter 写出流式响应
+// 流结束 (或读取失败) 时仍未出现任何内容且客户端未断开，则丢弃已缓冲的输出并返回 retry=true
+func (h *ProxyHandler) handleGuardedStream(c *gin.Context, adp adapter.ProviderAdapter, resp *http.Response) (retry bool, err error) {
+	original := c.Writer
+	guard := newStreamGuardWriter(original)
+	c.Writer = guard
+	err = h.handleResponse(c, adp, resp, true)
+	c.Writer = original
+
+	if guard.committed {
+		return false, err
+	}
+	if c.Request.Context().Err() == nil {
+		return true, err
+	}
+	return false, guard.commit()
+}
+
+// commit 将缓冲的响应头与内容写给客户端，之后的写入直接透传
+func (w *streamGuardWriter) commit() error {
+	if w.committed {
+		return nil
+	}
+	w.committed = true
+	for k, v := range w.header {
+		w.ResponseWriter.Header()[k] = v
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if _, err := w.ResponseWriter.Write(w.pending.Bytes()); err != nil {
+		return err
+	}
+	w.pending.Reset()
+	w.ResponseWriter.Flush()
+	return nil
+}
+
+// sseContentScanner 逐行检查写出的 SSE 数据中是否出现带内容的 chunk (正文、思考过程或工具调用)
+// 被拆分到多次写入中的不完整行保留到下一次写入时再检查
+type sseContentScanner struct {
+	partial []byte
+}
+
+// scan 检查本次写入后新出现的完整 SSE 行，其中有带内容的 chunk 时返回 true
+func (s *sseContentScanner) scan(b []byte) bool {
+	data := append(s.partial, b...)
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		s.partial = data
+		return false
+	}
+	s.partial = append([]byte(nil), data[end+1:]...)
+
+	for _, line := range bytes.Split(data[:end], []byte("\n")) {
+		payload, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+		if !ok {
+			continue
+		}
+		var chunk models.ChatCompletionResponse
+		if json.Unmarshal(bytes.TrimSpace(payload), &chunk) != nil {
+			continue
+		}
+		for _, choice := range chunk.Choices {
+			d := choice.Delta
+			if (d.Content != nil && d.Content != "") || d.ReasoningContent != "" || len(d.ToolCalls) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package core
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ttftKey Context 中记录流式响应首字节耗时 (time.Duration) 的键，由请求日志中间件读取
+const ttftKey = "ttft"
+
+// firstByteWriter 记录流式响应第一次向客户端写出带内容 chunk 的时间 (TTFT，从请求开始计算)
+// 只有角色等空 delta 的 chunk 不算首字；包在最外层的响应写入器上，因此空流重试等被缓冲或丢弃的内容不会计入；
+// 错误响应 (非 200) 不记录
+type firstByteWriter struct {
+	gin.ResponseWriter
+
+	c       *gin.Context
+	start   time.Time
+	scanner sseContentScanner
+	seen    bool
+}
+
+func newFirstByteWriter(c *gin.Context, start time.Time) *firstByteWriter {
+	return &firstByteWriter{ResponseWriter: c.Writer, c: c, start: start}
+}
+
+func (w *firstByteWriter) Write(b []byte) (int, error) {
+	w.mark(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *firstByteWriter) WriteString(s string) (int, error) {
+	if !w.seen {
+		w.mark([]byte(s))
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *firstByteWriter) mark(b []byte) {
+	if w.seen || len(b) == 0 {
+		return
+	}
+	if w.ResponseWriter.Status() != 200 {
+		w.seen = true
+		return
+	}
+	if w.scanner.scan(b) {
+		w.seen = true
+		w.c.Set(ttftKey, time.Since(w.start))
+	}
+}
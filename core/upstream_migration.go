@@ -0,0 +1,52 @@
+package core
+
+import (
+	"fmt"
+	"llm-gateway/core/adapter"
+	"llm-gateway/models"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// MigrateUpstreamPaths 将 OpenAI 兼容提供商保存的完整接口地址拆分为 base URL + upstream_path
+// 拆分前后实际请求的地址不变；已配置 upstream_path 的模型以及 Gemini/Claude 模型不做修改。
+func MigrateUpstreamPaths(db *gorm.DB, logger *logrus.Logger) (int, error) {
+	migrated := 0
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var configs []models.ModelConfig
+		if err := tx.Unscoped().Where("upstream_path = '' OR upstream_path IS NULL").Find(&configs).Error; err != nil {
+			return fmt.Errorf("failed to query models: %w", err)
+		}
+
+		for i := range configs {
+			switch strings.ToLower(configs[i].ProviderName) {
+			case "gemini", "claude", "anthropic":
+				continue
+			}
+			base, path, ok := adapter.SplitOpenAIUpstreamURL(configs[i].UpstreamURL)
+			if !ok {
+				continue
+			}
+			if err := tx.Unscoped().Model(&configs[i]).Updates(map[string]interface{}{
+				"upstream_url":  base,
+				"upstream_path": path,
+			}).Error; err != nil {
+				return fmt.Errorf("failed to update model %d: %w", configs[i].ID, err)
+			}
+			logger.Infof("Split upstream url of model %d: %s -> %s + %q", configs[i].ID, configs[i].UpstreamURL, base, path)
+			migrated++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if migrated > 0 {
+		logger.Infof("🔀 Migrated upstream paths of %d models", migrated)
+	}
+	return migrated, nil
+}
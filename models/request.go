@@ -3,6 +3,7 @@ package models
 import (
 	"encoding/json"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,6 +27,15 @@ type ChatCompletionRequest struct {
 	ToolChoice       interface{}            `json:"tool_choice,omitempty"`
 	ParallelToolCalls *bool                 `json:"parallel_tool_calls,omitempty"`
 	ResponseFormat   *ResponseFormat        `json:"response_format,omitempty"`
+	ReasoningEffort  string                 `json:"reasoning_effort,omitempty"` // low / medium / high，Claude 映射为思考预算
+	Logprobs         *bool                  `json:"logprobs,omitempty"`     // 仅 OpenAI 兼容上游支持，Claude / Gemini 忽略
+	TopLogprobs      *int                   `json:"top_logprobs,omitempty"`
+
+	// 网关扩展字段: Claude extended thinking 配置 (优先于 reasoning_effort)，不转发给 OpenAI 兼容上游
+	Thinking         *ThinkingConfig        `json:"thinking,omitempty"`
+
+	// 网关扩展字段: 整个请求 (含重试) 的超时预算，单位毫秒，不转发给上游
+	RequestTimeout   *int                   `json:"request_timeout,omitempty"`
 	
 	// Image Generation Fields
 	Prompt           interface{}            `json:"prompt,omitempty"` 
@@ -36,7 +46,7 @@ type ChatCompletionRequest struct {
 
 // ChatMessage 聊天消息
 type ChatMessage struct {
-	Role             string        `json:"role,omitempty" binding:"required,oneof=system user assistant tool"`
+	Role             string        `json:"role,omitempty" binding:"required,oneof=system developer user assistant tool function"`
 	Content          interface{}   `json:"content,omitempty"`
 	ReasoningContent string        `json:"reasoning_content,omitempty"` // For DeepSeek reasoning models
 	Name             string        `json:"name,omitempty"`
@@ -72,6 +82,13 @@ type ChatToolCallFunc struct {
 	Arguments string `json:"arguments"`
 }
 
+// ThinkingConfig Claude extended thinking 配置，格式与 Anthropic API 一致
+// 例如: {"type": "enabled", "budget_tokens": 2048}；type 为 "disabled" 时不启用
+type ThinkingConfig struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
+}
+
 // StreamOptions 流式选项
 type StreamOptions struct {
 	IncludeUsage bool `json:"include_usage,omitempty"`
@@ -116,6 +133,52 @@ type ChatCompletionUsage struct {
 	PromptTokens     int `json:"prompt_tokens"`
 	CompletionTokens int `json:"completion_tokens"`
 	TotalTokens      int `json:"total_tokens"`
+
+	// Prompt Caching (Claude)
+	CacheReadTokens  int `json:"cache_read_input_tokens,omitempty"`
+	CacheWriteTokens int `json:"cache_creation_input_tokens,omitempty"`
+}
+
+// EmbeddingRequest OpenAI 兼容的向量请求 (POST /v1/embeddings)
+// Input 可以是字符串、字符串数组、Token 数组或 Token 数组的数组
+type EmbeddingRequest struct {
+	Model          string      `json:"model" binding:"required"`
+	Input          interface{} `json:"input" binding:"required"`
+	EncodingFormat string      `json:"encoding_format,omitempty"`
+	Dimensions     *int        `json:"dimensions,omitempty"`
+	User           string      `json:"user,omitempty"`
+}
+
+// EmbeddingResponse OpenAI 兼容的向量响应
+type EmbeddingResponse struct {
+	Object string          `json:"object"`
+	Data   []EmbeddingData `json:"data"`
+	Model  string          `json:"model"`
+	Usage  EmbeddingUsage  `json:"usage"`
+}
+
+// EmbeddingData 单个输入的向量，Embedding 原样透传 (浮点数组或 base64 字符串)
+type EmbeddingData struct {
+	Object    string          `json:"object"`
+	Index     int             `json:"index"`
+	Embedding json.RawMessage `json:"embedding"`
+}
+
+// EmbeddingUsage 向量请求的 Token 用量
+type EmbeddingUsage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// OpenAIModel OpenAI 兼容的模型对象 (GET /v1/models/:id)
+type OpenAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"` // "model"
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+
+	// 网关扩展字段: 支持的请求特性 (组为其模型能力的并集)
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
 }
 
 // ErrorResponse 错误响应
@@ -137,6 +200,17 @@ type HealthResponse struct {
 	Gateway     string   `json:"gateway"`
 	ModelGroups []string `json:"model_groups"`
 	Timestamp   int64    `json:"timestamp"`
+
+	Checks *HealthChecks `json:"checks,omitempty"` // 仅 deep=true 时返回
+}
+
+// HealthChecks 深度健康检查结果
+type HealthChecks struct {
+	Database       string `json:"database"`        // "ok" 或错误信息
+	TotalGroups    int    `json:"total_groups"`
+	ServableGroups int    `json:"servable_groups"` // 至少有一个可用 Key 的组数量
+	InFlight       int64  `json:"in_flight"`               // 正在处理的代理请求数
+	MaxInFlight    int    `json:"max_in_flight,omitempty"` // 全局并发上限，0 表示不限制
 }
 
 // AdminStatsResponse 管理员统计响应
@@ -159,6 +233,41 @@ type AdminModelStats struct {
 	TotalRequests int     `json:"total_requests"`
 }
 
+// TTFTStats 模型的流式首字节耗时统计
+type TTFTStats struct {
+	ModelConfigID  uint    `json:"model_config_id"`
+	ModelGroupID   uint    `json:"model_group_id"`
+	StreamRequests int     `json:"stream_requests"` // 记录了首字节耗时的流式请求数
+	AvgTTFT        float64 `json:"avg_ttft_ms"`
+}
+
+// CacheStats 模型的 Prompt 缓存 Token 统计
+type CacheStats struct {
+	ModelConfigID    uint  `json:"model_config_id"`
+	ModelGroupID     uint  `json:"model_group_id"`
+	CacheReadTokens  int64 `json:"cache_read_tokens"`
+	CacheWriteTokens int64 `json:"cache_write_tokens"`
+}
+
+// EmbeddingStats 模型的 Embedding 请求统计 (与聊天统计分开)
+type EmbeddingStats struct {
+	ModelConfigID uint    `json:"model_config_id"`
+	ModelGroupID  uint    `json:"model_group_id"`
+	Requests      int     `json:"requests"`
+	Errors        int     `json:"errors"`
+	AvgLatency    float64 `json:"avg_latency_ms"`
+}
+
+// StatsBucket 按小时聚合的请求统计
+type StatsBucket struct {
+	Hour     time.Time `json:"hour"`
+	Requests int       `json:"requests"`
+	Success  int       `json:"success"`
+	Error    int       `json:"error"`
+
+	AvgDuration float64 `json:"avg_duration"` // 平均耗时 (毫秒)
+}
+
 // CreateModelGroupRequest 创建模型组请求
 type CreateModelGroupRequest struct {
 	GroupID  string           `json:"group_id" binding:"required"`
@@ -171,8 +280,278 @@ type CreateModelRequest struct {
 	ProviderName  string   `json:"provider_name" binding:"required"`
 	UpstreamURL   string   `json:"upstream_url" binding:"required,url"`
 	UpstreamModel string   `json:"upstream_model" binding:"required"`
+	UpstreamPath  string   `json:"upstream_path"` // 留空使用适配器默认路径
 	Keys          []string `json:"keys" binding:"required,min=1"`
 	Timeout       int      `json:"timeout" binding:"min=1,max=300"`
+
+	IdentityPatch     bool   `json:"identity_patch"`
+	IdentityPatchText string `json:"identity_patch_text"`
+
+	RequestOverrides *RequestOverrides `json:"request_overrides"`
+
+	MaxOutputTokens  int `json:"max_output_tokens" binding:"min=0"`
+	DefaultMaxTokens int `json:"default_max_tokens" binding:"min=0"`
+
+	HideThoughts bool `json:"hide_thoughts"`
+
+	Priority int `json:"priority"`
+
+	GoogleSearch *GoogleSearchConfig `json:"google_search"`
+
+	Capabilities *CapabilityOverrides `json:"capabilities"`
+
+	// 组内已存在相同 provider/url/path/model 的模型时: reject (默认) 返回 409，merge 将新 Key 合并到已有模型
+	OnDuplicate string `json:"on_duplicate" binding:"omitempty,oneof=reject merge"`
+}
+
+// RequestOverrides 模型级请求改写规则
+// 优先级: Temperature/TopP 强制覆盖客户端值；MaxTokensCap 仅在客户端值缺失或超出时生效；
+// SystemPrompt 作为第一条 system 消息插入，客户端自己的 system 消息保留在其后。
+type RequestOverrides struct {
+	MaxTokensCap *int     `json:"max_tokens_cap,omitempty"`
+	Temperature  *float64 `json:"temperature,omitempty"`
+	TopP         *float64 `json:"top_p,omitempty"`
+	SystemPrompt string   `json:"system_prompt,omitempty"`
+}
+
+// ParseRequestOverrides 解析 ModelConfig.RequestOverrides，空字符串返回 nil
+func ParseRequestOverrides(raw string) (*RequestOverrides, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var o RequestOverrides
+	if err := json.Unmarshal([]byte(raw), &o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// Encode 序列化为存储格式，nil 返回空字符串
+func (o *RequestOverrides) Encode() string {
+	if o == nil {
+		return ""
+	}
+	data, _ := json.Marshal(o)
+	return string(data)
+}
+
+// DefaultSearchTools 默认触发 Gemini 联网搜索的工具名
+var DefaultSearchTools = []string{"web_search", "google_search"}
+
+// GoogleSearchConfig Gemini 联网搜索注入配置
+// 客户端声明了 TriggerTools 中的工具时，不转发该函数，改为启用 Gemini 内置搜索
+type GoogleSearchConfig struct {
+	TriggerTools []string `json:"trigger_tools,omitempty"` // 留空使用 DefaultSearchTools
+
+	// 设置时改用 googleSearchRetrieval 动态检索 (Gemini 1.5)，模型判断需要搜索的置信度超过该阈值 (0-1) 才搜索
+	DynamicThreshold *float64 `json:"dynamic_threshold,omitempty"`
+
+	// 与其他函数同时启用搜索 (需要模型支持)；默认存在其他函数时放弃搜索
+	WithFunctions bool `json:"with_functions,omitempty"`
+}
+
+// ParseGoogleSearchConfig 解析 ModelConfig.GoogleSearch，空字符串返回 nil
+func ParseGoogleSearchConfig(raw string) (*GoogleSearchConfig, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var cfg GoogleSearchConfig
+	if err := json.Unmarshal([]byte(raw), &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Encode 序列化为存储格式，nil 或空配置返回空字符串
+func (g *GoogleSearchConfig) Encode() string {
+	if g == nil {
+		return ""
+	}
+	data, _ := json.Marshal(g)
+	if string(data) == "{}" {
+		return ""
+	}
+	return string(data)
+}
+
+// IsTrigger 判断工具名是否触发联网搜索
+func (g *GoogleSearchConfig) IsTrigger(name string) bool {
+	triggers := DefaultSearchTools
+	if g != nil && len(g.TriggerTools) > 0 {
+		triggers = g.TriggerTools
+	}
+	for _, t := range triggers {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Capabilities 模型/提供商支持的请求特性
+type Capabilities struct {
+	Tools      bool `json:"tools"`       // 函数调用 (tools)
+	Vision     bool `json:"vision"`      // 图片输入 (image_url 内容)
+	JSONSchema bool `json:"json_schema"` // response_format 为 json_schema 的结构化输出
+	Streaming  bool `json:"streaming"`   // 流式响应
+}
+
+// Missing 返回 required 中要求但当前不支持的特性名
+func (c Capabilities) Missing(required Capabilities) []string {
+	var missing []string
+	if required.Tools && !c.Tools {
+		missing = append(missing, "tools")
+	}
+	if required.Vision && !c.Vision {
+		missing = append(missing, "vision")
+	}
+	if required.JSONSchema && !c.JSONSchema {
+		missing = append(missing, "json_schema")
+	}
+	if required.Streaming && !c.Streaming {
+		missing = append(missing, "streaming")
+	}
+	return missing
+}
+
+// Union 合并两组能力 (任一支持即支持)
+func (c Capabilities) Union(other Capabilities) Capabilities {
+	return Capabilities{
+		Tools:      c.Tools || other.Tools,
+		Vision:     c.Vision || other.Vision,
+		JSONSchema: c.JSONSchema || other.JSONSchema,
+		Streaming:  c.Streaming || other.Streaming,
+	}
+}
+
+// RequiredCapabilities 返回请求用到的特性
+func RequiredCapabilities(req ChatCompletionRequest) Capabilities {
+	required := Capabilities{
+		Tools:     len(req.Tools) > 0,
+		Streaming: req.Stream,
+	}
+	if req.ResponseFormat != nil && req.ResponseFormat.Type == "json_schema" {
+		required.JSONSchema = true
+	}
+	for _, msg := range req.Messages {
+		parts, ok := msg.Content.([]interface{})
+		if !ok {
+			continue
+		}
+		for _, part := range parts {
+			if p, ok := part.(map[string]interface{}); ok && p["type"] == "image_url" {
+				required.Vision = true
+			}
+		}
+	}
+	return required
+}
+
+// CapabilityOverrides 模型级能力声明，覆盖提供商的默认能力 (未设置的字段沿用默认值)
+// 例如同一 OpenAI 兼容接口下的纯文本模型可声明 {"vision": false}
+type CapabilityOverrides struct {
+	Tools      *bool `json:"tools,omitempty"`
+	Vision     *bool `json:"vision,omitempty"`
+	JSONSchema *bool `json:"json_schema,omitempty"`
+	Streaming  *bool `json:"streaming,omitempty"`
+}
+
+// ParseCapabilityOverrides 解析 ModelConfig.Capabilities，空字符串返回 nil
+func ParseCapabilityOverrides(raw string) (*CapabilityOverrides, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var o CapabilityOverrides
+	if err := json.Unmarshal([]byte(raw), &o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// Encode 序列化为存储格式，nil 或空配置返回空字符串
+func (o *CapabilityOverrides) Encode() string {
+	if o == nil {
+		return ""
+	}
+	data, _ := json.Marshal(o)
+	if string(data) == "{}" {
+		return ""
+	}
+	return string(data)
+}
+
+// Apply 将覆盖项应用到默认能力上
+func (o *CapabilityOverrides) Apply(c Capabilities) Capabilities {
+	if o == nil {
+		return c
+	}
+	if o.Tools != nil {
+		c.Tools = *o.Tools
+	}
+	if o.Vision != nil {
+		c.Vision = *o.Vision
+	}
+	if o.JSONSchema != nil {
+		c.JSONSchema = *o.JSONSchema
+	}
+	if o.Streaming != nil {
+		c.Streaming = *o.Streaming
+	}
+	return c
+}
+
+// ContentFilterConfig 内容过滤规则 (GatewaySettings 中以 JSON 存储)
+// Action 为 "block" 时命中任一规则即拒绝；为 "redact" 时将命中内容替换为 Replacement
+type ContentFilterConfig struct {
+	Patterns    []string `json:"patterns"`              // 正则表达式 (Go RE2 语法)
+	Action      string   `json:"action"`                // "block" (默认) 或 "redact"
+	Replacement string   `json:"replacement,omitempty"` // 默认 "[REDACTED]"
+}
+
+// ParseContentFilterConfig 解析内容过滤规则，空字符串或没有规则时返回 nil
+func ParseContentFilterConfig(raw string) (*ContentFilterConfig, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var cfg ContentFilterConfig
+	if err := json.Unmarshal([]byte(raw), &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Patterns) == 0 {
+		return nil, nil
+	}
+	return &cfg, nil
+}
+
+// Apply 返回应用改写规则后的请求副本，不修改原请求
+func (o *RequestOverrides) Apply(req ChatCompletionRequest) ChatCompletionRequest {
+	if o == nil {
+		return req
+	}
+	if o.MaxTokensCap != nil && (req.MaxTokens == nil || *req.MaxTokens > *o.MaxTokensCap) {
+		capped := *o.MaxTokensCap
+		req.MaxTokens = &capped
+	}
+	if o.Temperature != nil {
+		temperature := *o.Temperature
+		req.Temperature = &temperature
+	}
+	if o.TopP != nil {
+		topP := *o.TopP
+		req.TopP = &topP
+	}
+	if o.SystemPrompt != "" {
+		messages := make([]ChatMessage, 0, len(req.Messages)+1)
+		messages = append(messages, ChatMessage{Role: "system", Content: o.SystemPrompt})
+		req.Messages = append(messages, req.Messages...)
+	}
+	return req
+}
+
+// ModelAliasRequest 创建/更新模型别名请求
+type ModelAliasRequest struct {
+	Alias  string `json:"alias"` // 仅创建时使用，更新时以 URL 中的别名为准
+	Target string `json:"target" binding:"required"`
 }
 
 // UpdateModelGroupRequest 更新模型组请求
@@ -189,6 +568,72 @@ type UpdateModelRequest struct {
 	Timeout       *int     `json:"timeout" binding:"omitempty,min=1,max=300"`
 }
 
+// RestoreRequest 从回收站恢复软删除的记录
+type RestoreRequest struct {
+	Type string `json:"type" binding:"required,oneof=group model key"`
+	ID   uint   `json:"id" binding:"required"`
+}
+
+// KeyStatusInfo 单个 API Key 的健康状态
+type KeyStatusInfo struct {
+	KeyID         uint       `json:"key_id,omitempty"`
+	KeyPreview    string     `json:"key_preview"`
+	Status        string     `json:"status"` // available, cooldown, dead
+	CooldownUntil *time.Time `json:"cooldown_until,omitempty"`
+	InFlight      int        `json:"in_flight,omitempty"` // 进行中的请求数 (仅 least_loaded_keys 组统计)
+
+	RateLimit *RateLimitInfo `json:"rate_limit,omitempty"` // 最近一次上游响应中的限流信息
+}
+
+// RateLimitInfo 上游 x-ratelimit-* 响应头中的限流信息，缺失的字段为 nil
+type RateLimitInfo struct {
+	LimitRequests     *int       `json:"limit_requests,omitempty"`
+	LimitTokens       *int       `json:"limit_tokens,omitempty"`
+	RemainingRequests *int       `json:"remaining_requests,omitempty"`
+	RemainingTokens   *int       `json:"remaining_tokens,omitempty"`
+	ResetRequests     *time.Time `json:"reset_requests,omitempty"`
+	ResetTokens       *time.Time `json:"reset_tokens,omitempty"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+// ModelKeyStatus 模型下所有 API Key 的健康状态
+type ModelKeyStatus struct {
+	ModelID       uint            `json:"model_id"`
+	GroupID       string          `json:"group_id"`
+	ProviderName  string          `json:"provider_name"`
+	UpstreamModel string          `json:"upstream_model"`
+	Keys          []KeyStatusInfo `json:"keys"`
+}
+
+// ModelResolution 模型名的完整解析结果 (路由诊断)
+type ModelResolution struct {
+	Model        string          `json:"model"`
+	Target       string          `json:"target"`        // 别名替换后的路由目标
+	GroupID      string          `json:"group_id"`
+	DefaultGroup bool            `json:"default_group"` // 组不存在，回落到 DefaultGroup
+	PinIndex     int             `json:"pin_index"`     // 指定的模型序号 (从 1 开始)，0 表示按策略选择
+	Strategy     string          `json:"strategy"`
+	MaxRetries   int             `json:"max_retries"`
+	Models       []ResolvedModel `json:"models"`
+
+	// 下一次请求将命中的模型序号与 Key，无可用 Key 等情况下为空并给出 RouteError
+	NextModelIndex int    `json:"next_model_index,omitempty"`
+	NextKeyPreview string `json:"next_key_preview,omitempty"`
+	RouteError     string `json:"route_error,omitempty"`
+}
+
+// ResolvedModel 路由诊断中组内的单个模型
+type ResolvedModel struct {
+	Index         int             `json:"index"` // 从 1 开始，与 "group$index" 一致
+	ModelID       uint            `json:"model_id"`
+	ProviderName  string          `json:"provider_name"`
+	UpstreamURL   string          `json:"upstream_url"`
+	UpstreamModel string          `json:"upstream_model"`
+	Priority      int             `json:"priority"`
+	AvailableKeys int             `json:"available_keys"`
+	Keys          []KeyStatusInfo `json:"keys"`
+}
+
 // APIResponse 通用API响应
 type APIResponse struct {
 	Success   bool        `json:"success"`
@@ -197,21 +642,26 @@ type APIResponse struct {
 	Timestamp int64       `json:"timestamp"`
 }
 
-// MaskAPIKey 脱敏API Key
-func MaskAPIKey(key string) string {
-	if key == "" {
-		return "***"
-	}
+// 脱敏时保留的前缀/后缀长度，由 GatewaySettings.KeyMaskPrefix / KeyMaskSuffix 配置
+var keyMaskPrefix, keyMaskSuffix atomic.Int32
 
-	if len(key) <= 4 {
-		return key[:1] + "***"
-	}
+func init() {
+	SetKeyMasking(3, 4)
+}
 
-	if len(key) <= 8 {
-		return key[:2] + "***" + key[len(key)-2:]
-	}
+// SetKeyMasking 设置脱敏时保留的前缀与后缀长度，负数视为 0
+func SetKeyMasking(prefix, suffix int) {
+	keyMaskPrefix.Store(int32(max(prefix, 0)))
+	keyMaskSuffix.Store(int32(max(suffix, 0)))
+}
 
-	return key[:3] + "***" + key[len(key)-4:]
+// MaskAPIKey 脱敏密钥 (日志与接口响应统一使用)
+// 保留配置的前缀与后缀，密钥较短时每侧最多保留长度的 1/4，保证大部分内容被隐藏
+func MaskAPIKey(key string) string {
+	limit := len(key) / 4
+	prefix := min(int(keyMaskPrefix.Load()), limit)
+	suffix := min(int(keyMaskSuffix.Load()), limit)
+	return key[:prefix] + "***" + key[len(key)-suffix:]
 }
 
 // NewSuccessResponse 创建成功响应
@@ -233,6 +683,28 @@ func NewErrorResponse(message string) *APIResponse {
 	}
 }
 
+// StopSequences 将 Stop 统一解析为字符串数组
+// Stop 可能是字符串、字符串数组 (内部映射) 或 JSON 解析得到的 []interface{}
+func (r *ChatCompletionRequest) StopSequences() []string {
+	switch v := r.Stop.(type) {
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	case []string:
+		return v
+	case []interface{}:
+		stops := make([]string, 0, len(v))
+		for _, item := range v {
+			if str, ok := item.(string); ok {
+				stops = append(stops, str)
+			}
+		}
+		return stops
+	}
+	return nil
+}
+
 // StringContent 从ChatMessage.Content提取字符串内容
 // 支持普通字符串和多模态数组格式
 func (m *ChatMessage) StringContent() string {
@@ -273,3 +745,24 @@ func (m *ChatMessage) StringContent() string {
 
 	return ""
 }
+
+// ConfigValidateRequest 待校验的完整配置 (POST /admin/config/validate，不落库)
+// 组与模型的结构与 GET /admin/model-groups/:group_id 返回的一致，Key 放在模型的 api_keys 中
+type ConfigValidateRequest struct {
+	Groups  []ModelGroup `json:"groups"`
+	Aliases []ModelAlias `json:"aliases,omitempty"`
+}
+
+// ConfigProblem 配置校验发现的问题
+// Severity 为 error 时该配置无法正常使用，warning 表示可用但可能不是预期的行为
+type ConfigProblem struct {
+	Path     string `json:"path"` // 如 groups[0].models[1].upstream_url
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// ConfigValidateResponse 配置校验结果，Valid 为 true 表示没有 error 级别的问题
+type ConfigValidateResponse struct {
+	Valid    bool            `json:"valid"`
+	Problems []ConfigProblem `json:"problems"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaskAPIKey(t *testing.T) {
+	cases := []struct {
+		key, want string
+	}{
+		{"", "***"},
+		{"abc", "***"},
+		{"abcd", "a***d"},
+		{"sk-12345", "sk***45"},
+		{"sk-abcdef123", "sk-***123"},
+		{"sk-admin-0123456789abcdef", "sk-***cdef"},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, MaskAPIKey(tc.key), tc.key)
+	}
+}
+
+func TestMaskAPIKey_Configured(t *testing.T) {
+	defer SetKeyMasking(3, 4)
+
+	SetKeyMasking(2, 6)
+	assert.Equal(t, "sk***abcdef", MaskAPIKey("sk-admin-0123456789abcdef"))
+	// 每侧最多保留长度的 1/4
+	SetKeyMasking(9, 9)
+	assert.Equal(t, "sk-adm***abcdef", MaskAPIKey("sk-admin-0123456789abcdef"))
+	assert.Equal(t, "sk***45", MaskAPIKey("sk-12345"))
+
+	SetKeyMasking(0, -1)
+	assert.Equal(t, "***", MaskAPIKey("sk-admin-0123456789abcdef"))
+}
@@ -11,6 +11,59 @@ import (
 type GatewaySettings struct {
 	gorm.Model
 	Port    int    `gorm:"default:8000" json:"port"`
+	MaxBodyBytes int64 `gorm:"default:10485760" json:"max_body_bytes"` // 请求体大小上限 (字节)，<= 0 表示不限制
+
+	// 重试策略: 重试次数 = Key 总数 * RetryMultiplier，限制在 [MinRetries, MaxRetries]
+	RetryMultiplier float64 `gorm:"default:1.5" json:"retry_multiplier"`
+	MinRetries      int     `gorm:"default:3" json:"min_retries"`
+	MaxRetries      int     `gorm:"default:12" json:"max_retries"`
+
+	// 上游返回 200 但流中没有任何内容时重试下一个 Key/模型 (仅在尚未向客户端输出时)；
+	// 默认关闭，避免掩盖正常的空回复
+	RetryEmptyStream bool `gorm:"default:false" json:"retry_empty_stream"`
+
+	// 上游 429 未返回 (或无法解析) Retry-After 时的 Key 冷却秒数，<= 0 时使用 60
+	RateLimitCooldown int `gorm:"default:60" json:"rate_limit_cooldown"`
+
+	// 上游 x-ratelimit-remaining-requests <= 该值 (或剩余 Token 为 0) 时提前冷却 Key 直到重置时间
+	RateLimitMinRequests int `gorm:"default:0" json:"rate_limit_min_requests"`
+
+	// 计算模型近期错误率的窗口大小 (最近 N 次上游请求)，配合组的 error_rate_threshold 使用
+	HealthWindow int `gorm:"default:20" json:"health_window"`
+
+	// 密钥脱敏 (日志、Key 状态、管理员密钥列表) 时保留的前缀与后缀长度
+	KeyMaskPrefix int `gorm:"default:3" json:"key_mask_prefix"`
+	KeyMaskSuffix int `gorm:"default:4" json:"key_mask_suffix"`
+
+	// 紧急情况下 (break-glass) 在管理员密钥列表中返回完整密钥，默认仅在创建时返回
+	RevealAdminKeys bool `gorm:"default:false" json:"reveal_admin_keys"`
+
+	// 请求的模型不匹配任何组时改用的默认组，留空时返回错误 (严格匹配)
+	DefaultGroup string `json:"default_group,omitempty"`
+
+	// 模型统计的持久化方式: 留空时随请求日志一起写入，"off" 只保存在内存中 (重启后清零)，
+	// 正整数表示每隔该秒数批量写入一次
+	StatsPersistence string `json:"stats_persistence,omitempty"`
+
+	// 将响应 (包括流式 chunk) 中的 model 改写为客户端请求的模型名 (组名或别名)，
+	// 默认关闭，返回上游实际的模型名
+	EchoRequestModel bool `gorm:"default:false" json:"echo_request_model"`
+
+	// 请求大小限制 (全局默认，组可单独覆盖): 消息条数与消息内容总字符数上限，0 表示不限制
+	MaxMessages     int `gorm:"default:0" json:"max_messages"`
+	MaxContextChars int `gorm:"default:0" json:"max_context_chars"`
+
+	// /v1/embeddings 单次上游请求的最大输入条数，超出时拆分为多个批次并发请求后按顺序合并
+	EmbeddingBatchSize int `gorm:"default:2048" json:"embedding_batch_size"`
+
+	// 可重试的上游错误信息 (JSON 字符串数组，不区分大小写的子串匹配)，留空不启用。
+	// 上游返回非 200 (或 200 但响应体是错误对象) 且响应体包含其中任一字符串时换用其他 Key/模型重试，
+	// 用于识别状态码无法区分的临时故障 (如 400 "overloaded")
+	RetryableErrors string `gorm:"type:text" json:"retryable_errors,omitempty"`
+
+	// 内容过滤规则 (JSON，结构见 ContentFilterConfig)，留空不启用
+	RequestFilter  string `gorm:"type:text" json:"request_filter,omitempty"`  // 路由前检查客户端消息
+	ResponseFilter string `gorm:"type:text" json:"response_filter,omitempty"` // 仅非流式响应
 }
 
 // AdminKey 管理员密钥
@@ -18,6 +71,13 @@ type AdminKey struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
 	Name      string    `json:"name"`                                    // 备注，如 "MacBook Pro"
 	Key       string    `gorm:"uniqueIndex:idx_admin_key_deleted" json:"key"` // 实际的 sk-admin-xxx
+
+	// 应用归属信息: 使用该密钥的代理请求以 X-Title / HTTP-Referer 头转发给上游，留空不发送
+	AppName    string `json:"app_name,omitempty"`
+	AppReferer string `json:"app_referer,omitempty"`
+
+	// 管理员权限: 可使用 X-Upstream-Base 等运维请求头，限定了可访问组的密钥不生效
+	IsAdmin   bool      `gorm:"default:false" json:"is_admin"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
@@ -27,8 +87,31 @@ type ModelConfig struct {
 	ProviderName   string `gorm:"not null" json:"provider_name"`
 	UpstreamURL    string `gorm:"not null" json:"upstream_url"`
 	UpstreamModel  string `gorm:"not null" json:"upstream_model"`
+	UpstreamPath   string `json:"upstream_path,omitempty"` // 追加到 UpstreamURL 之后的接口路径，留空使用适配器默认路径
 	Timeout        int    `gorm:"default:60" json:"timeout"`
 	ModelGroupID   uint   `json:"model_group_id"`
+	Priority       int    `gorm:"default:0" json:"priority"` // 组内顺序 (fallback 故障转移顺序、$序号)，越小越靠前，相同时按 ID
+
+	// 身份补丁 (仅 Gemini 生效)：默认关闭，只对会混淆自身身份的模型开启
+	IdentityPatch     bool   `gorm:"default:false" json:"identity_patch"`
+	IdentityPatchText string `json:"identity_patch_text,omitempty"` // 自定义补丁文本，留空使用默认模板，支持 {model} 占位符
+
+	// 请求改写规则 (JSON，结构见 RequestOverrides)，在适配器转换前应用
+	RequestOverrides string `gorm:"type:text" json:"request_overrides,omitempty"`
+
+	// 输出 Token 限制: MaxOutputTokens > 0 时将客户端的 max_tokens 下调到该值，避免上游 400；
+	// DefaultMaxTokens 为客户端未指定时的默认值 (仅 Claude 必填 max_tokens，0 表示使用 4096)
+	MaxOutputTokens  int `gorm:"default:0" json:"max_output_tokens"`
+	DefaultMaxTokens int `gorm:"default:0" json:"default_max_tokens"`
+
+	// 丢弃思考过程 (仅 Gemini 生效)：默认将 thought 部分作为 reasoning_content 返回
+	HideThoughts bool `gorm:"default:false" json:"hide_thoughts"`
+
+	// 联网搜索注入配置 (JSON，结构见 GoogleSearchConfig，仅 Gemini 生效)，留空使用默认行为
+	GoogleSearch string `gorm:"type:text" json:"google_search,omitempty"`
+
+	// 能力声明 (JSON，结构见 CapabilityOverrides)，覆盖提供商默认能力，留空使用默认值
+	Capabilities string `gorm:"type:text" json:"capabilities,omitempty"`
 
 	// 关联关系
 	ModelGroup     ModelGroup  `gorm:"foreignKey:ModelGroupID" json:"model_group,omitempty"`
@@ -41,6 +124,7 @@ type APIKey struct {
 	gorm.Model
 	KeyValue      string `gorm:"not null" json:"key_value"`
 	ModelConfigID uint   `json:"model_config_id"`
+	Weight        int    `gorm:"default:1" json:"weight"` // 组内 Key 的选择权重，越大被选中越多，<= 0 视为 1
 
 	// 关联关系
 	ModelConfig ModelConfig `gorm:"foreignKey:ModelConfigID" json:"model_config,omitempty"`
@@ -50,13 +134,69 @@ type APIKey struct {
 type ModelGroup struct {
 	gorm.Model
 	GroupID  string `gorm:"uniqueIndex:idx_group_id_deleted;not null" json:"group_id"`
-	Strategy string `gorm:"default:fallback" json:"strategy"` // "fallback" 或 "round_robin"
+	Strategy string `gorm:"default:fallback" json:"strategy"` // "fallback"、"round_robin" 或 "race"
+	RaceCount int   `gorm:"default:2" json:"race_count"`       // race 策略下并发请求的模型/Key 数量 (K)
+	MaxRetries int  `gorm:"default:0" json:"max_retries"`      // 覆盖全局重试策略，0 表示按全局策略计算
+	Tags     []string `gorm:"serializer:json" json:"tags,omitempty"` // 分类标签 (如 "env:prod")，用于管理端筛选
+
+	// 健康度降级: 近期错误率超过阈值 (0-1) 的模型不参与选择，0 表示关闭；
+	// 全部超过阈值时 deprioritize (默认) 仍使用全部模型，skip 直接返回错误
+	ErrorRateThreshold float64 `gorm:"default:0" json:"error_rate_threshold"`
+	UnhealthyAction    string  `json:"unhealthy_action,omitempty"`
+
+	// 上游全部不可用 (无可用 Key 或重试耗尽) 时的处理: error (默认，返回错误)、
+	// fallback_group:<组ID> (改用其他组重试)、static_message (返回 ExhaustedMessage 作为固定回复)
+	OnExhausted      string `json:"on_exhausted,omitempty"`
+	ExhaustedMessage string `json:"exhausted_message,omitempty"`
+
+	// 启用后按进行中请求数选择 Key (优先负载最低的可用 Key)，而不是单纯轮询
+	LeastLoadedKeys bool `gorm:"default:false" json:"least_loaded_keys"`
+
+	// 请求大小限制: 消息条数与消息内容总字符数上限，超出时直接返回 400，0 表示使用网关全局设置
+	MaxMessages     int `gorm:"default:0" json:"max_messages"`
+	MaxContextChars int `gorm:"default:0" json:"max_context_chars"`
+
+	// 组级限流 (令牌桶): 每分钟请求数与 Token 数上限，超出时返回 429，0 表示不限制；
+	// Token 数在转发前按请求内容估算预扣，请求结束后按上游返回的实际用量修正
+	RequestsPerMinute int `gorm:"default:0" json:"requests_per_minute"`
+	TokensPerMinute   int `gorm:"default:0" json:"tokens_per_minute"`
 
 	// 关联关系
 	Models []ModelConfig `gorm:"foreignKey:ModelGroupID" json:"models,omitempty"`
 	Stats  []ModelStats  `gorm:"foreignKey:ModelGroupID" json:"stats,omitempty"`
 }
 
+// AuditLog 管理操作审计日志 (谁在何时对什么对象做了什么)
+type AuditLog struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	AdminID   uint      `json:"admin_id"` // Admin Key ID，JWT 登录时为 0
+	AdminName string    `gorm:"index" json:"admin_name"`
+	AuthType  string    `json:"auth_type"`           // admin_key / jwt
+	Action    string    `gorm:"index" json:"action"` // 如 create_group、delete_key
+	Target    string    `json:"target"`              // 操作对象，如 group:ai-chat、model:3、key:12
+	Detail    string    `json:"detail,omitempty"`
+	IP        string    `json:"ip"`
+}
+
+// AdminKeyGroup 管理员密钥可访问的模型组，密钥没有任何记录时可访问全部组
+type AdminKeyGroup struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	AdminKeyID uint      `gorm:"uniqueIndex:idx_admin_key_group;not null" json:"admin_key_id"`
+	GroupID    string    `gorm:"uniqueIndex:idx_admin_key_group;not null" json:"group_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ModelAlias 模型别名，将客户端使用的模型名映射到路由目标 (如 "gpt4" -> "openai-pool$1")
+// 调整组或模型顺序后只需修改别名，客户端无需改动
+type ModelAlias struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Alias     string    `gorm:"uniqueIndex;not null" json:"alias"`
+	Target    string    `gorm:"not null" json:"target"` // 组 ID，可带 "$序号" 固定模型
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
 // ModelStats 模型统计信息
 type ModelStats struct {
 	gorm.Model
@@ -68,12 +208,27 @@ type ModelStats struct {
 	TotalLatency  float64 `gorm:"default:0" json:"total_latency"` // 毫秒
 	RequestCount  int   `gorm:"default:0" json:"request_count"`
 	TotalRequests int64 `gorm:"default:0" json:"total_requests"`  // 新增：总请求数（用于前端显示）
+	TotalTTFT     float64 `gorm:"default:0" json:"total_ttft"`   // 流式请求首字节耗时之和 (毫秒)
+	TTFTCount     int     `gorm:"default:0" json:"ttft_count"`   // 记录了首字节耗时的流式请求数
+	CacheReadTokens  int64 `gorm:"default:0" json:"cache_read_tokens"`  // 命中 Prompt 缓存的输入 Token 数之和
+	CacheWriteTokens int64 `gorm:"default:0" json:"cache_write_tokens"` // 写入 Prompt 缓存的输入 Token 数之和
+
+	// Embedding 请求单独计数，不计入上面的聊天统计
+	EmbeddingRequests int     `gorm:"default:0" json:"embedding_requests"`
+	EmbeddingErrors   int     `gorm:"default:0" json:"embedding_errors"`
+	EmbeddingLatency  float64 `gorm:"default:0" json:"embedding_latency"` // 毫秒
 
 	// 关联关系
 	ModelGroup  ModelGroup  `gorm:"foreignKey:ModelGroupID" json:"model_group,omitempty"`
 	ModelConfig ModelConfig `gorm:"foreignKey:ModelConfigID" json:"model_config,omitempty"`
 }
 
+// RequestLog.RequestType 的取值，统计按类型分开聚合
+const (
+	RequestTypeChat       = "chat"
+	RequestTypeEmbeddings = "embeddings"
+)
+
 // RequestLog 请求日志 (New for Async Logging)
 type RequestLog struct {
 	ID               uint      `gorm:"primaryKey" json:"id"`
@@ -92,7 +247,17 @@ type RequestLog struct {
 	ModelGroupID     uint      `json:"model_group_id"`  // 关联ID用于统计
 	PromptTokens     int       `json:"prompt_tokens"`
 	CompletionTokens int       `json:"completion_tokens"`
+	CacheReadTokens  int       `json:"cache_read_tokens"`
+	CacheWriteTokens int       `json:"cache_write_tokens"`
 	ErrorMsg         string    `json:"error_msg,omitempty"`
+	ErrorType        string    `gorm:"index" json:"error_type,omitempty"` // network / timeout / auth / rate_limit / client / server
+	TTFT             *int64    `json:"ttft,omitempty"`                    // 流式响应首字节耗时 (毫秒)，非流式或失败的请求为空
+	RequestType      string    `gorm:"index;default:chat" json:"request_type"` // chat / embeddings
+}
+
+// IsSuccess 判断请求是否计为成功 (4xx 视为客户端问题，429 除外)
+func (l *RequestLog) IsSuccess() bool {
+	return l.StatusCode >= 200 && l.StatusCode < 500 && l.StatusCode != 429
 }
 
 // RoutingInfo 路由信息（不存储到数据库）
@@ -102,14 +267,33 @@ type RoutingInfo struct {
 	Provider      string `json:"provider"`
 	UpstreamURL   string `json:"upstream_url"`
 	UpstreamModel string `json:"upstream_model"`
+	UpstreamPath  string `json:"upstream_path,omitempty"`
 	ModelConfigID uint   `json:"model_config_db_id"` // DB ID
 	APIKey        string `json:"api_key"`
 	Timeout       int    `json:"timeout"`
+
+	IdentityPatch     bool   `json:"identity_patch"`
+	IdentityPatchText string `json:"identity_patch_text,omitempty"`
+
+	RequestOverrides *RequestOverrides `json:"request_overrides,omitempty"`
+
+	MaxOutputTokens  int `json:"max_output_tokens,omitempty"`
+	DefaultMaxTokens int `json:"default_max_tokens,omitempty"`
+
+	HideThoughts bool `json:"hide_thoughts,omitempty"`
+
+	GoogleSearch *GoogleSearchConfig `json:"google_search,omitempty"`
+
+	// KeyAcquired 为 true 时 APIKey 的进行中计数已加一，请求结束后需调用 LoadBalancer.ReleaseKey
+	KeyAcquired bool `json:"-"`
 }
 
 // AutoMigrate 自动迁移数据库结构
 func AutoMigrate(db *gorm.DB) error {
-	return db.AutoMigrate(
+	// 升级前的数据库没有 is_admin 列，新增后为初始 Root Key 补上管理员权限
+	backfillRootAdmin := db.Migrator().HasTable(&AdminKey{}) && !db.Migrator().HasColumn(&AdminKey{}, "IsAdmin")
+
+	if err := db.AutoMigrate(
 		&GatewaySettings{},
 		&AdminKey{},
 		&ModelGroup{},
@@ -117,7 +301,18 @@ func AutoMigrate(db *gorm.DB) error {
 		&APIKey{},
 		&ModelStats{},
 		&RequestLog{}, // Add RequestLog to migration
-	)
+		&ModelAlias{},
+		&AuditLog{},
+		&AdminKeyGroup{},
+	); err != nil {
+		return err
+	}
+
+	if backfillRootAdmin {
+		// 初始 Root Key 的 ID 固定为 1 (不可删除)
+		return db.Model(&AdminKey{}).Where("id = ?", 1).Update("is_admin", true).Error
+	}
+	return nil
 }
 
 // GenerateAdminKey 生成管理员密钥
@@ -148,8 +343,9 @@ func InitializeDefaultData(db *gorm.DB) (string, error) {
 	if adminCount == 0 {
 		// 生成初始管理员密钥
 		adminKey := AdminKey{
-			Name: "Initial Root Key",
-			Key:  GenerateAdminKey(),
+			Name:    "Initial Root Key",
+			Key:     GenerateAdminKey(),
+			IsAdmin: true,
 		}
 		if err := db.Create(&adminKey).Error; err != nil {
 			return "", err
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestAutoMigrate_BackfillsRootAdmin(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+
+	// 升级前的 admin_keys 表没有 is_admin 列
+	assert.NoError(t, db.Exec("CREATE TABLE admin_keys (id integer PRIMARY KEY AUTOINCREMENT, name text, key text, created_at datetime)").Error)
+	assert.NoError(t, db.Exec("INSERT INTO admin_keys (name, key) VALUES ('Initial Root Key', 'sk-admin-root'), ('app', 'sk-admin-app')").Error)
+
+	assert.NoError(t, AutoMigrate(db))
+	var keys []AdminKey
+	assert.NoError(t, db.Order("id").Find(&keys).Error)
+	assert.Len(t, keys, 2)
+	assert.True(t, keys[0].IsAdmin)
+	assert.False(t, keys[1].IsAdmin)
+
+	// 列已存在时不再补写，撤销的权限保持撤销
+	db.Model(&AdminKey{}).Where("id = ?", 1).Update("is_admin", false)
+	assert.NoError(t, AutoMigrate(db))
+	assert.NoError(t, db.First(&keys[0], 1).Error)
+	assert.False(t, keys[0].IsAdmin)
+}